@@ -0,0 +1,732 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/cases"
+	"aml-system/internal/cli"
+	"aml-system/internal/configstore"
+	"aml-system/internal/evidence"
+	"aml-system/internal/store"
+)
+
+// openAPISpec documents the endpoints below; keep the two in step
+//
+//go:embed openapi.yaml
+var openAPISpec []byte
+
+// Page sizes of the list endpoints
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+func main() {
+	cli.Title("🏦 AML API")
+	cli.Title(strings.Repeat("=", 50))
+
+	flags := flag.NewFlagSet("api", flag.ExitOnError)
+	addr := flags.String("addr", defaultAddr(), "listen address")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	tokensPath := flags.String("tokens", "", "JSON file mapping bearer tokens to analysts (default: every request but /health refused)")
+	flags.Parse(os.Args[1:])
+
+	var tokens map[string]string
+	if *tokensPath != "" {
+		var err error
+		if tokens, err = loadTokens(*tokensPath); err != nil {
+			cli.Error(err.Error())
+			os.Exit(1)
+		}
+	} else {
+		cli.Warning("No -tokens file: every request but /health will be refused")
+	}
+
+	ctx := context.Background()
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		cli.Error(err.Error())
+		os.Exit(1)
+	}
+	defer st.Close()
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           newServer(st, tokens),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Shut down cleanly on Ctrl-C or when Cloud Run stops the instance
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stop
+		cli.Status("Shutting down...")
+		shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	cli.Success(fmt.Sprintf("Listening on %s (spec at /openapi.yaml)", *addr))
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		cli.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// defaultAddr listens on $PORT when set, as Cloud Run requires
+func defaultAddr() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return ":" + port
+	}
+	return ":8080"
+}
+
+// loadTokens reads the API's bearer tokens, a JSON object mapping each
+// token to the analyst it identifies
+func loadTokens(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API tokens: %v", err)
+	}
+	var tokens map[string]string
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to parse API tokens %s: %v", path, err)
+	}
+	for token, analyst := range tokens {
+		if token == "" || strings.TrimSpace(analyst) == "" {
+			return nil, fmt.Errorf("API tokens %s has an empty token or analyst", path)
+		}
+	}
+	return tokens, nil
+}
+
+// server answers the API. Every request but /health needs a bearer token.
+// Reads load fresh state from the store on every request; writes are
+// serialised because they allocate event IDs, and are made as the analyst
+// their bearer token identifies.
+type server struct {
+	st     store.Store
+	tokens map[string]string // bearer token to analyst
+	write  sync.Mutex
+}
+
+func newServer(st store.Store, tokens map[string]string) http.Handler {
+	s := &server{st: st, tokens: tokens}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/openapi.yaml", s.authenticated(s.handleSpec))
+	mux.HandleFunc("/alerts", s.authenticated(s.handleAlerts))
+	mux.HandleFunc("/alerts/", s.authenticated(s.handleAlert))
+	mux.HandleFunc("/customers", s.authenticated(s.handleCustomers))
+	mux.HandleFunc("/customers/", s.authenticated(s.handleCustomer))
+	mux.HandleFunc("/runs", s.authenticated(s.handleRuns))
+	mux.HandleFunc("/processing", s.authenticated(s.handleProcessing))
+	mux.HandleFunc("/", s.authenticated(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, notFound("no such resource %s", r.URL.Path))
+	}))
+	return mux
+}
+
+// apiError is an error with the HTTP status it is reported with
+type apiError struct {
+	status  int
+	message string
+}
+
+func (e *apiError) Error() string {
+	return e.message
+}
+
+func badRequest(format string, args ...interface{}) error {
+	return &apiError{http.StatusBadRequest, fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) error {
+	return &apiError{http.StatusNotFound, fmt.Sprintf(format, args...)}
+}
+
+// analystKey holds the authenticated analyst in a request's context
+type analystKey struct{}
+
+// authenticated refuses requests without a valid bearer token and passes
+// the others on with the analyst it identifies
+func (s *server) authenticated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		analyst, err := s.analyst(w, r)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), analystKey{}, analyst)))
+	}
+}
+
+// analystOf returns the analyst of a request that passed authenticated
+func analystOf(r *http.Request) string {
+	analyst, _ := r.Context().Value(analystKey{}).(string)
+	return analyst
+}
+
+// analyst returns the analyst the request's bearer token identifies
+func (s *server) analyst(w http.ResponseWriter, r *http.Request) (string, error) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="aml"`)
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", &apiError{http.StatusUnauthorized, "a bearer token is required"}
+	}
+	for known, analyst := range s.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+			w.Header().Del("WWW-Authenticate")
+			return analyst, nil
+		}
+	}
+	return "", &apiError{http.StatusUnauthorized, "the bearer token is not valid"}
+}
+
+// writeJSON writes v as the response body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+// writeError reports err as {"error": ...}. Errors that are not apiErrors
+// are store failures; they are logged and reported without their details,
+// which can name tables and internal state.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var e *apiError
+	if !errors.As(err, &e) {
+		cli.Error(fmt.Sprintf("%s %s: %v", r.Method, r.URL.Path, err))
+		e = &apiError{http.StatusInternalServerError, "internal error"}
+	}
+	writeJSON(w, e.status, map[string]string{"error": e.message})
+}
+
+// allow rejects methods other than method
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": r.Method + " not allowed"})
+	return false
+}
+
+// pageResponse is the envelope of every list endpoint
+type pageResponse struct {
+	Data     interface{} `json:"data"`
+	Page     int         `json:"page"`
+	PageSize int         `json:"page_size"`
+	Total    int         `json:"total"`
+}
+
+// paginate cuts the requested page out of n items, returning the slice
+// bounds and the page numbers used
+func paginate(r *http.Request, n int) (start, end, page, size int, err error) {
+	page, size = 1, defaultPageSize
+	query := r.URL.Query()
+	if v := query.Get("page"); v != "" {
+		if page, err = strconv.Atoi(v); err != nil || page < 1 {
+			return 0, 0, 0, 0, badRequest("page must be a positive integer")
+		}
+	}
+	if v := query.Get("page_size"); v != "" {
+		if size, err = strconv.Atoi(v); err != nil || size < 1 || size > maxPageSize {
+			return 0, 0, 0, 0, badRequest("page_size must be between 1 and %d", maxPageSize)
+		}
+	}
+	start = (page - 1) * size
+	if start > n {
+		start = n
+	}
+	end = start + size
+	if end > n {
+		end = n
+	}
+	return start, end, page, size, nil
+}
+
+// queryDate reads an optional YYYY-MM-DD parameter
+func queryDate(r *http.Request, name string) (string, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return "", nil
+	}
+	if _, err := time.Parse(aml.DateLayout, v); err != nil {
+		return "", badRequest("%s must be a date (YYYY-MM-DD)", name)
+	}
+	return v, nil
+}
+
+// queryInt reads an optional integer parameter
+func queryInt(r *http.Request, name string) (int64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, badRequest("%s must be an integer", name)
+	}
+	return n, nil
+}
+
+// queryBool reads an optional true/false parameter
+func queryBool(r *http.Request, name string) (bool, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, badRequest("%s must be true or false", name)
+	}
+	return b, nil
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *server) handleSpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(openAPISpec)
+}
+
+// handleAlerts lists cases: GET /alerts
+func (s *server) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	if err := s.listAlerts(w, r); err != nil {
+		writeError(w, r, err)
+	}
+}
+
+func (s *server) listAlerts(w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	filter := cases.Filter{
+		Status:     query.Get("status"),
+		Assignee:   query.Get("assignee"),
+		AlertType:  query.Get("type"),
+		Priority:   query.Get("priority"),
+		CustomerID: query.Get("customer_id"),
+	}
+	var err error
+	if filter.From, err = queryDate(r, "from"); err != nil {
+		return err
+	}
+	if filter.To, err = queryDate(r, "to"); err != nil {
+		return err
+	}
+	if filter.MinScore, err = queryInt(r, "min_score"); err != nil {
+		return err
+	}
+	if filter.Open, err = queryBool(r, "open"); err != nil {
+		return err
+	}
+	overdue, err := queryBool(r, "overdue")
+	if err != nil {
+		return err
+	}
+	if overdue {
+		filter.OverdueOn = time.Now().UTC()
+	}
+
+	book, err := cases.Load(r.Context(), s.st)
+	if err != nil {
+		return err
+	}
+	matched := book.List(filter)
+	start, end, page, size, err := paginate(r, len(matched))
+	if err != nil {
+		return err
+	}
+	data := make([]cases.Case, 0, end-start)
+	for _, c := range matched[start:end] {
+		data = append(data, c.Summary())
+	}
+	writeJSON(w, http.StatusOK, pageResponse{Data: data, Page: page, PageSize: size, Total: len(matched)})
+	return nil
+}
+
+// alertDetail is a case with its alerts, history and the transactions
+// that triggered any of its alerts
+type alertDetail struct {
+	*cases.Case
+	TransNums []string `json:"trans_nums"`
+}
+
+// handleAlert serves GET /alerts/{id}, the evidence package GET
+// /alerts/{id}/evidence and the disposition writes POST /alerts/{id}/status,
+// /assignee, /due-date and /notes. The ID may be that of any alert of a case.
+func (s *server) handleAlert(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/alerts/"), "/"), "/")
+	alertID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) > 2 {
+		writeError(w, r, notFound("no such resource %s", r.URL.Path))
+		return
+	}
+
+	if len(parts) == 1 {
+		if allow(w, r, http.MethodGet) {
+			if err := s.getAlert(w, r, alertID); err != nil {
+				writeError(w, r, err)
+			}
+		}
+		return
+	}
+	switch parts[1] {
+	case "evidence":
+		if allow(w, r, http.MethodGet) {
+			if err := s.getEvidence(w, r, alertID); err != nil {
+				writeError(w, r, err)
+			}
+		}
+	case "status", "assignee", "due-date", "notes":
+		if allow(w, r, http.MethodPost) {
+			if err := s.updateAlert(w, r, alertID, parts[1]); err != nil {
+				writeError(w, r, err)
+			}
+		}
+	default:
+		writeError(w, r, notFound("no such resource %s", r.URL.Path))
+	}
+}
+
+func (s *server) getAlert(w http.ResponseWriter, r *http.Request, alertID int64) error {
+	book, err := cases.Load(r.Context(), s.st)
+	if err != nil {
+		return err
+	}
+	c, err := book.Get(alertID)
+	if err != nil {
+		return notFound("%v", err)
+	}
+	var links []aml.AlertTransaction
+	if err := s.st.Load(r.Context(), store.AlertTransactionsTable, &links); err != nil {
+		return fmt.Errorf("failed to load alert transactions: %v", err)
+	}
+	members := make(map[int64]bool, len(c.AlertIDs))
+	for _, id := range c.AlertIDs {
+		members[id] = true
+	}
+	detail := alertDetail{Case: c, TransNums: []string{}}
+	for _, link := range links {
+		if members[link.AlertID] {
+			detail.TransNums = append(detail.TransNums, link.TransNum)
+		}
+	}
+	writeJSON(w, http.StatusOK, detail)
+	return nil
+}
+
+// getEvidence responds with the evidence package of an alert as a zip
+// archive
+func (s *server) getEvidence(w http.ResponseWriter, r *http.Request, alertID int64) error {
+	book, err := cases.Load(r.Context(), s.st)
+	if err != nil {
+		return err
+	}
+	if _, err := book.Get(alertID); err != nil {
+		return notFound("%v", err)
+	}
+	pkg, err := evidence.Gather(r.Context(), s.st, alertID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	data, err := pkg.Archive()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", pkg.Name()+".zip"))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+	return nil
+}
+
+// updateRequest is the body of the disposition writes; each uses the
+// fields it needs. The change is recorded as the analyst of the bearer
+// token, so a body naming an actor is refused as an unknown field.
+type updateRequest struct {
+	Status   string `json:"status"`
+	Assignee string `json:"assignee"`
+	DueDate  string `json:"due_date"`
+	Note     string `json:"note"`
+}
+
+// updateAlert applies a case change and responds with the updated case.
+// Changes the case's state does not allow, such as a move the workflow
+// forbids, are 409s; changes missing what they need, such as a note, are
+// 400s.
+func (s *server) updateAlert(w http.ResponseWriter, r *http.Request, alertID int64, action string) error {
+	actor := analystOf(r)
+	var req updateRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return badRequest("invalid request body: %v", err)
+	}
+	var due time.Time
+	if action == "due-date" {
+		var err error
+		if due, err = time.Parse(aml.DateLayout, req.DueDate); err != nil {
+			return badRequest("due_date must be a date (YYYY-MM-DD)")
+		}
+	}
+
+	s.write.Lock()
+	defer s.write.Unlock()
+
+	ctx := r.Context()
+	book, err := cases.Load(ctx, s.st)
+	if err != nil {
+		return err
+	}
+	if _, err := book.Get(alertID); err != nil {
+		return notFound("%v", err)
+	}
+
+	now := time.Now().UTC()
+	switch action {
+	case "status":
+		_, err = cases.Transition(ctx, s.st, book, alertID, req.Status, actor, req.Note, now)
+	case "assignee":
+		_, err = cases.Assign(ctx, s.st, book, alertID, req.Assignee, actor, now)
+	case "due-date":
+		_, err = cases.SetDueDate(ctx, s.st, book, alertID, due, actor, req.Note, now)
+	case "notes":
+		_, err = cases.AddNote(ctx, s.st, book, alertID, req.Note, actor, now)
+	}
+	var refused *cases.ChangeError
+	switch {
+	case errors.As(err, &refused) && refused.Conflict:
+		return &apiError{http.StatusConflict, refused.Error()}
+	case errors.As(err, &refused):
+		return badRequest("%v", refused)
+	case err != nil:
+		return err
+	}
+	c, _ := book.Get(alertID)
+	writeJSON(w, http.StatusOK, c)
+	return nil
+}
+
+// handleCustomers lists customer risk profiles: GET /customers
+func (s *server) handleCustomers(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	if err := s.listCustomers(w, r); err != nil {
+		writeError(w, r, err)
+	}
+}
+
+// profileScore reads the risk_score column of a profile row
+func profileScore(profile map[string]interface{}) float64 {
+	score, _ := profile["risk_score"].(float64)
+	return score
+}
+
+func (s *server) listCustomers(w http.ResponseWriter, r *http.Request) error {
+	category := strings.ToUpper(r.URL.Query().Get("risk_category"))
+	if category != "" && !aml.ValidRiskCategory(category) {
+		return badRequest("risk_category must be LOW, MEDIUM, HIGH or CRITICAL")
+	}
+	minScore, err := queryInt(r, "min_score")
+	if err != nil {
+		return err
+	}
+
+	// Profiles are passed through as rows so new columns reach clients
+	var profiles []map[string]interface{}
+	if err := s.st.Load(r.Context(), aml.CustomerProfilesTable, &profiles); err != nil {
+		return fmt.Errorf("failed to load customer risk profiles: %v", err)
+	}
+	matched := []map[string]interface{}{}
+	for _, profile := range profiles {
+		if category != "" && profile["risk_category"] != category {
+			continue
+		}
+		if profileScore(profile) < float64(minScore) {
+			continue
+		}
+		matched = append(matched, profile)
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return profileScore(matched[i]) > profileScore(matched[j]) })
+
+	start, end, page, size, err := paginate(r, len(matched))
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, pageResponse{Data: matched[start:end], Page: page, PageSize: size, Total: len(matched)})
+	return nil
+}
+
+// handleCustomer serves GET /customers/{id} and /customers/{id}/transactions
+func (s *server) handleCustomer(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/customers/"), "/"), "/")
+	var err error
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		err = s.getCustomer(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "transactions":
+		err = s.customerTransactions(w, r, parts[0])
+	default:
+		err = notFound("no such resource %s", r.URL.Path)
+	}
+	if err != nil {
+		writeError(w, r, err)
+	}
+}
+
+// customerDetail is a risk profile with the customer's alerts
+type customerDetail struct {
+	CustomerID string                 `json:"customer_id"`
+	Profile    map[string]interface{} `json:"profile"`
+	Alerts     []cases.Case           `json:"alerts"`
+}
+
+func (s *server) getCustomer(w http.ResponseWriter, r *http.Request, customerID string) error {
+	var profiles []map[string]interface{}
+	if err := s.st.Load(r.Context(), aml.CustomerProfilesTable, &profiles); err != nil {
+		return fmt.Errorf("failed to load customer risk profiles: %v", err)
+	}
+	detail := customerDetail{CustomerID: customerID, Alerts: []cases.Case{}}
+	for _, profile := range profiles {
+		if profile["customer_id"] == customerID {
+			detail.Profile = profile
+		}
+	}
+
+	book, err := cases.Load(r.Context(), s.st)
+	if err != nil {
+		return err
+	}
+	for _, c := range book.List(cases.Filter{CustomerID: customerID}) {
+		detail.Alerts = append(detail.Alerts, c.Summary())
+	}
+	if detail.Profile == nil && len(detail.Alerts) == 0 {
+		return notFound("no customer %s", customerID)
+	}
+	writeJSON(w, http.StatusOK, detail)
+	return nil
+}
+
+func (s *server) customerTransactions(w http.ResponseWriter, r *http.Request, customerID string) error {
+	from, err := queryDate(r, "from")
+	if err != nil {
+		return err
+	}
+	to, err := queryDate(r, "to")
+	if err != nil {
+		return err
+	}
+	var since time.Time
+	if from != "" {
+		day, _ := time.Parse(aml.DateLayout, from)
+		since = day.Add(-time.Nanosecond)
+	}
+
+	transactions, err := s.st.CustomerTransactions(r.Context(), customerID, since)
+	if err != nil {
+		return err
+	}
+	if to != "" {
+		end := len(transactions)
+		for end > 0 && transactions[end-1].Date() > to {
+			end--
+		}
+		transactions = transactions[:end]
+	}
+
+	// Newest first
+	matched := make([]aml.Transaction, len(transactions))
+	for i, txn := range transactions {
+		matched[len(transactions)-1-i] = txn
+	}
+	start, end, page, size, err := paginate(r, len(matched))
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, pageResponse{Data: matched[start:end], Page: page, PageSize: size, Total: len(matched)})
+	return nil
+}
+
+// handleRuns lists detection runs, newest first: GET /runs
+func (s *server) handleRuns(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	if err := s.listRuns(w, r); err != nil {
+		writeError(w, r, err)
+	}
+}
+
+func (s *server) listRuns(w http.ResponseWriter, r *http.Request) error {
+	source := r.URL.Query().Get("source")
+	version, err := queryInt(r, "config_version")
+	if err != nil {
+		return err
+	}
+
+	var runs []configstore.Run
+	if err := s.st.Load(r.Context(), configstore.RunsTable, &runs); err != nil {
+		return fmt.Errorf("failed to load detection runs: %v", err)
+	}
+	matched := []configstore.Run{}
+	for _, run := range runs {
+		if source != "" && run.Source != source {
+			continue
+		}
+		if version != 0 && run.ConfigVersion != version {
+			continue
+		}
+		matched = append(matched, run)
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].RunID > matched[j].RunID })
+
+	start, end, page, size, err := paginate(r, len(matched))
+	if err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, pageResponse{Data: matched[start:end], Page: page, PageSize: size, Total: len(matched)})
+	return nil
+}
+
+// handleProcessing returns the processing_metadata rows of the scheduled
+// SQL processing: GET /processing
+func (s *server) handleProcessing(w http.ResponseWriter, r *http.Request) {
+	if !allow(w, r, http.MethodGet) {
+		return
+	}
+	rows := []map[string]interface{}{}
+	if err := s.st.Load(r.Context(), store.ProcessingMetadata, &rows); err != nil {
+		writeError(w, r, fmt.Errorf("failed to load processing metadata: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": rows})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/cli"
+	"aml-system/internal/configstore"
+	"aml-system/internal/detect"
+	"aml-system/internal/intake"
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  baseline update [-config config/aml_config.json] [-since YYYY-MM-DD] [-dry-run] [-local dir]")
+	fmt.Println("  baseline show -customer First_Last [-local dir]")
+}
+
+func main() {
+	cli.Title("🏦 AML Behavioural Baselines")
+	cli.Title(strings.Repeat("=", 50))
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	var err error
+	switch os.Args[1] {
+	case "update":
+		err = runUpdate(ctx, os.Args[2:])
+	case "show":
+		err = runShow(ctx, os.Args[2:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		cli.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// runUpdate scores transactions the baselines have not seen yet, inserts
+// BEHAVIOUR_DEVIATION alerts and stores the updated baselines
+func runUpdate(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("update", flag.ExitOnError)
+	configPath := flags.String("config", detect.DefaultConfigPath, "detector config file")
+	since := flags.String("since", "", "only alert on activity after this date; earlier activity just builds the baselines (YYYY-MM-DD)")
+	dryRun := flags.Bool("dry-run", false, "print alerts without storing alerts or baselines")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	config, err := detect.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	content, err := configstore.ConfigContent(config)
+	if err != nil {
+		return err
+	}
+	var sinceTime time.Time
+	if *since != "" {
+		t, err := time.Parse(aml.DateLayout, *since)
+		if err != nil {
+			return fmt.Errorf("invalid -since date: %v", err)
+		}
+		sinceTime = t
+	}
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	var stats []detect.BaselineStat
+	if err := st.Load(ctx, detect.BaselinesTable, &stats); err != nil {
+		return fmt.Errorf("failed to load customer baselines: %v", err)
+	}
+	baselines := detect.NewBaselines(stats)
+	watermark := baselines.Watermark()
+	if watermark.IsZero() {
+		cli.Status("No baselines yet; building them from the full history")
+	} else {
+		cli.Status(fmt.Sprintf("Baselines for %d customers up to %s", baselines.Customers(), watermark.Format(aml.TransactionTimeLayout)))
+	}
+
+	startedAt := time.Now().UTC()
+	cli.Processing("Loading new transactions...")
+	transactions, err := st.Transactions(ctx, watermark)
+	if err != nil {
+		return err
+	}
+	if len(transactions) == 0 {
+		cli.Success("Baselines are up to date")
+		return nil
+	}
+
+	alerts := detect.UpdateBaselines(config, baselines, transactions, sinceTime)
+	detect.SortAlerts(alerts)
+	cli.Status(fmt.Sprintf("%s new transactions, %d deviation alerts", cli.FormatNumber(int64(len(transactions))), len(alerts)))
+	batch, err := intake.Prepare(ctx, st, configstore.Run{
+		Source:         "baseline update",
+		ConfigChecksum: configstore.Checksum(content, nil),
+		Config:         content,
+		Since:          sinceTime,
+		StartedAt:      startedAt,
+		Transactions:   int64(len(transactions)),
+	}, alerts, transactions, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	for _, note := range batch.Notes() {
+		cli.Status(note)
+	}
+
+	if *dryRun {
+		for _, alert := range batch.Alerts {
+			fmt.Printf("   • %s %s [%s %d] %s\n", alert.AlertDate, alert.CustomerID, alert.AlertType, alert.RiskScore, alert.Description)
+		}
+		cli.Warning("Dry run: no alerts or baselines stored")
+		return nil
+	}
+
+	if err := batch.Insert(ctx, st, time.Now().UTC()); err != nil {
+		return err
+	}
+	if err := st.Replace(ctx, detect.BaselinesTable, baselines.Stats(time.Now().UTC())); err != nil {
+		return fmt.Errorf("failed to store customer baselines: %v", err)
+	}
+	cli.Success(fmt.Sprintf("Updated baselines for %d customers and inserted %d alerts (run %d)", baselines.Customers(), len(batch.Alerts), batch.Run.RunID))
+	return nil
+}
+
+// runShow prints a customer's stored baseline
+func runShow(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("show", flag.ExitOnError)
+	customer := flags.String("customer", "", "customer ID (First_Last)")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	if *customer == "" {
+		return fmt.Errorf("-customer is required")
+	}
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	var stats []detect.BaselineStat
+	if err := st.Load(ctx, detect.BaselinesTable, &stats); err != nil {
+		return fmt.Errorf("failed to load customer baselines: %v", err)
+	}
+
+	person := ""
+	for _, stat := range stats {
+		if !strings.EqualFold(stat.CustomerID, *customer) {
+			continue
+		}
+		if stat.PersonKey != person {
+			person = stat.PersonKey
+			fmt.Printf("   %s (last transaction %s)\n", person, stat.LastTransaction.Format(aml.TransactionTimeLayout))
+		}
+		switch stat.Dimension {
+		case detect.DimensionAmount, detect.DimensionDistance:
+			mean := stat.ValueSum / float64(stat.TransactionCount)
+			fmt.Printf("     %-9s mean %.2f over %d transactions\n", stat.Dimension, mean, stat.TransactionCount)
+		default:
+			fmt.Printf("     %-9s %-15s %d\n", stat.Dimension, stat.Bucket, stat.TransactionCount)
+		}
+	}
+	if person == "" {
+		cli.Warning(fmt.Sprintf("No baseline for %s", *customer))
+	}
+	return nil
+}
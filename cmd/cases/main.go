@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/cases"
+	"aml-system/internal/cli"
+	"aml-system/internal/evidence"
+	"aml-system/internal/store"
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  cases list [-status S] [-assignee NAME|-] [-type T] [-priority P] [-customer ID] [-open] [-overdue] [-limit 50] [-local dir]")
+	fmt.Println("  cases show -case N [-local dir]")
+	fmt.Println("  cases assign -case N -to NAME -by NAME [-local dir]")
+	fmt.Println("  cases move -case N -status IN_REVIEW|ESCALATED|CLOSED_FALSE_POSITIVE|CLOSED_SAR_FILED -by NAME [-note TEXT] [-local dir]")
+	fmt.Println("  cases due -case N -date YYYY-MM-DD -by NAME [-note TEXT] [-local dir]")
+	fmt.Println("  cases note -case N -by NAME -text TEXT [-local dir]")
+	fmt.Println("  cases summary [-local dir]")
+	fmt.Println("  cases consolidate [-period 7] [-local dir]")
+	fmt.Println("  cases evidence -alert N [-out evidence_alert_N.zip] [-local dir]")
+}
+
+func main() {
+	cli.Title("🏦 AML Case Management")
+	cli.Title(strings.Repeat("=", 50))
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	var err error
+	switch os.Args[1] {
+	case "list":
+		err = runList(ctx, os.Args[2:])
+	case "show":
+		err = runShow(ctx, os.Args[2:])
+	case "assign":
+		err = runAssign(ctx, os.Args[2:])
+	case "move":
+		err = runMove(ctx, os.Args[2:])
+	case "due":
+		err = runDue(ctx, os.Args[2:])
+	case "note":
+		err = runNote(ctx, os.Args[2:])
+	case "summary":
+		err = runSummary(ctx, os.Args[2:])
+	case "consolidate":
+		err = runConsolidate(ctx, os.Args[2:])
+	case "evidence":
+		err = runEvidence(ctx, os.Args[2:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		cli.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// openBook opens the store and loads the cases
+func openBook(ctx context.Context, local string) (store.Store, *cases.Book, error) {
+	st, err := cli.OpenStore(ctx, local)
+	if err != nil {
+		return nil, nil, err
+	}
+	book, err := cases.Load(ctx, st)
+	if err != nil {
+		st.Close()
+		return nil, nil, err
+	}
+	return st, book, nil
+}
+
+// runList prints the work queue, earliest due first
+func runList(ctx context.Context, args []string) error {
+	var filter cases.Filter
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	flags.StringVar(&filter.Status, "status", "", "only cases in this status")
+	flags.StringVar(&filter.Assignee, "assignee", "", "only cases assigned to this analyst (- for unassigned)")
+	flags.StringVar(&filter.AlertType, "type", "", "only cases with an alert of this type")
+	flags.StringVar(&filter.Priority, "priority", "", "only cases with this priority of their aggregate score")
+	flags.StringVar(&filter.CustomerID, "customer", "", "only this customer")
+	flags.BoolVar(&filter.Open, "open", false, "only cases not yet closed")
+	overdue := flags.Bool("overdue", false, "only open cases past their due date")
+	limit := flags.Int("limit", 50, "maximum cases to print (0 for all)")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	now := time.Now().UTC()
+	if *overdue {
+		filter.OverdueOn = now
+	}
+
+	st, book, err := openBook(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	matched := book.List(filter)
+	if len(matched) == 0 {
+		cli.Warning("No matching cases")
+		return nil
+	}
+	shown := matched
+	if *limit > 0 && len(shown) > *limit {
+		shown = shown[:*limit]
+	}
+
+	fmt.Printf("%7s  %-21s  %-24s  %6s  %5s  %-6s  %-16s  %-10s  %s\n", "CASE", "STATUS", "CUSTOMER", "ALERTS", "SCORE", "PRIO", "ASSIGNEE", "DUE", "TYPES")
+	for _, c := range shown {
+		due := c.DueDate
+		if c.Overdue(now) {
+			due += "!"
+		}
+		assignee := c.Assignee
+		if assignee == "" {
+			assignee = "-"
+		}
+		fmt.Printf("%7d  %-21s  %-24s  %6d  %5d  %-6s  %-16s  %-10s  %s\n", c.CaseID, c.Status, c.CustomerID,
+			len(c.AlertIDs), c.AggregateScore, c.CasePriority, assignee, due, strings.Join(c.AlertTypes, ","))
+	}
+	if len(shown) < len(matched) {
+		cli.Status(fmt.Sprintf("Showing %d of %d cases (-limit 0 for all)", len(shown), len(matched)))
+	}
+	return nil
+}
+
+// runShow prints a case with its history
+func runShow(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("show", flag.ExitOnError)
+	caseID := flags.Int64("case", 0, "case ID, or the ID of any of its alerts")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, book, err := openBook(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	c, err := book.Get(*caseID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	fmt.Printf("   • Case:       %d (%s, aggregate score %d from %s)\n", c.CaseID, c.CasePriority, c.AggregateScore, plural(len(c.AlertIDs), "alert"))
+	fmt.Printf("   • Customer:   %s\n", c.CustomerID)
+	fmt.Printf("   • Dates:      %s to %s, detected %s\n", c.FirstDate, c.LastDate, c.DetectionDate)
+	fmt.Printf("   • Amount:     $%s\n", aml.FormatAmount(c.CaseAmount))
+	fmt.Printf("   • Status:     %s\n", c.Status)
+	if next := cases.Next(c.Status); len(next) > 0 {
+		fmt.Printf("   • Next:       %s\n", strings.Join(next, ", "))
+	}
+	if c.Assignee != "" {
+		fmt.Printf("   • Assignee:   %s\n", c.Assignee)
+	}
+	due := c.DueDate
+	if c.Overdue(now) {
+		due += " (overdue)"
+	}
+	fmt.Printf("   • Due:        %s\n", due)
+
+	fmt.Println()
+	cli.Status("Alerts:")
+	for _, alert := range c.Alerts {
+		fmt.Printf("   • %d %s %s [%d %s] %s\n", alert.AlertID, alert.AlertDate, alert.AlertType, alert.RiskScore, alert.Priority, alert.Description)
+	}
+	if len(c.Events) == 0 {
+		cli.Status("No changes since the case was opened")
+		return nil
+	}
+	fmt.Println()
+	cli.Status("History:")
+	for _, e := range c.Events {
+		fmt.Printf("   • %s  %-16s %s\n", e.At.Format("2006-01-02 15:04"), e.Actor, describe(e))
+	}
+	return nil
+}
+
+// describe renders an event for the case history
+func describe(e cases.Event) string {
+	var text string
+	switch e.EventType {
+	case cases.EventStatus:
+		text = fmt.Sprintf("%s → %s", e.FromStatus, e.ToStatus)
+	case cases.EventAssign:
+		text = "assigned to " + e.Assignee
+	case cases.EventDueDate:
+		text = "due " + e.DueDate
+	case cases.EventNote:
+		return "note: " + e.Note
+	}
+	if e.Note != "" {
+		text += ": " + e.Note
+	}
+	return text
+}
+
+// runAssign hands a case to an analyst
+func runAssign(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("assign", flag.ExitOnError)
+	caseID := flags.Int64("case", 0, "case ID, or the ID of any of its alerts")
+	to := flags.String("to", "", "analyst the case is assigned to")
+	by := flags.String("by", "", "who makes the assignment")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, book, err := openBook(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	event, err := cases.Assign(ctx, st, book, *caseID, *to, *by, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	cli.Success(fmt.Sprintf("Case %d assigned to %s", event.AlertID, *to))
+	return nil
+}
+
+// runMove changes the status of a case
+func runMove(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("move", flag.ExitOnError)
+	caseID := flags.Int64("case", 0, "case ID, or the ID of any of its alerts")
+	status := flags.String("status", "", "new status")
+	by := flags.String("by", "", "analyst making the change")
+	note := flags.String("note", "", "reason, required to close a case")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, book, err := openBook(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	event, err := cases.Transition(ctx, st, book, *caseID, *status, *by, *note, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	cli.Success(fmt.Sprintf("Case %d moved from %s to %s", event.AlertID, event.FromStatus, event.ToStatus))
+	return nil
+}
+
+// runDue sets the date a case must be decided by
+func runDue(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("due", flag.ExitOnError)
+	caseID := flags.Int64("case", 0, "case ID, or the ID of any of its alerts")
+	date := flags.String("date", "", "due date (YYYY-MM-DD)")
+	by := flags.String("by", "", "analyst making the change")
+	note := flags.String("note", "", "reason for the change")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	due, err := time.Parse(aml.DateLayout, *date)
+	if err != nil {
+		return fmt.Errorf("invalid -date: %v", err)
+	}
+
+	st, book, err := openBook(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	event, err := cases.SetDueDate(ctx, st, book, *caseID, due, *by, *note, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	cli.Success(fmt.Sprintf("Case %d is due %s", event.AlertID, *date))
+	return nil
+}
+
+// runNote adds a note to a case
+func runNote(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("note", flag.ExitOnError)
+	caseID := flags.Int64("case", 0, "case ID, or the ID of any of its alerts")
+	by := flags.String("by", "", "analyst writing the note")
+	text := flags.String("text", "", "note")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, book, err := openBook(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	event, err := cases.AddNote(ctx, st, book, *caseID, *text, *by, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	cli.Success(fmt.Sprintf("Note added to case %d", event.AlertID))
+	return nil
+}
+
+// runSummary counts cases by status and the open workload by assignee
+func runSummary(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("summary", flag.ExitOnError)
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, book, err := openBook(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	now := time.Now().UTC()
+	byStatus := map[string]int{}
+	workload := map[string]int{}
+	overdue := 0
+	for _, c := range book.List(cases.Filter{}) {
+		byStatus[c.Status]++
+		if cases.Closed(c.Status) {
+			continue
+		}
+		assignee := c.Assignee
+		if assignee == "" {
+			assignee = "(unassigned)"
+		}
+		workload[assignee]++
+		if c.Overdue(now) {
+			overdue++
+		}
+	}
+
+	cli.Status("Cases by status:")
+	for _, status := range cases.Statuses {
+		fmt.Printf("   • %-22s %s\n", status, cli.FormatNumber(int64(byStatus[status])))
+	}
+
+	var assignees []string
+	for assignee := range workload {
+		assignees = append(assignees, assignee)
+	}
+	sort.Strings(assignees)
+	fmt.Println()
+	cli.Status("Open cases by assignee:")
+	for _, assignee := range assignees {
+		fmt.Printf("   • %-22s %s\n", assignee, cli.FormatNumber(int64(workload[assignee])))
+	}
+	if overdue > 0 {
+		cli.Warning(fmt.Sprintf("%d open cases are overdue", overdue))
+	}
+	return nil
+}
+
+// runConsolidate puts the alerts not yet in a case into their customer's
+// open case, or a new one
+func runConsolidate(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("consolidate", flag.ExitOnError)
+	period := flags.Int("period", cases.DefaultPeriodDays, "days between a customer's alerts for them to share a case")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, book, err := openBook(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	links, err := cases.Consolidate(ctx, st, book, *period, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if len(links) == 0 {
+		cli.Success("Every alert is in a case")
+		return nil
+	}
+	opened := 0
+	for _, link := range links {
+		if link.CaseID == link.AlertID {
+			opened++
+		}
+	}
+	cli.Success(fmt.Sprintf("Consolidated %d alerts: %d new cases, %d added to existing ones", len(links), opened, len(links)-opened))
+	return nil
+}
+
+// runEvidence writes the evidence package of an alert
+func runEvidence(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("evidence", flag.ExitOnError)
+	alertID := flags.Int64("alert", 0, "alert to package")
+	out := flags.String("out", "", "archive to write (default: evidence_alert_<alert>.zip)")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	pkg, err := evidence.Gather(ctx, st, *alertID, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	data, err := pkg.Archive()
+	if err != nil {
+		return err
+	}
+	path := *out
+	if path == "" {
+		path = pkg.Name() + ".zip"
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+
+	fmt.Printf("   • Alert:        %d %s %s, case %d (%s)\n", pkg.Alert.AlertID, pkg.Alert.AlertDate, pkg.Alert.AlertType, pkg.Case.CaseID, pkg.Case.Status)
+	fmt.Printf("   • Transactions: %d\n", len(pkg.Transactions))
+	if len(pkg.Missing) > 0 {
+		fmt.Printf("   • Not found:    %s (not card transactions)\n", strings.Join(pkg.Missing, ", "))
+	}
+	if pkg.Run != nil {
+		fmt.Printf("   • Run:          %d (%s)\n", pkg.Run.RunID, pkg.Run.Source)
+	}
+	if pkg.Version != nil {
+		fmt.Printf("   • Config:       version %d\n", pkg.Version.Version)
+	}
+	sum := sha256.Sum256(data)
+	fmt.Printf("   • SHA-256:      %s\n", hex.EncodeToString(sum[:]))
+	cli.Success(fmt.Sprintf("Evidence package written to %s", path))
+	if pkg.ByDate {
+		cli.Warning("The detector did not record the triggering transactions; the package has the customer's transactions on the alert date")
+	}
+	return nil
+}
+
+// plural renders "1 alert" / "3 alerts"
+func plural(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/cli"
+	"aml-system/internal/configstore"
+	"aml-system/internal/detect"
+	"aml-system/internal/rules"
+	"aml-system/internal/store"
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  configs propose -author NAME -rationale TEXT -effective YYYY-MM-DD [-config config/aml_config.json] [-rules config/rules] [-local dir]")
+	fmt.Println("  configs approve -version N -approver NAME [-comment TEXT] [-local dir]")
+	fmt.Println("  configs reject -version N -approver NAME -comment TEXT [-local dir]")
+	fmt.Println("  configs activate -version N -by NAME [-comment TEXT] [-local dir]")
+	fmt.Println("  configs list [-local dir]")
+	fmt.Println("  configs show [-version N] [-local dir]")
+	fmt.Println("  configs checkout [-version N] [-config config/aml_config.json] [-rules config/rules] [-local dir]")
+	fmt.Println("  configs runs [-limit 20] [-local dir]")
+}
+
+func main() {
+	cli.Title("🏦 AML Configuration Versions")
+	cli.Title(strings.Repeat("=", 50))
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	var err error
+	switch os.Args[1] {
+	case "propose":
+		err = runPropose(ctx, os.Args[2:])
+	case "approve":
+		err = runDecide(ctx, "approve", configstore.ActionApprove, os.Args[2:])
+	case "reject":
+		err = runDecide(ctx, "reject", configstore.ActionReject, os.Args[2:])
+	case "activate":
+		err = runActivate(ctx, os.Args[2:])
+	case "list":
+		err = runList(ctx, os.Args[2:])
+	case "show":
+		err = runShow(ctx, os.Args[2:])
+	case "checkout":
+		err = runCheckout(ctx, os.Args[2:])
+	case "runs":
+		err = runRuns(ctx, os.Args[2:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		cli.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// openRegistry opens the store and loads the configuration versions
+func openRegistry(ctx context.Context, local string) (store.Store, *configstore.Registry, error) {
+	st, err := cli.OpenStore(ctx, local)
+	if err != nil {
+		return nil, nil, err
+	}
+	registry, err := configstore.Load(ctx, st)
+	if err != nil {
+		st.Close()
+		return nil, nil, err
+	}
+	return st, registry, nil
+}
+
+// runPropose snapshots the config file and rule files as a new version
+func runPropose(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("propose", flag.ExitOnError)
+	author := flags.String("author", "", "who proposes the change")
+	rationale := flags.String("rationale", "", "why the thresholds or rules change")
+	effective := flags.String("effective", "", "date the version takes effect once activated (YYYY-MM-DD)")
+	configPath := flags.String("config", detect.DefaultConfigPath, "detector config file")
+	rulesDir := flags.String("rules", rules.DefaultDir, "rules directory")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	var effectiveDate time.Time
+	if *effective != "" {
+		t, err := time.Parse(aml.DateLayout, *effective)
+		if err != nil {
+			return fmt.Errorf("invalid -effective date: %v", err)
+		}
+		effectiveDate = t
+	}
+	config, files, err := configstore.Snapshot(*configPath, *rulesDir)
+	if err != nil {
+		return err
+	}
+
+	st, registry, err := openRegistry(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	version, err := configstore.Propose(ctx, st, registry, *author, *rationale, effectiveDate, config, files, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if version.BasedOn > 0 {
+		cli.Status(fmt.Sprintf("Based on active version %d", version.BasedOn))
+	}
+	cli.Success(fmt.Sprintf("Proposed version %d (%s, %d rules, effective %s); it needs approval by someone other than %s",
+		version.Version, version.Checksum, len(version.Rules), version.EffectiveDate, version.Author))
+	return nil
+}
+
+// runDecide approves or rejects a proposed version
+func runDecide(ctx context.Context, name, action string, args []string) error {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	version := flags.Int64("version", 0, "version to "+name)
+	approver := flags.String("approver", "", "who signs off the decision")
+	comment := flags.String("comment", "", "comment recorded with the decision")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, registry, err := openRegistry(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	if _, err := configstore.Decide(ctx, st, registry, *version, action, *approver, *comment, time.Now().UTC()); err != nil {
+		return err
+	}
+	if action == configstore.ActionReject {
+		cli.Warning(fmt.Sprintf("Version %d rejected by %s", *version, *approver))
+		return nil
+	}
+	cli.Success(fmt.Sprintf("Version %d approved by %s", *version, *approver))
+	return nil
+}
+
+// runActivate puts an approved version in force from its effective date
+func runActivate(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("activate", flag.ExitOnError)
+	version := flags.Int64("version", 0, "version to activate")
+	by := flags.String("by", "", "who activates the version")
+	comment := flags.String("comment", "", "comment recorded with the activation")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, registry, err := openRegistry(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	now := time.Now().UTC()
+	if _, err := configstore.Activate(ctx, st, registry, *version, *by, *comment, now); err != nil {
+		return err
+	}
+	v, _ := registry.Get(*version)
+	if registry.Status(v, now) == configstore.StatusScheduled {
+		cli.Success(fmt.Sprintf("Version %d scheduled to take effect on %s", *version, v.EffectiveDate))
+	} else {
+		cli.Success(fmt.Sprintf("Version %d is active", *version))
+	}
+	cli.Status("Regenerate the processing SQL from it with: configs checkout, then make rules-sql")
+	return nil
+}
+
+// runList prints every version with its status and sign-off
+func runList(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, registry, err := openRegistry(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	if len(registry.Versions) == 0 {
+		cli.Warning("No config versions; detection uses the config files")
+		return nil
+	}
+	now := time.Now().UTC()
+	fmt.Printf("%7s  %-10s  %-10s  %-12s  %-16s  %-16s  %s\n", "VERSION", "STATUS", "EFFECTIVE", "CHECKSUM", "AUTHOR", "APPROVER", "RATIONALE")
+	for _, v := range registry.Versions {
+		approver := "-"
+		if e, ok := registry.Approval(v.Version); ok {
+			approver = e.Actor
+		}
+		fmt.Printf("%7d  %-10s  %-10s  %-12s  %-16s  %-16s  %s\n",
+			v.Version, registry.Status(v, now), v.EffectiveDate, v.Checksum, v.Author, approver, v.Rationale)
+	}
+	return nil
+}
+
+// runShow prints a version, by default the active one, with its history
+func runShow(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("show", flag.ExitOnError)
+	version := flags.Int64("version", 0, "version to show (default: active)")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, registry, err := openRegistry(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	now := time.Now().UTC()
+	v, err := selectVersion(registry, *version, now)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("   • Version:    %d (%s)\n", v.Version, registry.Status(v, now))
+	fmt.Printf("   • Checksum:   %s\n", v.Checksum)
+	fmt.Printf("   • Author:     %s, %s\n", v.Author, v.ProposedAt.Format(time.RFC3339))
+	fmt.Printf("   • Rationale:  %s\n", v.Rationale)
+	fmt.Printf("   • Effective:  %s\n", v.EffectiveDate)
+	if v.BasedOn > 0 {
+		fmt.Printf("   • Based on:   version %d\n", v.BasedOn)
+	}
+	for _, e := range registry.Events {
+		if e.Version == v.Version {
+			line := fmt.Sprintf("   • %-10s  %s by %s", eventLabels[e.Action]+":", e.At.Format(time.RFC3339), e.Actor)
+			if e.Comment != "" {
+				line += ": " + e.Comment
+			}
+			fmt.Println(line)
+		}
+	}
+	fmt.Println()
+	fmt.Println(v.Config)
+	for _, file := range v.Rules {
+		fmt.Printf("\n# %s\n%s", file.File, file.Content)
+	}
+	return nil
+}
+
+var eventLabels = map[string]string{
+	configstore.ActionApprove:  "Approved",
+	configstore.ActionReject:   "Rejected",
+	configstore.ActionActivate: "Activated",
+}
+
+// selectVersion returns the numbered version, or the active one for 0
+func selectVersion(registry *configstore.Registry, version int64, now time.Time) (configstore.Version, error) {
+	if version != 0 {
+		return registry.Get(version)
+	}
+	v, ok := registry.Active(now)
+	if !ok {
+		return v, fmt.Errorf("no config version is active")
+	}
+	return v, nil
+}
+
+// runCheckout writes a version, by default the active one, to the config
+// file and rules directory so the SQL can be regenerated from it
+func runCheckout(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("checkout", flag.ExitOnError)
+	version := flags.Int64("version", 0, "version to write (default: active)")
+	configPath := flags.String("config", detect.DefaultConfigPath, "detector config file to write")
+	rulesDir := flags.String("rules", rules.DefaultDir, "rules directory to write")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, registry, err := openRegistry(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	v, err := selectVersion(registry, *version, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if err := v.Checkout(*configPath, *rulesDir); err != nil {
+		return err
+	}
+	cli.Success(fmt.Sprintf("Wrote version %d to %s and %s", v.Version, *configPath, *rulesDir))
+	return nil
+}
+
+// runRuns prints the latest detection runs and their config versions
+func runRuns(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("runs", flag.ExitOnError)
+	limit := flags.Int("limit", 20, "number of runs to show")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	var runs []configstore.Run
+	if err := st.Load(ctx, configstore.RunsTable, &runs); err != nil {
+		return fmt.Errorf("failed to load detection runs: %v", err)
+	}
+	if len(runs) == 0 {
+		cli.Warning("No detection runs recorded")
+		return nil
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].RunID < runs[j].RunID })
+	if len(runs) > *limit {
+		runs = runs[len(runs)-*limit:]
+	}
+	fmt.Printf("%5s  %-20s  %-16s  %7s  %-12s  %12s  %7s  %10s  %s\n", "RUN", "STARTED", "SOURCE", "VERSION", "CHECKSUM", "TRANSACTIONS", "ALERTS", "SUPPRESSED", "ALERT IDS")
+	for _, run := range runs {
+		version := "files"
+		if run.ConfigVersion > 0 {
+			version = fmt.Sprint(run.ConfigVersion)
+		}
+		ids := "-"
+		if run.Alerts > 0 {
+			ids = fmt.Sprintf("%d-%d", run.FirstAlertID, run.LastAlertID)
+		}
+		fmt.Printf("%5d  %-20s  %-16s  %7s  %-12s  %12s  %7d  %10d  %s\n", run.RunID, run.StartedAt.Format("2006-01-02 15:04:05"),
+			run.Source, version, run.ConfigChecksum, cli.FormatNumber(run.Transactions), run.Alerts, run.Suppressed, ids)
+	}
+	return nil
+}
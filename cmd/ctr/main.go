@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/cli"
+	"aml-system/internal/ctr"
+	"aml-system/internal/fincen"
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  ctr build [-since YYYY-MM-DD] [-threshold 10000] [-cutoff-hour 0] [-roll-weekends] [-categories a,b] [-local dir]")
+	fmt.Println("  ctr list [-status PENDING|EXPORTED|VOID] [-local dir]")
+	fmt.Println("  ctr export -out ctr_batch.xml [-filer config/fincen_filer.json] [-from YYYY-MM-DD] [-to YYYY-MM-DD] [-local dir]")
+}
+
+func main() {
+	cli.Title("🏦 AML Currency Transaction Reports")
+	cli.Title(strings.Repeat("=", 50))
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	var err error
+	switch os.Args[1] {
+	case "build":
+		err = runBuild(ctx, os.Args[2:])
+	case "list":
+		err = runList(ctx, os.Args[2:])
+	case "export":
+		err = runExport(ctx, os.Args[2:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		cli.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// runBuild aggregates daily activity and refreshes the ctr_candidates table
+func runBuild(ctx context.Context, args []string) error {
+	config := ctr.DefaultConfig()
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	since := flags.String("since", "", "only aggregate transactions on or after this date (YYYY-MM-DD)")
+	flags.Float64Var(&config.Threshold, "threshold", config.Threshold, "daily total that must be exceeded")
+	flags.IntVar(&config.CutoffHour, "cutoff-hour", config.CutoffHour, "hour the business day ends (0 = midnight)")
+	flags.BoolVar(&config.RollWeekends, "roll-weekends", config.RollWeekends, "count weekend activity towards Monday")
+	categories := flags.String("categories", "", "comma-separated merchant categories treated as cash (default: all)")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	if config.CutoffHour < 0 || config.CutoffHour > 23 {
+		return fmt.Errorf("cutoff-hour must be between 0 and 23")
+	}
+	if *categories != "" {
+		for _, category := range strings.Split(*categories, ",") {
+			config.CashCategories = append(config.CashCategories, strings.TrimSpace(category))
+		}
+	}
+	var sinceTime time.Time
+	if *since != "" {
+		t, err := time.Parse(aml.DateLayout, *since)
+		if err != nil {
+			return fmt.Errorf("invalid -since date: %v", err)
+		}
+		sinceTime = t
+	}
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	// A business day is recomputed from all of its transactions, so a rerun
+	// from -since also reads the part of its first day before it. Transactions
+	// reads strictly after its time, so start just before the day does.
+	cli.Processing("Aggregating daily activity per customer...")
+	var from string
+	if !sinceTime.IsZero() {
+		from = config.BusinessDay(sinceTime)
+		sinceTime = config.DayStart(sinceTime).Add(-time.Nanosecond)
+	}
+	transactions, err := st.Transactions(ctx, sinceTime)
+	if err != nil {
+		return err
+	}
+	fresh := ctr.Aggregate(transactions, config)
+	cli.Status(fmt.Sprintf("%s transactions, %d customer-days over $%s",
+		cli.FormatNumber(int64(len(transactions))), len(fresh), aml.FormatAmount(config.Threshold)))
+
+	var existing []ctr.Candidate
+	if err := st.Load(ctx, ctr.CandidatesTable, &existing); err != nil {
+		return fmt.Errorf("failed to load CTR candidates: %v", err)
+	}
+	nextID, err := st.NextID(ctx, ctr.CandidatesTable, "candidate_id")
+	if err != nil {
+		return err
+	}
+
+	merged, changed := ctr.Merge(existing, fresh, from, nextID, time.Now().UTC())
+	if changed == 0 {
+		cli.Success("CTR candidates are up to date")
+		return nil
+	}
+	if err := st.Replace(ctx, ctr.CandidatesTable, merged); err != nil {
+		return fmt.Errorf("failed to store CTR candidates: %v", err)
+	}
+
+	cli.Success(fmt.Sprintf("Added, updated or voided %d CTR candidates", changed))
+	return nil
+}
+
+func runList(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	status := flags.String("status", ctr.StatusPending, "candidate status to show (empty for all)")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	var candidates []ctr.Candidate
+	if err := st.Load(ctx, ctr.CandidatesTable, &candidates); err != nil {
+		return fmt.Errorf("failed to load CTR candidates: %v", err)
+	}
+
+	shown := 0
+	for _, candidate := range candidates {
+		if *status != "" && candidate.Status != strings.ToUpper(*status) {
+			continue
+		}
+		fmt.Printf("   • #%d %s [%s]\n", candidate.CandidateID, candidate.Describe(), candidate.Status)
+		shown++
+	}
+	if shown == 0 {
+		cli.Warning("No CTR candidates found")
+	}
+	return nil
+}
+
+// runExport writes pending candidates to a FinCEN CTR batch file and marks
+// them as exported
+func runExport(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	out := flags.String("out", "", "output XML file")
+	filerPath := flags.String("filer", fincen.DefaultFilerConfig, "filing institution config")
+	from := flags.String("from", "", "first business date to export (YYYY-MM-DD)")
+	to := flags.String("to", "", "last business date to export (YYYY-MM-DD)")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	if *out == "" {
+		return fmt.Errorf("-out is required")
+	}
+	filer, err := fincen.LoadFiler(*filerPath)
+	if err != nil {
+		return err
+	}
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	var candidates []ctr.Candidate
+	if err := st.Load(ctx, ctr.CandidatesTable, &candidates); err != nil {
+		return fmt.Errorf("failed to load CTR candidates: %v", err)
+	}
+
+	var selected []int
+	var batch []ctr.Candidate
+	for i, candidate := range candidates {
+		if candidate.Status != ctr.StatusPending {
+			continue
+		}
+		if (*from != "" && candidate.BusinessDate < *from) || (*to != "" && candidate.BusinessDate > *to) {
+			continue
+		}
+		selected = append(selected, i)
+		batch = append(batch, candidate)
+	}
+	if len(batch) == 0 {
+		cli.Warning("No pending CTR candidates to export")
+		return nil
+	}
+
+	now := time.Now().UTC()
+	data, err := fincen.CTRBatch(filer, batch, now)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", *out, err)
+	}
+
+	batchID := "CTR-" + now.Format("20060102150405")
+	for _, i := range selected {
+		candidates[i].Status = ctr.StatusExported
+		candidates[i].BatchID = batchID
+	}
+	if err := st.Replace(ctx, ctr.CandidatesTable, candidates); err != nil {
+		return fmt.Errorf("failed to mark CTR candidates as exported: %v", err)
+	}
+
+	cli.Success(fmt.Sprintf("Exported %d CTRs to %s (batch %s)", len(batch), *out, batchID))
+	return nil
+}
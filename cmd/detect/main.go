@@ -0,0 +1,581 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/backtest"
+	"aml-system/internal/cli"
+	"aml-system/internal/configstore"
+	"aml-system/internal/detect"
+	"aml-system/internal/intake"
+	"aml-system/internal/merchant"
+	"aml-system/internal/rules"
+	"aml-system/internal/store"
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  detect run [-version N | -config config/aml_config.json -rules config/rules] [-since YYYY-MM-DD] [-detectors STRUCTURING,...] [-dry-run] [-local dir]")
+	fmt.Println("  detect peers [-config config/aml_config.json] [-dry-run] [-local dir]")
+	fmt.Println("  detect backtest -from YYYY-MM-DD -to YYYY-MM-DD [-configs config/aml_config.json,...] [-rules config/rules] [-detectors STRUCTURING,...] [-alert-cost 25] [-json report.json] [-local dir]")
+	fmt.Println("  detect tune -detector VELOCITY -param velocity_windows.0.min_transactions -values 3,4,5,6 -from YYYY-MM-DD -to YYYY-MM-DD [-choose 5] [-config config/aml_config.json] [-alert-cost 25] [-report tuning.md] [-json tuning.json] [-local dir]")
+	fmt.Println("  detect config [-config config/aml_config.json]")
+}
+
+func main() {
+	cli.Title("🏦 AML Detection Engine")
+	cli.Title(strings.Repeat("=", 50))
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	var err error
+	switch os.Args[1] {
+	case "run":
+		err = runDetect(ctx, os.Args[2:])
+	case "peers":
+		err = runPeers(ctx, os.Args[2:])
+	case "backtest":
+		err = runBacktest(ctx, os.Args[2:])
+	case "tune":
+		err = runTune(ctx, os.Args[2:])
+	case "config":
+		err = runConfig(os.Args[2:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		cli.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// runDetect runs the Go detectors over transactions after -since (plus the
+// history the detectors need), inserts the resulting alerts and records the
+// run with the config version it used
+func runDetect(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	version := flags.Int64("version", 0, "config version to run (default: the active version)")
+	configPath := flags.String("config", detect.DefaultConfigPath, "detector config file, used when no version is active or when given")
+	rulesDir := flags.String("rules", rules.DefaultDir, "directory of rule files run alongside the detectors, used like -config")
+	since := flags.String("since", "", "only alert on activity after this date (YYYY-MM-DD, default: all)")
+	names := flags.String("detectors", "", "comma-separated detectors to run (default: all)")
+	dryRun := flags.Bool("dry-run", false, "print alerts without storing them")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	fromFiles := false
+	flags.Visit(func(f *flag.Flag) {
+		fromFiles = fromFiles || f.Name == "config" || f.Name == "rules"
+	})
+	if fromFiles && *version != 0 {
+		return fmt.Errorf("-version cannot be combined with -config or -rules")
+	}
+
+	var sinceTime time.Time
+	if *since != "" {
+		t, err := time.Parse(aml.DateLayout, *since)
+		if err != nil {
+			return fmt.Errorf("invalid -since date: %v", err)
+		}
+		sinceTime = t
+	}
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	startedAt := time.Now().UTC()
+	settings, err := loadSettings(ctx, st, *version, *configPath, *rulesDir, fromFiles, startedAt)
+	if err != nil {
+		return err
+	}
+	reference, err := loadReference(ctx, st)
+	if err != nil {
+		return err
+	}
+	var selectedNames []string
+	if *names != "" {
+		selectedNames = strings.Split(*names, ",")
+	}
+	detectors, err := detect.Select(allDetectors(settings.config, reference, settings.rules), selectedNames)
+	if err != nil {
+		return err
+	}
+
+	historyStart := sinceTime
+	if lookback := detect.Lookback(detectors); lookback == detect.FullHistory {
+		historyStart = time.Time{}
+	} else if !sinceTime.IsZero() {
+		historyStart = sinceTime.Add(-lookback)
+	}
+
+	cli.Processing("Loading transactions...")
+	transactions, err := st.Transactions(ctx, historyStart)
+	if err != nil {
+		return err
+	}
+	cli.Status(fmt.Sprintf("Running %d detectors over %s transactions", len(detectors), cli.FormatNumber(int64(len(transactions)))))
+
+	alerts := detect.Run(detectors, transactions, sinceTime)
+	batch, err := intake.Prepare(ctx, st, configstore.Run{
+		Source:         "detect run",
+		ConfigVersion:  settings.version,
+		ConfigChecksum: settings.checksum,
+		Config:         settings.content,
+		Rules:          settings.files,
+		Since:          sinceTime,
+		StartedAt:      startedAt,
+		Transactions:   int64(len(transactions)),
+	}, alerts, transactions, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	for _, note := range batch.Notes() {
+		cli.Status(note)
+	}
+	counts := map[string]int{}
+	for _, alert := range batch.Alerts {
+		counts[alert.AlertType]++
+	}
+	types := make([]string, 0, len(counts))
+	for alertType := range counts {
+		types = append(types, alertType)
+	}
+	sort.Strings(types)
+	for _, alertType := range types {
+		cli.Status(fmt.Sprintf("%-14s %d alerts", alertType, counts[alertType]))
+	}
+
+	if *dryRun {
+		for _, alert := range batch.Alerts {
+			fmt.Printf("   • %s %s [%s %d] %s\n", alert.AlertDate, alert.CustomerID, alert.AlertType, alert.RiskScore, alert.Description)
+		}
+		cli.Warning("Dry run: no alerts stored")
+		return nil
+	}
+
+	if err := batch.Insert(ctx, st, time.Now().UTC()); err != nil {
+		return err
+	}
+	if len(batch.Alerts) == 0 {
+		cli.Success(fmt.Sprintf("No new alerts (run %d)", batch.Run.RunID))
+		return nil
+	}
+	cli.Success(fmt.Sprintf("Inserted %d alerts (run %d) into %d cases", len(batch.Alerts), batch.Run.RunID, batch.Cases()))
+	return nil
+}
+
+// settings is the detector configuration and rules a run uses
+type settings struct {
+	config   detect.Config
+	rules    []*rules.Compiled
+	version  int64 // 0 for unversioned files
+	checksum string
+	// The contents of unversioned files, recorded with the run
+	content string
+	files   []configstore.RuleFile
+}
+
+// loadSettings returns the numbered config version, or the one active at
+// now, falling back to the config files when none is active. fromFiles
+// uses the files regardless. Only approved versions can be run.
+func loadSettings(ctx context.Context, st store.Store, version int64, configPath, rulesDir string, fromFiles bool, now time.Time) (settings, error) {
+	if !fromFiles {
+		registry, err := configstore.Load(ctx, st)
+		if err != nil {
+			return settings{}, err
+		}
+		v, ok := registry.Active(now)
+		if version != 0 {
+			if v, err = registry.Get(version); err != nil {
+				return settings{}, err
+			}
+			if status := registry.Status(v, now); status == configstore.StatusProposed || status == configstore.StatusRejected {
+				return settings{}, fmt.Errorf("version %d is %s and cannot be run", version, status)
+			}
+			ok = true
+		}
+		if ok {
+			config, compiled, err := v.Detectors()
+			if err != nil {
+				return settings{}, err
+			}
+			cli.Status(fmt.Sprintf("Using config version %d (%s, effective %s)", v.Version, v.Checksum, v.EffectiveDate))
+			return settings{config: config, rules: compiled, version: v.Version, checksum: v.Checksum}, nil
+		}
+	}
+
+	content, files, err := configstore.Snapshot(configPath, rulesDir)
+	if err != nil {
+		return settings{}, err
+	}
+	config, err := detect.LoadConfig(configPath)
+	if err != nil {
+		return settings{}, err
+	}
+	compiled, err := rules.LoadDir(rulesDir)
+	if err != nil {
+		return settings{}, err
+	}
+	checksum := configstore.Checksum(content, files)
+	cli.Status(fmt.Sprintf("Using unversioned config %s and rules %s (%s)", configPath, rulesDir, checksum))
+	return settings{config: config, rules: compiled, checksum: checksum, content: content, files: files}, nil
+}
+
+// allDetectors returns the configured detectors followed by the rules
+func allDetectors(config detect.Config, reference detect.Reference, compiled []*rules.Compiled) []detect.Detector {
+	detectors := detect.All(config, reference)
+	for _, rule := range compiled {
+		detectors = append(detectors, rule)
+	}
+	return detectors
+}
+
+// loadReference reads the tables the detectors score against
+func loadReference(ctx context.Context, st store.Store) (detect.Reference, error) {
+	var reference detect.Reference
+
+	var scores []merchant.Score
+	if err := st.Load(ctx, merchant.ScoresTable, &scores); err != nil {
+		return reference, fmt.Errorf("failed to load merchant risk scores: %v", err)
+	}
+	reference.HighRiskMerchants = merchant.HighRisk(scores)
+
+	return reference, nil
+}
+
+// runPeers rebuilds customer_peer_metrics, the peer group comparison
+// behind PEER_OUTLIER alerts and the profile factor
+func runPeers(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("peers", flag.ExitOnError)
+	configPath := flags.String("config", detect.DefaultConfigPath, "detector config file")
+	dryRun := flags.Bool("dry-run", false, "print peer outliers without storing metrics")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	config, err := detect.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	cli.Processing("Loading transactions...")
+	transactions, err := st.Transactions(ctx, time.Time{})
+	if err != nil {
+		return err
+	}
+	metrics := detect.PeerMetrics(config, transactions)
+
+	groups := map[string]bool{}
+	var outliers, ungrouped int
+	for _, m := range metrics {
+		if m.PeerGroup == "" {
+			ungrouped++
+			continue
+		}
+		groups[m.PeerGroup] = true
+		if m.OutlierMetrics > 0 {
+			outliers++
+			fmt.Printf("   • %s (%s, %d peers): %d outlier metrics\n", m.CustomerID, m.PeerGroup, m.PeerGroupSize, m.OutlierMetrics)
+		}
+	}
+	cli.Status(fmt.Sprintf("%d customers in %d peer groups, %d outliers, %d without a group of %d+",
+		len(metrics)-ungrouped, len(groups), outliers, ungrouped, config.PeerMinGroupSize))
+
+	if *dryRun {
+		cli.Warning("Dry run: peer metrics not stored")
+		return nil
+	}
+	if err := st.Replace(ctx, detect.PeerMetricsTable, metrics); err != nil {
+		return fmt.Errorf("failed to store peer metrics: %v", err)
+	}
+	cli.Success(fmt.Sprintf("Stored peer metrics for %d customers", len(metrics)))
+	return nil
+}
+
+// runBacktest replays -from to -to through the detectors of each config
+// and reports precision, recall, volume and cost per true positive
+func runBacktest(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("backtest", flag.ExitOnError)
+	from := flags.String("from", "", "replay activity after this date (YYYY-MM-DD)")
+	to := flags.String("to", "", "replay activity up to and including this date (YYYY-MM-DD)")
+	configs := flags.String("configs", detect.DefaultConfigPath, "comma-separated detector config files, one threshold set each")
+	rulesDir := flags.String("rules", rules.DefaultDir, "directory of rule files replayed alongside the detectors")
+	names := flags.String("detectors", "", "comma-separated detectors to replay (default: all)")
+	alertCost := flags.Float64("alert-cost", 25, "cost of reviewing one alert in dollars")
+	jsonPath := flags.String("json", "", "also write the report as JSON to this file")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	if *from == "" || *to == "" {
+		return fmt.Errorf("-from and -to are required")
+	}
+	var window backtest.Window
+	var err error
+	if window.From, err = time.Parse(aml.DateLayout, *from); err != nil {
+		return fmt.Errorf("invalid -from date: %v", err)
+	}
+	if window.To, err = time.Parse(aml.DateLayout, *to); err != nil {
+		return fmt.Errorf("invalid -to date: %v", err)
+	}
+	if window.To.Before(window.From) {
+		return fmt.Errorf("-to must not be before -from")
+	}
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	reference, err := loadReference(ctx, st)
+	if err != nil {
+		return err
+	}
+	var selectedNames []string
+	if *names != "" {
+		selectedNames = strings.Split(*names, ",")
+	}
+	compiled, err := rules.LoadDir(*rulesDir)
+	if err != nil {
+		return err
+	}
+	var sets []backtest.ThresholdSet
+	historyStart := window.From
+	for _, path := range strings.Split(*configs, ",") {
+		path = strings.TrimSpace(path)
+		config, err := detect.LoadConfig(path)
+		if err != nil {
+			return err
+		}
+		detectors, err := detect.Select(allDetectors(config, reference, compiled), selectedNames)
+		if err != nil {
+			return err
+		}
+		if lookback := detect.Lookback(detectors); lookback == detect.FullHistory {
+			historyStart = time.Time{}
+		} else if start := window.From.Add(-lookback); start.Before(historyStart) {
+			historyStart = start
+		}
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		sets = append(sets, backtest.ThresholdSet{Name: name, Detectors: detectors})
+	}
+
+	cli.Processing("Loading transactions and alerts...")
+	transactions, err := st.Transactions(ctx, historyStart)
+	if err != nil {
+		return err
+	}
+	var stored []aml.Alert
+	if err := st.Load(ctx, store.AlertsTable, &stored); err != nil {
+		return fmt.Errorf("failed to load alerts: %v", err)
+	}
+
+	cli.Processing(fmt.Sprintf("Replaying %s to %s through %d threshold sets...", *from, *to, len(sets)))
+	report := backtest.Run(sets, backtest.NewLabels(transactions, window, stored), *alertCost, time.Now().UTC())
+	cli.Status(fmt.Sprintf("%s transactions in the window, %d labelled fraud",
+		cli.FormatNumber(int64(report.Transactions)), report.FraudTransactions))
+	backtest.WriteTable(os.Stdout, report)
+
+	if *jsonPath != "" {
+		if err := writeReport(*jsonPath, func(w io.Writer) error { return backtest.WriteJSON(w, report) }); err != nil {
+			return err
+		}
+		cli.Success(fmt.Sprintf("Wrote the backtest report to %s", *jsonPath))
+	}
+	return nil
+}
+
+// runTune sweeps one detector parameter over a grid of values and reports
+// above- and below-the-line results for the chosen threshold
+func runTune(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("tune", flag.ExitOnError)
+	detector := flags.String("detector", "", "detector to tune, e.g. VELOCITY")
+	parameter := flags.String("param", "", "config key to sweep, e.g. velocity_windows.0.min_transactions")
+	list := flags.String("values", "", "comma-separated values to try; start:end:step expands to a range")
+	chosen := flags.String("choose", "", "threshold to justify (default: the value in -config)")
+	from := flags.String("from", "", "replay activity after this date (YYYY-MM-DD)")
+	to := flags.String("to", "", "replay activity up to and including this date (YYYY-MM-DD)")
+	configPath := flags.String("config", detect.DefaultConfigPath, "detector config file the sweep starts from")
+	alertCost := flags.Float64("alert-cost", 25, "cost of reviewing one alert in dollars")
+	reportPath := flags.String("report", "", "write the justification report as Markdown to this file")
+	jsonPath := flags.String("json", "", "write the sweep as JSON to this file")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	if *detector == "" || *parameter == "" || *list == "" || *from == "" || *to == "" {
+		return fmt.Errorf("-detector, -param, -values, -from and -to are required")
+	}
+	values, err := backtest.ParseValues(*list)
+	if err != nil {
+		return err
+	}
+	var window backtest.Window
+	if window.From, err = time.Parse(aml.DateLayout, *from); err != nil {
+		return fmt.Errorf("invalid -from date: %v", err)
+	}
+	if window.To, err = time.Parse(aml.DateLayout, *to); err != nil {
+		return fmt.Errorf("invalid -to date: %v", err)
+	}
+	config, err := detect.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if _, err := config.Parameter(*parameter); err != nil {
+		return err
+	}
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	reference, err := loadReference(ctx, st)
+	if err != nil {
+		return err
+	}
+	// Load the history the widest setting needs
+	historyStart := window.From
+	for _, value := range append(values, *chosen) {
+		swept := config
+		if value != "" {
+			if swept, err = config.WithParameter(*parameter, value); err != nil {
+				return err
+			}
+		}
+		detectors, err := detect.Select(detect.All(swept, reference), []string{*detector})
+		if err != nil {
+			return err
+		}
+		if lookback := detect.Lookback(detectors); lookback == detect.FullHistory {
+			historyStart = time.Time{}
+		} else if start := window.From.Add(-lookback); start.Before(historyStart) {
+			historyStart = start
+		}
+	}
+
+	cli.Processing("Loading transactions and alerts...")
+	transactions, err := st.Transactions(ctx, historyStart)
+	if err != nil {
+		return err
+	}
+	var stored []aml.Alert
+	if err := st.Load(ctx, store.AlertsTable, &stored); err != nil {
+		return fmt.Errorf("failed to load alerts: %v", err)
+	}
+
+	cli.Processing(fmt.Sprintf("Sweeping %s over %d values...", *parameter, len(values)))
+	labels := backtest.NewLabels(transactions, window, stored)
+	sweep, err := backtest.Tune(*detector, *parameter, values, *chosen, config, reference, labels, *alertCost, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	cli.Status(fmt.Sprintf("%s transactions in the window, %d labelled fraud",
+		cli.FormatNumber(int64(sweep.Transactions)), sweep.FraudTransactions))
+	backtest.WriteSweepTable(os.Stdout, sweep)
+	fmt.Println()
+	for _, line := range sweep.Justification {
+		fmt.Printf("   • %s\n", line)
+	}
+
+	if *reportPath != "" {
+		if err := writeReport(*reportPath, func(w io.Writer) error { return backtest.WriteSweepReport(w, sweep) }); err != nil {
+			return err
+		}
+		cli.Success(fmt.Sprintf("Wrote the tuning report to %s", *reportPath))
+	}
+	if *jsonPath != "" {
+		if err := writeReport(*jsonPath, func(w io.Writer) error { return backtest.WriteSweepJSON(w, sweep) }); err != nil {
+			return err
+		}
+		cli.Success(fmt.Sprintf("Wrote the sweep to %s", *jsonPath))
+	}
+	return nil
+}
+
+// writeReport creates path and writes a report to it
+func writeReport(path string, write func(io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", path, err)
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
+
+// runConfig prints the effective detector configuration
+func runConfig(args []string) error {
+	flags := flag.NewFlagSet("config", flag.ExitOnError)
+	configPath := flags.String("config", detect.DefaultConfigPath, "detector config file")
+	flags.Parse(args)
+
+	config, err := detect.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("   • structuring: $%s threshold, %.0f%% buffer, %d+ transactions in %v day windows\n",
+		aml.FormatAmount(config.StructuringThreshold), config.StructuringBuffer*100,
+		config.StructuringMinTransactions, config.StructuringWindows)
+	for _, window := range config.VelocityWindows {
+		fmt.Printf("   • velocity: %d+ transactions", window.MinTransactions)
+		if window.MinAmount > 0 {
+			fmt.Printf(" or $%s", aml.FormatAmount(window.MinAmount))
+		}
+		fmt.Printf(" within %d minutes\n", window.Minutes)
+	}
+	fmt.Printf("   • geographic: more than %d states, or more than %d cities and transactions, in a day\n",
+		config.GeographicMaxStates, config.GeographicMaxCities)
+	fmt.Printf("   • card testing: %d+ transactions up to $%.2f then a $%s+ charge within %d minutes\n",
+		config.CardTestingMinBurst, config.CardTestingMaxAmount,
+		aml.FormatAmount(config.CardTestingLargeAmount), config.CardTestingWindowMinutes)
+	fmt.Printf("   • dormancy: $%s+ within %d days after %d+ days inactive\n",
+		aml.FormatAmount(config.DormancyMinAmount), config.DormancyWindowDays, config.DormancyMinDays)
+	fmt.Printf("   • merchant funnel: %d+ customers paying within %.0f%% of each other in a day\n",
+		config.MerchantFunnelMinCustomers, config.MerchantFunnelAmountTolerance*100)
+	fmt.Printf("   • merchant spike: %d+ transactions totaling %.0fx the %d-day daily average\n",
+		config.MerchantSpikeMinTransactions, config.MerchantSpikeMultiplier, config.MerchantSpikeBaselineDays)
+	fmt.Printf("   • behaviour: $%s+ transactions after %d+ of history, z-score %.1f, rare below %.0f%%, %d+ deviations\n",
+		aml.FormatAmount(config.BehaviourMinAmount), config.BehaviourMinHistory, config.BehaviourZScore,
+		config.BehaviourRareShare*100, config.BehaviourMinDeviations)
+	fmt.Printf("   • peers: %d-day activity, groups of %d+, urban from %s inhabitants, robust z-score %.1f\n",
+		config.PeerWindowDays, config.PeerMinGroupSize, cli.FormatNumber(config.PeerUrbanPopulation), config.PeerZScore)
+	fmt.Printf("   • network clusters: %d+ customers sharing an address or card, rings of %d+ with %.0f%%+ of pairs linked\n",
+		config.GraphMinClusterSize, config.GraphRingMinSize, config.GraphRingDensity*100)
+	fmt.Printf("   • risk score weights: structuring %d, velocity %d, geographic %d, round amounts %d, card testing %d, dormancy %d, high-risk merchant %d, merchant funnel %d, merchant spike %d, behaviour %d, peer outlier %d, graph cluster %d, graph ring %d\n",
+		config.RiskScoreWeights.Structuring, config.RiskScoreWeights.Velocity,
+		config.RiskScoreWeights.Geographic, config.RiskScoreWeights.RoundAmounts,
+		config.RiskScoreWeights.CardTesting, config.RiskScoreWeights.Dormancy,
+		config.RiskScoreWeights.HighRiskMerchant, config.RiskScoreWeights.MerchantFunnel,
+		config.RiskScoreWeights.MerchantSpike, config.RiskScoreWeights.Behaviour,
+		config.RiskScoreWeights.PeerOutlier, config.RiskScoreWeights.GraphCluster,
+		config.RiskScoreWeights.GraphRing)
+	return nil
+}
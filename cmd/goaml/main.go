@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/cases"
+	"aml-system/internal/cli"
+	"aml-system/internal/ctr"
+	"aml-system/internal/goaml"
+	"aml-system/internal/sar"
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  goaml str -case N -reason reason.txt -by NAME -out str.xml [-action TEXT] [-entity config/goaml_entity.json] -xsd goAMLSchema.xsd|-no-validate [-local dir]")
+	fmt.Println("  goaml ctr -candidate N -out ctr.xml [-entity config/goaml_entity.json] -xsd goAMLSchema.xsd|-no-validate [-local dir]")
+}
+
+func main() {
+	cli.Title("🏦 AML goAML Reports")
+	cli.Title(strings.Repeat("=", 50))
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	var err error
+	switch os.Args[1] {
+	case "str":
+		err = runSTR(ctx, os.Args[2:])
+	case "ctr":
+		err = runCTR(ctx, os.Args[2:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		cli.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// write renders a report and writes it to out once it validates against
+// xsd. A report is only written unvalidated with -no-validate. It returns
+// the SHA-256 of the file.
+func write(entity goaml.Entity, report goaml.Report, out, xsd string, noValidate bool) (string, error) {
+	data, err := goaml.Marshal(entity, report, time.Now().UTC())
+	if err != nil {
+		return "", err
+	}
+	if err := cli.WriteXML(out, data, xsd); err != nil {
+		return "", err
+	}
+	if noValidate {
+		cli.Warning(fmt.Sprintf("%s was not validated against the goAML schema", out))
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// checkValidation requires a schema unless validation is explicitly skipped
+func checkValidation(xsd string, noValidate bool) error {
+	if xsd == "" && !noValidate {
+		return fmt.Errorf("-xsd is required to validate the report; pass -no-validate to export without it")
+	}
+	if xsd != "" && noValidate {
+		return fmt.Errorf("-xsd cannot be combined with -no-validate")
+	}
+	return nil
+}
+
+// runSTR reports a case's activity as a suspicious transaction report
+// and notes the export on the case
+func runSTR(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("str", flag.ExitOnError)
+	caseID := flags.Int64("case", 0, "case ID, or the ID of any of its alerts")
+	reasonPath := flags.String("reason", "", "text file with the grounds for suspicion")
+	action := flags.String("action", "", "action taken on the subject")
+	by := flags.String("by", "", "analyst filing the report")
+	out := flags.String("out", "", "output XML file")
+	entityPath := flags.String("entity", goaml.DefaultEntityConfig, "reporting entity config")
+	xsd := flags.String("xsd", "", "goAML schema to validate the report against with xmllint")
+	noValidate := flags.Bool("no-validate", false, "export without validating against the schema")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	if *out == "" {
+		return fmt.Errorf("-out is required")
+	}
+	if err := checkValidation(*xsd, *noValidate); err != nil {
+		return err
+	}
+	if strings.TrimSpace(*by) == "" {
+		return fmt.Errorf("-by is required")
+	}
+	reason, err := os.ReadFile(*reasonPath)
+	if err != nil {
+		return fmt.Errorf("failed to read -reason: %v", err)
+	}
+	entity, err := goaml.LoadEntity(*entityPath)
+	if err != nil {
+		return err
+	}
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	assembled, err := sar.AssembleCase(ctx, st, *caseID)
+	if err != nil {
+		return err
+	}
+	report := goaml.STR(assembled, string(reason), *action)
+	checksum, err := write(entity, report, *out, *xsd, *noValidate)
+	if err != nil {
+		return err
+	}
+
+	book, err := cases.Load(ctx, st)
+	if err != nil {
+		return err
+	}
+	note := fmt.Sprintf("goAML STR %s exported to %s (sha256 %s)", report.EntityReference, *out, checksum)
+	if _, err := cases.AddNote(ctx, st, book, assembled.CaseID, note, *by, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	fmt.Printf("   • Subject:      %s %s (%s)\n", report.Subject.First, report.Subject.Last, report.Subject.DOB)
+	fmt.Printf("   • Activity:     %s to %s, %d transactions, $%s\n", assembled.From, assembled.To, len(report.Transactions), aml.FormatAmount(assembled.TotalAmount))
+	cli.Success(fmt.Sprintf("Wrote goAML STR %s to %s", report.EntityReference, *out))
+	return nil
+}
+
+// runCTR reports a CTR candidate's business day as a cash transaction
+// report
+func runCTR(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("ctr", flag.ExitOnError)
+	candidateID := flags.Int64("candidate", 0, "CTR candidate to report")
+	out := flags.String("out", "", "output XML file")
+	entityPath := flags.String("entity", goaml.DefaultEntityConfig, "reporting entity config")
+	xsd := flags.String("xsd", "", "goAML schema to validate the report against with xmllint")
+	noValidate := flags.Bool("no-validate", false, "export without validating against the schema")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	if *out == "" {
+		return fmt.Errorf("-out is required")
+	}
+	if err := checkValidation(*xsd, *noValidate); err != nil {
+		return err
+	}
+	entity, err := goaml.LoadEntity(*entityPath)
+	if err != nil {
+		return err
+	}
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	var candidates []ctr.Candidate
+	if err := st.Load(ctx, ctr.CandidatesTable, &candidates); err != nil {
+		return fmt.Errorf("failed to load CTR candidates: %v", err)
+	}
+	var candidate *ctr.Candidate
+	for i := range candidates {
+		if candidates[i].CandidateID == *candidateID {
+			candidate = &candidates[i]
+		}
+	}
+	if candidate == nil {
+		return fmt.Errorf("no CTR candidate %d", *candidateID)
+	}
+	if candidate.Status == ctr.StatusVoid {
+		return fmt.Errorf("CTR candidate %d was voided: its day is no longer over the threshold", *candidateID)
+	}
+	transactions, err := st.CustomerTransactions(ctx, candidate.CustomerID, time.Time{})
+	if err != nil {
+		return err
+	}
+	report, err := goaml.CTR(*candidate, transactions)
+	if err != nil {
+		return err
+	}
+	if _, err := write(entity, report, *out, *xsd, *noValidate); err != nil {
+		return err
+	}
+
+	fmt.Printf("   • %s\n", candidate.Describe())
+	cli.Success(fmt.Sprintf("Wrote goAML CTR %s to %s", report.EntityReference, *out))
+	return nil
+}
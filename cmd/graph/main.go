@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"aml-system/internal/cli"
+	"aml-system/internal/detect"
+	"aml-system/internal/graph"
+	"aml-system/internal/store"
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  graph build [-config config/aml_config.json] [-dry-run] [-local dir]")
+	fmt.Println("  graph list [-rings] [-local dir]")
+	fmt.Println("  graph export -out network.graphml [-format graphml|gexf] [-cluster NC-...] [-config config/aml_config.json] [-local dir]")
+}
+
+func main() {
+	cli.Title("🏦 AML Network Analysis")
+	cli.Title(strings.Repeat("=", 50))
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	var err error
+	switch os.Args[1] {
+	case "build":
+		err = runBuild(ctx, os.Args[2:])
+	case "list":
+		err = runList(ctx, os.Args[2:])
+	case "export":
+		err = runExport(ctx, os.Args[2:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		cli.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// loadGraph builds the network from every transaction
+func loadGraph(ctx context.Context, st store.Store) (*graph.Graph, error) {
+	cli.Processing("Loading transactions...")
+	transactions, err := st.Transactions(ctx, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	g := graph.Build(transactions)
+
+	var customers, links int
+	for _, node := range g.Nodes {
+		if node.Kind == graph.KindCustomer {
+			customers++
+		}
+	}
+	for _, edge := range g.Edges {
+		if edge.Kind != graph.EdgePayment {
+			links++
+		}
+	}
+	cli.Status(fmt.Sprintf("Graph: %s customers, %s merchants, %s edges, %d shared-attribute links",
+		cli.FormatNumber(int64(customers)), cli.FormatNumber(int64(len(g.Nodes)-customers)),
+		cli.FormatNumber(int64(len(g.Edges))), links))
+	return g, nil
+}
+
+// runBuild finds network clusters and replaces network_clusters
+func runBuild(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	configPath := flags.String("config", detect.DefaultConfigPath, "detector config file")
+	dryRun := flags.Bool("dry-run", false, "print clusters without storing them")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	config, err := detect.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	g, err := loadGraph(ctx, st)
+	if err != nil {
+		return err
+	}
+	clusters := graph.Clusters(g, config.GraphThresholds())
+	rows := graph.Rows(g, clusters, time.Now().UTC())
+	printClusters(rows, false)
+
+	var rings int
+	for _, c := range clusters {
+		if c.IsRing {
+			rings++
+		}
+	}
+	cli.Status(fmt.Sprintf("%d clusters, %d rings, %d customers", len(clusters), rings, len(rows)))
+
+	if *dryRun {
+		cli.Warning("Dry run: network clusters not stored")
+		return nil
+	}
+	if err := st.Replace(ctx, graph.ClustersTable, rows); err != nil {
+		return fmt.Errorf("failed to store network clusters: %v", err)
+	}
+	cli.Success(fmt.Sprintf("Stored %d network cluster members", len(rows)))
+	return nil
+}
+
+func runList(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	rings := flags.Bool("rings", false, "only show rings")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	var rows []graph.ClusterMember
+	if err := st.Load(ctx, graph.ClustersTable, &rows); err != nil {
+		return fmt.Errorf("failed to load network clusters: %v", err)
+	}
+	if len(rows) == 0 {
+		cli.Warning("No network clusters; run 'graph build' first")
+		return nil
+	}
+	printClusters(rows, *rings)
+	return nil
+}
+
+// printClusters prints each cluster followed by its members
+func printClusters(rows []graph.ClusterMember, ringsOnly bool) {
+	current := ""
+	for _, row := range rows {
+		if ringsOnly && !row.IsRing {
+			continue
+		}
+		if row.ClusterID != current {
+			current = row.ClusterID
+			kind := "cluster"
+			if row.IsRing {
+				kind = "ring"
+			}
+			fmt.Printf("   • %s %s: %d customers linked by %s, density %.2f, %d shared merchants, $%s\n",
+				row.ClusterID, kind, row.ClusterSize, strings.ToLower(strings.ReplaceAll(row.LinkTypes, ",", " and ")),
+				row.Density, row.SharedMerchants, cli.FormatNumber(int64(row.ClusterAmount)))
+		}
+		fmt.Printf("       %-25s linked to %-30s degree %d, pagerank %.5f\n",
+			row.CustomerID, row.LinkedCustomers, row.Degree, row.PageRank)
+	}
+}
+
+// runExport writes the whole network, or one cluster with the merchants its
+// members share, as GraphML or GEXF
+func runExport(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	out := flags.String("out", "", "output file")
+	format := flags.String("format", "", "graphml or gexf (default: from the -out extension)")
+	clusterID := flags.String("cluster", "", "only export this cluster")
+	configPath := flags.String("config", detect.DefaultConfigPath, "detector config file")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	if *out == "" {
+		return fmt.Errorf("-out is required")
+	}
+	if *format == "" {
+		*format = graph.FormatGraphML
+		if strings.HasSuffix(strings.ToLower(*out), ".gexf") {
+			*format = graph.FormatGEXF
+		}
+	}
+
+	config, err := detect.LoadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	g, err := loadGraph(ctx, st)
+	if err != nil {
+		return err
+	}
+	if *clusterID != "" {
+		var found bool
+		for _, c := range graph.Clusters(g, config.GraphThresholds()) {
+			if c.ID == *clusterID {
+				g = g.Subgraph(c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("no network cluster %s", *clusterID)
+		}
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", *out, err)
+	}
+	if err := graph.Write(file, g, *format, time.Now().UTC()); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", *out, err)
+	}
+	cli.Success(fmt.Sprintf("Wrote %d nodes and %d edges to %s", len(g.Nodes), len(g.Edges), *out))
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/cli"
+	"aml-system/internal/merchant"
+	"aml-system/internal/store"
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  merchants score [-registry config/merchant_risk.json] [-local dir]")
+	fmt.Println("  merchants list [-high-risk] [-limit 20] [-local dir]")
+	fmt.Println("  merchants profile [-local dir]")
+}
+
+func main() {
+	cli.Title("🏦 AML Merchant Risk")
+	cli.Title(strings.Repeat("=", 50))
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	var err error
+	switch os.Args[1] {
+	case "score":
+		err = runScore(ctx, os.Args[2:])
+	case "list":
+		err = runList(ctx, os.Args[2:])
+	case "profile":
+		err = runProfile(ctx, os.Args[2:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		cli.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// runScore rescores every merchant from the registry and current alerts and
+// replaces merchant_risk_scores
+func runScore(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("score", flag.ExitOnError)
+	registryPath := flags.String("registry", merchant.DefaultRegistryPath, "merchant risk registry file")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	registry, err := merchant.LoadRegistry(*registryPath)
+	if err != nil {
+		return err
+	}
+	cli.Status(fmt.Sprintf("Registry: %d categories, %d merchants, high risk from %d",
+		len(registry.Categories), len(registry.Merchants), registry.HighRiskThreshold))
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	cli.Processing("Loading transactions and alerts...")
+	transactions, err := st.Transactions(ctx, time.Time{})
+	if err != nil {
+		return err
+	}
+	var alerts []aml.Alert
+	if err := st.Load(ctx, store.AlertsTable, &alerts); err != nil {
+		return fmt.Errorf("failed to load alerts: %v", err)
+	}
+
+	scores := merchant.ScoreMerchants(registry, transactions, alerts, time.Now().UTC())
+	if err := st.Replace(ctx, merchant.ScoresTable, scores); err != nil {
+		return fmt.Errorf("failed to store merchant risk scores: %v", err)
+	}
+
+	high := merchant.HighRisk(scores)
+	cli.Success(fmt.Sprintf("Scored %s merchants, %d high risk", cli.FormatNumber(int64(len(scores))), len(high)))
+	return nil
+}
+
+func runList(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	highRisk := flags.Bool("high-risk", false, "only show high-risk merchants")
+	limit := flags.Int("limit", 20, "maximum merchants to show")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	var scores []merchant.Score
+	if err := st.Load(ctx, merchant.ScoresTable, &scores); err != nil {
+		return fmt.Errorf("failed to load merchant risk scores: %v", err)
+	}
+	if len(scores) == 0 {
+		cli.Warning("No merchant risk scores; run 'merchants score' first")
+		return nil
+	}
+
+	shown := 0
+	for _, score := range scores {
+		if (*highRisk && !score.IsHighRisk) || shown >= *limit {
+			continue
+		}
+		marker := " "
+		if score.IsHighRisk {
+			marker = "!"
+		}
+		fmt.Printf("   %s %3d  %-40s %-15s registry %3d, %d/%d customers alerted\n",
+			marker, score.RiskScore, score.Merchant, score.Category, score.RegistryWeight,
+			score.AlertedCustomers, score.Customers)
+		shown++
+	}
+	return nil
+}
+
+// runProfile rebuilds merchant_risk_profiles from transactions, merchant risk
+// scores and MERCHANT_ANOMALY alerts
+func runProfile(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("profile", flag.ExitOnError)
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	cli.Processing("Loading transactions, alerts and merchant risk scores...")
+	transactions, err := st.Transactions(ctx, time.Time{})
+	if err != nil {
+		return err
+	}
+	var alerts []aml.Alert
+	if err := st.Load(ctx, store.AlertsTable, &alerts); err != nil {
+		return fmt.Errorf("failed to load alerts: %v", err)
+	}
+	var scores []merchant.Score
+	if err := st.Load(ctx, merchant.ScoresTable, &scores); err != nil {
+		return fmt.Errorf("failed to load merchant risk scores: %v", err)
+	}
+
+	profiles := merchant.BuildProfiles(transactions, alerts, scores, time.Now().UTC())
+	if err := st.Replace(ctx, merchant.ProfilesTable, profiles); err != nil {
+		return fmt.Errorf("failed to store merchant risk profiles: %v", err)
+	}
+
+	categories := map[string]int{}
+	for _, profile := range profiles {
+		categories[profile.RiskCategory]++
+	}
+	cli.Success(fmt.Sprintf("Profiled %s merchants: %d critical, %d high, %d medium, %d low",
+		cli.FormatNumber(int64(len(profiles))), categories["CRITICAL"], categories["HIGH"],
+		categories["MEDIUM"], categories["LOW"]))
+	return nil
+}
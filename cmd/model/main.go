@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/cli"
+	"aml-system/internal/configstore"
+	"aml-system/internal/intake"
+	"aml-system/internal/model"
+	"aml-system/internal/store"
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  model train [-out models/isolation_forest.json] [-trees 100] [-sample-size 256] [-seed 42] [-contamination 0.01] [-until YYYY-MM-DD] [-local dir]")
+	fmt.Println("  model score [-model models/isolation_forest.json] [-since YYYY-MM-DD] [-dry-run] [-local dir]")
+	fmt.Println("  model info [-model models/isolation_forest.json]")
+	fmt.Println("  model fraud-train [-out models/fraud_model.json] [-iterations 1000] [-learning-rate 0.5] [-l2 0.01] [-holdout 0.2] [-local dir]")
+	fmt.Println("  model fraud-score [-model models/fraud_model.json] [-since YYYY-MM-DD] [-dry-run] [-local dir]")
+	fmt.Println("  model fraud-info [-model models/fraud_model.json]")
+}
+
+func main() {
+	cli.Title("🏦 AML Scoring Models")
+	cli.Title(strings.Repeat("=", 50))
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	var err error
+	switch os.Args[1] {
+	case "train":
+		err = runTrain(ctx, os.Args[2:])
+	case "score":
+		err = runScore(ctx, os.Args[2:])
+	case "info":
+		err = runInfo(os.Args[2:])
+	case "fraud-train":
+		err = runFraudTrain(ctx, os.Args[2:])
+	case "fraud-score":
+		err = runFraudScore(ctx, os.Args[2:])
+	case "fraud-info":
+		err = runFraudInfo(os.Args[2:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		cli.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// loadDays engineers customer-day features from every transaction,
+// returning the transactions too
+func loadDays(ctx context.Context, st store.Store) ([]model.CustomerDay, []aml.Transaction, error) {
+	cli.Processing("Loading transactions...")
+	transactions, err := st.Transactions(ctx, time.Time{})
+	if err != nil {
+		return nil, nil, err
+	}
+	days := model.CustomerDays(transactions)
+	cli.Status(fmt.Sprintf("%s customer-days from %s transactions",
+		cli.FormatNumber(int64(len(days))), cli.FormatNumber(int64(len(transactions)))))
+	return days, transactions, nil
+}
+
+// runTrain fits the isolation forest to customer-days up to -until and
+// writes the model file
+func runTrain(ctx context.Context, args []string) error {
+	defaults := model.DefaultForestConfig()
+	flags := flag.NewFlagSet("train", flag.ExitOnError)
+	out := flags.String("out", model.DefaultAnomalyModelPath, "model file to write")
+	trees := flags.Int("trees", defaults.Trees, "number of isolation trees")
+	sampleSize := flags.Int("sample-size", defaults.SampleSize, "customer-days sampled per tree")
+	seed := flags.Int64("seed", defaults.Seed, "random seed; the same data and seed give the same model")
+	contamination := flags.Float64("contamination", defaults.Contamination, "expected share of anomalous customer-days")
+	until := flags.String("until", "", "only train on days up to this date (YYYY-MM-DD, default: all)")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	if *until != "" {
+		if _, err := time.Parse(aml.DateLayout, *until); err != nil {
+			return fmt.Errorf("invalid -until date: %v", err)
+		}
+	}
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	days, _, err := loadDays(ctx, st)
+	if err != nil {
+		return err
+	}
+	if *until != "" {
+		var training []model.CustomerDay
+		for _, day := range days {
+			if day.Date <= *until {
+				training = append(training, day)
+			}
+		}
+		days = training
+	}
+
+	cli.Processing(fmt.Sprintf("Training %d trees on %s customer-days...", *trees, cli.FormatNumber(int64(len(days)))))
+	forest, err := model.TrainForest(days, model.ForestConfig{
+		Trees:         *trees,
+		SampleSize:    *sampleSize,
+		Seed:          *seed,
+		Contamination: *contamination,
+	}, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if err := model.SaveForest(*out, forest); err != nil {
+		return err
+	}
+	cli.Success(fmt.Sprintf("Saved %s to %s (threshold %.3f)", forest.Version, *out, forest.Threshold))
+	return nil
+}
+
+// runScore scores customer-days after -since, stores them in anomaly_scores
+// in place of earlier scores of the same days by the same model version and
+// raises ML_ANOMALY alerts for the anomalous ones
+func runScore(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("score", flag.ExitOnError)
+	modelPath := flags.String("model", model.DefaultAnomalyModelPath, "model file")
+	since := flags.String("since", "", "only score days after this date (YYYY-MM-DD, default: all)")
+	dryRun := flags.Bool("dry-run", false, "print anomalies without storing scores or alerts")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	var sinceTime time.Time
+	if *since != "" {
+		t, err := time.Parse(aml.DateLayout, *since)
+		if err != nil {
+			return fmt.Errorf("invalid -since date: %v", err)
+		}
+		sinceTime = t
+	}
+	forest, err := model.LoadForest(*modelPath)
+	if err != nil {
+		return err
+	}
+	cli.Status(fmt.Sprintf("Model %s, trained through %s, threshold %.3f", forest.Version, forest.TrainedThrough, forest.Threshold))
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	days, transactions, err := loadDays(ctx, st)
+	if err != nil {
+		return err
+	}
+	startedAt := time.Now().UTC()
+	scores, alerts := forest.ScoreDays(days, *since, startedAt)
+	cli.Status(fmt.Sprintf("Scored %s customer-days, %d anomalies", cli.FormatNumber(int64(len(scores))), len(alerts)))
+	parameters, err := configstore.Parameters(map[string]interface{}{
+		"model_path":      *modelPath,
+		"model_version":   forest.Version,
+		"trained_through": forest.TrainedThrough,
+		"threshold":       forest.Threshold,
+	})
+	if err != nil {
+		return err
+	}
+	batch, err := intake.Prepare(ctx, st, configstore.Run{
+		Source:         "model score",
+		ConfigChecksum: configstore.Checksum(parameters, nil),
+		Config:         parameters,
+		Since:          sinceTime,
+		StartedAt:      startedAt,
+		Transactions:   int64(len(transactions)),
+	}, alerts, transactions, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	for _, note := range batch.Notes() {
+		cli.Status(note)
+	}
+
+	if *dryRun {
+		for _, alert := range batch.Alerts {
+			fmt.Printf("   • %s %s [%s %d] %s\n", alert.AlertDate, alert.CustomerID, alert.AlertType, alert.RiskScore, alert.Description)
+		}
+		cli.Warning("Dry run: no scores or alerts stored")
+		return nil
+	}
+
+	if len(scores) > 0 {
+		var stored []model.AnomalyScore
+		if err := st.Load(ctx, model.AnomalyScoresTable, &stored); err != nil {
+			return fmt.Errorf("failed to load anomaly scores: %v", err)
+		}
+		if err := st.Replace(ctx, model.AnomalyScoresTable, model.MergeAnomalyScores(stored, scores)); err != nil {
+			return fmt.Errorf("failed to store anomaly scores: %v", err)
+		}
+	}
+	if err := batch.Insert(ctx, st, time.Now().UTC()); err != nil {
+		return err
+	}
+	cli.Success(fmt.Sprintf("Stored %d scores and %d alerts (run %d)", len(scores), len(batch.Alerts), batch.Run.RunID))
+	return nil
+}
+
+// runInfo prints the model's training settings
+func runInfo(args []string) error {
+	flags := flag.NewFlagSet("info", flag.ExitOnError)
+	modelPath := flags.String("model", model.DefaultAnomalyModelPath, "model file")
+	flags.Parse(args)
+
+	forest, err := model.LoadForest(*modelPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("   • version: %s\n", forest.Version)
+	fmt.Printf("   • trained: %s on %s customer-days through %s\n",
+		forest.TrainedAt.Format(time.RFC3339), cli.FormatNumber(int64(forest.TrainingRows)), forest.TrainedThrough)
+	fmt.Printf("   • trees: %d of %d samples, seed %d\n", len(forest.Trees), forest.SampleSize, forest.Seed)
+	fmt.Printf("   • threshold: %.3f (contamination %.1f%%)\n", forest.Threshold, forest.Contamination*100)
+	fmt.Printf("   • features: %s\n", strings.Join(forest.Features, ", "))
+	return nil
+}
+
+// loadVectors engineers transaction features from every transaction
+func loadVectors(ctx context.Context, st store.Store) ([]model.TransactionVector, error) {
+	cli.Processing("Loading transactions...")
+	transactions, err := st.Transactions(ctx, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	vectors := model.TransactionVectors(transactions)
+	cli.Status(fmt.Sprintf("%s transaction vectors", cli.FormatNumber(int64(len(vectors)))))
+	return vectors, nil
+}
+
+// runFraudTrain fits the fraud model to the labelled history, archives it
+// under its version and replaces fraud_model_coefficients for SQL scoring
+func runFraudTrain(ctx context.Context, args []string) error {
+	defaults := model.DefaultFraudModelConfig()
+	flags := flag.NewFlagSet("fraud-train", flag.ExitOnError)
+	out := flags.String("out", model.DefaultFraudModelPath, "model file to write")
+	iterations := flags.Int("iterations", defaults.Iterations, "gradient descent iterations")
+	learningRate := flags.Float64("learning-rate", defaults.LearningRate, "gradient descent step size")
+	l2 := flags.Float64("l2", defaults.L2, "L2 regularisation strength")
+	holdout := flags.Float64("holdout", defaults.Holdout, "share of the most recent transactions used to calibrate and evaluate")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	vectors, err := loadVectors(ctx, st)
+	if err != nil {
+		return err
+	}
+	cli.Processing(fmt.Sprintf("Training on %s transactions...", cli.FormatNumber(int64(len(vectors)))))
+	m, err := model.TrainFraudModel(vectors, model.FraudModelConfig{
+		Iterations:   *iterations,
+		LearningRate: *learningRate,
+		L2:           *l2,
+		Holdout:      *holdout,
+	}, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	printFraudModel(m, 10)
+
+	archive, err := model.SaveFraudModel(*out, m)
+	if err != nil {
+		return err
+	}
+	if err := st.Replace(ctx, model.FraudCoefficientsTable, m.Coefficients()); err != nil {
+		return fmt.Errorf("failed to store fraud model coefficients: %v", err)
+	}
+	cli.Success(fmt.Sprintf("Saved %s to %s and %s", m.Version, *out, archive))
+	return nil
+}
+
+// runFraudScore scores transactions after -since and stores them in
+// fraud_scores in place of earlier scores of the same transactions by the
+// same model version
+func runFraudScore(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("fraud-score", flag.ExitOnError)
+	modelPath := flags.String("model", model.DefaultFraudModelPath, "model file")
+	since := flags.String("since", "", "only score transactions after this date (YYYY-MM-DD, default: all)")
+	dryRun := flags.Bool("dry-run", false, "print the riskiest transactions without storing scores")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	var sinceTime time.Time
+	if *since != "" {
+		t, err := time.Parse(aml.DateLayout, *since)
+		if err != nil {
+			return fmt.Errorf("invalid -since date: %v", err)
+		}
+		sinceTime = t
+	}
+	m, err := model.LoadFraudModel(*modelPath)
+	if err != nil {
+		return err
+	}
+	cli.Status(fmt.Sprintf("Model %s, trained through %s, holdout AUC %.3f", m.Version, m.TrainedThrough, m.Metrics.AUC))
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	vectors, err := loadVectors(ctx, st)
+	if err != nil {
+		return err
+	}
+	scores := m.ScoreTransactions(vectors, sinceTime, time.Now().UTC())
+	cli.Status(fmt.Sprintf("Scored %s transactions", cli.FormatNumber(int64(len(scores)))))
+
+	if *dryRun {
+		ranked := append([]model.FraudScore(nil), scores...)
+		sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].FraudProbability > ranked[j].FraudProbability })
+		if len(ranked) > 10 {
+			ranked = ranked[:10]
+		}
+		for _, score := range ranked {
+			fmt.Printf("   • %s %-25s %5.1f%%  %s\n", score.TransDateTransTime.Format(aml.TransactionTimeLayout),
+				score.CustomerID, score.FraudProbability*100, score.TopFactors)
+		}
+		cli.Warning("Dry run: no scores stored")
+		return nil
+	}
+
+	if len(scores) > 0 {
+		var stored []model.FraudScore
+		if err := st.Load(ctx, model.FraudScoresTable, &stored); err != nil {
+			return fmt.Errorf("failed to load fraud scores: %v", err)
+		}
+		if err := st.Replace(ctx, model.FraudScoresTable, model.MergeFraudScores(stored, scores)); err != nil {
+			return fmt.Errorf("failed to store fraud scores: %v", err)
+		}
+	}
+	cli.Success(fmt.Sprintf("Stored %d fraud scores", len(scores)))
+	return nil
+}
+
+// runFraudInfo prints the fraud model's evaluation and feature importance
+func runFraudInfo(args []string) error {
+	flags := flag.NewFlagSet("fraud-info", flag.ExitOnError)
+	modelPath := flags.String("model", model.DefaultFraudModelPath, "model file")
+	flags.Parse(args)
+
+	m, err := model.LoadFraudModel(*modelPath)
+	if err != nil {
+		return err
+	}
+	printFraudModel(m, len(m.Features))
+	return nil
+}
+
+// printFraudModel prints the model's training summary, holdout metrics and
+// its top features by importance
+func printFraudModel(m *model.FraudModel, top int) {
+	fmt.Printf("   • version: %s\n", m.Version)
+	fmt.Printf("   • trained: %s on %s transactions (%d fraud) through %s\n",
+		m.TrainedAt.Format(time.RFC3339), cli.FormatNumber(int64(m.TrainingRows)), m.TrainingFrauds, m.TrainedThrough)
+	fmt.Printf("   • holdout: %s transactions (%d fraud), AUC %.3f, log loss %.4f, Brier %.4f\n",
+		cli.FormatNumber(int64(m.HoldoutRows)), m.HoldoutFrauds, m.Metrics.AUC, m.Metrics.LogLoss, m.Metrics.Brier)
+	fmt.Printf("   • calibration: p = 1 / (1 + exp(-(%.3f * z + %.3f)))\n", m.CalibrationA, m.CalibrationB)
+	fmt.Println("   • feature importance:")
+	for i, fi := range m.Importance() {
+		if i == top {
+			break
+		}
+		direction := "no effect (constant in training)"
+		if fi.Effect > 0 {
+			direction = "raises risk"
+		} else if fi.Effect < 0 {
+			direction = "lowers risk"
+		}
+		fmt.Printf("       %-28s %5.1f%%  %s\n", fi.Feature, fi.Importance*100, direction)
+	}
+}
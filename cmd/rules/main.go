@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/bq"
+	"aml-system/internal/cli"
+	"aml-system/internal/detect"
+	"aml-system/internal/rules"
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  rules validate [-dir config/rules]")
+	fmt.Println("  rules sql [-dir config/rules] [-rule NAME] [-incremental] [-out file.sql | -into sql/incremental_aml_processing.sql]")
+	fmt.Println("  rules test [-dir config/rules] [-rule NAME] [-since YYYY-MM-DD] [-local dir]")
+}
+
+func main() {
+	cli.Title("🏦 AML Detection Rules")
+	cli.Title(strings.Repeat("=", 50))
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	var err error
+	switch os.Args[1] {
+	case "validate":
+		err = runValidate(os.Args[2:])
+	case "sql":
+		err = runSQL(os.Args[2:])
+	case "test":
+		err = runTest(ctx, os.Args[2:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		cli.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// selectRules loads the rules of dir, or just the one named
+func selectRules(dir, name string) ([]*rules.Compiled, error) {
+	compiled, err := rules.LoadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		if len(compiled) == 0 {
+			return nil, fmt.Errorf("no rule files in %s", dir)
+		}
+		return compiled, nil
+	}
+	for _, rule := range compiled {
+		if rule.Name() == strings.ToUpper(name) {
+			return []*rules.Compiled{rule}, nil
+		}
+	}
+	return nil, fmt.Errorf("no rule %s in %s", name, dir)
+}
+
+// runValidate compiles every rule file, as a review check before merging
+func runValidate(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	dir := flags.String("dir", rules.DefaultDir, "rules directory")
+	flags.Parse(args)
+
+	compiled, err := selectRules(*dir, "")
+	if err != nil {
+		return err
+	}
+	for _, rule := range compiled {
+		fmt.Printf("   • %-22s %s, by %s over %s (%s)\n", rule.Name(),
+			plural(len(rule.Rule.Conditions), "condition"), strings.Join(rule.Rule.GroupBy, "+"), rule.Rule.Window, rule.Path)
+	}
+	cli.Success(fmt.Sprintf("%d rules valid", len(compiled)))
+	return nil
+}
+
+// Markers around the generated rule statements of a script written with -into
+const (
+	beginMarker = "-- BEGIN GENERATED RULES"
+	endMarker   = "-- END GENERATED RULES"
+)
+
+// runSQL prints or writes the BigQuery statements of the rules. The
+// -incremental form evaluates windows after last_processed_time; -into
+// replaces the statements between the generated rule markers of
+// sql/incremental_aml_processing.sql with it.
+func runSQL(args []string) error {
+	flags := flag.NewFlagSet("sql", flag.ExitOnError)
+	dir := flags.String("dir", rules.DefaultDir, "rules directory")
+	name := flags.String("rule", "", "only this rule (default: all)")
+	incremental := flags.Bool("incremental", false, "only windows ending after last_processed_time")
+	out := flags.String("out", "", "write the SQL to this file (default: stdout)")
+	into := flags.String("into", "", "replace the generated rules of this script (implies -incremental)")
+	flags.Parse(args)
+
+	compiled, err := selectRules(*dir, *name)
+	if err != nil {
+		return err
+	}
+	if *into != "" {
+		return spliceRules(*into, *dir, compiled)
+	}
+
+	var b strings.Builder
+	b.WriteString("-- ============================================================================\n")
+	fmt.Fprintf(&b, "-- RULE DETECTION - generated from %s by `rules sql`; edit the rule files\n", *dir)
+	b.WriteString("-- ============================================================================\n")
+	for _, rule := range compiled {
+		b.WriteString("\n")
+		b.WriteString(rule.SQL(bq.TableRef, *incremental))
+	}
+
+	if *out == "" {
+		fmt.Print(b.String())
+		return nil
+	}
+	if err := os.WriteFile(*out, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", *out, err)
+	}
+	cli.Success(fmt.Sprintf("Wrote %d rules to %s", len(compiled), *out))
+	return nil
+}
+
+// spliceRules rewrites the incremental statements of the rules between the
+// markers of script, keeping the markers' indentation
+func spliceRules(script, dir string, compiled []*rules.Compiled) error {
+	data, err := os.ReadFile(script)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", script, err)
+	}
+	content := string(data)
+	begin := strings.Index(content, beginMarker)
+	end := strings.Index(content, endMarker)
+	if begin < 0 || end < begin {
+		return fmt.Errorf("%s has no %q ... %q section", script, beginMarker, endMarker)
+	}
+	lineStart := strings.LastIndex(content[:begin], "\n") + 1
+	indent := content[lineStart:begin]
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (from %s by: rules sql -into)\n", beginMarker, dir)
+	for _, rule := range compiled {
+		for _, line := range strings.Split(rule.SQL(bq.TableRef, true), "\n") {
+			if line == "" {
+				b.WriteString(strings.TrimRight(indent, " ") + "\n")
+			} else {
+				b.WriteString(indent + line + "\n")
+			}
+		}
+	}
+	b.WriteString(indent)
+
+	content = content[:begin] + b.String() + content[end:]
+	if err := os.WriteFile(script, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", script, err)
+	}
+	cli.Success(fmt.Sprintf("Wrote %d rules into %s", len(compiled), script))
+	return nil
+}
+
+// runTest runs rules over stored transactions and prints the alerts they
+// would raise without storing them
+func runTest(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	dir := flags.String("dir", rules.DefaultDir, "rules directory")
+	name := flags.String("rule", "", "only this rule (default: all)")
+	since := flags.String("since", "", "only alert on activity after this date (YYYY-MM-DD, default: all)")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	compiled, err := selectRules(*dir, *name)
+	if err != nil {
+		return err
+	}
+	var sinceTime time.Time
+	if *since != "" {
+		if sinceTime, err = time.Parse(aml.DateLayout, *since); err != nil {
+			return fmt.Errorf("invalid -since date: %v", err)
+		}
+	}
+
+	detectors := make([]detect.Detector, len(compiled))
+	for i, rule := range compiled {
+		detectors[i] = rule
+	}
+	historyStart := sinceTime
+	if !sinceTime.IsZero() {
+		historyStart = sinceTime.Add(-detect.Lookback(detectors))
+	}
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	cli.Processing("Loading transactions...")
+	transactions, err := st.Transactions(ctx, historyStart)
+	if err != nil {
+		return err
+	}
+	cli.Status(fmt.Sprintf("Running %d rules over %s transactions", len(detectors), cli.FormatNumber(int64(len(transactions)))))
+
+	alerts := detect.Run(detectors, transactions, sinceTime)
+	for _, alert := range alerts {
+		fmt.Printf("   • %s %s [%s %d %s] %s\n", alert.AlertDate, alert.CustomerID, alert.AlertType, alert.RiskScore, alert.Priority, alert.Description)
+	}
+	if len(alerts) == 0 {
+		cli.Success("No alerts")
+		return nil
+	}
+	cli.Success(fmt.Sprintf("%d alerts (not stored; `detect run` raises them)", len(alerts)))
+	return nil
+}
+
+// plural renders "1 condition" / "3 conditions"
+func plural(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/cases"
+	"aml-system/internal/cli"
+	"aml-system/internal/fincen"
+	"aml-system/internal/sar"
+	"aml-system/internal/store"
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  sar prepare -case N -by NAME [-template config/sar_narrative.tmpl] [-filer config/fincen_filer.json] [-out narrative.txt] [-local dir]")
+	fmt.Println("  sar narrative -filing N -file narrative.txt -by NAME [-local dir]")
+	fmt.Println("  sar export -out sar_batch.xml -by NAME [-filing N] [-filer config/fincen_filer.json] -xsd EFL_SARXBatchSchema.xsd|-no-validate [-local dir]")
+	fmt.Println("  sar ack -filing N -bsa-id ID -by NAME [-local dir]")
+	fmt.Println("  sar list [-status DRAFT|EXPORTED|ACKNOWLEDGED] [-local dir]")
+	fmt.Println("  sar show -filing N [-local dir]")
+}
+
+func main() {
+	cli.Title("🏦 AML Suspicious Activity Reports")
+	cli.Title(strings.Repeat("=", 50))
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	var err error
+	switch os.Args[1] {
+	case "prepare":
+		err = runPrepare(ctx, os.Args[2:])
+	case "narrative":
+		err = runNarrative(ctx, os.Args[2:])
+	case "export":
+		err = runExport(ctx, os.Args[2:])
+	case "ack":
+		err = runAck(ctx, os.Args[2:])
+	case "list":
+		err = runList(ctx, os.Args[2:])
+	case "show":
+		err = runShow(ctx, os.Args[2:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		cli.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// loadFilings reads every SAR filing
+func loadFilings(ctx context.Context, st store.Store) ([]sar.Filing, error) {
+	var filings []sar.Filing
+	if err := st.Load(ctx, sar.FilingsTable, &filings); err != nil {
+		return nil, fmt.Errorf("failed to load SAR filings: %v", err)
+	}
+	return filings, nil
+}
+
+// storeFilings writes the filings back
+func storeFilings(ctx context.Context, st store.Store, filings []sar.Filing) error {
+	if err := st.Replace(ctx, sar.FilingsTable, filings); err != nil {
+		return fmt.Errorf("failed to store SAR filings: %v", err)
+	}
+	return nil
+}
+
+// noteCases adds a note to the case of each filing
+func noteCases(ctx context.Context, st store.Store, filings []sar.Filing, by string, note func(sar.Filing) string) error {
+	book, err := cases.Load(ctx, st)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, filing := range filings {
+		if _, err := cases.AddNote(ctx, st, book, filing.CaseID, note(filing), by, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runPrepare assembles a case's report and drafts its narrative. A case
+// has one draft at a time; preparing it again redrafts it.
+func runPrepare(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("prepare", flag.ExitOnError)
+	caseID := flags.Int64("case", 0, "case ID, or the ID of any of its alerts")
+	by := flags.String("by", "", "analyst preparing the report")
+	templatePath := flags.String("template", sar.DefaultTemplatePath, "narrative template")
+	filerPath := flags.String("filer", fincen.DefaultFilerConfig, "filing institution config")
+	out := flags.String("out", "", "file to write the narrative draft to (default: sar_<filing>_narrative.txt)")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	if strings.TrimSpace(*by) == "" {
+		return fmt.Errorf("-by is required")
+	}
+	filer, err := fincen.LoadFiler(*filerPath)
+	if err != nil {
+		return err
+	}
+	tmpl, err := sar.LoadTemplate(*templatePath)
+	if err != nil {
+		return err
+	}
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	report, err := sar.AssembleCase(ctx, st, *caseID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	report.Institution = filer.Name
+	report.PreparedBy = *by
+	report.PreparedOn = now.Format(aml.DateLayout)
+	text, err := sar.Narrative(tmpl, report)
+	if err != nil {
+		return err
+	}
+
+	filings, err := loadFilings(ctx, st)
+	if err != nil {
+		return err
+	}
+	filing := sar.NewFiling(report, text, *by, now)
+	if i := sar.Draft(filings, report.CaseID); i >= 0 {
+		filing.FilingID = filings[i].FilingID
+		filings[i] = filing
+		cli.Status(fmt.Sprintf("Redrafting filing %d", filing.FilingID))
+	} else {
+		if filing.FilingID, err = st.NextID(ctx, sar.FilingsTable, "filing_id"); err != nil {
+			return err
+		}
+		filings = append(filings, filing)
+	}
+	if err := storeFilings(ctx, st, filings); err != nil {
+		return err
+	}
+
+	path := *out
+	if path == "" {
+		path = fmt.Sprintf("sar_%d_narrative.txt", filing.FilingID)
+	}
+	if err := os.WriteFile(path, []byte(text+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	fmt.Printf("   • Subject:      %s %s (%s), %s\n", report.Subject.First, report.Subject.Last, report.Subject.DOB, strings.Join(report.Subject.Cards, ", "))
+	fmt.Printf("   • Activity:     %s to %s, %d transactions, $%s\n", report.From, report.To, len(report.Transactions), aml.FormatAmount(report.TotalAmount))
+	fmt.Printf("   • Alerts:       %s (%s)\n", joinIDs(filing.AlertIDs), strings.Join(report.AlertTypes, ", "))
+	cli.Success(fmt.Sprintf("Drafted SAR filing %d for case %d; narrative written to %s", filing.FilingID, report.CaseID, path))
+	if sar.CheckNarrative(text) != nil {
+		cli.Status(fmt.Sprintf("Complete the %s ... ]] parts, then: sar narrative -filing %d -file %s", sar.Placeholder, filing.FilingID, path))
+	}
+	return nil
+}
+
+// runNarrative replaces the narrative of a draft with the analyst's text
+func runNarrative(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("narrative", flag.ExitOnError)
+	filingID := flags.Int64("filing", 0, "draft filing to update")
+	file := flags.String("file", "", "narrative text file")
+	by := flags.String("by", "", "analyst writing the narrative")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	if strings.TrimSpace(*by) == "" {
+		return fmt.Errorf("-by is required")
+	}
+	data, err := os.ReadFile(*file)
+	if err != nil {
+		return fmt.Errorf("failed to read narrative: %v", err)
+	}
+	text := strings.TrimSpace(string(data))
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	filings, err := loadFilings(ctx, st)
+	if err != nil {
+		return err
+	}
+	i, err := sar.Find(filings, *filingID)
+	if err != nil {
+		return err
+	}
+	if filings[i].Status != sar.StatusDraft {
+		return fmt.Errorf("filing %d is %s; only %s filings can be changed", *filingID, filings[i].Status, sar.StatusDraft)
+	}
+	filings[i].Narrative = text
+	filings[i].PreparedBy = *by
+	filings[i].PreparedAt = time.Now().UTC()
+	if err := storeFilings(ctx, st, filings); err != nil {
+		return err
+	}
+	if err := sar.CheckNarrative(text); err != nil {
+		cli.Warning(fmt.Sprintf("Saved, but it cannot be exported yet: %v", err))
+		return nil
+	}
+	cli.Success(fmt.Sprintf("Narrative of filing %d saved (%d characters)", *filingID, len([]rune(text))))
+	return nil
+}
+
+// runExport writes draft filings to a FinCEN SAR batch file, marks them as
+// exported with the batch ID and file checksum, and notes it on the cases
+func runExport(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	out := flags.String("out", "", "output XML file")
+	by := flags.String("by", "", "who exports the batch")
+	filingID := flags.Int64("filing", 0, "only export this filing (default: every draft)")
+	filerPath := flags.String("filer", fincen.DefaultFilerConfig, "filing institution config")
+	xsd := flags.String("xsd", "", "FinCEN SAR batch schema to validate the file against with xmllint")
+	noValidate := flags.Bool("no-validate", false, "export without validating against the schema")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	if *out == "" {
+		return fmt.Errorf("-out is required")
+	}
+	if strings.TrimSpace(*by) == "" {
+		return fmt.Errorf("-by is required")
+	}
+	if *xsd == "" && !*noValidate {
+		return fmt.Errorf("-xsd is required to validate the batch; pass -no-validate to export without it")
+	}
+	if *xsd != "" && *noValidate {
+		return fmt.Errorf("-xsd cannot be combined with -no-validate")
+	}
+	filer, err := fincen.LoadFiler(*filerPath)
+	if err != nil {
+		return err
+	}
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	filings, err := loadFilings(ctx, st)
+	if err != nil {
+		return err
+	}
+	var selected []int
+	var batch []sar.Filing
+	for i, filing := range filings {
+		if filing.Status != sar.StatusDraft || (*filingID != 0 && filing.FilingID != *filingID) {
+			continue
+		}
+		selected = append(selected, i)
+		batch = append(batch, filing)
+	}
+	if len(batch) == 0 {
+		cli.Warning("No draft SAR filings to export")
+		return nil
+	}
+
+	now := time.Now().UTC()
+	data, err := fincen.SARBatch(filer, batch, now)
+	if err != nil {
+		return err
+	}
+	if err := cli.WriteXML(*out, data, *xsd); err != nil {
+		return err
+	}
+	if *noValidate {
+		cli.Warning(fmt.Sprintf("%s was not validated against the FinCEN schema", *out))
+	}
+
+	sum := sha256.Sum256(data)
+	batchID := "SAR-" + now.Format("20060102150405")
+	for _, i := range selected {
+		filings[i].Status = sar.StatusExported
+		filings[i].BatchID = batchID
+		filings[i].File = *out
+		filings[i].Checksum = hex.EncodeToString(sum[:])
+		filings[i].ExportedAt = now
+	}
+	if err := storeFilings(ctx, st, filings); err != nil {
+		return err
+	}
+	if err := noteCases(ctx, st, batch, *by, func(f sar.Filing) string {
+		return fmt.Sprintf("SAR filing %d exported in batch %s", f.FilingID, batchID)
+	}); err != nil {
+		return err
+	}
+
+	cli.Success(fmt.Sprintf("Exported %d SARs to %s (batch %s)", len(batch), *out, batchID))
+	cli.Status("Record the BSA IDs FinCEN returns with: sar ack -filing N -bsa-id ID")
+	return nil
+}
+
+// runAck records the BSA ID FinCEN assigned to an accepted filing
+func runAck(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("ack", flag.ExitOnError)
+	filingID := flags.Int64("filing", 0, "exported filing FinCEN accepted")
+	bsaID := flags.String("bsa-id", "", "BSA ID from the FinCEN acknowledgement")
+	by := flags.String("by", "", "who records the acknowledgement")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	if *bsaID == "" || strings.Trim(*bsaID, "0123456789") != "" {
+		return fmt.Errorf("-bsa-id must be the numeric BSA ID")
+	}
+	if strings.TrimSpace(*by) == "" {
+		return fmt.Errorf("-by is required")
+	}
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	filings, err := loadFilings(ctx, st)
+	if err != nil {
+		return err
+	}
+	i, err := sar.Find(filings, *filingID)
+	if err != nil {
+		return err
+	}
+	if filings[i].Status != sar.StatusExported {
+		return fmt.Errorf("filing %d is %s, not %s", *filingID, filings[i].Status, sar.StatusExported)
+	}
+	filings[i].Status = sar.StatusAcknowledged
+	filings[i].BSAID = *bsaID
+	filings[i].AcknowledgedAt = time.Now().UTC()
+	if err := storeFilings(ctx, st, filings); err != nil {
+		return err
+	}
+	if err := noteCases(ctx, st, filings[i:i+1], *by, func(f sar.Filing) string {
+		return fmt.Sprintf("SAR filing %d accepted by FinCEN, BSA ID %s", f.FilingID, f.BSAID)
+	}); err != nil {
+		return err
+	}
+	cli.Success(fmt.Sprintf("Filing %d acknowledged with BSA ID %s", *filingID, *bsaID))
+	return nil
+}
+
+// runList prints the filings
+func runList(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	status := flags.String("status", "", "only filings in this status")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	filings, err := loadFilings(ctx, st)
+	if err != nil {
+		return err
+	}
+	shown := 0
+	for _, f := range filings {
+		if *status != "" && f.Status != strings.ToUpper(*status) {
+			continue
+		}
+		if shown == 0 {
+			fmt.Printf("%6s  %6s  %-12s  %-24s  %-23s  %12s  %-20s  %s\n", "FILING", "CASE", "STATUS", "SUBJECT", "ACTIVITY", "AMOUNT", "BATCH", "BSA ID")
+		}
+		batch, bsaID := f.BatchID, f.BSAID
+		if batch == "" {
+			batch = "-"
+		}
+		if bsaID == "" {
+			bsaID = "-"
+		}
+		fmt.Printf("%6d  %6d  %-12s  %-24s  %-10s to %-10s  %12s  %-20s  %s\n", f.FilingID, f.CaseID, f.Status, f.CustomerID,
+			f.ActivityFrom, f.ActivityTo, "$"+aml.FormatAmount(f.TotalAmount), batch, bsaID)
+		shown++
+	}
+	if shown == 0 {
+		cli.Warning("No SAR filings found")
+	}
+	return nil
+}
+
+// runShow prints a filing with its narrative
+func runShow(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("show", flag.ExitOnError)
+	filingID := flags.Int64("filing", 0, "filing to show")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	filings, err := loadFilings(ctx, st)
+	if err != nil {
+		return err
+	}
+	i, err := sar.Find(filings, *filingID)
+	if err != nil {
+		return err
+	}
+	f := filings[i]
+	fmt.Printf("   • Filing:       %d (%s), case %d\n", f.FilingID, f.Status, f.CaseID)
+	fmt.Printf("   • Subject:      %s %s (%s), %s, %s %s %s\n", f.First, f.Last, f.DOB, f.Street, f.City, f.State, f.Zip)
+	fmt.Printf("   • Cards:        %s\n", strings.Join(f.Cards, ", "))
+	fmt.Printf("   • Activity:     %s to %s, %d transactions, $%s\n", f.ActivityFrom, f.ActivityTo, len(f.TransNums), aml.FormatAmount(f.TotalAmount))
+	fmt.Printf("   • Alerts:       %s (%s)\n", joinIDs(f.AlertIDs), strings.Join(f.AlertTypes, ", "))
+	fmt.Printf("   • Prepared:     %s by %s\n", f.PreparedAt.Format(time.RFC3339), f.PreparedBy)
+	if f.BatchID != "" {
+		fmt.Printf("   • Exported:     %s in %s (%s, sha256 %s)\n", f.ExportedAt.Format(time.RFC3339), f.BatchID, f.File, f.Checksum)
+	}
+	if f.BSAID != "" {
+		fmt.Printf("   • BSA ID:       %s, %s\n", f.BSAID, f.AcknowledgedAt.Format(time.RFC3339))
+	}
+	fmt.Println()
+	fmt.Println(f.Narrative)
+	return nil
+}
+
+func joinIDs(ids []int64) string {
+	parts := make([]string, len(ids))
+	for i, id := range ids {
+		parts[i] = fmt.Sprint(id)
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/cli"
+	"aml-system/internal/configstore"
+	"aml-system/internal/intake"
+	"aml-system/internal/screening"
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  screen import -source OFAC_SDN|EU_CONSOLIDATED|UN_CONSOLIDATED [-local dir] file...")
+	fmt.Println("  screen run [-threshold 0.90] [-since YYYY-MM-DD] [-wires wires.csv] [-local dir]")
+}
+
+func main() {
+	cli.Title("🏦 AML Sanctions Screening")
+	cli.Title(strings.Repeat("=", 50))
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	var err error
+	switch os.Args[1] {
+	case "import":
+		err = runImport(ctx, os.Args[2:])
+	case "run":
+		err = runScreening(ctx, os.Args[2:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		cli.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// runImport replaces the stored entries of one list with the given files
+func runImport(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	source := flags.String("source", screening.SourceOFACSDN, "list source: "+strings.Join(screening.Sources(), ", "))
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no list files given")
+	}
+
+	var imported []screening.Entry
+	for _, path := range flags.Args() {
+		cli.Processing(fmt.Sprintf("Parsing %s list from %s...", *source, path))
+		entries, err := screening.LoadFile(path, *source)
+		if err != nil {
+			return err
+		}
+		cli.Status(fmt.Sprintf("Parsed %s entries", cli.FormatNumber(int64(len(entries)))))
+		imported = append(imported, entries...)
+	}
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	var existing []screening.Entry
+	if err := st.Load(ctx, screening.EntriesTable, &existing); err != nil {
+		return fmt.Errorf("failed to load existing list entries: %v", err)
+	}
+
+	kept := existing[:0]
+	for _, entry := range existing {
+		if entry.Source != *source {
+			kept = append(kept, entry)
+		}
+	}
+	if err := st.Replace(ctx, screening.EntriesTable, append(kept, imported...)); err != nil {
+		return fmt.Errorf("failed to store list entries: %v", err)
+	}
+
+	cli.Success(fmt.Sprintf("Imported %s %s entries (%s entries from other lists kept)",
+		cli.FormatNumber(int64(len(imported))), *source, cli.FormatNumber(int64(len(kept)))))
+	return nil
+}
+
+// runScreening screens customers, merchants and wire counterparties and
+// raises SANCTIONS_HIT alerts for new matches
+func runScreening(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	threshold := flags.Float64("threshold", screening.DefaultThreshold, "minimum match score (0-1)")
+	sinceFlag := flags.String("since", "", "only screen activity after this date (default: all history)")
+	wiresFile := flags.String("wires", "", "optional wires CSV to screen counterparties")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	var since time.Time
+	if *sinceFlag != "" {
+		parsed, err := time.Parse(aml.DateLayout, *sinceFlag)
+		if err != nil {
+			return fmt.Errorf("invalid -since date: %v", err)
+		}
+		since = parsed
+	}
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	var entries []screening.Entry
+	if err := st.Load(ctx, screening.EntriesTable, &entries); err != nil {
+		return fmt.Errorf("failed to load list entries: %v", err)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no sanctions list entries stored; run 'screen import' first")
+	}
+	screener := screening.NewScreener(entries, *threshold)
+	entryCount, nameCount := screener.Size()
+	cli.Status(fmt.Sprintf("Loaded %s list entries (%s names incl. aliases), threshold %.2f",
+		cli.FormatNumber(int64(entryCount)), cli.FormatNumber(int64(nameCount)), *threshold))
+
+	startedAt := time.Now().UTC()
+	cli.Processing("Loading transactions...")
+	transactions, err := st.Transactions(ctx, since)
+	if err != nil {
+		return err
+	}
+	cli.Status(fmt.Sprintf("Loaded %s transactions", cli.FormatNumber(int64(len(transactions)))))
+
+	subjects := append(screening.CustomerSubjects(transactions), screening.MerchantSubjects(transactions)...)
+	if *wiresFile != "" {
+		wires, err := screening.ReadWiresCSV(*wiresFile)
+		if err != nil {
+			return err
+		}
+		subjects = append(subjects, screening.CounterpartySubjects(wires)...)
+		cli.Status(fmt.Sprintf("Loaded %s wires", cli.FormatNumber(int64(len(wires)))))
+	}
+
+	var previous []screening.MatchRecord
+	if err := st.Load(ctx, screening.MatchesTable, &previous); err != nil {
+		return fmt.Errorf("failed to load previous matches: %v", err)
+	}
+	seen := map[string]bool{}
+	for _, record := range previous {
+		seen[matchKey(record.CustomerID, record.SubjectType, record.SubjectName, record.ListSource, record.ListUID)] = true
+	}
+
+	cli.Processing(fmt.Sprintf("Screening %s names...", cli.FormatNumber(int64(len(subjects)))))
+	var hits []screening.Hit
+	for _, subject := range subjects {
+		matches := screener.Screen(subject.Type, subject.Name)
+		if len(matches) == 0 {
+			continue
+		}
+
+		// Only alert customers who have not already been flagged for this entry
+		var fresh []screening.Party
+		for _, party := range subject.Parties {
+			if !seen[matchKey(party.CustomerID, subject.Type, subject.Name, matches[0].Entry.Source, matches[0].Entry.UID)] {
+				fresh = append(fresh, party)
+			}
+		}
+		if len(fresh) == 0 {
+			continue
+		}
+		subject.Parties = fresh
+		hits = append(hits, screening.Hit{Subject: subject, Matches: matches})
+	}
+
+	if len(hits) == 0 {
+		cli.Success("No new sanctions matches")
+		return nil
+	}
+
+	alerts, matches := screening.Alerts(hits)
+	parameters, err := configstore.Parameters(map[string]interface{}{
+		"match_threshold": *threshold,
+		"list_entries":    entryCount,
+		"wires":           *wiresFile,
+	})
+	if err != nil {
+		return err
+	}
+	batch, err := intake.Prepare(ctx, st, configstore.Run{
+		Source:         "sanctions screen",
+		ConfigChecksum: configstore.Checksum(parameters, nil),
+		Config:         parameters,
+		Since:          since,
+		StartedAt:      startedAt,
+		Transactions:   int64(len(transactions)),
+	}, alerts, transactions, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	for _, note := range batch.Notes() {
+		cli.Status(note)
+	}
+	if err := batch.Insert(ctx, st, time.Now().UTC()); err != nil {
+		return err
+	}
+	// A match on a party already alerted for the day is recorded against
+	// that alert; one whose alert was suppressed has nothing to review
+	alerts = batch.Raised
+
+	screenedAt := time.Now().UTC()
+	var records []screening.MatchRecord
+	i := 0
+	for _, hit := range hits {
+		for range hit.Subject.Parties {
+			if alerts[i].AlertID != 0 {
+				records = append(records, screening.MatchRecords(alerts[i], hit.Subject, matches[i], screenedAt)...)
+			}
+			i++
+		}
+	}
+	if len(records) > 0 {
+		if err := st.Append(ctx, screening.MatchesTable, records); err != nil {
+			return fmt.Errorf("failed to store match details: %v", err)
+		}
+	}
+
+	cli.Success(fmt.Sprintf("Raised %d SANCTIONS_HIT alerts from %d matched names (run %d)", len(batch.Alerts), len(hits), batch.Run.RunID))
+	for _, hit := range hits {
+		best := hit.Matches[0]
+		fmt.Printf("   • %s '%s' → %s '%s' (%.2f)\n",
+			hit.Subject.Type, hit.Subject.Name, best.Entry.Source, best.MatchedName, best.Score)
+	}
+	return nil
+}
+
+func matchKey(parts ...string) string {
+	return strings.Join(parts, "|")
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"aml-system/internal/cli"
+	"aml-system/internal/store"
+	"aml-system/internal/suppress"
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  suppress add [-customer ID] [-merchant NAME] [-detector GEOGRAPHIC] [-from YYYY-MM-DD] [-to YYYY-MM-DD] -reason TEXT -author NAME -expires YYYY-MM-DD [-local dir]")
+	fmt.Println("  suppress approve -rule N -approver NAME [-comment TEXT] [-local dir]")
+	fmt.Println("  suppress revoke -rule N -by NAME -comment TEXT [-local dir]")
+	fmt.Println("  suppress list [-all] [-local dir]")
+	fmt.Println("  suppress hits [-rule N] [-limit 50] [-local dir]")
+}
+
+func main() {
+	cli.Title("🏦 AML Alert Suppression")
+	cli.Title(strings.Repeat("=", 50))
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	var err error
+	switch os.Args[1] {
+	case "add":
+		err = runAdd(ctx, os.Args[2:])
+	case "approve":
+		err = runApprove(ctx, os.Args[2:])
+	case "revoke":
+		err = runRevoke(ctx, os.Args[2:])
+	case "list":
+		err = runList(ctx, os.Args[2:])
+	case "hits":
+		err = runHits(ctx, os.Args[2:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		cli.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// openRegistry opens the store and loads the suppression rules
+func openRegistry(ctx context.Context, local string) (store.Store, *suppress.Registry, error) {
+	st, err := cli.OpenStore(ctx, local)
+	if err != nil {
+		return nil, nil, err
+	}
+	registry, err := suppress.Load(ctx, st)
+	if err != nil {
+		st.Close()
+		return nil, nil, err
+	}
+	return st, registry, nil
+}
+
+// runAdd proposes a suppression rule
+func runAdd(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("add", flag.ExitOnError)
+	customer := flags.String("customer", "", "customer ID (first_last) the rule covers")
+	merchant := flags.String("merchant", "", "merchant the rule covers; alerts on activity only at it are suppressed")
+	detector := flags.String("detector", "", "alert type the rule covers (default: all)")
+	from := flags.String("from", "", "first alert date covered (YYYY-MM-DD)")
+	to := flags.String("to", "", "last alert date covered (YYYY-MM-DD)")
+	reason := flags.String("reason", "", "why the activity is legitimate")
+	author := flags.String("author", "", "who proposes the rule")
+	expires := flags.String("expires", "", fmt.Sprintf("last day the rule applies, at most %d days ahead (YYYY-MM-DD)", suppress.MaxDays))
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, registry, err := openRegistry(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	rule, err := suppress.Propose(ctx, st, registry, suppress.Rule{
+		CustomerID: *customer,
+		Merchant:   *merchant,
+		Detector:   *detector,
+		FromDate:   *from,
+		ToDate:     *to,
+		Reason:     *reason,
+		Author:     *author,
+		Expires:    *expires,
+	}, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	cli.Success(fmt.Sprintf("Proposed rule %d (%s, until %s); it needs approval by someone other than %s",
+		rule.RuleID, rule.Scope(), rule.Expires, rule.Author))
+	return nil
+}
+
+// runApprove puts a proposed rule in force
+func runApprove(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("approve", flag.ExitOnError)
+	rule := flags.Int64("rule", 0, "rule to approve")
+	approver := flags.String("approver", "", "who signs off the rule")
+	comment := flags.String("comment", "", "comment recorded with the approval")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, registry, err := openRegistry(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	if _, err := suppress.Approve(ctx, st, registry, *rule, *approver, *comment, time.Now().UTC()); err != nil {
+		return err
+	}
+	r, _ := registry.Get(*rule)
+	cli.Success(fmt.Sprintf("Rule %d approved by %s; it suppresses alerts until %s", *rule, *approver, r.Expires))
+	return nil
+}
+
+// runRevoke withdraws a rule before its expiry
+func runRevoke(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("revoke", flag.ExitOnError)
+	rule := flags.Int64("rule", 0, "rule to revoke")
+	by := flags.String("by", "", "who revokes the rule")
+	comment := flags.String("comment", "", "why the rule no longer holds")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, registry, err := openRegistry(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	if _, err := suppress.Revoke(ctx, st, registry, *rule, *by, *comment, time.Now().UTC()); err != nil {
+		return err
+	}
+	cli.Warning(fmt.Sprintf("Rule %d revoked by %s", *rule, *by))
+	return nil
+}
+
+// runList prints the rules with their status, approver and hits
+func runList(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	all := flags.Bool("all", false, "include expired and revoked rules")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, registry, err := openRegistry(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	var hits []suppress.Hit
+	if err := st.Load(ctx, suppress.HitsTable, &hits); err != nil {
+		return fmt.Errorf("failed to load suppressed alerts: %v", err)
+	}
+	counts := suppress.Counts(hits)
+
+	now := time.Now().UTC()
+	shown := 0
+	fmt.Printf("%5s  %-8s  %-10s  %-16s  %-16s  %6s  %-50s  %s\n", "RULE", "STATUS", "EXPIRES", "AUTHOR", "APPROVER", "HITS", "SCOPE", "REASON")
+	for _, rule := range registry.Rules {
+		status := registry.Status(rule, now)
+		if !*all && (status == suppress.StatusExpired || status == suppress.StatusRevoked) {
+			continue
+		}
+		approver := "-"
+		if e, ok := registry.Decision(rule.RuleID, suppress.ActionApprove); ok {
+			approver = e.Actor
+		}
+		fmt.Printf("%5d  %-8s  %-10s  %-16s  %-16s  %6d  %-50s  %s\n",
+			rule.RuleID, status, rule.Expires, rule.Author, approver, counts[rule.RuleID], rule.Scope(), rule.Reason)
+		shown++
+	}
+	if shown == 0 {
+		cli.Warning("No suppression rules in force")
+	}
+	return nil
+}
+
+// runHits prints the latest suppressed alerts
+func runHits(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("hits", flag.ExitOnError)
+	rule := flags.Int64("rule", 0, "only alerts suppressed by this rule")
+	limit := flags.Int("limit", 50, "number of alerts to show")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	var hits []suppress.Hit
+	if err := st.Load(ctx, suppress.HitsTable, &hits); err != nil {
+		return fmt.Errorf("failed to load suppressed alerts: %v", err)
+	}
+	matched := hits[:0]
+	for _, hit := range hits {
+		if *rule == 0 || hit.RuleID == *rule {
+			matched = append(matched, hit)
+		}
+	}
+	if len(matched) == 0 {
+		cli.Warning("No suppressed alerts")
+		return nil
+	}
+	sort.SliceStable(matched, func(i, j int) bool { return matched[i].SuppressedAt.After(matched[j].SuppressedAt) })
+	if len(matched) > *limit {
+		matched = matched[:*limit]
+	}
+	fmt.Printf("%5s  %-16s  %5s  %-10s  %-22s  %-24s  %5s  %s\n", "RULE", "SOURCE", "RUN", "DATE", "TYPE", "CUSTOMER", "SCORE", "DESCRIPTION")
+	for _, hit := range matched {
+		run := "-"
+		if hit.RunID > 0 {
+			run = fmt.Sprint(hit.RunID)
+		}
+		fmt.Printf("%5d  %-16s  %5s  %-10s  %-22s  %-24s  %5d  %s\n",
+			hit.RuleID, hit.Source, run, hit.AlertDate, hit.AlertType, hit.CustomerID, hit.RiskScore, hit.Description)
+	}
+	return nil
+}
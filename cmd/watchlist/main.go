@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/cli"
+	"aml-system/internal/configstore"
+	"aml-system/internal/intake"
+	"aml-system/internal/screening"
+	"aml-system/internal/watchlist"
+)
+
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  watchlist import -list NAME -category PEP|ADVERSE_MEDIA|INTERNAL [-risk-category HIGH] [-local dir] file")
+	fmt.Println("  watchlist lists [-local dir]")
+	fmt.Println("  watchlist screen [-threshold 0.90] [-local dir]")
+}
+
+func main() {
+	cli.Title("🏦 AML Watchlist Screening")
+	cli.Title(strings.Repeat("=", 50))
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	ctx := context.Background()
+	var err error
+	switch os.Args[1] {
+	case "import":
+		err = runImport(ctx, os.Args[2:])
+	case "lists":
+		err = runLists(ctx, os.Args[2:])
+	case "screen":
+		err = runScreen(ctx, os.Args[2:])
+	default:
+		usage()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		cli.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// runImport replaces one watchlist and its entries
+func runImport(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	name := flags.String("list", "", "watchlist name (JSON files may set list_name)")
+	category := flags.String("category", "", "list category: "+strings.Join(watchlist.Categories(), ", "))
+	riskCategory := flags.String("risk-category", "", "customer risk category a hit raises profiles to")
+	description := flags.String("description", "", "free-text description of the list")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("expected exactly one list file")
+	}
+
+	list, entries, err := watchlist.LoadFile(flags.Arg(0), watchlist.List{
+		ListName:     *name,
+		Category:     strings.ToUpper(*category),
+		RiskCategory: strings.ToUpper(*riskCategory),
+		Description:  *description,
+	})
+	if err != nil {
+		return err
+	}
+	list.ImportedAt = time.Now().UTC()
+	cli.Status(fmt.Sprintf("Parsed %s entries for %s list '%s'", cli.FormatNumber(list.EntryCount), list.Category, list.ListName))
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	var lists []watchlist.List
+	if err := st.Load(ctx, watchlist.ListsTable, &lists); err != nil {
+		return fmt.Errorf("failed to load watchlists: %v", err)
+	}
+	var existing []watchlist.Entry
+	if err := st.Load(ctx, watchlist.EntriesTable, &existing); err != nil {
+		return fmt.Errorf("failed to load watchlist entries: %v", err)
+	}
+
+	keptLists := []watchlist.List{list}
+	for _, l := range lists {
+		if l.ListName != list.ListName {
+			keptLists = append(keptLists, l)
+		}
+	}
+	keptEntries := existing[:0]
+	for _, entry := range existing {
+		if entry.ListName != list.ListName {
+			keptEntries = append(keptEntries, entry)
+		}
+	}
+
+	watchlist.SortLists(keptLists)
+	if err := st.Replace(ctx, watchlist.ListsTable, keptLists); err != nil {
+		return fmt.Errorf("failed to store watchlist: %v", err)
+	}
+	if err := st.Replace(ctx, watchlist.EntriesTable, append(keptEntries, entries...)); err != nil {
+		return fmt.Errorf("failed to store watchlist entries: %v", err)
+	}
+
+	cli.Success(fmt.Sprintf("Imported watchlist '%s' (%s, hits raise risk to %s)", list.ListName, list.Category, list.RiskCategory))
+	return nil
+}
+
+func runLists(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("lists", flag.ExitOnError)
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	var lists []watchlist.List
+	if err := st.Load(ctx, watchlist.ListsTable, &lists); err != nil {
+		return fmt.Errorf("failed to load watchlists: %v", err)
+	}
+	if len(lists) == 0 {
+		cli.Warning("No watchlists imported")
+		return nil
+	}
+
+	watchlist.SortLists(lists)
+	for _, list := range lists {
+		fmt.Printf("   • %-14s %-30s %6d entries → %s (imported %s)\n",
+			list.Category, list.ListName, list.EntryCount, list.RiskCategory, list.ImportedAt.Format(aml.DateLayout))
+	}
+	return nil
+}
+
+// runScreen screens every customer in the transaction table against all
+// watchlists, raises WATCHLIST alerts and lifts customer risk categories
+func runScreen(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("screen", flag.ExitOnError)
+	threshold := flags.Float64("threshold", screening.DefaultThreshold, "minimum match score (0-1)")
+	local := flags.String("local", "", "local store directory (default: BigQuery)")
+	flags.Parse(args)
+
+	st, err := cli.OpenStore(ctx, *local)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	var lists []watchlist.List
+	if err := st.Load(ctx, watchlist.ListsTable, &lists); err != nil {
+		return fmt.Errorf("failed to load watchlists: %v", err)
+	}
+	var entries []watchlist.Entry
+	if err := st.Load(ctx, watchlist.EntriesTable, &entries); err != nil {
+		return fmt.Errorf("failed to load watchlist entries: %v", err)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no watchlist entries stored; run 'watchlist import' first")
+	}
+	var previous []watchlist.Hit
+	if err := st.Load(ctx, watchlist.HitsTable, &previous); err != nil {
+		return fmt.Errorf("failed to load previous hits: %v", err)
+	}
+	cli.Status(fmt.Sprintf("Loaded %d watchlists with %s entries", len(lists), cli.FormatNumber(int64(len(entries)))))
+
+	startedAt := time.Now().UTC()
+	cli.Processing("Deriving customers from transactions...")
+	transactions, err := st.Transactions(ctx, time.Time{})
+	if err != nil {
+		return err
+	}
+	customers := screening.CustomerSubjects(transactions)
+	cli.Status(fmt.Sprintf("Screening %s customers...", cli.FormatNumber(int64(len(customers)))))
+
+	alerts, hits := watchlist.Screen(customers, lists, entries, *threshold, previous)
+	if len(alerts) == 0 {
+		cli.Success("No new watchlist hits")
+		return nil
+	}
+
+	parameters, err := configstore.Parameters(map[string]interface{}{
+		"match_threshold": *threshold,
+		"lists":           len(lists),
+		"list_entries":    len(entries),
+	})
+	if err != nil {
+		return err
+	}
+	batch, err := intake.Prepare(ctx, st, configstore.Run{
+		Source:         "watchlist screen",
+		ConfigChecksum: configstore.Checksum(parameters, nil),
+		Config:         parameters,
+		StartedAt:      startedAt,
+		Transactions:   int64(len(transactions)),
+	}, alerts, transactions, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	for _, note := range batch.Notes() {
+		cli.Status(note)
+	}
+	if err := batch.Insert(ctx, st, time.Now().UTC()); err != nil {
+		return err
+	}
+	// A hit on a customer already alerted for the day is recorded against
+	// that alert; one whose alert was suppressed is neither stored nor
+	// raises the customer's profile
+	stored := hits[:0]
+	for i, hit := range hits {
+		if hit.AlertID = batch.Raised[i].AlertID; hit.AlertID != 0 {
+			stored = append(stored, hit)
+		}
+	}
+	hits = stored
+	if len(hits) == 0 {
+		cli.Success(fmt.Sprintf("Raised no WATCHLIST alerts (run %d)", batch.Run.RunID))
+		return nil
+	}
+	if err := st.Append(ctx, watchlist.HitsTable, hits); err != nil {
+		return fmt.Errorf("failed to store watchlist hits: %v", err)
+	}
+
+	var profiles []map[string]interface{}
+	if err := st.Load(ctx, aml.CustomerProfilesTable, &profiles); err != nil {
+		return fmt.Errorf("failed to load customer risk profiles: %v", err)
+	}
+	if changed := watchlist.RaiseProfiles(profiles, hits); changed > 0 {
+		if err := st.Replace(ctx, aml.CustomerProfilesTable, profiles); err != nil {
+			return fmt.Errorf("failed to update customer risk profiles: %v", err)
+		}
+		cli.Status(fmt.Sprintf("Raised the risk category of %d customer profiles", changed))
+	}
+
+	cli.Success(fmt.Sprintf("Raised %d WATCHLIST alerts (run %d)", len(batch.Alerts), batch.Run.RunID))
+	for _, hit := range hits {
+		fmt.Printf("   • %s → %s '%s' entry '%s' (%.2f)\n", hit.CustomerName, hit.Category, hit.ListName, hit.MatchedName, hit.Score)
+	}
+	return nil
+}
@@ -6,6 +6,7 @@ require (
 	cloud.google.com/go/bigquery v1.57.1
 	github.com/fatih/color v1.16.0
 	google.golang.org/api v0.150.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
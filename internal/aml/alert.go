@@ -0,0 +1,131 @@
+package aml
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Alert types written to aml_alerts_level1
+const (
+	AlertVelocity           = "VELOCITY"
+	AlertStructuring        = "STRUCTURING"
+	AlertGeographic         = "GEOGRAPHIC"
+	AlertSanctionsHit       = "SANCTIONS_HIT"
+	AlertWatchlist          = "WATCHLIST"
+	AlertCardTesting        = "CARD_TESTING"
+	AlertDormancy           = "DORMANCY_REACTIVATION"
+	AlertHighRiskMerchant   = "HIGH_RISK_MERCHANT"
+	AlertMerchantAnomaly    = "MERCHANT_ANOMALY"
+	AlertBehaviourDeviation = "BEHAVIOUR_DEVIATION"
+	AlertPeerOutlier        = "PEER_OUTLIER"
+	AlertGraphCluster       = "GRAPH_CLUSTER"
+	AlertMLAnomaly          = "ML_ANOMALY"
+)
+
+// Alert priorities
+const (
+	PriorityHigh   = "HIGH"
+	PriorityMedium = "MEDIUM"
+	PriorityLow    = "LOW"
+)
+
+// StatusOpen is the status of a newly generated alert
+const StatusOpen = "OPEN"
+
+// StatusInReview is the status of an alert an analyst is working
+const StatusInReview = "IN_REVIEW"
+
+// Alert statuses that record an analyst's disposition
+const (
+	StatusEscalated     = "ESCALATED"
+	StatusFalsePositive = "CLOSED_FALSE_POSITIVE"
+	StatusSARFiled      = "CLOSED_SAR_FILED"
+)
+
+// Alert represents a row of the aml_alerts_level1 table
+type Alert struct {
+	AlertID       int64     `json:"alert_id"`
+	CustomerID    string    `json:"customer_id"`
+	AlertDate     string    `json:"alert_date"`
+	AlertType     string    `json:"alert_type"`
+	RiskScore     int64     `json:"risk_score"`
+	Description   string    `json:"description"`
+	Priority      string    `json:"priority"`
+	TotalAmount   float64   `json:"total_amount"`
+	Status        string    `json:"status"`
+	DetectionDate string    `json:"detection_date"`
+	CreatedAt     time.Time `json:"created_at"`
+
+	// TransNums lists the transactions that triggered the alert. They are
+	// stored separately in alert_transactions.
+	TransNums []string `json:"-"`
+}
+
+// AlertTransaction links an alert to one of its triggering transactions
+type AlertTransaction struct {
+	AlertID  int64  `json:"alert_id"`
+	TransNum string `json:"trans_num"`
+}
+
+// MerchantSubject prefixes the customer_id of alerts raised on a merchant
+// rather than a customer, so they consolidate into cases of their own and
+// are never matched to a customer's suppressions or profile
+const MerchantSubject = "merchant:"
+
+// Merchant returns the merchant the alert was raised on, if it was raised
+// on one
+func (a Alert) Merchant() (string, bool) {
+	return strings.CutPrefix(a.CustomerID, MerchantSubject)
+}
+
+// DedupKey identifies the detector, customer and window of an alert.
+// Detectors report a window on the day it closes, so rerunning them over
+// overlapping windows repeats the key of the alert they raised before.
+func (a Alert) DedupKey() string {
+	return a.AlertType + "|" + a.CustomerID + "|" + a.AlertDate
+}
+
+// PriorityForScore applies the HIGH/MEDIUM/LOW bands used by the SQL detectors
+func PriorityForScore(score int64) string {
+	switch {
+	case score >= 80:
+		return PriorityHigh
+	case score >= 50:
+		return PriorityMedium
+	default:
+		return PriorityLow
+	}
+}
+
+// ClampScore limits a risk score to the 0-100 range
+func ClampScore(score int64) int64 {
+	if score < 0 {
+		return 0
+	}
+	if score > 100 {
+		return 100
+	}
+	return score
+}
+
+// FormatAmount renders a dollar amount the way FORMAT('%\'.0f') does in the SQL
+func FormatAmount(amount float64) string {
+	whole := fmt.Sprintf("%.0f", amount)
+	negative := whole[0] == '-'
+	if negative {
+		whole = whole[1:]
+	}
+
+	result := ""
+	for i, char := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			result += ","
+		}
+		result += string(char)
+	}
+	if negative {
+		return "-" + result
+	}
+	return result
+}
@@ -0,0 +1,46 @@
+package aml
+
+// CustomerProfilesTable holds one risk profile per customer
+const CustomerProfilesTable = "customer_risk_profiles_level2"
+
+// Customer risk categories, lowest to highest
+const (
+	RiskLow      = "LOW"
+	RiskMedium   = "MEDIUM"
+	RiskHigh     = "HIGH"
+	RiskCritical = "CRITICAL"
+)
+
+var riskCategoryRank = map[string]int{
+	RiskLow:      1,
+	RiskMedium:   2,
+	RiskHigh:     3,
+	RiskCritical: 4,
+}
+
+// RiskCategoryForScore applies the 80/60/40 bands of the risk profile SQL
+func RiskCategoryForScore(score int64) string {
+	switch {
+	case score >= 80:
+		return RiskCritical
+	case score >= 60:
+		return RiskHigh
+	case score >= 40:
+		return RiskMedium
+	default:
+		return RiskLow
+	}
+}
+
+// ValidRiskCategory reports whether category is one of the four categories
+func ValidRiskCategory(category string) bool {
+	return riskCategoryRank[category] > 0
+}
+
+// RaiseRiskCategory returns the higher of current and floor
+func RaiseRiskCategory(current, floor string) string {
+	if riskCategoryRank[floor] > riskCategoryRank[current] {
+		return floor
+	}
+	return current
+}
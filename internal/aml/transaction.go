@@ -0,0 +1,189 @@
+package aml
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TransactionTimeLayout is the layout of trans_date_trans_time in the source CSV
+const TransactionTimeLayout = "2006-01-02 15:04:05"
+
+// DateLayout is the layout used for every DATE column
+const DateLayout = "2006-01-02"
+
+// Transaction represents a row of the credit_card_transactions table
+type Transaction struct {
+	TransDateTransTime time.Time `bigquery:"trans_date_trans_time" json:"trans_date_trans_time"`
+	CCNum              int64     `bigquery:"cc_num" json:"cc_num"`
+	Merchant           string    `bigquery:"merchant" json:"merchant"`
+	Category           string    `bigquery:"category" json:"category"`
+	Amount             float64   `bigquery:"amt" json:"amt"`
+	First              string    `bigquery:"first" json:"first"`
+	Last               string    `bigquery:"last" json:"last"`
+	Gender             string    `bigquery:"gender" json:"gender"`
+	Street             string    `bigquery:"street" json:"street"`
+	City               string    `bigquery:"city" json:"city"`
+	State              string    `bigquery:"state" json:"state"`
+	Zip                string    `bigquery:"zip" json:"zip"`
+	Lat                float64   `bigquery:"lat" json:"lat"`
+	Long               float64   `bigquery:"long" json:"long"`
+	CityPop            int64     `bigquery:"city_pop" json:"city_pop"`
+	Job                string    `bigquery:"job" json:"job"`
+	DOB                string    `bigquery:"dob" json:"dob"`
+	TransNum           string    `bigquery:"trans_num" json:"trans_num"`
+	UnixTime           int64     `bigquery:"unix_time" json:"unix_time"`
+	MerchLat           float64   `bigquery:"merch_lat" json:"merch_lat"`
+	MerchLong          float64   `bigquery:"merch_long" json:"merch_long"`
+	IsFraud            bool      `bigquery:"is_fraud" json:"is_fraud"`
+}
+
+// CustomerID mirrors CONCAT(first, '_', last) used throughout the SQL
+func (t Transaction) CustomerID() string {
+	return CustomerID(t.First, t.Last)
+}
+
+// PersonKey identifies the cardholder behind a transaction across all of
+// their cards. Unlike CustomerID it separates namesakes by date of birth.
+func (t Transaction) PersonKey() string {
+	return t.First + "|" + t.Last + "|" + t.DOB
+}
+
+// Date returns the transaction date in DATE column format
+func (t Transaction) Date() string {
+	return t.TransDateTransTime.Format(DateLayout)
+}
+
+// HomeDistance is the great-circle distance in km between the cardholder's
+// address and the merchant
+func (t Transaction) HomeDistance() float64 {
+	const earthRadiusKm = 6371.0
+	toRadians := func(degrees float64) float64 { return degrees * math.Pi / 180 }
+
+	lat1, lat2 := toRadians(t.Lat), toRadians(t.MerchLat)
+	dLat := lat2 - lat1
+	dLong := toRadians(t.MerchLong - t.Long)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLong/2)*math.Sin(dLong/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// CustomerID builds the customer key from a cardholder name
+func CustomerID(first, last string) string {
+	return first + "_" + last
+}
+
+// ReadTransactionsCSV loads transactions from a CSV export with the same
+// columns as the credit_card_transactions table
+func ReadTransactionsCSV(path string) ([]Transaction, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV file: %v", err)
+	}
+	defer file.Close()
+
+	return ParseTransactionsCSV(file)
+}
+
+// ParseTransactionsCSV reads transactions from r, matching columns by header name
+func ParseTransactionsCSV(r io.Reader) ([]Transaction, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, required := range []string{"trans_date_trans_time", "first", "last", "amt"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("CSV is missing required column %q", required)
+		}
+	}
+
+	var transactions []Transaction
+	line := 1
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		txn, err := parseTransaction(field)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		transactions = append(transactions, txn)
+	}
+
+	return transactions, nil
+}
+
+func parseTransaction(field func(string) string) (Transaction, error) {
+	var txn Transaction
+	var err error
+
+	txn.TransDateTransTime, err = time.Parse(TransactionTimeLayout, field("trans_date_trans_time"))
+	if err != nil {
+		return txn, fmt.Errorf("invalid trans_date_trans_time: %v", err)
+	}
+	if txn.Amount, err = parseFloat(field("amt")); err != nil {
+		return txn, fmt.Errorf("invalid amt: %v", err)
+	}
+
+	txn.CCNum, _ = parseInt(field("cc_num"))
+	txn.Merchant = field("merchant")
+	txn.Category = field("category")
+	txn.First = field("first")
+	txn.Last = field("last")
+	txn.Gender = field("gender")
+	txn.Street = field("street")
+	txn.City = field("city")
+	txn.State = field("state")
+	txn.Zip = field("zip")
+	txn.Lat, _ = parseFloat(field("lat"))
+	txn.Long, _ = parseFloat(field("long"))
+	txn.CityPop, _ = parseInt(field("city_pop"))
+	txn.Job = field("job")
+	txn.DOB = field("dob")
+	txn.TransNum = field("trans_num")
+	txn.UnixTime, _ = parseInt(field("unix_time"))
+	txn.MerchLat, _ = parseFloat(field("merch_lat"))
+	txn.MerchLong, _ = parseFloat(field("merch_long"))
+	txn.IsFraud = field("is_fraud") == "1" || strings.EqualFold(field("is_fraud"), "true")
+
+	return txn, nil
+}
+
+func parseFloat(s string) (float64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func parseInt(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
@@ -0,0 +1,217 @@
+// Package backtest replays historical transactions through the detectors
+// and measures the alerts against the is_fraud labels and the analysts'
+// dispositions of the alerts raised at the time.
+package backtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/detect"
+)
+
+// AllDetectors is the Detector of the row combining every detector of a
+// threshold set
+const AllDetectors = "ALL"
+
+// Window is the replayed period: activity after From up to and including
+// the day of To
+type Window struct {
+	From time.Time
+	To   time.Time
+}
+
+// Contains reports whether t falls in the window
+func (w Window) Contains(t time.Time) bool {
+	return t.After(w.From) && t.Before(w.To.AddDate(0, 0, 1))
+}
+
+// ThresholdSet is a named detector configuration to evaluate
+type ThresholdSet struct {
+	Name      string
+	Detectors []detect.Detector
+}
+
+// Result measures one detector, or AllDetectors, under one threshold set.
+// An alert is a true positive when any of its transactions is labelled
+// fraud; recall is the share of the window's fraud transactions that are
+// part of an alert. Dispositions come from the stored alert with the same
+// type, customer and date.
+type Result struct {
+	ThresholdSet        string   `json:"threshold_set"`
+	Detector            string   `json:"detector"`
+	Alerts              int      `json:"alerts"`
+	TruePositives       int      `json:"true_positives"`
+	Precision           float64  `json:"precision"`
+	DetectedFrauds      int      `json:"detected_frauds"`
+	Recall              float64  `json:"recall"`
+	Confirmed           int      `json:"confirmed"`
+	FalsePositives      int      `json:"false_positives"`
+	Undispositioned     int      `json:"undispositioned"`
+	Cost                float64  `json:"cost"`
+	CostPerTruePositive *float64 `json:"cost_per_true_positive"`
+}
+
+// Report is the outcome of a backtest
+type Report struct {
+	From              string    `json:"from"`
+	To                string    `json:"to"`
+	Transactions      int       `json:"transactions"`
+	FraudTransactions int       `json:"fraud_transactions"`
+	AlertCost         float64   `json:"alert_cost"`
+	Results           []Result  `json:"results"`
+	GeneratedAt       time.Time `json:"generated_at"`
+}
+
+// Labels hold the replayed transactions of a window with their fraud labels
+// and the dispositions of the alerts stored at the time
+type Labels struct {
+	Window            Window
+	Transactions      int
+	FraudTransactions int
+
+	replay       []aml.Transaction
+	fraud        map[string]bool
+	dispositions map[string]string
+}
+
+// NewLabels prepares transactions, which must include the history the
+// detectors need before the window, for replay. stored are the alerts
+// raised at the time, whose status carries the disposition.
+func NewLabels(transactions []aml.Transaction, window Window, stored []aml.Alert) *Labels {
+	l := &Labels{Window: window, fraud: map[string]bool{}, dispositions: map[string]string{}}
+	for _, txn := range transactions {
+		if txn.TransDateTransTime.Before(window.To.AddDate(0, 0, 1)) {
+			l.replay = append(l.replay, txn)
+		}
+		if window.Contains(txn.TransDateTransTime) {
+			l.Transactions++
+			if txn.IsFraud {
+				l.fraud[txn.TransNum] = true
+			}
+		}
+	}
+	l.FraudTransactions = len(l.fraud)
+	for _, alert := range stored {
+		l.dispositions[alertKey(alert)] = alert.Status
+	}
+	return l
+}
+
+// Replay runs the detectors over the window
+func (l *Labels) Replay(detectors []detect.Detector) []aml.Alert {
+	return detect.Run(detectors, l.replay, l.Window.From)
+}
+
+// TruePositive reports whether any of the alert's transactions is labelled
+// fraud
+func (l *Labels) TruePositive(alert aml.Alert) bool {
+	for _, transNum := range alert.TransNums {
+		if l.fraud[transNum] {
+			return true
+		}
+	}
+	return false
+}
+
+// Run replays the window through each threshold set. alertCost is the cost
+// of reviewing one alert.
+func Run(sets []ThresholdSet, labels *Labels, alertCost float64, now time.Time) Report {
+	report := Report{
+		From:              labels.Window.From.Format(aml.DateLayout),
+		To:                labels.Window.To.Format(aml.DateLayout),
+		Transactions:      labels.Transactions,
+		FraudTransactions: labels.FraudTransactions,
+		AlertCost:         alertCost,
+		GeneratedAt:       now,
+	}
+	for _, set := range sets {
+		alerts := labels.Replay(set.Detectors)
+		byDetector := map[string][]aml.Alert{}
+		for _, alert := range alerts {
+			byDetector[alert.AlertType] = append(byDetector[alert.AlertType], alert)
+		}
+		names := make([]string, len(set.Detectors))
+		for i, detector := range set.Detectors {
+			names[i] = detector.Name()
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			report.Results = append(report.Results, labels.Evaluate(set.Name, name, byDetector[name], alertCost))
+		}
+		report.Results = append(report.Results, labels.Evaluate(set.Name, AllDetectors, alerts, alertCost))
+	}
+	return report
+}
+
+func alertKey(alert aml.Alert) string {
+	return alert.AlertType + "|" + alert.CustomerID + "|" + alert.AlertDate
+}
+
+// Evaluate measures alerts raised by detector under a threshold set
+func (l *Labels) Evaluate(set, detector string, alerts []aml.Alert, alertCost float64) Result {
+	result := Result{ThresholdSet: set, Detector: detector, Alerts: len(alerts)}
+	detected := map[string]bool{}
+	for _, alert := range alerts {
+		if l.TruePositive(alert) {
+			result.TruePositives++
+			for _, transNum := range alert.TransNums {
+				if l.fraud[transNum] {
+					detected[transNum] = true
+				}
+			}
+		}
+
+		switch l.dispositions[alertKey(alert)] {
+		case aml.StatusEscalated, aml.StatusSARFiled:
+			result.Confirmed++
+		case aml.StatusFalsePositive:
+			result.FalsePositives++
+		default:
+			result.Undispositioned++
+		}
+	}
+
+	result.DetectedFrauds = len(detected)
+	if result.Alerts > 0 {
+		result.Precision = float64(result.TruePositives) / float64(result.Alerts)
+	}
+	if l.FraudTransactions > 0 {
+		result.Recall = float64(result.DetectedFrauds) / float64(l.FraudTransactions)
+	}
+	result.Cost = float64(result.Alerts) * alertCost
+	if result.TruePositives > 0 {
+		cost := result.Cost / float64(result.TruePositives)
+		result.CostPerTruePositive = &cost
+	}
+	return result
+}
+
+// WriteTable prints the results as an aligned table
+func WriteTable(w io.Writer, report Report) {
+	fmt.Fprintf(w, "%-16s %-22s %7s %5s %9s %7s %9s %5s %5s %7s %12s\n",
+		"THRESHOLD SET", "DETECTOR", "ALERTS", "TP", "PRECISION", "FRAUDS", "RECALL", "CONF", "FP", "OPEN", "COST/TP")
+	for _, r := range report.Results {
+		costPerTP := "-"
+		if r.CostPerTruePositive != nil {
+			costPerTP = "$" + aml.FormatAmount(*r.CostPerTruePositive)
+		}
+		fmt.Fprintf(w, "%-16s %-22s %7d %5d %8.1f%% %7d %8.1f%% %5d %5d %7d %12s\n",
+			r.ThresholdSet, r.Detector, r.Alerts, r.TruePositives, r.Precision*100,
+			r.DetectedFrauds, r.Recall*100, r.Confirmed, r.FalsePositives, r.Undispositioned, costPerTP)
+	}
+}
+
+// WriteJSON writes the report as indented JSON
+func WriteJSON(w io.Writer, report Report) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return fmt.Errorf("failed to encode backtest report: %v", err)
+	}
+	return nil
+}
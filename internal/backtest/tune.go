@@ -0,0 +1,336 @@
+package backtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/detect"
+)
+
+// Setting is one value of a threshold sweep. Alerts raised at the setting
+// but not at the chosen value are below the line; chosen alerts the
+// setting no longer raises are dropped.
+type Setting struct {
+	Value  string `json:"value"`
+	Chosen bool   `json:"chosen"`
+	Result
+	F1                   float64 `json:"f1"`
+	Added                int     `json:"added_alerts"`
+	AddedTruePositives   int     `json:"added_true_positives"`
+	Dropped              int     `json:"dropped_alerts"`
+	DroppedTruePositives int     `json:"dropped_true_positives"`
+}
+
+// Sweep is the outcome of replaying one detector over a grid of values of
+// one config parameter
+type Sweep struct {
+	Detector          string    `json:"detector"`
+	Parameter         string    `json:"parameter"`
+	Chosen            string    `json:"chosen"`
+	From              string    `json:"from"`
+	To                string    `json:"to"`
+	Transactions      int       `json:"transactions"`
+	FraudTransactions int       `json:"fraud_transactions"`
+	AlertCost         float64   `json:"alert_cost"`
+	Settings          []Setting `json:"settings"`
+	Justification     []string  `json:"justification"`
+	GeneratedAt       time.Time `json:"generated_at"`
+}
+
+// ParseValues reads a comma-separated list of JSON values, where start:end:step
+// expands to a numeric range
+func ParseValues(list string) ([]string, error) {
+	var values []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 {
+			values = append(values, item)
+			continue
+		}
+		var bounds [3]float64
+		for i, part := range parts {
+			v, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid range %q: %v", item, err)
+			}
+			bounds[i] = v
+		}
+		if bounds[2] <= 0 || bounds[1] < bounds[0] {
+			return nil, fmt.Errorf("invalid range %q: needs start <= end and a positive step", item)
+		}
+		for k := 0; ; k++ {
+			v := bounds[0] + float64(k)*bounds[2]
+			if v > bounds[1]+1e-9 {
+				break
+			}
+			values = append(values, strconv.FormatFloat(math.Round(v*1e9)/1e9, 'f', -1, 64))
+		}
+	}
+	return values, nil
+}
+
+// canonical re-encodes a JSON value so that 0.90 and 0.9 compare equal
+func canonical(value string) string {
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		return value
+	}
+	encoded, _ := json.Marshal(parsed)
+	return string(encoded)
+}
+
+// Tune replays the detector under base with parameter set to each value.
+// chosen is the threshold to justify (default: the value in base); it is
+// added to the grid when missing.
+func Tune(detector, parameter string, values []string, chosen string, base detect.Config, reference detect.Reference, labels *Labels, alertCost float64, now time.Time) (Sweep, error) {
+	if chosen == "" {
+		current, err := base.Parameter(parameter)
+		if err != nil {
+			return Sweep{}, err
+		}
+		chosen = current
+	}
+	chosen = canonical(chosen)
+	var grid []string
+	for _, value := range values {
+		grid = append(grid, canonical(value))
+	}
+	found := false
+	for _, value := range grid {
+		found = found || value == chosen
+	}
+	if !found {
+		grid = append(grid, chosen)
+		sortNumeric(grid)
+	}
+
+	sweep := Sweep{
+		Detector:          strings.ToUpper(detector),
+		Parameter:         parameter,
+		Chosen:            chosen,
+		From:              labels.Window.From.Format(aml.DateLayout),
+		To:                labels.Window.To.Format(aml.DateLayout),
+		Transactions:      labels.Transactions,
+		FraudTransactions: labels.FraudTransactions,
+		AlertCost:         alertCost,
+		GeneratedAt:       now,
+	}
+	raised := make([]map[string]bool, len(grid))
+	chosenIndex := 0
+	for i, value := range grid {
+		config, err := base.WithParameter(parameter, value)
+		if err != nil {
+			return sweep, err
+		}
+		detectors, err := detect.Select(detect.All(config, reference), []string{detector})
+		if err != nil {
+			return sweep, err
+		}
+		alerts := labels.Replay(detectors)
+		raised[i] = map[string]bool{}
+		for _, alert := range alerts {
+			raised[i][alertKey(alert)] = labels.TruePositive(alert)
+		}
+
+		setting := Setting{
+			Value:  value,
+			Chosen: value == chosen,
+			Result: labels.Evaluate(parameter+"="+value, sweep.Detector, alerts, alertCost),
+		}
+		if p, r := setting.Precision, setting.Recall; p+r > 0 {
+			setting.F1 = 2 * p * r / (p + r)
+		}
+		if setting.Chosen {
+			chosenIndex = i
+		}
+		sweep.Settings = append(sweep.Settings, setting)
+	}
+
+	for i := range sweep.Settings {
+		s := &sweep.Settings[i]
+		for key, truePositive := range raised[i] {
+			if _, ok := raised[chosenIndex][key]; !ok {
+				s.Added++
+				if truePositive {
+					s.AddedTruePositives++
+				}
+			}
+		}
+		for key, truePositive := range raised[chosenIndex] {
+			if _, ok := raised[i][key]; !ok {
+				s.Dropped++
+				if truePositive {
+					s.DroppedTruePositives++
+				}
+			}
+		}
+	}
+	sweep.Justification = justify(sweep, chosenIndex)
+	return sweep, nil
+}
+
+// justify summarises the above- and below-the-line evidence for the chosen
+// value
+func justify(sweep Sweep, chosenIndex int) []string {
+	chosen := sweep.Settings[chosenIndex]
+	lines := []string{fmt.Sprintf(
+		"At the chosen %s = %s, %s raises %d alerts; %d (%.0f%%) include a fraud-labelled transaction and they catch %d of %d fraud transactions (%.0f%% recall)%s.",
+		sweep.Parameter, chosen.Value, sweep.Detector, chosen.Alerts, chosen.TruePositives, chosen.Precision*100,
+		chosen.DetectedFrauds, sweep.FraudTransactions, chosen.Recall*100, costClause(chosen.Result))}
+
+	best := chosenIndex
+	for i, s := range sweep.Settings {
+		if s.F1 > sweep.Settings[best].F1 {
+			best = i
+		}
+		if i == chosenIndex {
+			continue
+		}
+		var parts []string
+		if s.Added > 0 {
+			parts = append(parts, fmt.Sprintf("adds %s below the line, of which %d (%.0f%%) true positive",
+				plural(s.Added, "alert"), s.AddedTruePositives, percent(s.AddedTruePositives, s.Added)))
+		}
+		if s.Dropped > 0 {
+			parts = append(parts, fmt.Sprintf("drops %s above the line, losing %s",
+				plural(s.Dropped, "alert"), plural(s.DroppedTruePositives, "true positive")))
+		}
+		if len(parts) == 0 {
+			parts = append(parts, "raises the same alerts")
+		}
+		lines = append(lines, fmt.Sprintf("%s = %s %s.", sweep.Parameter, s.Value, strings.Join(parts, " and ")))
+	}
+
+	if best == chosenIndex {
+		lines = append(lines, fmt.Sprintf("The chosen value has the best balance of precision and recall in the sweep (F1 %.2f).", chosen.F1))
+	} else {
+		lines = append(lines, fmt.Sprintf("%s = %s has a higher F1 than the chosen value (%.2f against %.2f); document why the chosen value is kept or change it.",
+			sweep.Parameter, sweep.Settings[best].Value, sweep.Settings[best].F1, chosen.F1))
+	}
+	return lines
+}
+
+// sortNumeric orders the values when they are all numbers
+func sortNumeric(values []string) {
+	numbers := make([]float64, len(values))
+	for i, value := range values {
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return
+		}
+		numbers[i] = v
+	}
+	sort.Sort(byNumber{values, numbers})
+}
+
+type byNumber struct {
+	values  []string
+	numbers []float64
+}
+
+func (b byNumber) Len() int           { return len(b.values) }
+func (b byNumber) Less(i, j int) bool { return b.numbers[i] < b.numbers[j] }
+func (b byNumber) Swap(i, j int) {
+	b.values[i], b.values[j] = b.values[j], b.values[i]
+	b.numbers[i], b.numbers[j] = b.numbers[j], b.numbers[i]
+}
+
+// plural renders "1 alert" / "3 alerts"
+func plural(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+func percent(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return float64(part) / float64(whole) * 100
+}
+
+func costClause(r Result) string {
+	if r.CostPerTruePositive == nil {
+		return ""
+	}
+	return fmt.Sprintf(" at $%s per true positive", aml.FormatAmount(*r.CostPerTruePositive))
+}
+
+// WriteSweepTable prints the sweep as an aligned table
+func WriteSweepTable(w io.Writer, sweep Sweep) {
+	fmt.Fprintf(w, "%-10s %7s %5s %9s %7s %5s %12s %11s %11s\n",
+		"VALUE", "ALERTS", "TP", "PRECISION", "RECALL", "F1", "COST/TP", "ADDED (TP)", "DROPPED (TP)")
+	for _, s := range sweep.Settings {
+		value := s.Value
+		if s.Chosen {
+			value += " *"
+		}
+		costPerTP := "-"
+		if s.CostPerTruePositive != nil {
+			costPerTP = "$" + aml.FormatAmount(*s.CostPerTruePositive)
+		}
+		fmt.Fprintf(w, "%-10s %7d %5d %8.1f%% %6.1f%% %5.2f %12s %11s %11s\n",
+			value, s.Alerts, s.TruePositives, s.Precision*100, s.Recall*100, s.F1, costPerTP,
+			fmt.Sprintf("%d (%d)", s.Added, s.AddedTruePositives), fmt.Sprintf("%d (%d)", s.Dropped, s.DroppedTruePositives))
+	}
+}
+
+// WriteSweepReport writes the sweep as a Markdown report for the model risk
+// file
+func WriteSweepReport(w io.Writer, sweep Sweep) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Threshold tuning: %s `%s`\n\n", sweep.Detector, sweep.Parameter)
+	fmt.Fprintf(&b, "- Window: activity after %s up to and including %s\n", sweep.From, sweep.To)
+	fmt.Fprintf(&b, "- Transactions: %d, of which %d labelled fraud\n", sweep.Transactions, sweep.FraudTransactions)
+	fmt.Fprintf(&b, "- Chosen value: `%s`\n", sweep.Chosen)
+	fmt.Fprintf(&b, "- Review cost: $%s per alert\n", aml.FormatAmount(sweep.AlertCost))
+	fmt.Fprintf(&b, "- Generated: %s\n\n", sweep.GeneratedAt.Format(time.RFC3339))
+
+	b.WriteString("| Value | Alerts | True positives | Precision | Recall | F1 | Cost per TP | Below the line (TP) | Dropped (TP) |\n")
+	b.WriteString("|---|---:|---:|---:|---:|---:|---:|---:|---:|\n")
+	for _, s := range sweep.Settings {
+		value := "`" + s.Value + "`"
+		if s.Chosen {
+			value = "**" + value + "** (chosen)"
+		}
+		costPerTP := "-"
+		if s.CostPerTruePositive != nil {
+			costPerTP = "$" + aml.FormatAmount(*s.CostPerTruePositive)
+		}
+		fmt.Fprintf(&b, "| %s | %d | %d | %.1f%% | %.1f%% | %.2f | %s | %d (%d) | %d (%d) |\n",
+			value, s.Alerts, s.TruePositives, s.Precision*100, s.Recall*100, s.F1, costPerTP,
+			s.Added, s.AddedTruePositives, s.Dropped, s.DroppedTruePositives)
+	}
+
+	b.WriteString("\n## Above- and below-the-line testing\n\n")
+	for _, line := range sweep.Justification {
+		fmt.Fprintf(&b, "- %s\n", line)
+	}
+	b.WriteString("\nAn alert is a true positive when any of its transactions is labelled `is_fraud`. " +
+		"Below-the-line alerts are raised at a setting but not at the chosen value; " +
+		"dropped alerts are raised at the chosen value but not at the setting.\n")
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("failed to write tuning report: %v", err)
+	}
+	return nil
+}
+
+// WriteSweepJSON writes the sweep as indented JSON
+func WriteSweepJSON(w io.Writer, sweep Sweep) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(sweep); err != nil {
+		return fmt.Errorf("failed to encode tuning report: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+package bq
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/iterator"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/store"
+)
+
+// Configuration
+const (
+	ProjectID = "anlaytics-465216"
+	DatasetID = "aml_data"
+)
+
+// Store implements store.Store on top of the aml_data BigQuery dataset
+type Store struct {
+	client  *bigquery.Client
+	dataset *bigquery.Dataset
+}
+
+// Open creates a BigQuery client for the default project and dataset
+func Open(ctx context.Context) (*Store, error) {
+	client, err := bigquery.NewClient(ctx, ProjectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create BigQuery client: %v", err)
+	}
+
+	return &Store{
+		client:  client,
+		dataset: client.Dataset(DatasetID),
+	}, nil
+}
+
+// Client exposes the underlying BigQuery client
+func (s *Store) Client() *bigquery.Client {
+	return s.client
+}
+
+// TableRef returns the fully qualified, quoted name of a table
+func TableRef(table string) string {
+	return fmt.Sprintf("`%s.%s.%s`", ProjectID, DatasetID, table)
+}
+
+// transactionColumns selects the transaction columns. zip and dob are
+// autodetected as INT64 and DATE by the CSV loader, so they are cast back to
+// strings.
+const transactionColumns = `
+			trans_date_trans_time, cc_num, merchant, category, amt,
+			first, last, gender, street, city, state,
+			CAST(zip AS STRING) AS zip, lat, long, city_pop, job,
+			CAST(dob AS STRING) AS dob, trans_num, unix_time,
+			merch_lat, merch_long, CAST(is_fraud AS BOOL) AS is_fraud`
+
+// Transactions loads transactions after since
+func (s *Store) Transactions(ctx context.Context, since time.Time) ([]aml.Transaction, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE trans_date_trans_time > @since
+		ORDER BY trans_date_trans_time
+	`, transactionColumns, TableRef(store.TransactionsTable))
+
+	return s.queryTransactions(ctx, query, []bigquery.QueryParameter{{Name: "since", Value: since}})
+}
+
+// CustomerTransactions loads one customer's transactions after since
+func (s *Store) CustomerTransactions(ctx context.Context, customerID string, since time.Time) ([]aml.Transaction, error) {
+	query := fmt.Sprintf(`
+		SELECT %s
+		FROM %s
+		WHERE CONCAT(first, '_', last) = @customer_id
+			AND trans_date_trans_time > @since
+		ORDER BY trans_date_trans_time
+	`, transactionColumns, TableRef(store.TransactionsTable))
+
+	return s.queryTransactions(ctx, query, []bigquery.QueryParameter{
+		{Name: "customer_id", Value: customerID},
+		{Name: "since", Value: since},
+	})
+}
+
+func (s *Store) queryTransactions(ctx context.Context, query string, params []bigquery.QueryParameter) ([]aml.Transaction, error) {
+	q := s.client.Query(query)
+	q.Parameters = params
+	it, err := q.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query transactions: %v", err)
+	}
+
+	var transactions []aml.Transaction
+	for {
+		var txn aml.Transaction
+		err := it.Next(&txn)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read transaction: %v", err)
+		}
+		transactions = append(transactions, txn)
+	}
+	return transactions, nil
+}
+
+// Load reads a whole table. Rows are round-tripped through JSON so the
+// destination structs only need json tags.
+func (s *Store) Load(ctx context.Context, table string, dst interface{}) error {
+	return s.Query(ctx, fmt.Sprintf("SELECT * FROM %s", TableRef(table)), nil, dst)
+}
+
+// Query runs a SELECT statement and decodes the rows into dst
+func (s *Store) Query(ctx context.Context, query string, params map[string]interface{}, dst interface{}) error {
+	q := s.client.Query(query)
+	for name, value := range params {
+		q.Parameters = append(q.Parameters, bigquery.QueryParameter{Name: name, Value: value})
+	}
+
+	it, err := q.Read(ctx)
+	if err != nil {
+		return fmt.Errorf("query failed: %v", err)
+	}
+
+	rows := []map[string]bigquery.Value{}
+	for {
+		row := map[string]bigquery.Value{}
+		err := it.Next(&row)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read row: %v", err)
+		}
+		rows = append(rows, row)
+	}
+
+	data, err := json.Marshal(rows)
+	if err != nil {
+		return fmt.Errorf("failed to encode rows: %v", err)
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return fmt.Errorf("failed to decode rows: %v", err)
+	}
+	return nil
+}
+
+// Exec runs a DML or scripting statement and waits for it to finish
+func (s *Store) Exec(ctx context.Context, statement string, params map[string]interface{}) error {
+	q := s.client.Query(statement)
+	for name, value := range params {
+		q.Parameters = append(q.Parameters, bigquery.QueryParameter{Name: name, Value: value})
+	}
+
+	job, err := q.Run(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start job: %v", err)
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return fmt.Errorf("job failed: %v", err)
+	}
+	if status.Err() != nil {
+		return fmt.Errorf("job completed with error: %v", status.Err())
+	}
+	return nil
+}
+
+// Append loads rows into the table with a load job rather than streaming
+// them, so they can be changed by DML (see Update) as soon as it returns
+func (s *Store) Append(ctx context.Context, table string, rows interface{}) error {
+	return s.load(ctx, table, rows, bigquery.WriteAppend)
+}
+
+// Replace truncates the table and loads rows
+func (s *Store) Replace(ctx context.Context, table string, rows interface{}) error {
+	return s.load(ctx, table, rows, bigquery.WriteTruncate)
+}
+
+// load writes rows to the table as newline-delimited JSON
+func (s *Store) load(ctx context.Context, table string, rows interface{}, disposition bigquery.TableWriteDisposition) error {
+	value := reflect.ValueOf(rows)
+	if value.Kind() != reflect.Slice {
+		return fmt.Errorf("rows must be a slice, got %T", rows)
+	}
+	if value.Len() == 0 && disposition == bigquery.WriteAppend {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for i := 0; i < value.Len(); i++ {
+		if err := encoder.Encode(value.Index(i).Interface()); err != nil {
+			return fmt.Errorf("failed to encode rows for %s: %v", table, err)
+		}
+	}
+
+	source := bigquery.NewReaderSource(&buf)
+	source.SourceFormat = bigquery.JSON
+
+	loader := s.dataset.Table(table).LoaderFrom(source)
+	loader.CreateDisposition = bigquery.CreateIfNeeded
+	loader.WriteDisposition = disposition
+
+	job, err := loader.Run(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start load job for %s: %v", table, err)
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return fmt.Errorf("load job for %s failed: %v", table, err)
+	}
+	if status.Err() != nil {
+		return fmt.Errorf("load job for %s completed with error: %v", table, status.Err())
+	}
+	return nil
+}
+
+// update is one row of an Update, passed as a query parameter
+type update struct {
+	Key   int64  `bigquery:"key"`
+	Value string `bigquery:"value"`
+}
+
+// Update changes the rows with one DML statement, so concurrent changes to
+// other rows are kept
+func (s *Store) Update(ctx context.Context, table, keyColumn, column string, values map[int64]string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	updates := make([]update, 0, len(values))
+	for key, value := range values {
+		updates = append(updates, update{key, value})
+	}
+	statement := fmt.Sprintf("UPDATE %s t SET %s = u.value FROM UNNEST(@updates) u WHERE t.%s = u.key",
+		TableRef(table), column, keyColumn)
+	if err := s.Exec(ctx, statement, map[string]interface{}{"updates": updates}); err != nil {
+		return fmt.Errorf("failed to update %s: %v", table, err)
+	}
+	return nil
+}
+
+// NextID returns MAX(column) + 1 for the table
+func (s *Store) NextID(ctx context.Context, table, column string) (int64, error) {
+	query := fmt.Sprintf("SELECT IFNULL(MAX(%s), 0) + 1 FROM %s", column, TableRef(table))
+
+	it, err := s.client.Query(query).Read(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query next %s: %v", column, err)
+	}
+
+	var row []bigquery.Value
+	if err := it.Next(&row); err != nil {
+		return 0, fmt.Errorf("failed to read next %s: %v", column, err)
+	}
+
+	next, ok := row[0].(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected %s type %T", column, row[0])
+	}
+	return next, nil
+}
+
+// Close releases the BigQuery client
+func (s *Store) Close() error {
+	return s.client.Close()
+}
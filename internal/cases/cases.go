@@ -0,0 +1,596 @@
+// Package cases manages the review of alerts. A customer's related alerts
+// are consolidated into a case, which moves from OPEN through IN_REVIEW and
+// ESCALATED to a closing disposition, with an assignee, a due date and
+// notes. Every change is appended to alert_events; the status column of the
+// case's alerts in aml_alerts_level1 follows the latest status event.
+package cases
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/store"
+)
+
+// Tables of the case history
+const (
+	EventsTable = "alert_events"
+	LinksTable  = "case_alerts"
+)
+
+// Event types
+const (
+	EventStatus  = "STATUS"
+	EventAssign  = "ASSIGN"
+	EventDueDate = "DUE_DATE"
+	EventNote    = "NOTE"
+)
+
+// DefaultDueDays is the time allowed for a case without an explicit due
+// date: FinCEN expects a SAR within 30 days of initial detection
+const DefaultDueDays = 30
+
+// DefaultPeriodDays is how close, in days, an alert's date must be to the
+// alerts of its customer's open case to join it
+const DefaultPeriodDays = 7
+
+// transitions lists the statuses each status can move to. The closing
+// statuses are final.
+var transitions = map[string][]string{
+	aml.StatusOpen:      {aml.StatusInReview},
+	aml.StatusInReview:  {aml.StatusEscalated, aml.StatusFalsePositive},
+	aml.StatusEscalated: {aml.StatusSARFiled, aml.StatusFalsePositive},
+}
+
+// Statuses lists the case statuses in workflow order
+var Statuses = []string{aml.StatusOpen, aml.StatusInReview, aml.StatusEscalated, aml.StatusFalsePositive, aml.StatusSARFiled}
+
+// Next returns the statuses a case in status can move to
+func Next(status string) []string {
+	return transitions[status]
+}
+
+// Closed reports whether status is a closing disposition
+func Closed(status string) bool {
+	return status == aml.StatusFalsePositive || status == aml.StatusSARFiled
+}
+
+// ChangeError is a case change refused before anything is stored, as
+// opposed to a failure to store it. Conflict is set when the case's current
+// state does not allow the change, such as a move the workflow forbids;
+// otherwise the change lacks something it needs, such as a note.
+type ChangeError struct {
+	Conflict bool
+	message  string
+}
+
+func (e *ChangeError) Error() string {
+	return e.message
+}
+
+func conflict(format string, args ...interface{}) error {
+	return &ChangeError{Conflict: true, message: fmt.Sprintf(format, args...)}
+}
+
+func incomplete(format string, args ...interface{}) error {
+	return &ChangeError{message: fmt.Sprintf(format, args...)}
+}
+
+// Event is one change to a case, stored in alert_events against the case's
+// first alert. Only the fields of its type are set.
+type Event struct {
+	EventID    int64     `json:"event_id"`
+	AlertID    int64     `json:"alert_id"`
+	EventType  string    `json:"event_type"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Assignee   string    `json:"assignee"`
+	DueDate    string    `json:"due_date,omitempty"` // NULL unless a DUE_DATE event
+	Note       string    `json:"note"`
+	Actor      string    `json:"actor"`
+	At         time.Time `json:"at"`
+}
+
+// Link puts an alert in a case, stored in case_alerts. A case's first alert
+// is linked to itself. Links are only ever appended.
+type Link struct {
+	CaseID   int64     `json:"case_id"`
+	AlertID  int64     `json:"alert_id"`
+	LinkedAt time.Time `json:"linked_at"`
+}
+
+// Case is a customer's related alerts, worked as one. It carries the fields
+// of its first alert, whose ID is the case ID, with the state its events
+// give it. Alerts not yet consolidated are cases of their own.
+type Case struct {
+	aml.Alert
+	CaseID         int64       `json:"case_id"`
+	AlertIDs       []int64     `json:"alert_ids"`
+	AlertTypes     []string    `json:"alert_types"`
+	AggregateScore int64       `json:"aggregate_score"`
+	CasePriority   string      `json:"case_priority"`
+	FirstDate      string      `json:"first_alert_date"`
+	LastDate       string      `json:"last_alert_date"`
+	CaseAmount     float64     `json:"case_amount"`
+	Assignee       string      `json:"assignee"`
+	DueDate        string      `json:"due_date"` // explicit or DefaultDueDays after detection
+	UpdatedAt      time.Time   `json:"updated_at"`
+	Alerts         []aml.Alert `json:"alerts,omitempty"`
+	Events         []Event     `json:"events,omitempty"`
+}
+
+// Overdue reports whether an unclosed case is past its due date on date
+func (c *Case) Overdue(date time.Time) bool {
+	return !Closed(c.Status) && c.DueDate != "" && c.DueDate < date.Format(aml.DateLayout)
+}
+
+// Notes returns the case's note events, oldest first
+func (c *Case) Notes() []Event {
+	var notes []Event
+	for _, e := range c.Events {
+		if e.Note != "" {
+			notes = append(notes, e)
+		}
+	}
+	return notes
+}
+
+// Summary is the case without its alerts and history, for listings
+func (c *Case) Summary() Case {
+	summary := *c
+	summary.Alerts = nil
+	summary.Events = nil
+	return summary
+}
+
+// apply folds an event into the case
+func (c *Case) apply(e Event) {
+	switch e.EventType {
+	case EventStatus:
+		c.Status = e.ToStatus
+	case EventAssign:
+		c.Assignee = e.Assignee
+	case EventDueDate:
+		c.DueDate = e.DueDate
+	}
+	c.UpdatedAt = e.At
+	c.Events = append(c.Events, e)
+}
+
+// add puts an alert in the case and updates the aggregates
+func (c *Case) add(alert aml.Alert) {
+	c.Alerts = append(c.Alerts, alert)
+	c.AlertIDs = append(c.AlertIDs, alert.AlertID)
+	c.CaseAmount += alert.TotalAmount
+	if c.FirstDate == "" || alert.AlertDate < c.FirstDate {
+		c.FirstDate = alert.AlertDate
+	}
+	if alert.AlertDate > c.LastDate {
+		c.LastDate = alert.AlertDate
+	}
+
+	best := map[string]int64{}
+	for _, a := range c.Alerts {
+		if a.RiskScore > best[a.AlertType] {
+			best[a.AlertType] = a.RiskScore
+		}
+	}
+	c.AlertTypes = c.AlertTypes[:0]
+	for alertType := range best {
+		c.AlertTypes = append(c.AlertTypes, alertType)
+	}
+	sort.Strings(c.AlertTypes)
+	c.AggregateScore = AggregateScore(best)
+	c.CasePriority = aml.PriorityForScore(c.AggregateScore)
+}
+
+// AggregateScore combines the best score of each alert type as independent
+// evidence: 100 * (1 - Π(1 - score/100)). One alert keeps its score; repeats
+// of a type add nothing, and each further type raises the score.
+func AggregateScore(best map[string]int64) int64 {
+	remaining := 1.0
+	for _, score := range best {
+		remaining *= 1 - float64(aml.ClampScore(score))/100
+	}
+	return aml.ClampScore(int64(math.Round(100 * (1 - remaining))))
+}
+
+// within reports whether date is within days of the case's alert dates
+func (c *Case) within(date string, days int) bool {
+	day, err := time.Parse(aml.DateLayout, date)
+	if err != nil {
+		return false
+	}
+	from := day.AddDate(0, 0, -days).Format(aml.DateLayout)
+	to := day.AddDate(0, 0, days).Format(aml.DateLayout)
+	return c.LastDate >= from && c.FirstDate <= to
+}
+
+// defaultDueDate is DefaultDueDays after the alert was detected
+func defaultDueDate(alert aml.Alert) string {
+	detected := alert.DetectionDate
+	if detected == "" {
+		detected = alert.AlertDate
+	}
+	day, err := time.Parse(aml.DateLayout, detected)
+	if err != nil {
+		return ""
+	}
+	return day.AddDate(0, 0, DefaultDueDays).Format(aml.DateLayout)
+}
+
+// Book holds every case
+type Book struct {
+	alerts []aml.Alert
+	events []Event
+	links  []Link
+
+	cases []*Case
+	index map[int64]*Case // by the ID of each alert in a case
+}
+
+// Load reads the alerts, their case links and events
+func Load(ctx context.Context, st store.Store) (*Book, error) {
+	b := &Book{}
+	if err := st.Load(ctx, store.AlertsTable, &b.alerts); err != nil {
+		return nil, fmt.Errorf("failed to load alerts: %v", err)
+	}
+	if err := st.Load(ctx, LinksTable, &b.links); err != nil {
+		return nil, fmt.Errorf("failed to load case links: %v", err)
+	}
+	if err := st.Load(ctx, EventsTable, &b.events); err != nil {
+		return nil, fmt.Errorf("failed to load alert events: %v", err)
+	}
+	sort.Slice(b.alerts, func(i, j int) bool { return b.alerts[i].AlertID < b.alerts[j].AlertID })
+	sort.Slice(b.events, func(i, j int) bool { return b.events[i].EventID < b.events[j].EventID })
+	b.build()
+	return b, nil
+}
+
+// build assembles the cases from the alerts, links and events
+func (b *Book) build() {
+	caseOf := make(map[int64]int64, len(b.links))
+	for _, link := range b.links {
+		caseOf[link.AlertID] = link.CaseID
+	}
+
+	b.cases = nil
+	b.index = make(map[int64]*Case, len(b.alerts))
+	var members []aml.Alert
+	for _, alert := range b.alerts {
+		if alert.Status == "" {
+			alert.Status = aml.StatusOpen
+		}
+		if id, ok := caseOf[alert.AlertID]; ok && id != alert.AlertID {
+			members = append(members, alert)
+			continue
+		}
+		c := &Case{Alert: alert, CaseID: alert.AlertID, DueDate: defaultDueDate(alert), UpdatedAt: alert.CreatedAt}
+		c.add(alert)
+		b.cases = append(b.cases, c)
+		b.index[alert.AlertID] = c
+	}
+	for _, alert := range members {
+		c, ok := b.index[caseOf[alert.AlertID]]
+		if !ok {
+			// The case's first alert is gone, as after a full reprocessing
+			c = &Case{Alert: alert, CaseID: alert.AlertID, DueDate: defaultDueDate(alert), UpdatedAt: alert.CreatedAt}
+			b.cases = append(b.cases, c)
+		}
+		c.add(alert)
+		b.index[alert.AlertID] = c
+	}
+	for _, e := range b.events {
+		if c, ok := b.index[e.AlertID]; ok {
+			c.apply(e)
+		}
+	}
+}
+
+// Get returns the case an alert belongs to
+func (b *Book) Get(alertID int64) (*Case, error) {
+	c, ok := b.index[alertID]
+	if !ok {
+		return nil, fmt.Errorf("no alert %d", alertID)
+	}
+	return c, nil
+}
+
+// Filter selects cases; empty fields match everything
+type Filter struct {
+	Status     string
+	Assignee   string // "-" selects unassigned cases
+	AlertType  string // any alert of the case
+	Priority   string // of the aggregate score
+	CustomerID string
+	From, To   string    // alert date range (YYYY-MM-DD), inclusive, overlapping the case's
+	MinScore   int64     // lowest aggregate score
+	Open       bool      // only cases not yet closed
+	OverdueOn  time.Time // only cases overdue on this date
+}
+
+func (f Filter) match(c *Case) bool {
+	switch {
+	case f.Status != "" && c.Status != strings.ToUpper(f.Status):
+		return false
+	case f.Assignee == "-" && c.Assignee != "":
+		return false
+	case f.Assignee != "" && f.Assignee != "-" && c.Assignee != f.Assignee:
+		return false
+	case f.AlertType != "" && !contains(c.AlertTypes, strings.ToUpper(f.AlertType)):
+		return false
+	case f.Priority != "" && c.CasePriority != strings.ToUpper(f.Priority):
+		return false
+	case f.CustomerID != "" && c.CustomerID != f.CustomerID:
+		return false
+	case f.From != "" && c.LastDate < f.From, f.To != "" && c.FirstDate > f.To:
+		return false
+	case c.AggregateScore < f.MinScore:
+		return false
+	case f.Open && Closed(c.Status):
+		return false
+	case !f.OverdueOn.IsZero() && !c.Overdue(f.OverdueOn):
+		return false
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the cases matching filter, earliest due first and highest
+// aggregate score first within a day
+func (b *Book) List(filter Filter) []*Case {
+	var result []*Case
+	for _, c := range b.cases {
+		if filter.match(c) {
+			result = append(result, c)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if result[i].DueDate != result[j].DueDate {
+			return result[i].DueDate < result[j].DueDate
+		}
+		return result[i].AggregateScore > result[j].AggregateScore
+	})
+	return result
+}
+
+// Consolidate links the alerts not yet in a case, in alert ID order. An
+// alert joins the latest open case of its customer whose alert dates are
+// within periodDays of its own, taking the case's status; otherwise it
+// opens a case. Alerts already worked on their own, with events or a
+// status other than OPEN, stay separate cases.
+func Consolidate(ctx context.Context, st store.Store, b *Book, periodDays int, now time.Time) ([]Link, error) {
+	linked := make(map[int64]bool, len(b.links))
+	for _, link := range b.links {
+		linked[link.AlertID] = true
+	}
+	worked := map[int64]bool{}
+	for _, e := range b.events {
+		worked[e.AlertID] = true
+	}
+
+	// Unconsolidated alerts are their own cases in the book; rebuild the
+	// cases from the consolidated alerts alone and add the rest in order
+	cases := map[int64]*Case{}
+	latest := map[string][]*Case{} // open cases by customer
+	caseOf := map[int64]int64{}
+	for _, link := range b.links {
+		caseOf[link.AlertID] = link.CaseID
+	}
+	for _, alert := range b.alerts {
+		if !linked[alert.AlertID] {
+			continue
+		}
+		id := caseOf[alert.AlertID]
+		c, ok := cases[id]
+		if !ok {
+			existing, err := b.Get(alert.AlertID)
+			if err != nil {
+				return nil, err
+			}
+			c = &Case{CaseID: id, Alert: existing.Alert}
+			cases[id] = c
+			if !Closed(existing.Status) {
+				latest[alert.CustomerID] = append(latest[alert.CustomerID], c)
+			}
+		}
+		c.add(alert)
+	}
+
+	var links []Link
+	statuses := map[int64]string{}
+	for _, alert := range b.alerts {
+		if linked[alert.AlertID] {
+			continue
+		}
+		var target *Case
+		if !worked[alert.AlertID] && (alert.Status == "" || alert.Status == aml.StatusOpen) {
+			open := latest[alert.CustomerID]
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i].within(alert.AlertDate, periodDays) {
+					target = open[i]
+					break
+				}
+			}
+		}
+		if target == nil {
+			target = &Case{CaseID: alert.AlertID, Alert: alert}
+			if target.Status == "" {
+				target.Status = aml.StatusOpen
+			}
+			if !Closed(target.Status) {
+				latest[alert.CustomerID] = append(latest[alert.CustomerID], target)
+			}
+		} else if target.Status != aml.StatusOpen {
+			statuses[alert.AlertID] = target.Status
+		}
+		target.add(alert)
+		links = append(links, Link{CaseID: target.CaseID, AlertID: alert.AlertID, LinkedAt: now})
+	}
+	if len(links) == 0 {
+		return nil, nil
+	}
+
+	if err := st.Append(ctx, LinksTable, links); err != nil {
+		return nil, fmt.Errorf("failed to store case links: %v", err)
+	}
+	if len(statuses) > 0 {
+		if err := b.writeStatuses(ctx, st, statuses); err != nil {
+			return nil, err
+		}
+	}
+	b.links = append(b.links, links...)
+	b.build()
+	return links, nil
+}
+
+// ConsolidateNew loads the cases and consolidates the alerts inserted since
+// they were last consolidated, for commands that raise alerts
+func ConsolidateNew(ctx context.Context, st store.Store, now time.Time) ([]Link, error) {
+	b, err := Load(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	return Consolidate(ctx, st, b, DefaultPeriodDays, now)
+}
+
+// Transition moves a case to status along an allowed transition. Closing a
+// case needs a note giving the reason for the disposition.
+func Transition(ctx context.Context, st store.Store, b *Book, alertID int64, status, actor, note string, now time.Time) (Event, error) {
+	c, err := b.Get(alertID)
+	if err != nil {
+		return Event{}, err
+	}
+	status = strings.ToUpper(status)
+	allowed := false
+	for _, next := range Next(c.Status) {
+		if next == status {
+			allowed = true
+		}
+	}
+	if !allowed {
+		if len(Next(c.Status)) == 0 {
+			return Event{}, conflict("case %d is %s and cannot change status", c.CaseID, c.Status)
+		}
+		return Event{}, conflict("case %d is %s; it can move to %s, not %s",
+			c.CaseID, c.Status, strings.Join(Next(c.Status), " or "), status)
+	}
+	if Closed(status) && strings.TrimSpace(note) == "" {
+		return Event{}, incomplete("closing case %d as %s needs a note", c.CaseID, status)
+	}
+
+	e := Event{EventType: EventStatus, FromStatus: c.Status, ToStatus: status, Note: note}
+	if err := b.record(ctx, st, c, e, actor, now); err != nil {
+		return Event{}, err
+	}
+	statuses := map[int64]string{}
+	for _, id := range c.AlertIDs {
+		statuses[id] = status
+	}
+	if err := b.writeStatuses(ctx, st, statuses); err != nil {
+		return Event{}, err
+	}
+	return c.Events[len(c.Events)-1], nil
+}
+
+// Assign hands a case to an analyst
+func Assign(ctx context.Context, st store.Store, b *Book, alertID int64, assignee, actor string, now time.Time) (Event, error) {
+	c, err := b.Get(alertID)
+	if err != nil {
+		return Event{}, err
+	}
+	if strings.TrimSpace(assignee) == "" {
+		return Event{}, incomplete("an assignee is required")
+	}
+	if Closed(c.Status) {
+		return Event{}, conflict("case %d is %s", c.CaseID, c.Status)
+	}
+	if c.Assignee == assignee {
+		return Event{}, conflict("case %d is already assigned to %s", c.CaseID, assignee)
+	}
+	e := Event{EventType: EventAssign, Assignee: assignee}
+	if err := b.record(ctx, st, c, e, actor, now); err != nil {
+		return Event{}, err
+	}
+	return c.Events[len(c.Events)-1], nil
+}
+
+// SetDueDate changes the date a case must be decided by
+func SetDueDate(ctx context.Context, st store.Store, b *Book, alertID int64, due time.Time, actor, note string, now time.Time) (Event, error) {
+	c, err := b.Get(alertID)
+	if err != nil {
+		return Event{}, err
+	}
+	if Closed(c.Status) {
+		return Event{}, conflict("case %d is %s", c.CaseID, c.Status)
+	}
+	e := Event{EventType: EventDueDate, DueDate: due.Format(aml.DateLayout), Note: note}
+	if err := b.record(ctx, st, c, e, actor, now); err != nil {
+		return Event{}, err
+	}
+	return c.Events[len(c.Events)-1], nil
+}
+
+// AddNote records an analyst's note on a case, closed cases included
+func AddNote(ctx context.Context, st store.Store, b *Book, alertID int64, note, actor string, now time.Time) (Event, error) {
+	c, err := b.Get(alertID)
+	if err != nil {
+		return Event{}, err
+	}
+	if strings.TrimSpace(note) == "" {
+		return Event{}, incomplete("a note is required")
+	}
+	e := Event{EventType: EventNote, Note: note}
+	if err := b.record(ctx, st, c, e, actor, now); err != nil {
+		return Event{}, err
+	}
+	return c.Events[len(c.Events)-1], nil
+}
+
+// record appends an event against the case and applies it
+func (b *Book) record(ctx context.Context, st store.Store, c *Case, e Event, actor string, now time.Time) error {
+	if strings.TrimSpace(actor) == "" {
+		return incomplete("the analyst making the change is required")
+	}
+	next, err := st.NextID(ctx, EventsTable, "event_id")
+	if err != nil {
+		return fmt.Errorf("failed to allocate an alert event ID: %v", err)
+	}
+	e.EventID = next
+	e.AlertID = c.CaseID
+	e.Actor = actor
+	e.At = now
+	if err := st.Append(ctx, EventsTable, []Event{e}); err != nil {
+		return fmt.Errorf("failed to store alert event: %v", err)
+	}
+	b.events = append(b.events, e)
+	c.apply(e)
+	return nil
+}
+
+// writeStatuses sets the status column of the given alerts only, so
+// alerts inserted or changed since the book was loaded are kept
+func (b *Book) writeStatuses(ctx context.Context, st store.Store, statuses map[int64]string) error {
+	if err := st.Update(ctx, store.AlertsTable, "alert_id", "status", statuses); err != nil {
+		return fmt.Errorf("failed to update alert status: %v", err)
+	}
+	for i := range b.alerts {
+		if status, ok := statuses[b.alerts[i].AlertID]; ok {
+			b.alerts[i].Status = status
+		}
+	}
+	return nil
+}
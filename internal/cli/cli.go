@@ -0,0 +1,113 @@
+// Package cli holds the console output helpers and store selection shared by
+// the Go command-line tools.
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/fatih/color"
+
+	"aml-system/internal/bq"
+	"aml-system/internal/store"
+)
+
+// Color functions for output
+var (
+	info    = color.New(color.FgBlue).Add(color.Bold)
+	success = color.New(color.FgGreen).Add(color.Bold)
+	warning = color.New(color.FgYellow).Add(color.Bold)
+	errorC  = color.New(color.FgRed).Add(color.Bold)
+	process = color.New(color.FgMagenta).Add(color.Bold)
+	title   = color.New(color.FgCyan).Add(color.Bold)
+)
+
+func Status(message string) {
+	info.Printf("[INFO] %s\n", message)
+}
+
+func Success(message string) {
+	success.Printf("[SUCCESS] %s\n", message)
+}
+
+func Warning(message string) {
+	warning.Printf("[WARNING] %s\n", message)
+}
+
+func Error(message string) {
+	errorC.Printf("[ERROR] %s\n", message)
+}
+
+func Processing(message string) {
+	process.Printf("[PROCESSING] %s\n", message)
+}
+
+// Title prints a command banner
+func Title(message string) {
+	title.Println(message)
+}
+
+// OpenStore returns the local development store when dir is set and the
+// BigQuery dataset otherwise
+func OpenStore(ctx context.Context, dir string) (store.Store, error) {
+	if dir != "" {
+		Status(fmt.Sprintf("Using local store: %s", dir))
+		return store.OpenLocal(dir)
+	}
+	Status(fmt.Sprintf("Using BigQuery dataset: %s.%s", bq.ProjectID, bq.DatasetID))
+	return bq.Open(ctx)
+}
+
+// FormatNumber adds thousands separators to n
+func FormatNumber(n int64) string {
+	str := strconv.FormatInt(n, 10)
+	if len(str) <= 3 {
+		return str
+	}
+
+	result := ""
+	for i, char := range str {
+		if i > 0 && (len(str)-i)%3 == 0 {
+			result += ","
+		}
+		result += string(char)
+	}
+	return result
+}
+
+// WriteXML writes an XML document to path once it validates against the
+// schema xsd with xmllint, or unchecked when xsd is empty. The document is
+// validated in a temporary file beside path and renamed into place, so an
+// invalid document never replaces path.
+func WriteXML(path string, data []byte, xsd string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+
+	if xsd != "" {
+		if output, err := exec.Command("xmllint", "--noout", "--schema", xsd, tmp.Name()).CombinedOutput(); err != nil {
+			return fmt.Errorf("%s does not validate against %s, so it was not written: %v\n%s", path, xsd, err, output)
+		}
+		Status(fmt.Sprintf("%s validates against %s", path, xsd))
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %v", path, err)
+	}
+	return nil
+}
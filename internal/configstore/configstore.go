@@ -0,0 +1,471 @@
+// Package configstore keeps immutable, numbered versions of the detector
+// thresholds and rule files with their business sign-off. A version is
+// proposed with a rationale and an effective date, approved by someone other
+// than its author, and activated; the detection runs record the version
+// their alerts came from.
+package configstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/detect"
+	"aml-system/internal/rules"
+	"aml-system/internal/store"
+)
+
+// Tables of the configuration store
+const (
+	VersionsTable  = "config_versions"
+	EventsTable    = "config_version_events"
+	RunsTable      = "detection_runs"
+	RunAlertsTable = "detection_run_alerts"
+)
+
+// Version statuses, derived from the events of a version
+const (
+	StatusProposed   = "PROPOSED"
+	StatusRejected   = "REJECTED"
+	StatusApproved   = "APPROVED"
+	StatusScheduled  = "SCHEDULED" // activated, effective date still ahead
+	StatusActive     = "ACTIVE"
+	StatusSuperseded = "SUPERSEDED"
+)
+
+// Event actions
+const (
+	ActionApprove  = "APPROVE"
+	ActionReject   = "REJECT"
+	ActionActivate = "ACTIVATE"
+)
+
+// Version is one immutable configuration, stored in config_versions. Config
+// is the complete detector configuration as JSON, defaults included.
+type Version struct {
+	Version       int64      `json:"version"`
+	Author        string     `json:"author"`
+	Rationale     string     `json:"rationale"`
+	EffectiveDate string     `json:"effective_date"`
+	BasedOn       int64      `json:"based_on"` // version active when proposed, 0 for none
+	Config        string     `json:"config"`
+	Rules         []RuleFile `json:"rules"`
+	Checksum      string     `json:"checksum"`
+	ProposedAt    time.Time  `json:"proposed_at"`
+}
+
+// RuleFile is a rule file of a version
+type RuleFile struct {
+	File    string `json:"file"`
+	Content string `json:"content"`
+}
+
+// Event records a decision on a version, stored in config_version_events.
+// Events are only ever appended.
+type Event struct {
+	Version int64     `json:"version"`
+	Action  string    `json:"action"`
+	Actor   string    `json:"actor"`
+	Comment string    `json:"comment"`
+	At      time.Time `json:"at"`
+}
+
+// Run is one detection run, stored in detection_runs. The alerts it raised
+// are linked to it in detection_run_alerts; FirstAlertID and LastAlertID
+// only bound their IDs, which other runs can interleave. A run of
+// detectors from unversioned files records the parameters it used in
+// Config and Rules, so its alerts can be explained like a version's; a
+// screening or model run records its settings in Config.
+type Run struct {
+	RunID          int64      `json:"run_id"`
+	Source         string     `json:"source"`
+	ConfigVersion  int64      `json:"config_version"` // 0 when run from unversioned files
+	ConfigChecksum string     `json:"config_checksum"`
+	Config         string     `json:"config,omitempty"`
+	Rules          []RuleFile `json:"rules,omitempty"`
+	Since          time.Time  `json:"since"`
+	StartedAt      time.Time  `json:"started_at"`
+	FinishedAt     time.Time  `json:"finished_at"`
+	Transactions   int64      `json:"transactions"`
+	Alerts         int64      `json:"alerts"`
+	Suppressed     int64      `json:"suppressed"` // alerts dropped by suppression rules
+	FirstAlertID   int64      `json:"first_alert_id"`
+	LastAlertID    int64      `json:"last_alert_id"`
+}
+
+// RunAlert links a run to an alert it raised, stored in detection_run_alerts
+type RunAlert struct {
+	RunID   int64 `json:"run_id"`
+	AlertID int64 `json:"alert_id"`
+}
+
+// Snapshot reads a detector config file and rules directory into the
+// contents of a version, checking both compile
+func Snapshot(configPath, rulesDir string) (string, []RuleFile, error) {
+	config, err := detect.LoadConfig(configPath)
+	if err != nil {
+		return "", nil, err
+	}
+	content, err := ConfigContent(config)
+	if err != nil {
+		return "", nil, err
+	}
+
+	paths, err := rules.Files(rulesDir)
+	if err != nil {
+		return "", nil, err
+	}
+	var files []RuleFile
+	var compiled []*rules.Compiled
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to read rule file %s: %v", path, err)
+		}
+		rule, err := rules.Parse(path, content)
+		if err != nil {
+			return "", nil, err
+		}
+		compiled = append(compiled, rule)
+		files = append(files, RuleFile{File: filepath.Base(path), Content: string(content)})
+	}
+	if err := rules.CheckNames(compiled); err != nil {
+		return "", nil, err
+	}
+	return content, files, nil
+}
+
+// ConfigContent encodes a detector config as the config of a version or
+// run
+func ConfigContent(config detect.Config) (string, error) {
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode detector config: %v", err)
+	}
+	return string(data), nil
+}
+
+// Parameters encodes the settings of a run without a detector config, such
+// as a match threshold or the model it scored with, as the run's config
+func Parameters(settings map[string]interface{}) (string, error) {
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to encode run parameters: %v", err)
+	}
+	return string(data), nil
+}
+
+// Checksum identifies configuration contents independently of the version
+// number
+func Checksum(config string, files []RuleFile) string {
+	hash := sha256.New()
+	hash.Write([]byte(config))
+	for _, file := range files {
+		fmt.Fprintf(hash, "\x00%s\x00%s", file.File, file.Content)
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:12]
+}
+
+// Detectors compiles the version into its detector config and rules
+func (v Version) Detectors() (detect.Config, []*rules.Compiled, error) {
+	config, err := detect.ParseConfig([]byte(v.Config))
+	if err != nil {
+		return config, nil, fmt.Errorf("invalid config of version %d: %v", v.Version, err)
+	}
+	if err := config.Validate(); err != nil {
+		return config, nil, fmt.Errorf("invalid config of version %d: %v", v.Version, err)
+	}
+	var compiled []*rules.Compiled
+	for _, file := range v.Rules {
+		rule, err := rules.Parse(filepath.Join(rules.DefaultDir, file.File), []byte(file.Content))
+		if err != nil {
+			return config, nil, err
+		}
+		compiled = append(compiled, rule)
+	}
+	return config, compiled, rules.CheckNames(compiled)
+}
+
+// Checkout writes the version's config file and rule files, removing other
+// rule files from rulesDir so the directory matches the version
+func (v Version) Checkout(configPath, rulesDir string) error {
+	if err := os.WriteFile(configPath, []byte(v.Config+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", configPath, err)
+	}
+	if err := os.MkdirAll(rulesDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", rulesDir, err)
+	}
+	existing, err := rules.Files(rulesDir)
+	if err != nil {
+		return err
+	}
+	keep := map[string]bool{}
+	for _, file := range v.Rules {
+		path := filepath.Join(rulesDir, file.File)
+		if err := os.WriteFile(path, []byte(file.Content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %v", path, err)
+		}
+		keep[path] = true
+	}
+	for _, path := range existing {
+		if !keep[path] {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove %s: %v", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Registry holds every version and event
+type Registry struct {
+	Versions []Version
+	Events   []Event
+}
+
+// Load reads the configuration store
+func Load(ctx context.Context, st store.Store) (*Registry, error) {
+	r := &Registry{}
+	if err := st.Load(ctx, VersionsTable, &r.Versions); err != nil {
+		return nil, fmt.Errorf("failed to load config versions: %v", err)
+	}
+	if err := st.Load(ctx, EventsTable, &r.Events); err != nil {
+		return nil, fmt.Errorf("failed to load config version events: %v", err)
+	}
+	sort.Slice(r.Versions, func(i, j int) bool { return r.Versions[i].Version < r.Versions[j].Version })
+	sort.SliceStable(r.Events, func(i, j int) bool { return r.Events[i].At.Before(r.Events[j].At) })
+	return r, nil
+}
+
+// Get returns a version by number
+func (r *Registry) Get(version int64) (Version, error) {
+	for _, v := range r.Versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return Version{}, fmt.Errorf("no config version %d", version)
+}
+
+// events returns the decisions on a version in time order
+func (r *Registry) events(version int64) []Event {
+	var events []Event
+	for _, e := range r.Events {
+		if e.Version == version {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// Active returns the version in force on date: of the activated versions
+// effective by then, the one with the latest effective date, the latest
+// activated on a tie. ok is false when no version is in force.
+func (r *Registry) Active(date time.Time) (Version, bool) {
+	day := date.Format(aml.DateLayout)
+	var active Version
+	var activatedAt time.Time
+	found := false
+	for _, e := range r.Events {
+		if e.Action != ActionActivate {
+			continue
+		}
+		v, err := r.Get(e.Version)
+		if err != nil || v.EffectiveDate > day {
+			continue
+		}
+		if !found || v.EffectiveDate > active.EffectiveDate ||
+			(v.EffectiveDate == active.EffectiveDate && !e.At.Before(activatedAt)) {
+			active, activatedAt, found = v, e.At, true
+		}
+	}
+	return active, found
+}
+
+// Status derives a version's status on date from its events
+func (r *Registry) Status(version Version, date time.Time) string {
+	status := StatusProposed
+	for _, e := range r.events(version.Version) {
+		switch e.Action {
+		case ActionApprove:
+			status = StatusApproved
+		case ActionReject:
+			status = StatusRejected
+		case ActionActivate:
+			status = StatusSuperseded
+		}
+	}
+	if status != StatusSuperseded {
+		return status
+	}
+	if version.EffectiveDate > date.Format(aml.DateLayout) {
+		return StatusScheduled
+	}
+	if active, ok := r.Active(date); ok && active.Version == version.Version {
+		return StatusActive
+	}
+	return StatusSuperseded
+}
+
+// Approval returns the approve or reject event of a version
+func (r *Registry) Approval(version int64) (Event, bool) {
+	for _, e := range r.events(version) {
+		if e.Action == ActionApprove || e.Action == ActionReject {
+			return e, true
+		}
+	}
+	return Event{}, false
+}
+
+// Propose stores a new version. It is rejected when its contents are those
+// of the version currently in force.
+func Propose(ctx context.Context, st store.Store, r *Registry, author, rationale string, effective time.Time, config string, files []RuleFile, now time.Time) (Version, error) {
+	if strings.TrimSpace(author) == "" {
+		return Version{}, fmt.Errorf("an author is required")
+	}
+	if strings.TrimSpace(rationale) == "" {
+		return Version{}, fmt.Errorf("a rationale is required")
+	}
+	if effective.IsZero() {
+		return Version{}, fmt.Errorf("an effective date is required")
+	}
+
+	v := Version{
+		Author:        author,
+		Rationale:     rationale,
+		EffectiveDate: effective.Format(aml.DateLayout),
+		Config:        config,
+		Rules:         files,
+		Checksum:      Checksum(config, files),
+		ProposedAt:    now,
+	}
+	if active, ok := r.Active(now); ok {
+		if active.Checksum == v.Checksum {
+			return Version{}, fmt.Errorf("the configuration is unchanged from active version %d", active.Version)
+		}
+		v.BasedOn = active.Version
+	}
+	for _, pending := range r.Versions {
+		if pending.Checksum == v.Checksum && r.Status(pending, now) == StatusProposed {
+			return Version{}, fmt.Errorf("version %d already proposes this configuration", pending.Version)
+		}
+	}
+
+	next, err := st.NextID(ctx, VersionsTable, "version")
+	if err != nil {
+		return Version{}, fmt.Errorf("failed to allocate a config version: %v", err)
+	}
+	v.Version = next
+	if err := st.Append(ctx, VersionsTable, []Version{v}); err != nil {
+		return Version{}, fmt.Errorf("failed to store config version: %v", err)
+	}
+	r.Versions = append(r.Versions, v)
+	return v, nil
+}
+
+// Decide records an approval or rejection. The approver must not be the
+// author, and a version is decided once.
+func Decide(ctx context.Context, st store.Store, r *Registry, version int64, action, approver, comment string, now time.Time) (Event, error) {
+	v, err := r.Get(version)
+	if err != nil {
+		return Event{}, err
+	}
+	if strings.TrimSpace(approver) == "" {
+		return Event{}, fmt.Errorf("an approver is required")
+	}
+	if strings.EqualFold(approver, v.Author) {
+		return Event{}, fmt.Errorf("version %d was proposed by %s and needs another approver", version, v.Author)
+	}
+	if action == ActionReject && strings.TrimSpace(comment) == "" {
+		return Event{}, fmt.Errorf("a rejection needs a comment")
+	}
+	if status := r.Status(v, now); status != StatusProposed {
+		return Event{}, fmt.Errorf("version %d is %s, not %s", version, status, StatusProposed)
+	}
+	return appendEvent(ctx, st, r, Event{Version: version, Action: action, Actor: approver, Comment: comment, At: now})
+}
+
+// Activate puts an approved version in force from its effective date
+func Activate(ctx context.Context, st store.Store, r *Registry, version int64, actor, comment string, now time.Time) (Event, error) {
+	v, err := r.Get(version)
+	if err != nil {
+		return Event{}, err
+	}
+	if strings.TrimSpace(actor) == "" {
+		return Event{}, fmt.Errorf("the activating user is required")
+	}
+	if status := r.Status(v, now); status != StatusApproved {
+		return Event{}, fmt.Errorf("version %d is %s; only %s versions can be activated", version, status, StatusApproved)
+	}
+	if _, _, err := v.Detectors(); err != nil {
+		return Event{}, err
+	}
+	return appendEvent(ctx, st, r, Event{Version: version, Action: ActionActivate, Actor: actor, Comment: comment, At: now})
+}
+
+func appendEvent(ctx context.Context, st store.Store, r *Registry, e Event) (Event, error) {
+	if err := st.Append(ctx, EventsTable, []Event{e}); err != nil {
+		return Event{}, fmt.Errorf("failed to record %s of version %d: %v", strings.ToLower(e.Action), e.Version, err)
+	}
+	r.Events = append(r.Events, e)
+	return e, nil
+}
+
+// RecordRun stores a detection run with the next run ID and links it to
+// the alerts it raised
+func RecordRun(ctx context.Context, st store.Store, run Run, alertIDs []int64) (Run, error) {
+	next, err := st.NextID(ctx, RunsTable, "run_id")
+	if err != nil {
+		return run, fmt.Errorf("failed to allocate a run ID: %v", err)
+	}
+	run.RunID = next
+	if err := st.Append(ctx, RunsTable, []Run{run}); err != nil {
+		return run, fmt.Errorf("failed to record detection run: %v", err)
+	}
+	if len(alertIDs) == 0 {
+		return run, nil
+	}
+	links := make([]RunAlert, len(alertIDs))
+	for i, id := range alertIDs {
+		links[i] = RunAlert{RunID: run.RunID, AlertID: id}
+	}
+	if err := st.Append(ctx, RunAlertsTable, links); err != nil {
+		return run, fmt.Errorf("failed to link run %d to its alerts: %v", run.RunID, err)
+	}
+	return run, nil
+}
+
+// RunOf returns the run that raised an alert, if one was recorded
+func RunOf(ctx context.Context, st store.Store, alertID int64) (*Run, error) {
+	var links []RunAlert
+	if err := st.Load(ctx, RunAlertsTable, &links); err != nil {
+		return nil, fmt.Errorf("failed to load detection run alerts: %v", err)
+	}
+	runID := int64(0)
+	for _, link := range links {
+		if link.AlertID == alertID {
+			runID = link.RunID
+		}
+	}
+	if runID == 0 {
+		return nil, nil
+	}
+	var runs []Run
+	if err := st.Load(ctx, RunsTable, &runs); err != nil {
+		return nil, fmt.Errorf("failed to load detection runs: %v", err)
+	}
+	for i := range runs {
+		if runs[i].RunID == runID {
+			return &runs[i], nil
+		}
+	}
+	return nil, fmt.Errorf("alert %d is linked to run %d, which is not recorded", alertID, runID)
+}
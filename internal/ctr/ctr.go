@@ -0,0 +1,259 @@
+// Package ctr aggregates cash activity per person and business day to find
+// transactions that require a Currency Transaction Report.
+package ctr
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// CandidatesTable holds one row per person and business day over the threshold
+const CandidatesTable = "ctr_candidates"
+
+// Candidate statuses
+const (
+	StatusPending  = "PENDING"
+	StatusExported = "EXPORTED"
+	StatusVoid     = "VOID" // the day is no longer over the threshold
+)
+
+// Config controls how activity is aggregated
+type Config struct {
+	// Threshold is the daily total that must be exceeded ($10,000)
+	Threshold float64
+
+	// CutoffHour is the hour of day at which the business day ends.
+	// Transactions at or after it count towards the next business day.
+	// 0 uses calendar days.
+	CutoffHour int
+
+	// RollWeekends moves Saturday and Sunday activity to Monday's business day
+	RollWeekends bool
+
+	// CashCategories limits aggregation to these merchant categories.
+	// Empty treats every transaction as cash-equivalent.
+	CashCategories []string
+}
+
+// DefaultConfig returns the regulatory $10,000 threshold on calendar days
+func DefaultConfig() Config {
+	return Config{Threshold: 10000}
+}
+
+// Candidate is a row of ctr_candidates
+type Candidate struct {
+	CandidateID      int64     `json:"candidate_id"`
+	CustomerID       string    `json:"customer_id"`
+	First            string    `json:"first"`
+	Last             string    `json:"last"`
+	DOB              string    `json:"dob"`
+	Gender           string    `json:"gender"`
+	Street           string    `json:"street"`
+	City             string    `json:"city"`
+	State            string    `json:"state"`
+	Zip              string    `json:"zip"`
+	Job              string    `json:"job"`
+	BusinessDate     string    `json:"business_date"`
+	TotalAmount      float64   `json:"total_amount"`
+	TransactionCount int64     `json:"transaction_count"`
+	CardCount        int64     `json:"card_count"`
+	Cards            []string  `json:"cards"`
+	TransNums        []string  `json:"trans_nums"`
+	FirstTransaction time.Time `json:"first_transaction"`
+	LastTransaction  time.Time `json:"last_transaction"`
+	Status           string    `json:"status"`
+	BatchID          string    `json:"batch_id"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// Key identifies the person and business day of a candidate
+func (c Candidate) Key() string {
+	return c.First + "|" + c.Last + "|" + c.DOB + "|" + c.BusinessDate
+}
+
+// BusinessDay returns the business date a transaction at t belongs to
+func (c Config) BusinessDay(t time.Time) string {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	if c.CutoffHour > 0 && t.Hour() >= c.CutoffHour {
+		day = day.AddDate(0, 0, 1)
+	}
+	if c.RollWeekends {
+		switch day.Weekday() {
+		case time.Saturday:
+			day = day.AddDate(0, 0, 2)
+		case time.Sunday:
+			day = day.AddDate(0, 0, 1)
+		}
+	}
+	return day.Format(aml.DateLayout)
+}
+
+// DayStart returns the earliest time whose transactions belong to the same
+// business day as a transaction at t. Aggregating from there covers that
+// day in full although t may fall after its cutoff or on a weekend.
+func (c Config) DayStart(t time.Time) time.Time {
+	day := c.BusinessDay(t)
+	start := time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	for c.BusinessDay(start.Add(-time.Hour)) == day {
+		start = start.Add(-time.Hour)
+	}
+	return start
+}
+
+// Aggregate sums activity per person and business day across all of their
+// cards and returns the days whose total exceeds the threshold
+func Aggregate(transactions []aml.Transaction, config Config) []Candidate {
+	cash := map[string]bool{}
+	for _, category := range config.CashCategories {
+		cash[category] = true
+	}
+
+	groups := map[string]*Candidate{}
+	cards := map[string]map[string]bool{}
+	for _, txn := range transactions {
+		if len(cash) > 0 && !cash[txn.Category] {
+			continue
+		}
+
+		businessDate := config.BusinessDay(txn.TransDateTransTime)
+		key := txn.PersonKey() + "|" + businessDate
+		candidate, ok := groups[key]
+		if !ok {
+			candidate = &Candidate{
+				CustomerID:       txn.CustomerID(),
+				First:            txn.First,
+				Last:             txn.Last,
+				DOB:              txn.DOB,
+				Gender:           txn.Gender,
+				Street:           txn.Street,
+				City:             txn.City,
+				State:            txn.State,
+				Zip:              txn.Zip,
+				Job:              txn.Job,
+				BusinessDate:     businessDate,
+				FirstTransaction: txn.TransDateTransTime,
+				LastTransaction:  txn.TransDateTransTime,
+			}
+			groups[key] = candidate
+			cards[key] = map[string]bool{}
+		}
+
+		candidate.TotalAmount += txn.Amount
+		candidate.TransactionCount++
+		candidate.TransNums = append(candidate.TransNums, txn.TransNum)
+		if txn.TransDateTransTime.Before(candidate.FirstTransaction) {
+			candidate.FirstTransaction = txn.TransDateTransTime
+		}
+		if txn.TransDateTransTime.After(candidate.LastTransaction) {
+			candidate.LastTransaction = txn.TransDateTransTime
+		}
+		card := MaskCard(txn.CCNum)
+		if !cards[key][card] {
+			cards[key][card] = true
+			candidate.Cards = append(candidate.Cards, card)
+		}
+	}
+
+	var candidates []Candidate
+	for _, candidate := range groups {
+		if candidate.TotalAmount <= config.Threshold {
+			continue
+		}
+		candidate.CardCount = int64(len(candidate.Cards))
+		sort.Strings(candidate.Cards)
+		candidates = append(candidates, *candidate)
+	}
+	SortCandidates(candidates)
+	return candidates
+}
+
+// Merge combines freshly aggregated candidates with the stored table.
+// fresh must be aggregated from every transaction of the business days
+// from from on ("" for every day); candidates of earlier days in it are
+// ignored. Pending rows of those days are recomputed from the new data and
+// voided when the day is no longer over the threshold, and void rows over
+// it again become pending; exported rows are kept as filed. New rows get
+// IDs starting at nextID. It returns the merged table and the number of
+// rows that were added or changed.
+func Merge(existing, fresh []Candidate, from string, nextID int64, now time.Time) ([]Candidate, int) {
+	byKey := map[string]int{}
+	merged := make([]Candidate, len(existing))
+	copy(merged, existing)
+	for i, candidate := range merged {
+		byKey[candidate.Key()] = i
+	}
+
+	changed := 0
+	over := map[string]bool{}
+	for _, candidate := range fresh {
+		if candidate.BusinessDate < from {
+			continue
+		}
+		over[candidate.Key()] = true
+		i, ok := byKey[candidate.Key()]
+		if !ok {
+			candidate.CandidateID = nextID
+			nextID++
+			candidate.Status = StatusPending
+			candidate.CreatedAt = now
+			byKey[candidate.Key()] = len(merged)
+			merged = append(merged, candidate)
+			changed++
+			continue
+		}
+
+		previous := merged[i]
+		switch {
+		case previous.Status == StatusExported:
+			continue
+		case previous.Status == StatusPending && previous.TransactionCount == candidate.TransactionCount &&
+			previous.TotalAmount == candidate.TotalAmount:
+			continue
+		}
+		candidate.CandidateID = previous.CandidateID
+		candidate.Status = StatusPending
+		candidate.CreatedAt = previous.CreatedAt
+		merged[i] = candidate
+		changed++
+	}
+
+	for i, candidate := range merged {
+		if candidate.Status == StatusPending && candidate.BusinessDate >= from && !over[candidate.Key()] {
+			merged[i].Status = StatusVoid
+			changed++
+		}
+	}
+
+	SortCandidates(merged)
+	return merged, changed
+}
+
+// SortCandidates orders candidates by business date then customer
+func SortCandidates(candidates []Candidate) {
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].BusinessDate != candidates[j].BusinessDate {
+			return candidates[i].BusinessDate < candidates[j].BusinessDate
+		}
+		return candidates[i].Key() < candidates[j].Key()
+	})
+}
+
+// MaskCard keeps only the last four digits of a card number
+func MaskCard(ccNum int64) string {
+	digits := strconv.FormatInt(ccNum, 10)
+	if len(digits) <= 4 {
+		return digits
+	}
+	return strings.Repeat("*", len(digits)-4) + digits[len(digits)-4:]
+}
+
+// Describe summarises a candidate for console output
+func (c Candidate) Describe() string {
+	return fmt.Sprintf("%s %s (%s): $%s in %d transactions on %d card(s)",
+		c.BusinessDate, c.CustomerID, c.DOB, aml.FormatAmount(c.TotalAmount), c.TransactionCount, c.CardCount)
+}
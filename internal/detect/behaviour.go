@@ -0,0 +1,319 @@
+package detect
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// BaselinesTable holds the running behavioural statistics of every customer
+const BaselinesTable = "customer_baselines"
+
+// Baseline dimensions. AMOUNT and DISTANCE keep a count, sum and sum of
+// squares of the value; CATEGORY and HOUR count transactions per bucket.
+const (
+	DimensionAmount   = "AMOUNT"
+	DimensionDistance = "DISTANCE"
+	DimensionCategory = "CATEGORY"
+	DimensionHour     = "HOUR"
+)
+
+// BaselineStat is a row of customer_baselines, one per customer, dimension
+// and bucket. Bucket is empty for AMOUNT and DISTANCE.
+type BaselineStat struct {
+	PersonKey        string    `json:"person_key"`
+	CustomerID       string    `json:"customer_id"`
+	Dimension        string    `json:"dimension"`
+	Bucket           string    `json:"bucket"`
+	TransactionCount int64     `json:"transaction_count"`
+	ValueSum         float64   `json:"value_sum"`
+	ValueSumSquares  float64   `json:"value_sum_squares"`
+	LastTransaction  time.Time `json:"last_transaction"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// moments accumulates a value's count, sum and sum of squares
+type moments struct {
+	count      int64
+	sum        float64
+	sumSquares float64
+}
+
+func (m *moments) add(value float64) {
+	m.count++
+	m.sum += value
+	m.sumSquares += value * value
+}
+
+func (m moments) mean() float64 {
+	if m.count == 0 {
+		return 0
+	}
+	return m.sum / float64(m.count)
+}
+
+// stddev is the population standard deviation, as STDDEV_POP in the SQL
+func (m moments) stddev() float64 {
+	if m.count == 0 {
+		return 0
+	}
+	mean := m.mean()
+	return math.Sqrt(math.Max(0, m.sumSquares/float64(m.count)-mean*mean))
+}
+
+// customerBaseline is one customer's behaviour so far
+type customerBaseline struct {
+	customerID      string
+	amount          moments
+	distance        moments
+	categories      map[string]int64
+	hours           map[int]int64
+	lastTransaction time.Time
+}
+
+// Baselines are the behavioural baselines of all customers, keyed by
+// resolved customer (name and date of birth)
+type Baselines struct {
+	customers map[string]*customerBaseline
+}
+
+// NewBaselines rebuilds baselines from stored customer_baselines rows
+func NewBaselines(stats []BaselineStat) *Baselines {
+	b := &Baselines{customers: map[string]*customerBaseline{}}
+	for _, stat := range stats {
+		c := b.customer(stat.PersonKey, stat.CustomerID)
+		m := moments{count: stat.TransactionCount, sum: stat.ValueSum, sumSquares: stat.ValueSumSquares}
+		switch stat.Dimension {
+		case DimensionAmount:
+			c.amount = m
+		case DimensionDistance:
+			c.distance = m
+		case DimensionCategory:
+			c.categories[stat.Bucket] = stat.TransactionCount
+		case DimensionHour:
+			hour, err := strconv.Atoi(stat.Bucket)
+			if err == nil {
+				c.hours[hour] = stat.TransactionCount
+			}
+		}
+		if stat.LastTransaction.After(c.lastTransaction) {
+			c.lastTransaction = stat.LastTransaction
+		}
+	}
+	return b
+}
+
+func (b *Baselines) customer(personKey, customerID string) *customerBaseline {
+	c, ok := b.customers[personKey]
+	if !ok {
+		c = &customerBaseline{customerID: customerID, categories: map[string]int64{}, hours: map[int]int64{}}
+		b.customers[personKey] = c
+	}
+	return c
+}
+
+// Watermark is the time of the latest transaction folded into the baselines.
+// Later transactions have not been seen yet.
+func (b *Baselines) Watermark() time.Time {
+	var watermark time.Time
+	for _, c := range b.customers {
+		if c.lastTransaction.After(watermark) {
+			watermark = c.lastTransaction
+		}
+	}
+	return watermark
+}
+
+// Customers is the number of customers with a baseline
+func (b *Baselines) Customers() int {
+	return len(b.customers)
+}
+
+// add folds a transaction into its customer's baseline
+func (b *Baselines) add(txn aml.Transaction) {
+	c := b.customer(txn.PersonKey(), txn.CustomerID())
+	c.amount.add(txn.Amount)
+	c.distance.add(txn.HomeDistance())
+	c.categories[txn.Category]++
+	c.hours[txn.TransDateTransTime.Hour()]++
+	if txn.TransDateTransTime.After(c.lastTransaction) {
+		c.lastTransaction = txn.TransDateTransTime
+	}
+}
+
+// Stats returns the baselines as customer_baselines rows
+func (b *Baselines) Stats(now time.Time) []BaselineStat {
+	var stats []BaselineStat
+	for key, c := range b.customers {
+		row := func(dimension, bucket string, m moments) BaselineStat {
+			return BaselineStat{
+				PersonKey:        key,
+				CustomerID:       c.customerID,
+				Dimension:        dimension,
+				Bucket:           bucket,
+				TransactionCount: m.count,
+				ValueSum:         m.sum,
+				ValueSumSquares:  m.sumSquares,
+				LastTransaction:  c.lastTransaction,
+				UpdatedAt:        now,
+			}
+		}
+		stats = append(stats, row(DimensionAmount, "", c.amount), row(DimensionDistance, "", c.distance))
+		for category, count := range c.categories {
+			stats = append(stats, row(DimensionCategory, category, moments{count: count}))
+		}
+		for hour, count := range c.hours {
+			stats = append(stats, row(DimensionHour, strconv.Itoa(hour), moments{count: count}))
+		}
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].PersonKey != stats[j].PersonKey {
+			return stats[i].PersonKey < stats[j].PersonKey
+		}
+		if stats[i].Dimension != stats[j].Dimension {
+			return stats[i].Dimension < stats[j].Dimension
+		}
+		return stats[i].Bucket < stats[j].Bucket
+	})
+	return stats
+}
+
+// deviation is a transaction that departs from its customer's baseline
+type deviation struct {
+	txn     aml.Transaction
+	reasons []string
+}
+
+// UpdateBaselines scores transactions after the watermark and after since
+// against each customer's baseline, then folds every new transaction into
+// the baselines. Transactions up to since only build the baseline. New
+// activity is scored against the baseline as it stood before the batch, so
+// that a burst of unusual activity does not become its own baseline.
+//
+// A transaction of at least BehaviourMinAmount deviates on each dimension
+// where it is BehaviourZScore standard deviations above the customer's mean
+// amount or distance from home, or where its category or hour of day makes
+// up less than BehaviourRareShare of the customer's history. Customers need
+// BehaviourMinHistory transactions first. Transactions with at least
+// BehaviourMinDeviations deviations raise one BEHAVIOUR_DEVIATION alert per
+// customer and day.
+func UpdateBaselines(config Config, baselines *Baselines, transactions []aml.Transaction, since time.Time) []aml.Alert {
+	watermark := baselines.Watermark()
+	var batch []aml.Transaction
+	for _, txn := range transactions {
+		if !txn.TransDateTransTime.After(watermark) {
+			continue
+		}
+		if txn.TransDateTransTime.After(since) {
+			batch = append(batch, txn)
+		} else {
+			baselines.add(txn)
+		}
+	}
+
+	var deviations []deviation
+	for _, txn := range batch {
+		c, ok := baselines.customers[txn.PersonKey()]
+		if !ok || c.amount.count < int64(config.BehaviourMinHistory) || txn.Amount < config.BehaviourMinAmount {
+			continue
+		}
+		if reasons := c.deviations(config, txn); len(reasons) >= config.BehaviourMinDeviations {
+			deviations = append(deviations, deviation{txn: txn, reasons: reasons})
+		}
+	}
+	for _, txn := range batch {
+		baselines.add(txn)
+	}
+
+	type key struct{ person, date string }
+	var order []key
+	days := map[key][]deviation{}
+	for _, d := range deviations {
+		k := key{d.txn.PersonKey(), d.txn.Date()}
+		if _, ok := days[k]; !ok {
+			order = append(order, k)
+		}
+		days[k] = append(days[k], d)
+	}
+
+	alerts := make([]aml.Alert, 0, len(order))
+	for _, k := range order {
+		alerts = append(alerts, behaviourAlert(config, days[k]))
+	}
+	return alerts
+}
+
+// deviations lists how txn departs from the baseline, each as expected
+// versus observed
+func (c *customerBaseline) deviations(config Config, txn aml.Transaction) []string {
+	var reasons []string
+	history := float64(c.amount.count)
+
+	if z := zScore(txn.Amount, c.amount, 1); z >= config.BehaviourZScore {
+		reasons = append(reasons, fmt.Sprintf("amount $%s vs expected $%s (z %.1f)",
+			aml.FormatAmount(txn.Amount), aml.FormatAmount(c.amount.mean()), z))
+	}
+	distance := txn.HomeDistance()
+	if z := zScore(distance, c.distance, 1); z >= config.BehaviourZScore {
+		reasons = append(reasons, fmt.Sprintf("%.0f km from home vs expected %.0f km (z %.1f)",
+			distance, c.distance.mean(), z))
+	}
+	if share := float64(c.categories[txn.Category]) / history; share < config.BehaviourRareShare {
+		reasons = append(reasons, fmt.Sprintf("category %s in %.0f%% of history vs at least %.0f%% expected",
+			txn.Category, share*100, config.BehaviourRareShare*100))
+	}
+	hour := txn.TransDateTransTime.Hour()
+	if share := float64(c.hours[hour]) / history; share < config.BehaviourRareShare {
+		reasons = append(reasons, fmt.Sprintf("hour %02d:00 in %.0f%% of history vs at least %.0f%% expected",
+			hour, share*100, config.BehaviourRareShare*100))
+	}
+	return reasons
+}
+
+// zScore measures how far above the mean value lies. The standard
+// deviation is floored at minStddev so customers with very regular
+// behaviour do not produce infinite scores.
+func zScore(value float64, m moments, minStddev float64) float64 {
+	return (value - m.mean()) / math.Max(m.stddev(), minStddev)
+}
+
+// behaviourAlert reports a customer's deviating transactions of one day,
+// describing the one with the most deviations
+func behaviourAlert(config Config, deviations []deviation) aml.Alert {
+	top := deviations[0]
+	var count int
+	txns := make([]aml.Transaction, len(deviations))
+	for i, d := range deviations {
+		txns[i] = d.txn
+		count += len(d.reasons)
+		if len(d.reasons) > len(top.reasons) || (len(d.reasons) == len(top.reasons) && d.txn.Amount > top.txn.Amount) {
+			top = d
+		}
+	}
+
+	total := sumAmounts(txns)
+	score := aml.ClampScore(int64(count) * config.RiskScoreWeights.Behaviour)
+	description := fmt.Sprintf("Transaction of $%s deviated from the customer's baseline: %s",
+		aml.FormatAmount(top.txn.Amount), strings.Join(top.reasons, "; "))
+	if len(txns) > 1 {
+		description = fmt.Sprintf("%d transactions totaling $%s deviated from the customer's baseline; most unusual: %s",
+			len(txns), aml.FormatAmount(total), strings.Join(top.reasons, "; "))
+	}
+
+	return aml.Alert{
+		CustomerID:  top.txn.CustomerID(),
+		AlertDate:   top.txn.Date(),
+		AlertType:   aml.AlertBehaviourDeviation,
+		RiskScore:   score,
+		Description: description,
+		Priority:    aml.PriorityForScore(score),
+		TotalAmount: total,
+		TransNums:   transNums(txns),
+	}
+}
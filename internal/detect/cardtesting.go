@@ -0,0 +1,130 @@
+package detect
+
+import (
+	"fmt"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// CardTesting flags stolen-card testing: a burst of tiny charges on one
+// card followed by a large charge. Every large charge looks back
+// CardTestingWindowMinutes for micro transactions on the same cc_num; large
+// charges that follow the same burst are reported in one alert.
+type CardTesting struct {
+	config Config
+}
+
+// NewCardTesting creates the card testing detector
+func NewCardTesting(config Config) *CardTesting {
+	return &CardTesting{config: config}
+}
+
+// Name implements Detector
+func (c *CardTesting) Name() string {
+	return aml.AlertCardTesting
+}
+
+// Lookback implements Detector
+func (c *CardTesting) Lookback() time.Duration {
+	return time.Duration(c.config.CardTestingWindowMinutes) * time.Minute
+}
+
+// Detect implements Detector
+func (c *CardTesting) Detect(transactions []aml.Transaction, since time.Time) []aml.Alert {
+	index := map[int64]int{}
+	var cards [][]aml.Transaction
+	for _, txn := range transactions {
+		i, ok := index[txn.CCNum]
+		if !ok {
+			i = len(cards)
+			index[txn.CCNum] = i
+			cards = append(cards, nil)
+		}
+		cards[i] = append(cards[i], txn)
+	}
+
+	var alerts []aml.Alert
+	for _, txns := range cards {
+		alerts = append(alerts, c.detectCard(txns, since)...)
+	}
+	return alerts
+}
+
+// burst is one run of micro transactions and the large charges after it
+type burst struct {
+	micro []aml.Transaction
+	large []aml.Transaction
+}
+
+func (c *CardTesting) detectCard(txns []aml.Transaction, since time.Time) []aml.Alert {
+	window := time.Duration(c.config.CardTestingWindowMinutes) * time.Minute
+
+	// Indexes of the micro transactions, in time order
+	var micro []int
+	for i, txn := range txns {
+		if txn.Amount <= c.config.CardTestingMaxAmount {
+			micro = append(micro, i)
+		}
+	}
+
+	type key struct{ first, count int }
+	var order []key
+	bursts := map[key]*burst{}
+	lo := 0
+	for _, txn := range txns {
+		if txn.Amount < c.config.CardTestingLargeAmount || !txn.TransDateTransTime.After(since) {
+			continue
+		}
+
+		start := txn.TransDateTransTime.Add(-window)
+		for lo < len(micro) && txns[micro[lo]].TransDateTransTime.Before(start) {
+			lo++
+		}
+		hi := lo
+		for hi < len(micro) && txns[micro[hi]].TransDateTransTime.Before(txn.TransDateTransTime) {
+			hi++
+		}
+		if hi-lo < c.config.CardTestingMinBurst {
+			continue
+		}
+
+		k := key{micro[lo], hi - lo}
+		b, ok := bursts[k]
+		if !ok {
+			b = &burst{}
+			for _, i := range micro[lo:hi] {
+				b.micro = append(b.micro, txns[i])
+			}
+			bursts[k] = b
+			order = append(order, k)
+		}
+		b.large = append(b.large, txn)
+	}
+
+	alerts := make([]aml.Alert, 0, len(order))
+	for _, k := range order {
+		alerts = append(alerts, c.alert(*bursts[k]))
+	}
+	return alerts
+}
+
+func (c *CardTesting) alert(b burst) aml.Alert {
+	large := sumAmounts(b.large)
+	first, last := b.large[0], b.large[len(b.large)-1]
+	minutes := int(first.TransDateTransTime.Sub(b.micro[0].TransDateTransTime).Minutes())
+	score := aml.ClampScore(int64(len(b.micro)) * c.config.RiskScoreWeights.CardTesting)
+
+	return aml.Alert{
+		CustomerID: first.CustomerID(),
+		AlertDate:  last.Date(),
+		AlertType:  aml.AlertCardTesting,
+		RiskScore:  score,
+		Description: fmt.Sprintf("Card ending %04d had %d transactions under $%.2f within %d minutes followed by %s totaling $%s",
+			first.CCNum%10000, len(b.micro), c.config.CardTestingMaxAmount, minutes,
+			plural(len(b.large), "large charge"), aml.FormatAmount(large)),
+		Priority:    aml.PriorityForScore(score),
+		TotalAmount: sumAmounts(b.micro) + large,
+		TransNums:   append(transNums(b.micro), transNums(b.large)...),
+	}
+}
@@ -0,0 +1,363 @@
+package detect
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultConfigPath is where the tools look for detector settings
+const DefaultConfigPath = "config/aml_config.json"
+
+// Weights are the per-occurrence risk score weights of each detector
+type Weights struct {
+	Structuring  int64 `json:"structuring"`
+	Velocity     int64 `json:"velocity"`
+	Geographic   int64 `json:"geographic"`
+	RoundAmounts int64 `json:"round_amounts"`
+	CardTesting  int64 `json:"card_testing"`
+	Dormancy     int64 `json:"dormancy"`
+
+	// HighRiskMerchant is added per extra transaction to the merchant's
+	// own risk score
+	HighRiskMerchant int64 `json:"high_risk_merchant"`
+
+	// MerchantFunnel is scored per customer in a funnel and MerchantSpike
+	// per multiple of the merchant's daily average
+	MerchantFunnel int64 `json:"merchant_funnel"`
+	MerchantSpike  int64 `json:"merchant_spike"`
+
+	// Behaviour is scored per deviating dimension
+	Behaviour int64 `json:"behaviour"`
+
+	// PeerOutlier is scored per metric on which the customer is an outlier
+	PeerOutlier int64 `json:"peer_outlier"`
+
+	// GraphCluster is scored per other customer in a network cluster, and
+	// GraphRing is added when the cluster is a ring
+	GraphCluster int64 `json:"graph_cluster"`
+	GraphRing    int64 `json:"graph_ring"`
+}
+
+// Config holds detector thresholds. Keys mirror AML_CONFIG in
+// scripts/aml_detection.R and the DECLAREs in the processing SQL.
+type Config struct {
+	// Structuring: transactions in [threshold*buffer, threshold) are counted
+	// over rolling windows of StructuringWindows days
+	StructuringThreshold       float64 `json:"structuring_threshold"`
+	StructuringBuffer          float64 `json:"structuring_buffer"`
+	StructuringMinTransactions int     `json:"structuring_min_transactions"`
+	StructuringWindows         []int   `json:"structuring_windows"`
+
+	// Velocity: sliding windows evaluated together, each with its own
+	// count and amount trigger
+	VelocityWindows []VelocityWindow `json:"velocity_windows"`
+
+	// Geographic: one customer name in more than GeographicMaxStates
+	// states, or in more than GeographicMaxCities cities with more than as
+	// many transactions, in a day
+	GeographicMaxStates int `json:"geographic_max_states"`
+	GeographicMaxCities int `json:"geographic_max_cities"`
+
+	// Card testing: a burst of at least CardTestingMinBurst transactions of
+	// at most CardTestingMaxAmount on one card, followed within
+	// CardTestingWindowMinutes by a charge of CardTestingLargeAmount or more
+	CardTestingMaxAmount     float64 `json:"card_testing_max_amount"`
+	CardTestingMinBurst      int     `json:"card_testing_min_burst"`
+	CardTestingWindowMinutes int     `json:"card_testing_window_minutes"`
+	CardTestingLargeAmount   float64 `json:"card_testing_large_amount"`
+
+	// Dormancy reactivation: a customer whose transaction comes
+	// DormancyMinDays or more calendar days after their previous one, who
+	// then moves DormancyMinAmount or more in the DormancyWindowDays
+	// calendar days from that day
+	DormancyMinDays    int     `json:"dormancy_min_days"`
+	DormancyWindowDays int     `json:"dormancy_window_days"`
+	DormancyMinAmount  float64 `json:"dormancy_min_amount"`
+
+	// Merchant anomalies, per merchant and day: a funnel is at least
+	// MerchantFunnelMinCustomers customers paying amounts within
+	// MerchantFunnelAmountTolerance (relative) of each other; a spike is at
+	// least MerchantSpikeMinTransactions transactions totaling
+	// MerchantSpikeMultiplier times the daily average of the previous
+	// MerchantSpikeBaselineDays
+	MerchantFunnelMinCustomers    int     `json:"merchant_funnel_min_customers"`
+	MerchantFunnelAmountTolerance float64 `json:"merchant_funnel_amount_tolerance"`
+	MerchantSpikeBaselineDays     int     `json:"merchant_spike_baseline_days"`
+	MerchantSpikeMultiplier       float64 `json:"merchant_spike_multiplier"`
+	MerchantSpikeMinTransactions  int     `json:"merchant_spike_min_transactions"`
+
+	// Behavioural baselines: see UpdateBaselines
+	BehaviourMinHistory    int     `json:"behaviour_min_history"`
+	BehaviourMinAmount     float64 `json:"behaviour_min_amount"`
+	BehaviourZScore        float64 `json:"behaviour_z_score"`
+	BehaviourRareShare     float64 `json:"behaviour_rare_share"`
+	BehaviourMinDeviations int     `json:"behaviour_min_deviations"`
+
+	// Peer groups: customers are grouped by job, age band and area type
+	// (urban from PeerUrbanPopulation city_pop), falling back to coarser
+	// groups below PeerMinGroupSize members. Activity over PeerWindowDays
+	// with a robust z-score of PeerZScore or more is an outlier.
+	PeerWindowDays      int     `json:"peer_window_days"`
+	PeerUrbanPopulation int64   `json:"peer_urban_population"`
+	PeerMinGroupSize    int     `json:"peer_min_group_size"`
+	PeerZScore          float64 `json:"peer_z_score"`
+
+	// Network clusters: customers linked by a shared address or card, with
+	// at least GraphMinClusterSize members. A ring has GraphRingMinSize
+	// members and at least GraphRingDensity of its pairs linked directly.
+	GraphMinClusterSize int     `json:"graph_min_cluster_size"`
+	GraphRingMinSize    int     `json:"graph_ring_min_size"`
+	GraphRingDensity    float64 `json:"graph_ring_density"`
+
+	RiskScoreWeights Weights `json:"risk_score_weights"`
+}
+
+// VelocityWindow raises a velocity alert when a customer makes at least
+// MinTransactions transactions, or spends at least MinAmount, within any
+// span of Minutes. A zero MinAmount disables the amount trigger.
+type VelocityWindow struct {
+	Minutes         int     `json:"minutes"`
+	MinTransactions int     `json:"min_transactions"`
+	MinAmount       float64 `json:"min_amount"`
+}
+
+// DefaultConfig returns the AML_CONFIG defaults
+func DefaultConfig() Config {
+	return Config{
+		StructuringThreshold:       10000,
+		StructuringBuffer:          0.9,
+		StructuringMinTransactions: 2,
+		StructuringWindows:         []int{1, 3, 7, 30},
+		VelocityWindows: []VelocityWindow{
+			{Minutes: 5, MinTransactions: 5},
+			{Minutes: 60, MinTransactions: 10, MinAmount: 5000},
+			{Minutes: 1440, MinTransactions: 25, MinAmount: 15000},
+		},
+		GeographicMaxStates:      2,
+		GeographicMaxCities:      5,
+		CardTestingMaxAmount:     5,
+		CardTestingMinBurst:      5,
+		CardTestingWindowMinutes: 60,
+		CardTestingLargeAmount:   100,
+		DormancyMinDays:          90,
+		DormancyWindowDays:       7,
+		DormancyMinAmount:        5000,
+
+		MerchantFunnelMinCustomers:    5,
+		MerchantFunnelAmountTolerance: 0.01,
+		MerchantSpikeBaselineDays:     30,
+		MerchantSpikeMultiplier:       5,
+		MerchantSpikeMinTransactions:  10,
+
+		BehaviourMinHistory:    20,
+		BehaviourMinAmount:     100,
+		BehaviourZScore:        3,
+		BehaviourRareShare:     0.05,
+		BehaviourMinDeviations: 2,
+
+		PeerWindowDays:      90,
+		PeerUrbanPopulation: 50000,
+		PeerMinGroupSize:    5,
+		PeerZScore:          3.5,
+
+		GraphMinClusterSize: 2,
+		GraphRingMinSize:    3,
+		GraphRingDensity:    0.5,
+
+		RiskScoreWeights: Weights{
+			Structuring:      25,
+			Velocity:         20,
+			Geographic:       15,
+			RoundAmounts:     10,
+			CardTesting:      15,
+			Dormancy:         20,
+			HighRiskMerchant: 5,
+			MerchantFunnel:   10,
+			MerchantSpike:    10,
+			Behaviour:        15,
+			PeerOutlier:      20,
+			GraphCluster:     20,
+			GraphRing:        30,
+		},
+	}
+}
+
+// LoadConfig reads a JSON config file over the defaults. Keys missing from
+// the file keep their default value; a list in the file replaces the default
+// list, and fields its entries leave out are zero. A missing file at
+// DefaultConfigPath yields the defaults.
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig()
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && path == DefaultConfigPath {
+		return config, nil
+	}
+	if err != nil {
+		return config, fmt.Errorf("failed to read detector config: %v", err)
+	}
+	if config, err = ParseConfig(data); err != nil {
+		return config, fmt.Errorf("invalid detector config %s: %v", path, err)
+	}
+	return config, config.Validate()
+}
+
+// ParseConfig decodes JSON settings over the defaults, like LoadConfig,
+// without validating them
+func ParseConfig(data []byte) (Config, error) {
+	// Decoding into the default slices would reuse their entries, keeping
+	// default values for fields the file's entries leave out, so lists
+	// start empty and only get the defaults back when the file has none
+	config := DefaultConfig()
+	defaults := config
+	config.StructuringWindows, config.VelocityWindows = nil, nil
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, err
+	}
+	if config.StructuringWindows == nil {
+		config.StructuringWindows = defaults.StructuringWindows
+	}
+	if config.VelocityWindows == nil {
+		config.VelocityWindows = defaults.VelocityWindows
+	}
+	return config, nil
+}
+
+// Validate rejects settings the detectors cannot work with
+func (c Config) Validate() error {
+	if c.StructuringThreshold <= 0 {
+		return fmt.Errorf("structuring_threshold must be positive")
+	}
+	if c.StructuringBuffer <= 0 || c.StructuringBuffer >= 1 {
+		return fmt.Errorf("structuring_buffer must be between 0 and 1")
+	}
+	if c.StructuringMinTransactions < 1 {
+		return fmt.Errorf("structuring_min_transactions must be at least 1")
+	}
+	if len(c.StructuringWindows) == 0 {
+		return fmt.Errorf("structuring_windows must list at least one window")
+	}
+	for _, days := range c.StructuringWindows {
+		if days < 1 {
+			return fmt.Errorf("structuring_windows must be whole days of at least 1")
+		}
+	}
+	if len(c.VelocityWindows) == 0 {
+		return fmt.Errorf("velocity_windows must list at least one window")
+	}
+	for _, window := range c.VelocityWindows {
+		if window.Minutes < 1 || window.MinTransactions < 2 || window.MinAmount < 0 {
+			return fmt.Errorf("velocity window %+v needs minutes >= 1, min_transactions >= 2 and a non-negative min_amount", window)
+		}
+	}
+	if c.GeographicMaxStates < 1 || c.GeographicMaxCities < 1 {
+		return fmt.Errorf("geographic_max_states and geographic_max_cities must be at least 1")
+	}
+	if c.CardTestingMaxAmount <= 0 || c.CardTestingLargeAmount <= c.CardTestingMaxAmount {
+		return fmt.Errorf("card_testing_large_amount must exceed a positive card_testing_max_amount")
+	}
+	if c.CardTestingMinBurst < 2 || c.CardTestingWindowMinutes < 1 {
+		return fmt.Errorf("card_testing_min_burst must be at least 2 and card_testing_window_minutes at least 1")
+	}
+	if c.DormancyMinDays < 1 || c.DormancyWindowDays < 1 || c.DormancyMinAmount <= 0 {
+		return fmt.Errorf("dormancy_min_days and dormancy_window_days must be at least 1 and dormancy_min_amount positive")
+	}
+	if c.MerchantFunnelMinCustomers < 2 || c.MerchantFunnelAmountTolerance < 0 {
+		return fmt.Errorf("merchant_funnel_min_customers must be at least 2 and merchant_funnel_amount_tolerance not negative")
+	}
+	if c.MerchantSpikeBaselineDays < 1 || c.MerchantSpikeMultiplier <= 1 || c.MerchantSpikeMinTransactions < 1 {
+		return fmt.Errorf("merchant_spike_baseline_days and merchant_spike_min_transactions must be at least 1 and merchant_spike_multiplier above 1")
+	}
+	if c.BehaviourMinHistory < 2 || c.BehaviourZScore <= 0 || c.BehaviourMinAmount < 0 {
+		return fmt.Errorf("behaviour_min_history must be at least 2, behaviour_z_score positive and behaviour_min_amount not negative")
+	}
+	if c.BehaviourRareShare < 0 || c.BehaviourRareShare >= 1 || c.BehaviourMinDeviations < 1 || c.BehaviourMinDeviations > 4 {
+		return fmt.Errorf("behaviour_rare_share must be between 0 and 1 and behaviour_min_deviations between 1 and 4")
+	}
+	if c.PeerWindowDays < 1 || c.PeerUrbanPopulation < 1 || c.PeerMinGroupSize < 3 || c.PeerZScore <= 0 {
+		return fmt.Errorf("peer_window_days and peer_urban_population must be at least 1, peer_min_group_size at least 3 and peer_z_score positive")
+	}
+	if c.GraphMinClusterSize < 2 || c.GraphRingMinSize < 3 || c.GraphRingDensity <= 0 || c.GraphRingDensity > 1 {
+		return fmt.Errorf("graph_min_cluster_size must be at least 2, graph_ring_min_size at least 3 and graph_ring_density between 0 and 1")
+	}
+	return nil
+}
+
+// Parameter returns the JSON value of a config key. Nested keys are joined
+// with dots and list elements addressed by index, e.g.
+// velocity_windows.0.min_transactions.
+func (c Config) Parameter(path string) (string, error) {
+	var doc interface{}
+	data, _ := json.Marshal(c)
+	json.Unmarshal(data, &doc)
+
+	value, err := walkParameter(doc, path, nil)
+	if err != nil {
+		return "", err
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded), nil
+}
+
+// WithParameter returns a copy of the config with the key at path set to
+// value, given as JSON
+func (c Config) WithParameter(path, value string) (Config, error) {
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		return c, fmt.Errorf("invalid value %q for %s: %v", value, path, err)
+	}
+	var doc interface{}
+	data, _ := json.Marshal(c)
+	json.Unmarshal(data, &doc)
+	if _, err := walkParameter(doc, path, parsed); err != nil {
+		return c, err
+	}
+
+	var updated Config
+	data, _ = json.Marshal(doc)
+	if err := json.Unmarshal(data, &updated); err != nil {
+		return c, fmt.Errorf("invalid value %q for %s: %v", value, path, err)
+	}
+	return updated, updated.Validate()
+}
+
+// walkParameter follows path through a decoded config and returns the value
+// there, replacing it first when set is not nil
+func walkParameter(doc interface{}, path string, set interface{}) (interface{}, error) {
+	keys := strings.Split(path, ".")
+	current := doc
+	for i, key := range keys {
+		last := i == len(keys)-1
+		switch node := current.(type) {
+		case map[string]interface{}:
+			next, ok := node[key]
+			if !ok {
+				return nil, fmt.Errorf("unknown config key %q", path)
+			}
+			if last && set != nil {
+				node[key] = set
+				return set, nil
+			}
+			current = next
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, fmt.Errorf("config key %q has no element %s", path, key)
+			}
+			if last && set != nil {
+				node[index] = set
+				return set, nil
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("unknown config key %q", path)
+		}
+	}
+	return current, nil
+}
@@ -0,0 +1,172 @@
+// Package detect implements the transaction monitoring detectors in Go so
+// they can run against the local store and over historical data. Each
+// detector mirrors the corresponding rule in the processing SQL.
+package detect
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// Detector finds one kind of suspicious activity
+type Detector interface {
+	// Name is the alert type the detector raises
+	Name() string
+
+	// Lookback is how much history before the batch the detector needs.
+	// FullHistory means all of it.
+	Lookback() time.Duration
+
+	// Detect returns alerts for activity ending after since. The
+	// transactions are ordered by time and include the lookback history.
+	Detect(transactions []aml.Transaction, since time.Time) []aml.Alert
+}
+
+// FullHistory is the Lookback of detectors that need every earlier transaction
+const FullHistory time.Duration = -1
+
+// Reference is data computed outside the transaction batch that some
+// detectors score against
+type Reference struct {
+	// HighRiskMerchants maps merchant name to risk score (merchant_risk_scores)
+	HighRiskMerchants map[string]int64
+}
+
+// All returns every detector configured by config
+func All(config Config, reference Reference) []Detector {
+	return []Detector{
+		NewStructuring(config),
+		NewVelocity(config),
+		NewGeographic(config),
+		NewCardTesting(config),
+		NewDormancy(config),
+		NewHighRiskMerchant(config, reference.HighRiskMerchants),
+		NewMerchantAnomaly(config),
+		NewPeerOutlier(config),
+		NewGraphCluster(config),
+	}
+}
+
+// Select returns the detectors named in names (alert types, case
+// insensitive). An empty list selects all of them.
+func Select(detectors []Detector, names []string) ([]Detector, error) {
+	if len(names) == 0 {
+		return detectors, nil
+	}
+
+	byName := map[string]Detector{}
+	for _, detector := range detectors {
+		byName[detector.Name()] = detector
+	}
+	var selected []Detector
+	for _, name := range names {
+		detector, ok := byName[strings.ToUpper(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown detector %q", name)
+		}
+		selected = append(selected, detector)
+	}
+	return selected, nil
+}
+
+// Lookback returns the longest history any of the detectors needs
+func Lookback(detectors []Detector) time.Duration {
+	var longest time.Duration
+	for _, detector := range detectors {
+		lookback := detector.Lookback()
+		if lookback == FullHistory {
+			return FullHistory
+		}
+		if lookback > longest {
+			longest = lookback
+		}
+	}
+	return longest
+}
+
+// Run applies every detector and returns their alerts ordered by date,
+// customer and type
+func Run(detectors []Detector, transactions []aml.Transaction, since time.Time) []aml.Alert {
+	var alerts []aml.Alert
+	for _, detector := range detectors {
+		alerts = append(alerts, detector.Detect(transactions, since)...)
+	}
+	SortAlerts(alerts)
+	return alerts
+}
+
+// SortAlerts orders alerts by date, customer and type
+func SortAlerts(alerts []aml.Alert) {
+	sort.SliceStable(alerts, func(i, j int) bool {
+		if alerts[i].AlertDate != alerts[j].AlertDate {
+			return alerts[i].AlertDate < alerts[j].AlertDate
+		}
+		if alerts[i].CustomerID != alerts[j].CustomerID {
+			return alerts[i].CustomerID < alerts[j].CustomerID
+		}
+		return alerts[i].AlertType < alerts[j].AlertType
+	})
+}
+
+// byPerson groups transactions by resolved customer (name and date of birth),
+// keeping each group in time order
+func byPerson(transactions []aml.Transaction, keep func(aml.Transaction) bool) [][]aml.Transaction {
+	index := map[string]int{}
+	var groups [][]aml.Transaction
+	for _, txn := range transactions {
+		if keep != nil && !keep(txn) {
+			continue
+		}
+		key := txn.PersonKey()
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], txn)
+	}
+	return groups
+}
+
+// dayNumber counts calendar days since the Unix epoch so that day windows
+// can be compared with integer arithmetic
+func dayNumber(t time.Time) int64 {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400
+}
+
+func transNums(transactions []aml.Transaction) []string {
+	nums := make([]string, len(transactions))
+	for i, txn := range transactions {
+		nums[i] = txn.TransNum
+	}
+	return nums
+}
+
+func countCards(transactions []aml.Transaction) int {
+	cards := map[int64]bool{}
+	for _, txn := range transactions {
+		cards[txn.CCNum] = true
+	}
+	return len(cards)
+}
+
+func sumAmounts(transactions []aml.Transaction) float64 {
+	var total float64
+	for _, txn := range transactions {
+		total += txn.Amount
+	}
+	return total
+}
+
+// plural renders "1 day" / "3 days"
+func plural(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
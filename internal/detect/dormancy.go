@@ -0,0 +1,82 @@
+package detect
+
+import (
+	"fmt"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// Dormancy flags customers whose transaction comes DormancyMinDays or more
+// calendar days after their previous one and who move at least
+// DormancyMinAmount in the window of DormancyWindowDays calendar days that
+// starts on the day they return. The alert is raised when the transaction
+// that takes the window's total over the amount is after since, and covers
+// every transaction of the window, including those after that one.
+type Dormancy struct {
+	config Config
+}
+
+// NewDormancy creates the dormancy reactivation detector
+func NewDormancy(config Config) *Dormancy {
+	return &Dormancy{config: config}
+}
+
+// Name implements Detector
+func (d *Dormancy) Name() string {
+	return aml.AlertDormancy
+}
+
+// Lookback implements Detector. The previous activity can be arbitrarily
+// old, so the whole history is needed.
+func (d *Dormancy) Lookback() time.Duration {
+	return FullHistory
+}
+
+// Detect implements Detector
+func (d *Dormancy) Detect(transactions []aml.Transaction, since time.Time) []aml.Alert {
+	var alerts []aml.Alert
+	for _, txns := range byPerson(transactions, nil) {
+		for i := 1; i < len(txns); i++ {
+			start := dayNumber(txns[i].TransDateTransTime)
+			dormant := start - dayNumber(txns[i-1].TransDateTransTime)
+			if dormant < int64(d.config.DormancyMinDays) {
+				continue
+			}
+
+			end := i
+			crossed := -1
+			var total float64
+			for end < len(txns) && dayNumber(txns[end].TransDateTransTime)-start < int64(d.config.DormancyWindowDays) {
+				total += txns[end].Amount
+				if crossed < 0 && total >= d.config.DormancyMinAmount {
+					crossed = end
+				}
+				end++
+			}
+			if crossed >= 0 && txns[crossed].TransDateTransTime.After(since) {
+				alerts = append(alerts, d.alert(txns[i:end], txns[i-1], int(dormant)))
+			}
+		}
+	}
+	return alerts
+}
+
+func (d *Dormancy) alert(window []aml.Transaction, previous aml.Transaction, dormant int) aml.Alert {
+	total := sumAmounts(window)
+	weight := d.config.RiskScoreWeights.Dormancy
+	score := aml.ClampScore(weight * (int64(dormant/d.config.DormancyMinDays) + int64(total/d.config.DormancyMinAmount)))
+
+	return aml.Alert{
+		CustomerID: window[0].CustomerID(),
+		AlertDate:  window[0].Date(),
+		AlertType:  aml.AlertDormancy,
+		RiskScore:  score,
+		Description: fmt.Sprintf("Customer reactivated after %s dormant (last activity %s) and moved $%s in %s within %s",
+			plural(dormant, "day"), previous.Date(), aml.FormatAmount(total),
+			plural(len(window), "transaction"), plural(d.config.DormancyWindowDays, "day")),
+		Priority:    aml.PriorityForScore(score),
+		TotalAmount: total,
+		TransNums:   transNums(window),
+	}
+}
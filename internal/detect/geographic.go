@@ -0,0 +1,89 @@
+package detect
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// Geographic flags a customer name seen in more than GeographicMaxStates
+// states, or in more than GeographicMaxCities cities over as many
+// transactions, in one day. Like the SQL it groups by customer_id rather
+// than by person, since several addresses behind one name is the signal.
+type Geographic struct {
+	config Config
+}
+
+// NewGeographic creates the geographic anomaly detector
+func NewGeographic(config Config) *Geographic {
+	return &Geographic{config: config}
+}
+
+// Name implements Detector
+func (g *Geographic) Name() string {
+	return aml.AlertGeographic
+}
+
+// Lookback implements Detector. Days are evaluated on their own.
+func (g *Geographic) Lookback() time.Duration {
+	return 0
+}
+
+// Detect implements Detector
+func (g *Geographic) Detect(transactions []aml.Transaction, since time.Time) []aml.Alert {
+	type key struct{ customerID, date string }
+	days := map[key][]aml.Transaction{}
+	var order []key
+	for _, txn := range transactions {
+		if !txn.TransDateTransTime.After(since) {
+			continue
+		}
+		k := key{txn.CustomerID(), txn.Date()}
+		if _, ok := days[k]; !ok {
+			order = append(order, k)
+		}
+		days[k] = append(days[k], txn)
+	}
+
+	var alerts []aml.Alert
+	for _, k := range order {
+		txns := days[k]
+		states := map[string]bool{}
+		cities := map[string]bool{}
+		for _, txn := range txns {
+			states[txn.State] = true
+			cities[txn.City] = true
+		}
+		if len(states) > g.config.GeographicMaxStates ||
+			(len(cities) > g.config.GeographicMaxCities && len(txns) > g.config.GeographicMaxCities) {
+			alerts = append(alerts, g.alert(k.customerID, k.date, txns, states, cities))
+		}
+	}
+	return alerts
+}
+
+// alert scores the geographic weight per state plus 3 per city, as the SQL does
+func (g *Geographic) alert(customerID, date string, txns []aml.Transaction, states, cities map[string]bool) aml.Alert {
+	score := aml.ClampScore(int64(len(states))*g.config.RiskScoreWeights.Geographic + int64(len(cities))*3)
+	names := make([]string, 0, len(states))
+	for state := range states {
+		names = append(names, state)
+	}
+	sort.Strings(names)
+	total := sumAmounts(txns)
+
+	return aml.Alert{
+		CustomerID: customerID,
+		AlertDate:  date,
+		AlertType:  aml.AlertGeographic,
+		RiskScore:  score,
+		Description: fmt.Sprintf("Customer transacted in %s and %d cities in one day (%s)",
+			plural(len(states), "state"), len(cities), strings.Join(names, ", ")),
+		Priority:    aml.PriorityForScore(score),
+		TotalAmount: total,
+		TransNums:   transNums(txns),
+	}
+}
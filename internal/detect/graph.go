@@ -0,0 +1,123 @@
+package detect
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/ctr"
+	"aml-system/internal/graph"
+)
+
+// GraphThresholds returns the cluster and ring thresholds of config
+func (c Config) GraphThresholds() graph.Thresholds {
+	return graph.Thresholds{
+		MinClusterSize: c.GraphMinClusterSize,
+		RingMinSize:    c.GraphRingMinSize,
+		RingDensity:    c.GraphRingDensity,
+	}
+}
+
+// GraphCluster flags customers linked to other customers by a shared
+// address or card. Every member of a cluster with activity after since gets
+// an alert, scored per linked customer and raised again for rings.
+type GraphCluster struct {
+	config Config
+}
+
+// NewGraphCluster creates the network cluster detector
+func NewGraphCluster(config Config) *GraphCluster {
+	return &GraphCluster{config: config}
+}
+
+// Name implements Detector
+func (c *GraphCluster) Name() string {
+	return aml.AlertGraphCluster
+}
+
+// Lookback implements Detector. Links can come from any earlier transaction.
+func (c *GraphCluster) Lookback() time.Duration {
+	return FullHistory
+}
+
+// Detect implements Detector
+func (c *GraphCluster) Detect(transactions []aml.Transaction, since time.Time) []aml.Alert {
+	g := graph.Build(transactions)
+	clusters := graph.Clusters(g, c.config.GraphThresholds())
+	if len(clusters) == 0 {
+		return nil
+	}
+
+	byPersonKey := map[string][]aml.Transaction{}
+	for _, txn := range transactions {
+		byPersonKey[txn.PersonKey()] = append(byPersonKey[txn.PersonKey()], txn)
+	}
+
+	var alerts []aml.Alert
+	for _, cluster := range clusters {
+		var last time.Time
+		for _, i := range cluster.Members {
+			txns := byPersonKey[g.Nodes[i].PersonKey()]
+			if t := txns[len(txns)-1].TransDateTransTime; t.After(last) {
+				last = t
+			}
+		}
+		if !last.After(since) {
+			continue
+		}
+		for _, i := range cluster.Members {
+			alerts = append(alerts, c.alert(g, cluster, i, byPersonKey, last))
+		}
+	}
+	return alerts
+}
+
+// alert reports one cluster member. It links the member's transactions on
+// shared cards and at merchants other members also paid.
+func (c *GraphCluster) alert(g *graph.Graph, cluster graph.Cluster, member int, byPersonKey map[string][]aml.Transaction, last time.Time) aml.Alert {
+	node := g.Nodes[member]
+	shared := map[string]bool{}
+	for _, i := range cluster.SharedMerchants {
+		shared[g.Nodes[i].Label] = true
+	}
+	cards := map[string]bool{}
+	for _, link := range g.Links(member) {
+		if card := strings.TrimPrefix(link, "card "); card != link {
+			cards[card] = true
+		}
+	}
+	var linked []aml.Transaction
+	for _, txn := range byPersonKey[node.PersonKey()] {
+		if shared[txn.Merchant] || cards[ctr.MaskCard(txn.CCNum)] {
+			linked = append(linked, txn)
+		}
+	}
+
+	weights := c.config.RiskScoreWeights
+	score := int64(len(cluster.Members)-1) * weights.GraphCluster
+	kind := "network cluster"
+	if cluster.IsRing {
+		score += weights.GraphRing
+		kind = fmt.Sprintf("tightly-knit ring (density %.2f)", cluster.Density)
+	}
+	score = aml.ClampScore(score)
+
+	description := fmt.Sprintf("Customer is in %s %s of %d customers: linked to %s by %s",
+		kind, cluster.ID, len(cluster.Members), strings.Join(g.LinkedCustomers(member), ", "),
+		strings.Join(g.Links(member), "; "))
+	if len(cluster.SharedMerchants) > 0 {
+		description += fmt.Sprintf("; members share %s", plural(len(cluster.SharedMerchants), "merchant"))
+	}
+
+	return aml.Alert{
+		CustomerID:  node.Label,
+		AlertDate:   last.Format(aml.DateLayout),
+		AlertType:   aml.AlertGraphCluster,
+		RiskScore:   score,
+		Description: description,
+		Priority:    aml.PriorityForScore(score),
+		TotalAmount: sumAmounts(linked),
+		TransNums:   transNums(linked),
+	}
+}
@@ -0,0 +1,87 @@
+package detect
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// HighRiskMerchant flags customers paying merchants scored as high risk in
+// merchant_risk_scores, one alert per customer and day. The alert starts at
+// the riskiest merchant's score and adds the high_risk_merchant weight for
+// every further transaction. Only transactions after since are considered.
+type HighRiskMerchant struct {
+	config    Config
+	merchants map[string]int64
+}
+
+// NewHighRiskMerchant creates the detector for the given high-risk merchant scores
+func NewHighRiskMerchant(config Config, merchants map[string]int64) *HighRiskMerchant {
+	return &HighRiskMerchant{config: config, merchants: merchants}
+}
+
+// Name implements Detector
+func (h *HighRiskMerchant) Name() string {
+	return aml.AlertHighRiskMerchant
+}
+
+// Lookback implements Detector
+func (h *HighRiskMerchant) Lookback() time.Duration {
+	return 0
+}
+
+// Detect implements Detector
+func (h *HighRiskMerchant) Detect(transactions []aml.Transaction, since time.Time) []aml.Alert {
+	if len(h.merchants) == 0 {
+		return nil
+	}
+	risky := func(txn aml.Transaction) bool {
+		_, ok := h.merchants[txn.Merchant]
+		return ok && txn.TransDateTransTime.After(since)
+	}
+
+	var alerts []aml.Alert
+	for _, txns := range byPerson(transactions, risky) {
+		start := 0
+		for i := range txns {
+			if i == len(txns)-1 || txns[i+1].Date() != txns[i].Date() {
+				alerts = append(alerts, h.alert(txns[start:i+1]))
+				start = i + 1
+			}
+		}
+	}
+	return alerts
+}
+
+func (h *HighRiskMerchant) alert(txns []aml.Transaction) aml.Alert {
+	var top int64
+	names := map[string]bool{}
+	for _, txn := range txns {
+		if score := h.merchants[txn.Merchant]; score > top {
+			top = score
+		}
+		names[txn.Merchant] = true
+	}
+	merchants := make([]string, 0, len(names))
+	for name := range names {
+		merchants = append(merchants, name)
+	}
+	sort.Strings(merchants)
+
+	total := sumAmounts(txns)
+	score := aml.ClampScore(top + int64(len(txns)-1)*h.config.RiskScoreWeights.HighRiskMerchant)
+	return aml.Alert{
+		CustomerID: txns[0].CustomerID(),
+		AlertDate:  txns[0].Date(),
+		AlertType:  aml.AlertHighRiskMerchant,
+		RiskScore:  score,
+		Description: fmt.Sprintf("Customer made %s totaling $%s at high-risk merchants (%s)",
+			plural(len(txns), "transaction"), aml.FormatAmount(total), strings.Join(merchants, ", ")),
+		Priority:    aml.PriorityForScore(score),
+		TotalAmount: total,
+		TransNums:   transNums(txns),
+	}
+}
@@ -0,0 +1,207 @@
+package detect
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// MerchantAnomaly looks at activity from the merchant's side, one merchant
+// and day at a time. It flags funnels, where many unrelated customers pay
+// the merchant near-identical amounts on the same day, and volume spikes,
+// where the day's takings are a multiple of the merchant's average over the
+// preceding MerchantSpikeBaselineDays. Both signals for a merchant and day
+// are reported in one alert whose customer_id is the merchant name.
+type MerchantAnomaly struct {
+	config Config
+}
+
+// NewMerchantAnomaly creates the merchant anomaly detector
+func NewMerchantAnomaly(config Config) *MerchantAnomaly {
+	return &MerchantAnomaly{config: config}
+}
+
+// Name implements Detector
+func (m *MerchantAnomaly) Name() string {
+	return aml.AlertMerchantAnomaly
+}
+
+// Lookback implements Detector
+func (m *MerchantAnomaly) Lookback() time.Duration {
+	return time.Duration(m.config.MerchantSpikeBaselineDays+1) * 24 * time.Hour
+}
+
+// Detect implements Detector
+func (m *MerchantAnomaly) Detect(transactions []aml.Transaction, since time.Time) []aml.Alert {
+	index := map[string]int{}
+	var merchants [][]aml.Transaction
+	for _, txn := range transactions {
+		i, ok := index[txn.Merchant]
+		if !ok {
+			i = len(merchants)
+			index[txn.Merchant] = i
+			merchants = append(merchants, nil)
+		}
+		merchants[i] = append(merchants[i], txn)
+	}
+
+	var alerts []aml.Alert
+	for _, txns := range merchants {
+		alerts = append(alerts, m.detectMerchant(txns, since)...)
+	}
+	return alerts
+}
+
+// merchantDay is one merchant's activity on one calendar day
+type merchantDay struct {
+	day   int64
+	txns  []aml.Transaction
+	total float64
+}
+
+func (m *MerchantAnomaly) detectMerchant(txns []aml.Transaction, since time.Time) []aml.Alert {
+	var days []merchantDay
+	for _, txn := range txns {
+		day := dayNumber(txn.TransDateTransTime)
+		if len(days) == 0 || days[len(days)-1].day != day {
+			days = append(days, merchantDay{day: day})
+		}
+		d := &days[len(days)-1]
+		d.txns = append(d.txns, txn)
+		d.total += txn.Amount
+	}
+
+	var alerts []aml.Alert
+	var baseline float64 // takings over the baseline days before days[i]
+	lo := 0
+	for i, d := range days {
+		for lo < i && d.day-days[lo].day > int64(m.config.MerchantSpikeBaselineDays) {
+			baseline -= days[lo].total
+			lo++
+		}
+		if d.txns[len(d.txns)-1].TransDateTransTime.After(since) {
+			if alert, ok := m.detectDay(d, baseline); ok {
+				alerts = append(alerts, alert)
+			}
+		}
+		baseline += d.total
+	}
+	return alerts
+}
+
+// funnel is the largest group of a day's payments with near-identical amounts
+type funnel struct {
+	txns      []aml.Transaction
+	customers int
+}
+
+func (m *MerchantAnomaly) detectDay(d merchantDay, baseline float64) (aml.Alert, bool) {
+	f := m.largestFunnel(d.txns)
+	isFunnel := f.customers >= m.config.MerchantFunnelMinCustomers
+
+	average := baseline / float64(m.config.MerchantSpikeBaselineDays)
+	var ratio float64
+	if average > 0 {
+		ratio = d.total / average
+	}
+	isSpike := len(d.txns) >= m.config.MerchantSpikeMinTransactions && average > 0 && ratio >= m.config.MerchantSpikeMultiplier
+	if !isFunnel && !isSpike {
+		return aml.Alert{}, false
+	}
+
+	weights := m.config.RiskScoreWeights
+	var score int64
+	var reasons []string
+	var nums []string
+	total := d.total
+	if isFunnel {
+		score += int64(f.customers) * weights.MerchantFunnel
+		reasons = append(reasons, fmt.Sprintf("Merchant received %d payments %s from %d unrelated customers on %s",
+			len(f.txns), amountRange(f.txns), f.customers, plural(countCards(f.txns), "card")))
+		nums = transNums(f.txns)
+		total = sumAmounts(f.txns)
+	}
+	if isSpike {
+		score += int64(ratio) * weights.MerchantSpike
+		reasons = append(reasons, fmt.Sprintf("Merchant took $%s in %s, %.1fx its %d-day daily average of $%s",
+			aml.FormatAmount(d.total), plural(len(d.txns), "transaction"), ratio,
+			m.config.MerchantSpikeBaselineDays, aml.FormatAmount(average)))
+		nums = transNums(d.txns)
+		total = d.total
+	}
+	score = aml.ClampScore(score)
+
+	return aml.Alert{
+		CustomerID:  aml.MerchantSubject + d.txns[0].Merchant,
+		AlertDate:   d.txns[0].Date(),
+		AlertType:   aml.AlertMerchantAnomaly,
+		RiskScore:   score,
+		Description: strings.Join(reasons, "; "),
+		Priority:    aml.PriorityForScore(score),
+		TotalAmount: total,
+		TransNums:   nums,
+	}, true
+}
+
+// largestFunnel finds the amount band [a, a*(1+tolerance)] paid by the most
+// distinct customers, preferring the larger total and then the lower band
+func (m *MerchantAnomaly) largestFunnel(txns []aml.Transaction) funnel {
+	sorted := make([]aml.Transaction, len(txns))
+	copy(sorted, txns)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Amount < sorted[j].Amount
+	})
+
+	var best funnel
+	var bestTotal float64
+	hi := 0
+	for lo := range sorted {
+		limit := sorted[lo].Amount * (1 + m.config.MerchantFunnelAmountTolerance)
+		if hi < lo {
+			hi = lo
+		}
+		for hi < len(sorted) && sorted[hi].Amount <= limit {
+			hi++
+		}
+
+		band := sorted[lo:hi]
+		customers := map[string]bool{}
+		for _, txn := range band {
+			customers[txn.PersonKey()] = true
+		}
+		total := sumAmounts(band)
+		if len(customers) > best.customers || (len(customers) == best.customers && total > bestTotal) {
+			best = funnel{txns: band, customers: len(customers)}
+			bestTotal = total
+		}
+	}
+
+	// Report the funnel's transactions in time order
+	ordered := make([]aml.Transaction, len(best.txns))
+	copy(ordered, best.txns)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].TransDateTransTime.Before(ordered[j].TransDateTransTime)
+	})
+	best.txns = ordered
+	return best
+}
+
+// amountRange renders "of $499.99" or "between $480.00 and $484.50"
+func amountRange(txns []aml.Transaction) string {
+	low, high := txns[0].Amount, txns[0].Amount
+	for _, txn := range txns {
+		if txn.Amount < low {
+			low = txn.Amount
+		}
+		if txn.Amount > high {
+			high = txn.Amount
+		}
+	}
+	if low == high {
+		return fmt.Sprintf("of $%.2f", low)
+	}
+	return fmt.Sprintf("between $%.2f and $%.2f", low, high)
+}
@@ -0,0 +1,319 @@
+package detect
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// PeerMetricsTable holds every customer's peer group and activity metrics
+const PeerMetricsTable = "customer_peer_metrics"
+
+// Area types derived from city_pop
+const (
+	AreaUrban = "URBAN"
+	AreaRural = "RURAL"
+)
+
+// Peer group levels, from most to least specific. A customer is compared
+// with the most specific group that has at least PeerMinGroupSize members.
+const (
+	PeerLevelJob  = "JOB_AGE_AREA"
+	PeerLevelAge  = "AGE_AREA"
+	PeerLevelArea = "AREA"
+)
+
+// PeerMetric is a row of customer_peer_metrics: one customer's activity
+// over the peer window and how it compares with their peer group
+type PeerMetric struct {
+	PersonKey        string    `json:"person_key"`
+	CustomerID       string    `json:"customer_id"`
+	Job              string    `json:"job"`
+	AgeBand          string    `json:"age_band"`
+	AreaType         string    `json:"area_type"`
+	PeerLevel        string    `json:"peer_level"`
+	PeerGroup        string    `json:"peer_group"`
+	PeerGroupSize    int64     `json:"peer_group_size"`
+	TotalAmount      float64   `json:"total_amount"`
+	TransactionCount int64     `json:"transaction_count"`
+	AvgAmount        float64   `json:"avg_amount"`
+	NightShare       float64   `json:"night_share"`
+	OnlineShare      float64   `json:"online_share"`
+	TotalAmountZ     float64   `json:"total_amount_z"`
+	TransactionZ     float64   `json:"transaction_count_z"`
+	AvgAmountZ       float64   `json:"avg_amount_z"`
+	NightShareZ      float64   `json:"night_share_z"`
+	OnlineShareZ     float64   `json:"online_share_z"`
+	OutlierMetrics   int64     `json:"outlier_metrics"`
+	LastTransaction  time.Time `json:"last_transaction"`
+	WindowEnd        time.Time `json:"window_end"`
+
+	// medians are the peer group medians of peerMeasures, for descriptions
+	medians []float64
+}
+
+// peerMeasure is one of the compared metrics
+type peerMeasure struct {
+	label string
+	value func(*PeerMetric) float64
+	z     func(*PeerMetric) *float64
+	// floor is the smallest spread used for the robust z-score, so groups
+	// whose members all behave alike do not produce infinite scores
+	floor  float64
+	format func(float64) string
+}
+
+var peerMeasures = []peerMeasure{
+	{"volume", func(m *PeerMetric) float64 { return m.TotalAmount }, func(m *PeerMetric) *float64 { return &m.TotalAmountZ }, 1, formatDollars},
+	{"transactions", func(m *PeerMetric) float64 { return float64(m.TransactionCount) }, func(m *PeerMetric) *float64 { return &m.TransactionZ }, 1, formatCount},
+	{"average amount", func(m *PeerMetric) float64 { return m.AvgAmount }, func(m *PeerMetric) *float64 { return &m.AvgAmountZ }, 1, formatDollars},
+	{"night share", func(m *PeerMetric) float64 { return m.NightShare }, func(m *PeerMetric) *float64 { return &m.NightShareZ }, 0.05, formatPercent},
+	{"online share", func(m *PeerMetric) float64 { return m.OnlineShare }, func(m *PeerMetric) *float64 { return &m.OnlineShareZ }, 0.05, formatPercent},
+}
+
+func formatDollars(v float64) string { return "$" + aml.FormatAmount(v) }
+func formatCount(v float64) string   { return fmt.Sprintf("%.0f", v) }
+func formatPercent(v float64) string { return fmt.Sprintf("%.0f%%", v*100) }
+
+// PeerMetrics computes each customer's activity over the PeerWindowDays
+// ending at the latest transaction and scores it against their peer group.
+// Each metric's z-score is robust: the distance from the group median in
+// units of 1.4826 times the median absolute deviation, with the spread
+// floored at 10% of the median.
+func PeerMetrics(config Config, transactions []aml.Transaction) []PeerMetric {
+	if len(transactions) == 0 {
+		return nil
+	}
+	end := transactions[len(transactions)-1].TransDateTransTime
+	start := end.AddDate(0, 0, -config.PeerWindowDays)
+
+	var metrics []PeerMetric
+	index := map[string]int{}
+	var nights, online []int64
+	for _, txn := range transactions {
+		if !txn.TransDateTransTime.After(start) {
+			continue
+		}
+		key := txn.PersonKey()
+		i, ok := index[key]
+		if !ok {
+			i = len(metrics)
+			index[key] = i
+			metrics = append(metrics, PeerMetric{
+				PersonKey:  key,
+				CustomerID: txn.CustomerID(),
+				Job:        txn.Job,
+				AgeBand:    ageBand(txn.DOB, end),
+				AreaType:   areaType(txn.CityPop, config.PeerUrbanPopulation),
+				WindowEnd:  end,
+			})
+			nights = append(nights, 0)
+			online = append(online, 0)
+		}
+		m := &metrics[i]
+		m.TotalAmount += txn.Amount
+		m.TransactionCount++
+		m.LastTransaction = txn.TransDateTransTime
+		if hour := txn.TransDateTransTime.Hour(); hour >= 22 || hour < 6 {
+			nights[i]++
+		}
+		if strings.HasSuffix(txn.Category, "_net") {
+			online[i]++
+		}
+	}
+	for i := range metrics {
+		m := &metrics[i]
+		m.AvgAmount = m.TotalAmount / float64(m.TransactionCount)
+		m.NightShare = float64(nights[i]) / float64(m.TransactionCount)
+		m.OnlineShare = float64(online[i]) / float64(m.TransactionCount)
+	}
+
+	assignPeerGroups(config, metrics)
+
+	groups := map[string][]int{}
+	for i, m := range metrics {
+		if m.PeerGroup != "" {
+			groups[m.PeerGroup] = append(groups[m.PeerGroup], i)
+		}
+	}
+	for _, members := range groups {
+		for _, i := range members {
+			metrics[i].medians = make([]float64, len(peerMeasures))
+		}
+		for k, measure := range peerMeasures {
+			values := make([]float64, len(members))
+			for j, i := range members {
+				values[j] = measure.value(&metrics[i])
+			}
+			center := median(values)
+			deviations := make([]float64, len(values))
+			for j, v := range values {
+				deviations[j] = math.Abs(v - center)
+			}
+			spread := math.Max(1.4826*median(deviations), math.Max(0.1*math.Abs(center), measure.floor))
+			for j, i := range members {
+				metrics[i].medians[k] = center
+				z := (values[j] - center) / spread
+				*measure.z(&metrics[i]) = z
+				if z >= config.PeerZScore {
+					metrics[i].OutlierMetrics++
+				}
+			}
+		}
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		return metrics[i].PersonKey < metrics[j].PersonKey
+	})
+	return metrics
+}
+
+// assignPeerGroups puts each customer in the most specific group with at
+// least PeerMinGroupSize members. Customers without one are not compared.
+func assignPeerGroups(config Config, metrics []PeerMetric) {
+	levels := []struct {
+		level string
+		key   func(PeerMetric) string
+	}{
+		{PeerLevelJob, func(m PeerMetric) string { return m.Job + ", " + m.AgeBand + ", " + strings.ToLower(m.AreaType) }},
+		{PeerLevelAge, func(m PeerMetric) string { return m.AgeBand + ", " + strings.ToLower(m.AreaType) }},
+		{PeerLevelArea, func(m PeerMetric) string { return strings.ToLower(m.AreaType) }},
+	}
+
+	for _, level := range levels {
+		sizes := map[string]int64{}
+		for _, m := range metrics {
+			sizes[level.key(m)]++
+		}
+		for i := range metrics {
+			m := &metrics[i]
+			if m.PeerGroup != "" {
+				continue
+			}
+			if size := sizes[level.key(*m)]; size >= int64(config.PeerMinGroupSize) {
+				m.PeerLevel = level.level
+				m.PeerGroup = level.key(*m)
+				m.PeerGroupSize = size
+			}
+		}
+	}
+}
+
+// ageBand buckets a customer's age at t
+func ageBand(dob string, t time.Time) string {
+	birth, err := time.Parse(aml.DateLayout, dob)
+	if err != nil {
+		return "unknown age"
+	}
+	age := t.Year() - birth.Year()
+	if t.YearDay() < birth.YearDay() {
+		age--
+	}
+	switch {
+	case age < 25:
+		return "under 25"
+	case age < 35:
+		return "25-34"
+	case age < 45:
+		return "35-44"
+	case age < 55:
+		return "45-54"
+	case age < 65:
+		return "55-64"
+	default:
+		return "65+"
+	}
+}
+
+// areaType classifies a city as urban from urbanPopulation inhabitants
+func areaType(cityPop, urbanPopulation int64) string {
+	if cityPop >= urbanPopulation {
+		return AreaUrban
+	}
+	return AreaRural
+}
+
+// median interpolates between the middle values, as PERCENTILE_CONT(x, 0.5)
+func median(values []float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n == 0 {
+		return 0
+	}
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// PeerOutlier flags customers whose activity over the peer window is far
+// above their peer group's on at least one metric. Customers are alerted
+// when they have activity after since.
+type PeerOutlier struct {
+	config Config
+}
+
+// NewPeerOutlier creates the peer outlier detector
+func NewPeerOutlier(config Config) *PeerOutlier {
+	return &PeerOutlier{config: config}
+}
+
+// Name implements Detector
+func (p *PeerOutlier) Name() string {
+	return aml.AlertPeerOutlier
+}
+
+// Lookback implements Detector
+func (p *PeerOutlier) Lookback() time.Duration {
+	return time.Duration(p.config.PeerWindowDays) * 24 * time.Hour
+}
+
+// Detect implements Detector
+func (p *PeerOutlier) Detect(transactions []aml.Transaction, since time.Time) []aml.Alert {
+	var alerts []aml.Alert
+	for _, m := range PeerMetrics(p.config, transactions) {
+		if m.OutlierMetrics == 0 || !m.LastTransaction.After(since) {
+			continue
+		}
+		alerts = append(alerts, p.alert(m, transactions))
+	}
+	return alerts
+}
+
+func (p *PeerOutlier) alert(m PeerMetric, transactions []aml.Transaction) aml.Alert {
+	var reasons []string
+	for k, measure := range peerMeasures {
+		if z := *measure.z(&m); z >= p.config.PeerZScore {
+			reasons = append(reasons, fmt.Sprintf("%s %s vs peer median %s (z %.1f)",
+				measure.label, measure.format(measure.value(&m)), measure.format(m.medians[k]), z))
+		}
+	}
+
+	// Link the window's transactions
+	start := m.WindowEnd.AddDate(0, 0, -p.config.PeerWindowDays)
+	var nums []string
+	for _, txn := range transactions {
+		if txn.PersonKey() == m.PersonKey && txn.TransDateTransTime.After(start) {
+			nums = append(nums, txn.TransNum)
+		}
+	}
+
+	score := aml.ClampScore(m.OutlierMetrics * p.config.RiskScoreWeights.PeerOutlier)
+	return aml.Alert{
+		CustomerID: m.CustomerID,
+		AlertDate:  m.LastTransaction.Format(aml.DateLayout),
+		AlertType:  aml.AlertPeerOutlier,
+		RiskScore:  score,
+		Description: fmt.Sprintf("Customer's %d-day activity is an outlier among %d peers (%s): %s",
+			p.config.PeerWindowDays, m.PeerGroupSize, m.PeerGroup, strings.Join(reasons, "; ")),
+		Priority:    aml.PriorityForScore(score),
+		TotalAmount: m.TotalAmount,
+		TransNums:   nums,
+	}
+}
@@ -0,0 +1,118 @@
+package detect
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// Structuring flags repeated transactions just under the reporting threshold
+// within rolling windows of days, across every card of one resolved customer.
+//
+// For each window size a window ends on every day with a qualifying
+// transaction. Only maximal windows are reported: a window is skipped when
+// the next one still contains all of its transactions. A larger window with
+// exactly the same transactions as a smaller one is not reported again.
+type Structuring struct {
+	config Config
+}
+
+// NewStructuring creates the structuring detector
+func NewStructuring(config Config) *Structuring {
+	windows := append([]int(nil), config.StructuringWindows...)
+	sort.Ints(windows)
+	config.StructuringWindows = windows
+	return &Structuring{config: config}
+}
+
+// Name implements Detector
+func (s *Structuring) Name() string {
+	return aml.AlertStructuring
+}
+
+// Lookback implements Detector
+func (s *Structuring) Lookback() time.Duration {
+	windows := s.config.StructuringWindows
+	return time.Duration(windows[len(windows)-1]) * 24 * time.Hour
+}
+
+// Detect implements Detector
+func (s *Structuring) Detect(transactions []aml.Transaction, since time.Time) []aml.Alert {
+	threshold := s.config.StructuringThreshold
+	floor := threshold * s.config.StructuringBuffer
+	band := func(txn aml.Transaction) bool {
+		return txn.Amount >= floor && txn.Amount < threshold
+	}
+
+	var alerts []aml.Alert
+	for _, txns := range byPerson(transactions, band) {
+		alerts = append(alerts, s.detectPerson(txns, since)...)
+	}
+	return alerts
+}
+
+// detectPerson evaluates every window size over one customer's in-band
+// transactions
+func (s *Structuring) detectPerson(txns []aml.Transaction, since time.Time) []aml.Alert {
+	days := make([]int64, len(txns))
+	for i, txn := range txns {
+		days[i] = dayNumber(txn.TransDateTransTime)
+	}
+
+	// Index of the last transaction on each distinct day
+	var ends []int
+	for i := range txns {
+		if i == len(txns)-1 || days[i+1] != days[i] {
+			ends = append(ends, i)
+		}
+	}
+
+	type span struct{ first, last int64 }
+	reported := map[span]bool{}
+	var alerts []aml.Alert
+	for _, window := range s.config.StructuringWindows {
+		lo := 0
+		for e, hi := range ends {
+			end := days[hi]
+			for days[lo] < end-int64(window)+1 {
+				lo++
+			}
+			count := hi - lo + 1
+			if count < s.config.StructuringMinTransactions {
+				continue
+			}
+			if e+1 < len(ends) && days[ends[e+1]]-int64(window)+1 <= days[lo] {
+				continue
+			}
+			if !txns[hi].TransDateTransTime.After(since) {
+				continue
+			}
+			key := span{days[lo], end}
+			if reported[key] {
+				continue
+			}
+			reported[key] = true
+			alerts = append(alerts, s.alert(txns[lo:hi+1], window))
+		}
+	}
+	return alerts
+}
+
+func (s *Structuring) alert(txns []aml.Transaction, window int) aml.Alert {
+	total := sumAmounts(txns)
+	score := aml.ClampScore(int64(len(txns)) * s.config.RiskScoreWeights.Structuring)
+	return aml.Alert{
+		CustomerID: txns[0].CustomerID(),
+		AlertDate:  txns[len(txns)-1].Date(),
+		AlertType:  aml.AlertStructuring,
+		RiskScore:  score,
+		Description: fmt.Sprintf("Customer made %d transactions totaling $%s just under $%s threshold within %s on %s",
+			len(txns), aml.FormatAmount(total), aml.FormatAmount(s.config.StructuringThreshold),
+			plural(window, "day"), plural(countCards(txns), "card")),
+		Priority:    aml.PriorityForScore(score),
+		TotalAmount: total,
+		TransNums:   transNums(txns),
+	}
+}
@@ -0,0 +1,131 @@
+package detect
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// Velocity counts transactions and spend inside sliding time windows ending
+// at each of a customer's transactions. Overlapping qualifying windows of
+// one size form an episode, which is reported once at its busiest window
+// (most transactions, then highest amount). Windows are measured against
+// transaction timestamps, never the wall clock, so history can be
+// reprocessed.
+type Velocity struct {
+	config Config
+}
+
+// NewVelocity creates the velocity detector
+func NewVelocity(config Config) *Velocity {
+	windows := append([]VelocityWindow(nil), config.VelocityWindows...)
+	sort.SliceStable(windows, func(i, j int) bool { return windows[i].Minutes < windows[j].Minutes })
+	config.VelocityWindows = windows
+	return &Velocity{config: config}
+}
+
+// Name implements Detector
+func (v *Velocity) Name() string {
+	return aml.AlertVelocity
+}
+
+// Lookback implements Detector
+func (v *Velocity) Lookback() time.Duration {
+	windows := v.config.VelocityWindows
+	return time.Duration(windows[len(windows)-1].Minutes) * time.Minute
+}
+
+// Detect implements Detector
+func (v *Velocity) Detect(transactions []aml.Transaction, since time.Time) []aml.Alert {
+	var alerts []aml.Alert
+	for _, txns := range byPerson(transactions, nil) {
+		alerts = append(alerts, v.detectPerson(txns, since)...)
+	}
+	return alerts
+}
+
+func (v *Velocity) detectPerson(txns []aml.Transaction, since time.Time) []aml.Alert {
+	prefix := make([]float64, len(txns)+1)
+	for i, txn := range txns {
+		prefix[i+1] = prefix[i] + txn.Amount
+	}
+
+	type span struct{ lo, hi int }
+	reported := map[span]bool{}
+	var alerts []aml.Alert
+	for _, window := range v.config.VelocityWindows {
+		length := time.Duration(window.Minutes) * time.Minute
+
+		var peak, last span
+		open := false
+		flush := func() {
+			if open && !reported[peak] {
+				reported[peak] = true
+				alerts = append(alerts, v.alert(txns[peak.lo:peak.hi+1], window))
+			}
+			open = false
+		}
+
+		lo := 0
+		for hi := range txns {
+			end := txns[hi].TransDateTransTime
+			for end.Sub(txns[lo].TransDateTransTime) > length {
+				lo++
+			}
+			if !end.After(since) {
+				continue
+			}
+			count := hi - lo + 1
+			amount := prefix[hi+1] - prefix[lo]
+			if count < window.MinTransactions && (window.MinAmount == 0 || count < 2 || amount < window.MinAmount) {
+				continue
+			}
+
+			current := span{lo, hi}
+			if open && txns[lo].TransDateTransTime.After(txns[last.hi].TransDateTransTime) {
+				flush()
+			}
+			if !open {
+				peak, open = current, true
+			} else if peakCount := peak.hi - peak.lo + 1; count > peakCount ||
+				(count == peakCount && amount > prefix[peak.hi+1]-prefix[peak.lo]) {
+				peak = current
+			}
+			last = current
+		}
+		flush()
+	}
+	return alerts
+}
+
+func (v *Velocity) alert(txns []aml.Transaction, window VelocityWindow) aml.Alert {
+	total := sumAmounts(txns)
+	weight := v.config.RiskScoreWeights.Velocity
+	score := int64(len(txns)) * weight
+	if window.MinAmount > 0 {
+		score += int64(total/window.MinAmount) * weight
+	}
+	score = aml.ClampScore(score)
+
+	return aml.Alert{
+		CustomerID: txns[0].CustomerID(),
+		AlertDate:  txns[len(txns)-1].Date(),
+		AlertType:  aml.AlertVelocity,
+		RiskScore:  score,
+		Description: fmt.Sprintf("Customer made %d transactions totaling $%s within %s",
+			len(txns), aml.FormatAmount(total), windowLabel(window.Minutes)),
+		Priority:    aml.PriorityForScore(score),
+		TotalAmount: total,
+		TransNums:   transNums(txns),
+	}
+}
+
+// windowLabel renders a window length as "5 minutes" or "24 hours"
+func windowLabel(minutes int) string {
+	if minutes%60 == 0 {
+		return plural(minutes/60, "hour")
+	}
+	return plural(minutes, "minute")
+}
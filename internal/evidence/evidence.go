@@ -0,0 +1,335 @@
+// Package evidence builds the evidence package of an alert: one archive
+// with the transactions that triggered it, the risk profile of the customer
+// or merchant it was raised on, the
+// detection run and detector configuration that raised it, the case history
+// and a printable summary, listed with their checksums in a manifest.
+package evidence
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/cases"
+	"aml-system/internal/configstore"
+	"aml-system/internal/merchant"
+	"aml-system/internal/store"
+)
+
+// ManifestName is the checksum manifest of a package, in the format of
+// sha256sum so it can be checked with sha256sum -c
+const ManifestName = "MANIFEST.sha256"
+
+// Package is everything known about an alert at the time it is built
+type Package struct {
+	Alert aml.Alert
+	Case  *cases.Case
+	// Transactions triggered the alert or, when the detector did not record
+	// them (ByDate), are the customer's or merchant's transactions on the
+	// alert date
+	Transactions []aml.Transaction
+	ByDate       bool
+	// Missing are triggering transactions that are not card transactions,
+	// such as wires
+	Missing  []string
+	Profile  map[string]interface{} // nil when the customer or merchant has none
+	Run      *configstore.Run       // nil when no run recorded the alert
+	Version  *configstore.Version   // nil when the run used unversioned files
+	Approval *configstore.Event
+	// Config and Rules are the parameters the run used, from its
+	// version or as it recorded them; empty for runs without any
+	Config      string
+	Rules       []configstore.RuleFile
+	GeneratedAt time.Time
+}
+
+// parameterised are the sources of runs whose alerts depend on parameters,
+// which their evidence must show, and the file under config/ it shows them
+// in
+var parameterised = map[string]string{
+	"detect run":       "aml_config.json",
+	"baseline update":  "aml_config.json",
+	"incremental_sql":  "aml_config.json",
+	"sanctions screen": "screening.json",
+	"watchlist screen": "screening.json",
+	"model score":      "model.json",
+}
+
+// Gather collects the package of an alert from the store
+func Gather(ctx context.Context, st store.Store, alertID int64, now time.Time) (*Package, error) {
+	book, err := cases.Load(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	c, err := book.Get(alertID)
+	if err != nil {
+		return nil, err
+	}
+	p := &Package{Case: c, GeneratedAt: now}
+	member := false
+	for _, alert := range c.Alerts {
+		if alert.AlertID == alertID {
+			p.Alert, member = alert, true
+		}
+	}
+	if !member {
+		return nil, fmt.Errorf("alert %d is not in case %d", alertID, c.CaseID)
+	}
+
+	var links []aml.AlertTransaction
+	if err := st.Load(ctx, store.AlertTransactionsTable, &links); err != nil {
+		return nil, fmt.Errorf("failed to load alert transactions: %v", err)
+	}
+	triggering := map[string]bool{}
+	for _, link := range links {
+		if link.AlertID == alertID {
+			triggering[link.TransNum] = true
+			p.Alert.TransNums = append(p.Alert.TransNums, link.TransNum)
+		}
+	}
+	// Alerts raised on a merchant span many customers, so their
+	// transactions are found among everyone's
+	name, onMerchant := p.Alert.Merchant()
+	var transactions []aml.Transaction
+	if onMerchant {
+		transactions, err = st.Transactions(ctx, time.Time{})
+	} else {
+		transactions, err = st.CustomerTransactions(ctx, p.Alert.CustomerID, time.Time{})
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.ByDate = len(triggering) == 0
+	found := map[string]bool{}
+	for _, txn := range transactions {
+		if onMerchant && txn.Merchant != name {
+			continue
+		}
+		if triggering[txn.TransNum] || (p.ByDate && txn.Date() == p.Alert.AlertDate) {
+			p.Transactions = append(p.Transactions, txn)
+			found[txn.TransNum] = true
+		}
+	}
+	for _, num := range p.Alert.TransNums {
+		if !found[num] {
+			p.Missing = append(p.Missing, num)
+		}
+	}
+	sort.SliceStable(p.Transactions, func(i, j int) bool {
+		return p.Transactions[i].TransDateTransTime.Before(p.Transactions[j].TransDateTransTime)
+	})
+
+	var profiles []map[string]interface{}
+	if onMerchant {
+		if err := st.Load(ctx, merchant.ProfilesTable, &profiles); err != nil {
+			return nil, fmt.Errorf("failed to load merchant risk profiles: %v", err)
+		}
+	} else if err := st.Load(ctx, aml.CustomerProfilesTable, &profiles); err != nil {
+		return nil, fmt.Errorf("failed to load customer risk profiles: %v", err)
+	}
+	for _, profile := range profiles {
+		if onMerchant && profile["merchant"] == name || !onMerchant && profile["customer_id"] == p.Alert.CustomerID {
+			p.Profile = profile
+		}
+	}
+
+	if p.Run, err = configstore.RunOf(ctx, st, alertID); err != nil {
+		return nil, err
+	}
+	switch {
+	case p.Run == nil:
+	case p.Run.ConfigVersion > 0:
+		registry, err := configstore.Load(ctx, st)
+		if err != nil {
+			return nil, err
+		}
+		version, err := registry.Get(p.Run.ConfigVersion)
+		if err != nil {
+			return nil, err
+		}
+		p.Version = &version
+		if approval, ok := registry.Approval(version.Version); ok {
+			p.Approval = &approval
+		}
+		p.Config, p.Rules = version.Config, version.Rules
+	case p.Run.Config != "":
+		p.Config, p.Rules = p.Run.Config, p.Run.Rules
+	case parameterised[p.Run.Source] != "":
+		return nil, fmt.Errorf("run %d that raised alert %d recorded neither a config version nor the parameters it used, so its evidence would be incomplete", p.Run.RunID, alertID)
+	}
+	return p, nil
+}
+
+// Name is the directory the package's files are archived under
+func (p *Package) Name() string {
+	return fmt.Sprintf("evidence_alert_%d", p.Alert.AlertID)
+}
+
+// Archive writes the package as a zip archive of its files and their
+// manifest
+func (p *Package) Archive() ([]byte, error) {
+	files, err := p.files()
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest bytes.Buffer
+	for _, f := range files {
+		sum := sha256.Sum256(f.data)
+		fmt.Fprintf(&manifest, "%s  %s\n", hex.EncodeToString(sum[:]), f.name)
+	}
+	files = append(files, file{ManifestName, manifest.Bytes()})
+
+	var b bytes.Buffer
+	archive := zip.NewWriter(&b)
+	for _, f := range files {
+		w, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     path.Join(p.Name(), f.name),
+			Method:   zip.Deflate,
+			Modified: p.GeneratedAt,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to add %s to the evidence package: %v", f.name, err)
+		}
+		if _, err := w.Write(f.data); err != nil {
+			return nil, fmt.Errorf("failed to add %s to the evidence package: %v", f.name, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write the evidence package: %v", err)
+	}
+	return b.Bytes(), nil
+}
+
+type file struct {
+	name string
+	data []byte
+}
+
+// files renders the contents of the package
+func (p *Package) files() ([]file, error) {
+	var files []file
+	add := func(name string, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %v", name, err)
+		}
+		files = append(files, file{name, append(data, '\n')})
+		return nil
+	}
+
+	summary, err := p.summary()
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, file{"summary.html", summary})
+	alert := struct {
+		aml.Alert
+		TransNums []string `json:"trans_nums"`
+	}{p.Alert, p.Alert.TransNums}
+	if err := add("alert.json", alert); err != nil {
+		return nil, err
+	}
+	if err := add("case.json", p.Case); err != nil {
+		return nil, err
+	}
+	transactions, err := transactionsCSV(p.Transactions)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, file{"transactions.csv", transactions})
+	if p.Profile != nil {
+		name := "customer_profile.json"
+		if _, ok := p.Alert.Merchant(); ok {
+			name = "merchant_profile.json"
+		}
+		if err := add(name, p.Profile); err != nil {
+			return nil, err
+		}
+	}
+	if p.Run != nil {
+		run := *p.Run
+		run.Config, run.Rules = "", nil
+		if err := add("run.json", run); err != nil {
+			return nil, err
+		}
+	}
+	if p.Config != "" {
+		config, err := p.config()
+		if err != nil {
+			return nil, err
+		}
+		name := parameterised[p.Run.Source]
+		if name == "" {
+			name = "aml_config.json"
+		}
+		files = append(files, file{path.Join("config", name), config})
+		for _, rule := range p.Rules {
+			files = append(files, file{path.Join("config/rules", rule.File), []byte(rule.Content)})
+		}
+	}
+	if p.Version != nil {
+		version := *p.Version
+		version.Config, version.Rules = "", nil
+		meta := struct {
+			configstore.Version
+			Approval *configstore.Event `json:"approval"`
+		}{version, p.Approval}
+		if err := add("config/version.json", meta); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// config returns the parameters the run used, indented
+func (p *Package) config() ([]byte, error) {
+	var b bytes.Buffer
+	if err := json.Indent(&b, []byte(p.Config), "", "  "); err != nil {
+		return nil, fmt.Errorf("the parameters of run %d are not valid JSON: %v", p.Run.RunID, err)
+	}
+	b.WriteByte('\n')
+	return b.Bytes(), nil
+}
+
+// transactionColumns are the columns of credit_card_transactions, so the
+// file can be read back with aml.ReadTransactionsCSV
+var transactionColumns = []string{
+	"trans_date_trans_time", "cc_num", "merchant", "category", "amt", "first", "last", "gender",
+	"street", "city", "state", "zip", "lat", "long", "city_pop", "job", "dob", "trans_num",
+	"unix_time", "merch_lat", "merch_long", "is_fraud",
+}
+
+func transactionsCSV(transactions []aml.Transaction) ([]byte, error) {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Write(transactionColumns)
+	float := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for _, t := range transactions {
+		fraud := "0"
+		if t.IsFraud {
+			fraud = "1"
+		}
+		w.Write([]string{
+			t.TransDateTransTime.Format(aml.TransactionTimeLayout), strconv.FormatInt(t.CCNum, 10), t.Merchant,
+			t.Category, float(t.Amount), t.First, t.Last, t.Gender, t.Street, t.City, t.State, t.Zip,
+			float(t.Lat), float(t.Long), strconv.FormatInt(t.CityPop, 10), t.Job, t.DOB, t.TransNum,
+			strconv.FormatInt(t.UnixTime, 10), float(t.MerchLat), float(t.MerchLong), fraud,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("failed to write transactions.csv: %v", err)
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,205 @@
+package evidence
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/ctr"
+)
+
+// summaryTemplate renders the summary as a single self-contained page
+// without charts or scripts, laid out to print to PDF
+var summaryTemplate = template.Must(template.New("summary").Funcs(template.FuncMap{
+	"cents": cents,
+	"mask":  ctr.MaskCard,
+	"when": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format("2006-01-02 15:04:05 UTC")
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Evidence package: alert {{.Alert.AlertID}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; font-size: 11pt; margin: 2em; color: #111; }
+h1 { font-size: 16pt; } h2 { font-size: 13pt; margin-top: 1.5em; border-bottom: 1px solid #999; }
+table { border-collapse: collapse; width: 100%; margin: 0.5em 0; }
+th, td { border: 1px solid #bbb; padding: 3px 6px; text-align: left; vertical-align: top; }
+th { background: #eee; } td.num { text-align: right; }
+table.fields th { width: 28%; }
+pre { font-size: 9pt; white-space: pre-wrap; border: 1px solid #bbb; padding: 6px; }
+@media print { body { margin: 0; } h2 { page-break-after: avoid; } tr { page-break-inside: avoid; } }
+</style>
+</head>
+<body>
+<h1>Evidence package: alert {{.Alert.AlertID}}, {{.Alert.AlertType}}</h1>
+<p>Generated {{when .GeneratedAt}}. The files of this package and their SHA-256 checksums are listed in MANIFEST.sha256.</p>
+
+<h2>Alert</h2>
+<table class="fields">
+<tr><th>Alert ID</th><td>{{.Alert.AlertID}}</td></tr>
+{{with .Merchant}}<tr><th>Merchant</th><td>{{.}}</td></tr>
+{{else}}<tr><th>Customer</th><td>{{.Alert.CustomerID}}</td></tr>
+{{end}}
+<tr><th>Type</th><td>{{.Alert.AlertType}}</td></tr>
+<tr><th>Alert date</th><td>{{.Alert.AlertDate}}</td></tr>
+<tr><th>Risk score</th><td>{{.Alert.RiskScore}} ({{.Alert.Priority}})</td></tr>
+<tr><th>Amount</th><td>${{cents .Alert.TotalAmount}}</td></tr>
+<tr><th>Description</th><td>{{.Alert.Description}}</td></tr>
+<tr><th>Detected</th><td>{{.Alert.DetectionDate}}{{with when .Alert.CreatedAt}}, created {{.}}{{end}}</td></tr>
+</table>
+
+<h2>Case</h2>
+<table class="fields">
+<tr><th>Case ID</th><td>{{.Case.CaseID}}</td></tr>
+<tr><th>Status</th><td>{{.Case.Status}}</td></tr>
+<tr><th>Assignee</th><td>{{.Case.Assignee}}</td></tr>
+<tr><th>Due date</th><td>{{.Case.DueDate}}</td></tr>
+<tr><th>Alerts</th><td>{{range $i, $id := .Case.AlertIDs}}{{if $i}}, {{end}}{{$id}}{{end}}</td></tr>
+<tr><th>Aggregate score</th><td>{{.Case.AggregateScore}} ({{.Case.CasePriority}})</td></tr>
+</table>
+{{with .Case.Events}}
+<table>
+<tr><th>At</th><th>Actor</th><th>Change</th><th>Note</th></tr>
+{{range .}}<tr><td>{{when .At}}</td><td>{{.Actor}}</td><td>{{.EventType}}{{with .ToStatus}} → {{.}}{{end}}{{with .Assignee}} to {{.}}{{end}}{{with .DueDate}} due {{.}}{{end}}</td><td>{{.Note}}</td></tr>
+{{end}}</table>
+{{else}}
+<p>No changes since the case was opened.</p>
+{{end}}
+
+<h2>Transactions</h2>
+{{if .ByDate}}<p>The detector did not record the transactions that triggered this alert; these are all of the {{if .Merchant}}merchant's{{else}}customer's{{end}} transactions on the alert date.</p>
+{{else}}<p>The transactions that triggered this alert.</p>
+{{end}}{{with .Missing}}<p>Not among the card transactions, and listed by number only: {{range $i, $n := .}}{{if $i}}, {{end}}{{$n}}{{end}}.</p>
+{{end}}
+<table>
+<tr><th>Time</th><th>Transaction</th><th>Card</th><th>Merchant</th><th>Category</th><th>Amount</th></tr>
+{{range .Transactions}}<tr><td>{{.TransDateTransTime.Format "2006-01-02 15:04:05"}}</td><td>{{.TransNum}}</td><td>{{mask .CCNum}}</td><td>{{.Merchant}}</td><td>{{.Category}}</td><td class="num">${{cents .Amount}}</td></tr>
+{{end}}<tr><th colspan="5">Total ({{len .Transactions}})</th><td class="num">${{cents .Total}}</td></tr>
+</table>
+
+<h2>{{if .Merchant}}Merchant{{else}}Customer{{end}} profile</h2>
+{{with .Subject}}
+<table class="fields">
+<tr><th>Name</th><td>{{.First}} {{.Last}}</td></tr>
+<tr><th>Date of birth</th><td>{{.DOB}}</td></tr>
+<tr><th>Address</th><td>{{.Street}}, {{.City}}, {{.State}} {{.Zip}}</td></tr>
+<tr><th>Occupation</th><td>{{.Job}}</td></tr>
+</table>
+{{end}}
+{{with .ProfileFields}}
+<table class="fields">
+{{range .}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{else}}
+<p>The {{if .Merchant}}merchant{{else}}customer{{end}} has no risk profile.</p>
+{{end}}
+
+<h2>Detection run</h2>
+{{with .Run}}
+<table class="fields">
+<tr><th>Run ID</th><td>{{.RunID}}</td></tr>
+<tr><th>Source</th><td>{{.Source}}</td></tr>
+<tr><th>Started</th><td>{{when .StartedAt}}</td></tr>
+<tr><th>Finished</th><td>{{when .FinishedAt}}</td></tr>
+<tr><th>Activity since</th><td>{{with when .Since}}{{.}}{{else}}all history{{end}}</td></tr>
+<tr><th>Transactions scanned</th><td>{{.Transactions}}</td></tr>
+<tr><th>Alerts raised</th><td>{{.Alerts}} (IDs {{.FirstAlertID}} to {{.LastAlertID}}), {{.Suppressed}} suppressed</td></tr>
+<tr><th>Configuration</th><td>{{if .ConfigVersion}}version {{.ConfigVersion}}{{else}}unversioned{{end}}, checksum {{.ConfigChecksum}}</td></tr>
+</table>
+{{else}}
+<p>No detection run recorded this alert.</p>
+{{end}}
+
+<h2>Parameters</h2>
+{{if .Config}}
+<table class="fields">
+{{with .Version}}<tr><th>Version</th><td>{{.Version}}, effective {{.EffectiveDate}}</td></tr>
+<tr><th>Proposed</th><td>{{.Author}}, {{when .ProposedAt}}: {{.Rationale}}</td></tr>
+<tr><th>Approved</th><td>{{with $.Approval}}{{.Actor}}, {{when .At}}{{with .Comment}}: {{.}}{{end}}{{end}}</td></tr>
+{{else}}<tr><th>Version</th><td>Unversioned, as recorded by the run</td></tr>
+{{end}}<tr><th>Checksum</th><td>{{.Run.ConfigChecksum}}</td></tr>
+{{with .Rules}}<tr><th>Rule files</th><td>{{range $i, $r := .}}{{if $i}}, {{end}}{{$r.File}}{{end}}</td></tr>
+{{end}}
+</table>
+<pre>{{.Indented}}</pre>
+{{else}}
+<p>The run does not use parameters.</p>
+{{end}}
+</body>
+</html>
+`))
+
+// field is one row of the profile table
+type field struct {
+	Name  string
+	Value string
+}
+
+// summaryData is what the summary template renders
+type summaryData struct {
+	*Package
+	Merchant      string           // the merchant the alert was raised on, if any
+	Subject       *aml.Transaction // the customer's details, from their latest transaction
+	Total         float64
+	ProfileFields []field
+	Indented      string // the run's parameters
+}
+
+// summary renders the package's summary page
+func (p *Package) summary() ([]byte, error) {
+	data := summaryData{Package: p}
+	data.Merchant, _ = p.Alert.Merchant()
+	for i, txn := range p.Transactions {
+		data.Total += txn.Amount
+		if data.Merchant == "" {
+			data.Subject = &p.Transactions[i]
+		}
+	}
+	for name, value := range p.Profile {
+		data.ProfileFields = append(data.ProfileFields, field{name, profileValue(value)})
+	}
+	sort.Slice(data.ProfileFields, func(i, j int) bool { return data.ProfileFields[i].Name < data.ProfileFields[j].Name })
+	if p.Config != "" {
+		config, err := p.config()
+		if err != nil {
+			return nil, err
+		}
+		data.Indented = string(config)
+	}
+
+	var b bytes.Buffer
+	if err := summaryTemplate.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("failed to render the evidence summary: %v", err)
+	}
+	return b.Bytes(), nil
+}
+
+// cents formats an amount to the cent, as recorded
+func cents(amount float64) string {
+	c := int64(math.Round(amount * 100))
+	return fmt.Sprintf("%s.%02d", aml.FormatAmount(float64(c/100)), c%100)
+}
+
+// profileValue formats a profile column without exponents
+func profileValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
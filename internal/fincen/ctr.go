@@ -0,0 +1,281 @@
+package fincen
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"aml-system/internal/ctr"
+)
+
+// Namespaces of the FinCEN base schema
+const (
+	baseNamespace = "www.fincen.gov/base"
+	xsiNamespace  = "http://www.w3.org/2001/XMLSchema-instance"
+)
+
+// Activity party type codes used by the CTR
+const (
+	partyTransmitter           = "35"
+	partyTransmitterContact    = "37"
+	partyReportingInstitution  = "30"
+	partyContactOffice         = "8"
+	partyTransactionLocation   = "34"
+	partyPersonOnOwnBehalf     = "50"
+	identificationEIN          = "2"
+	identificationTIN          = "4"
+	identificationTCC          = "28"
+	ctrDetailPayments          = "46"
+	organizationDepositoryType = "1"
+)
+
+type ctrBatch struct {
+	XMLName        xml.Name      `xml:"fc2:EFilingBatchXML"`
+	XmlnsFC2       string        `xml:"xmlns:fc2,attr"`
+	XmlnsXSI       string        `xml:"xmlns:xsi,attr"`
+	SchemaLocation string        `xml:"xsi:schemaLocation,attr"`
+	ActivityCount  int           `xml:"ActivityCount,attr"`
+	TotalAmount    string        `xml:"TotalAmount,attr"`
+	PartyCount     int           `xml:"PartyCount,attr"`
+	FormTypeCode   string        `xml:"fc2:FormTypeCode"`
+	Activities     []ctrActivity `xml:"fc2:Activity"`
+}
+
+type ctrActivity struct {
+	SeqNum              int                 `xml:"SeqNum,attr"`
+	FilingDateText      string              `xml:"fc2:FilingDateText"`
+	ActivityAssociation activityAssociation `xml:"fc2:ActivityAssociation"`
+	Parties             []party             `xml:"fc2:Party"`
+	CurrencyActivity    currencyActivity    `xml:"fc2:CurrencyTransactionActivity"`
+}
+
+type activityAssociation struct {
+	SeqNum                 int    `xml:"SeqNum,attr"`
+	InitialReportIndicator string `xml:"fc2:InitialReportIndicator"`
+}
+
+type party struct {
+	SeqNum                   int                   `xml:"SeqNum,attr"`
+	ActivityPartyTypeCode    string                `xml:"fc2:ActivityPartyTypeCode"`
+	FemaleGenderIndicator    string                `xml:"fc2:FemaleGenderIndicator,omitempty"`
+	IndividualBirthDateText  string                `xml:"fc2:IndividualBirthDateText,omitempty"`
+	CashInAmountText         string                `xml:"fc2:IndividualEntityCashInAmountText,omitempty"`
+	MaleGenderIndicator      string                `xml:"fc2:MaleGenderIndicator,omitempty"`
+	PrimaryRegulatorTypeCode string                `xml:"fc2:PrimaryRegulatorTypeCode,omitempty"`
+	UnknownGenderIndicator   string                `xml:"fc2:UnknownGenderIndicator,omitempty"`
+	PartyName                *partyName            `xml:"fc2:PartyName,omitempty"`
+	Address                  *address              `xml:"fc2:Address,omitempty"`
+	PhoneNumber              *phoneNumber          `xml:"fc2:PhoneNumber,omitempty"`
+	Identifications          []partyIdentification `xml:"fc2:PartyIdentification,omitempty"`
+	Classification           *organizationClass    `xml:"fc2:OrganizationClassificationTypeSubtype,omitempty"`
+	Occupation               *occupation           `xml:"fc2:PartyOccupationBusiness,omitempty"`
+}
+
+type partyName struct {
+	SeqNum                      int    `xml:"SeqNum,attr"`
+	PartyNameTypeCode           string `xml:"fc2:PartyNameTypeCode"`
+	RawEntityIndividualLastName string `xml:"fc2:RawEntityIndividualLastName,omitempty"`
+	RawIndividualFirstName      string `xml:"fc2:RawIndividualFirstName,omitempty"`
+	RawPartyFullName            string `xml:"fc2:RawPartyFullName,omitempty"`
+}
+
+type address struct {
+	SeqNum                int    `xml:"SeqNum,attr"`
+	RawCityText           string `xml:"fc2:RawCityText"`
+	RawCountryCodeText    string `xml:"fc2:RawCountryCodeText"`
+	RawStateCodeText      string `xml:"fc2:RawStateCodeText"`
+	RawStreetAddress1Text string `xml:"fc2:RawStreetAddress1Text"`
+	RawZIPCode            string `xml:"fc2:RawZIPCode"`
+}
+
+type phoneNumber struct {
+	SeqNum          int    `xml:"SeqNum,attr"`
+	PhoneNumberText string `xml:"fc2:PhoneNumberText"`
+}
+
+type partyIdentification struct {
+	SeqNum                        int    `xml:"SeqNum,attr"`
+	PartyIdentificationNumberText string `xml:"fc2:PartyIdentificationNumberText,omitempty"`
+	PartyIdentificationTypeCode   string `xml:"fc2:PartyIdentificationTypeCode,omitempty"`
+	TINUnknownIndicator           string `xml:"fc2:TINUnknownIndicator,omitempty"`
+}
+
+type organizationClass struct {
+	SeqNum             int    `xml:"SeqNum,attr"`
+	OrganizationTypeID string `xml:"fc2:OrganizationTypeID"`
+}
+
+type occupation struct {
+	SeqNum                 int    `xml:"SeqNum,attr"`
+	OccupationBusinessText string `xml:"fc2:OccupationBusinessText"`
+}
+
+type currencyActivity struct {
+	SeqNum                        int              `xml:"SeqNum,attr"`
+	AggregateTransactionIndicator string           `xml:"fc2:AggregateTransactionIndicator"`
+	TotalCashInReceiveAmountText  string           `xml:"fc2:TotalCashInReceiveAmountText"`
+	TransactionDateText           string           `xml:"fc2:TransactionDateText"`
+	Details                       []currencyDetail `xml:"fc2:CurrencyTransactionActivityDetail"`
+}
+
+type currencyDetail struct {
+	SeqNum                      int    `xml:"SeqNum,attr"`
+	DetailTypeCode              string `xml:"fc2:CurrencyTransactionActivityDetailTypeCode"`
+	DetailTransactionAmountText string `xml:"fc2:DetailTransactionAmountText"`
+}
+
+// CTRBatch renders candidates as a FinCEN CTR (form CTRX) batch file, one
+// activity per person and business day. Card activity is reported as
+// aggregated cash-in payments.
+func CTRBatch(filer Filer, candidates []ctr.Candidate, filingDate time.Time) ([]byte, error) {
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no CTR candidates to export")
+	}
+	if err := filer.Validate(); err != nil {
+		return nil, err
+	}
+
+	s := &seq{}
+	batch := ctrBatch{
+		XmlnsFC2:       baseNamespace,
+		XmlnsXSI:       xsiNamespace,
+		SchemaLocation: baseNamespace + " https://www.fincen.gov/base/EFL_CTRXBatchSchema.xsd",
+		FormTypeCode:   "CTRX",
+	}
+
+	// The batch total is the sum of the amounts as reported, each rounded
+	// up on its own
+	var total int64
+	for _, candidate := range candidates {
+		activity := ctrActivity{
+			SeqNum:              s.take(),
+			FilingDateText:      dateText(filingDate),
+			ActivityAssociation: activityAssociation{SeqNum: s.take(), InitialReportIndicator: "Y"},
+		}
+		activity.Parties = append(filerParties(filer, s), subjectParty(candidate, s))
+
+		amount := amountText(candidate.TotalAmount)
+		activity.CurrencyActivity = currencyActivity{
+			SeqNum:                        s.take(),
+			AggregateTransactionIndicator: "Y",
+			TotalCashInReceiveAmountText:  amount,
+			TransactionDateText:           parseDateText(candidate.BusinessDate),
+			Details: []currencyDetail{{
+				SeqNum:                      s.take(),
+				DetailTypeCode:              ctrDetailPayments,
+				DetailTransactionAmountText: amount,
+			}},
+		}
+
+		batch.Activities = append(batch.Activities, activity)
+		batch.PartyCount += len(activity.Parties)
+		total += wholeDollars(candidate.TotalAmount)
+	}
+	batch.ActivityCount = len(batch.Activities)
+	batch.TotalAmount = strconv.FormatInt(total, 10)
+
+	data, err := xml.MarshalIndent(batch, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode CTR batch: %v", err)
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// filerParties returns the transmitter, transmitter contact, reporting
+// institution, contact office and transaction location parties
+func filerParties(filer Filer, s *seq) []party {
+	institution := func(typeCode string) party {
+		return party{
+			SeqNum:                s.take(),
+			ActivityPartyTypeCode: typeCode,
+			PartyName:             &partyName{SeqNum: s.take(), PartyNameTypeCode: "L", RawPartyFullName: filer.Name},
+			Address:               filerAddress(filer, s),
+		}
+	}
+
+	transmitter := institution(partyTransmitter)
+	transmitter.PhoneNumber = &phoneNumber{SeqNum: s.take(), PhoneNumberText: digits(filer.ContactPhone)}
+	transmitter.Identifications = []partyIdentification{
+		{SeqNum: s.take(), PartyIdentificationNumberText: filer.EIN, PartyIdentificationTypeCode: identificationTIN},
+		{SeqNum: s.take(), PartyIdentificationNumberText: filer.TCC, PartyIdentificationTypeCode: identificationTCC},
+	}
+
+	contact := party{
+		SeqNum:                s.take(),
+		ActivityPartyTypeCode: partyTransmitterContact,
+		PartyName:             &partyName{SeqNum: s.take(), PartyNameTypeCode: "L", RawPartyFullName: filer.ContactName},
+	}
+
+	reporting := institution(partyReportingInstitution)
+	reporting.PrimaryRegulatorTypeCode = filer.PrimaryRegulatorCode
+	reporting.Identifications = []partyIdentification{
+		{SeqNum: s.take(), PartyIdentificationNumberText: filer.EIN, PartyIdentificationTypeCode: identificationEIN},
+	}
+	reporting.Classification = &organizationClass{SeqNum: s.take(), OrganizationTypeID: organizationDepositoryType}
+
+	office := party{
+		SeqNum:                s.take(),
+		ActivityPartyTypeCode: partyContactOffice,
+		PartyName:             &partyName{SeqNum: s.take(), PartyNameTypeCode: "L", RawPartyFullName: filer.ContactName},
+		PhoneNumber:           &phoneNumber{SeqNum: s.take(), PhoneNumberText: digits(filer.ContactPhone)},
+	}
+
+	location := institution(partyTransactionLocation)
+	location.Identifications = []partyIdentification{
+		{SeqNum: s.take(), PartyIdentificationNumberText: filer.EIN, PartyIdentificationTypeCode: identificationEIN},
+	}
+	location.Classification = &organizationClass{SeqNum: s.take(), OrganizationTypeID: organizationDepositoryType}
+
+	return []party{transmitter, contact, reporting, office, location}
+}
+
+// subjectParty describes the person who conducted the transactions
+func subjectParty(candidate ctr.Candidate, s *seq) party {
+	subject := party{
+		SeqNum:                  s.take(),
+		ActivityPartyTypeCode:   partyPersonOnOwnBehalf,
+		IndividualBirthDateText: parseDateText(candidate.DOB),
+		CashInAmountText:        amountText(candidate.TotalAmount),
+		PartyName: &partyName{
+			SeqNum:                      s.take(),
+			PartyNameTypeCode:           "L",
+			RawEntityIndividualLastName: candidate.Last,
+			RawIndividualFirstName:      candidate.First,
+		},
+		Address: &address{
+			SeqNum:                s.take(),
+			RawCityText:           candidate.City,
+			RawCountryCodeText:    "US",
+			RawStateCodeText:      candidate.State,
+			RawStreetAddress1Text: candidate.Street,
+			RawZIPCode:            candidate.Zip,
+		},
+		Identifications: []partyIdentification{{SeqNum: s.take(), TINUnknownIndicator: "Y"}},
+	}
+
+	switch strings.ToUpper(candidate.Gender) {
+	case "F":
+		subject.FemaleGenderIndicator = "Y"
+	case "M":
+		subject.MaleGenderIndicator = "Y"
+	default:
+		subject.UnknownGenderIndicator = "Y"
+	}
+	if candidate.Job != "" {
+		subject.Occupation = &occupation{SeqNum: s.take(), OccupationBusinessText: candidate.Job}
+	}
+	return subject
+}
+
+func filerAddress(filer Filer, s *seq) *address {
+	return &address{
+		SeqNum:                s.take(),
+		RawCityText:           filer.City,
+		RawCountryCodeText:    filer.Country,
+		RawStateCodeText:      filer.State,
+		RawStreetAddress1Text: filer.Street,
+		RawZIPCode:            filer.Zip,
+	}
+}
@@ -0,0 +1,120 @@
+// Package fincen writes FinCEN BSA batch XML filings.
+package fincen
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultFilerConfig is where the tools look for the filing institution
+const DefaultFilerConfig = "config/fincen_filer.json"
+
+// Filer describes the reporting financial institution and the transmitter.
+// It is loaded from a JSON file (see config/fincen_filer.example.json).
+type Filer struct {
+	Name                 string `json:"name"`
+	EIN                  string `json:"ein"`
+	TCC                  string `json:"tcc"`
+	PrimaryRegulatorCode string `json:"primary_regulator_code"`
+	Street               string `json:"street"`
+	City                 string `json:"city"`
+	State                string `json:"state"`
+	Zip                  string `json:"zip"`
+	Country              string `json:"country"`
+	ContactName          string `json:"contact_name"`
+	ContactPhone         string `json:"contact_phone"`
+}
+
+var (
+	einPattern   = regexp.MustCompile(`^\d{9}$`)
+	tccPattern   = regexp.MustCompile(`^[A-Z0-9]{8}$`)
+	phonePattern = regexp.MustCompile(`^\d{10,16}$`)
+)
+
+// LoadFiler reads and validates a filer configuration file
+func LoadFiler(path string) (Filer, error) {
+	var filer Filer
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return filer, fmt.Errorf("failed to read filer config (copy config/fincen_filer.example.json to %s): %v", path, err)
+	}
+	if err := json.Unmarshal(data, &filer); err != nil {
+		return filer, fmt.Errorf("invalid filer config %s: %v", path, err)
+	}
+	if filer.Country == "" {
+		filer.Country = "US"
+	}
+	return filer, filer.Validate()
+}
+
+// Validate checks the fields FinCEN requires for the filer parties
+func (f Filer) Validate() error {
+	var problems []string
+	if strings.TrimSpace(f.Name) == "" {
+		problems = append(problems, "name is required")
+	}
+	if !einPattern.MatchString(f.EIN) {
+		problems = append(problems, "ein must be 9 digits")
+	}
+	if !tccPattern.MatchString(f.TCC) {
+		problems = append(problems, "tcc must be the 8 character transmitter control code")
+	}
+	if f.Street == "" || f.City == "" || f.State == "" || f.Zip == "" {
+		problems = append(problems, "street, city, state and zip are required")
+	}
+	if !phonePattern.MatchString(digits(f.ContactPhone)) {
+		problems = append(problems, "contact_phone must have 10-16 digits")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid filer config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// dateText formats a date as FinCEN's YYYYMMDD
+func dateText(t time.Time) string {
+	return t.Format("20060102")
+}
+
+// parseDateText converts a YYYY-MM-DD DATE value to YYYYMMDD, or "" if it is not a date
+func parseDateText(value string) string {
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return ""
+	}
+	return dateText(t)
+}
+
+// wholeDollars rounds up to whole dollars as the BSA forms require
+func wholeDollars(amount float64) int64 {
+	return int64(math.Ceil(amount))
+}
+
+// amountText is an amount as the BSA forms report it, in whole dollars
+func amountText(amount float64) string {
+	return strconv.FormatInt(wholeDollars(amount), 10)
+}
+
+func digits(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// seq hands out the SeqNum attributes, which must be unique within a batch
+type seq struct{ next int }
+
+func (s *seq) take() int {
+	s.next++
+	return s.next
+}
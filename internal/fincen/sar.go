@@ -0,0 +1,269 @@
+package fincen
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/sar"
+)
+
+// Activity party type code of the SAR subject
+const partySubject = "33"
+
+// Suspicious activity types and subtypes of the SAR (FinCEN SAR XML User
+// Guide, SuspiciousActivityTypeID / SuspiciousActivitySubtypeID). Review
+// them against the current release of the guide when it changes.
+const (
+	activityStructuring       = "1"
+	activityBelowCTRThreshold = "110" // multiple transactions below CTR threshold
+	activityFraud             = "3"
+	activityCardFraud         = "309" // credit/debit card
+	activityMoneyLaundering   = "8"
+	activityOutOfPattern      = "814" // transaction out of pattern for customer(s)
+	activityOther             = "9"
+	activityMultipleLocations = "913" // suspicious use of multiple transaction locations
+	activityWorkingTogether   = "915" // two or more individuals working together
+	activityOtherOther        = "9999"
+)
+
+// classification is the SAR activity a type of alert reports
+type classification struct {
+	typeID    string
+	subtypeID string
+}
+
+var alertClassifications = map[string]classification{
+	aml.AlertStructuring:        {activityStructuring, activityBelowCTRThreshold},
+	aml.AlertCardTesting:        {activityFraud, activityCardFraud},
+	aml.AlertVelocity:           {activityMoneyLaundering, activityOutOfPattern},
+	aml.AlertDormancy:           {activityMoneyLaundering, activityOutOfPattern},
+	aml.AlertBehaviourDeviation: {activityMoneyLaundering, activityOutOfPattern},
+	aml.AlertPeerOutlier:        {activityMoneyLaundering, activityOutOfPattern},
+	aml.AlertMLAnomaly:          {activityMoneyLaundering, activityOutOfPattern},
+	aml.AlertGeographic:         {activityOther, activityMultipleLocations},
+	aml.AlertGraphCluster:       {activityOther, activityWorkingTogether},
+}
+
+// Lengths the SAR schema allows
+const (
+	maxLastName  = 150
+	maxFirstName = 35
+	maxStreet    = 100
+	maxCity      = 50
+	maxZIP       = 9
+	maxAmount    = 15 // digits of whole dollars
+)
+
+type sarBatch struct {
+	XMLName        xml.Name      `xml:"fc2:EFilingBatchXML"`
+	XmlnsFC2       string        `xml:"xmlns:fc2,attr"`
+	XmlnsXSI       string        `xml:"xmlns:xsi,attr"`
+	SchemaLocation string        `xml:"xsi:schemaLocation,attr"`
+	ActivityCount  int           `xml:"ActivityCount,attr"`
+	TotalAmount    string        `xml:"TotalAmount,attr"`
+	PartyCount     int           `xml:"PartyCount,attr"`
+	FormTypeCode   string        `xml:"fc2:FormTypeCode"`
+	Activities     []sarActivity `xml:"fc2:Activity"`
+}
+
+type sarActivity struct {
+	SeqNum              int                 `xml:"SeqNum,attr"`
+	FilingDateText      string              `xml:"fc2:FilingDateText"`
+	ActivityAssociation activityAssociation `xml:"fc2:ActivityAssociation"`
+	Parties             []party             `xml:"fc2:Party"`
+	SuspiciousActivity  suspiciousActivity  `xml:"fc2:SuspiciousActivity"`
+	Narrative           narrative           `xml:"fc2:ActivityNarrativeInformation"`
+}
+
+type suspiciousActivity struct {
+	SeqNum                           int                      `xml:"SeqNum,attr"`
+	SuspiciousActivityFromDateText   string                   `xml:"fc2:SuspiciousActivityFromDateText"`
+	SuspiciousActivityToDateText     string                   `xml:"fc2:SuspiciousActivityToDateText"`
+	TotalSuspiciousAmountText        string                   `xml:"fc2:TotalSuspiciousAmountText"`
+	SuspiciousActivityClassification []activityClassification `xml:"fc2:SuspiciousActivityClassification"`
+}
+
+type activityClassification struct {
+	SeqNum                          int    `xml:"SeqNum,attr"`
+	OtherSuspiciousActivityTypeText string `xml:"fc2:OtherSuspiciousActivityTypeText,omitempty"`
+	SuspiciousActivitySubtypeID     string `xml:"fc2:SuspiciousActivitySubtypeID"`
+	SuspiciousActivityTypeID        string `xml:"fc2:SuspiciousActivityTypeID"`
+}
+
+type narrative struct {
+	SeqNum                          int    `xml:"SeqNum,attr"`
+	ActivityNarrativeSequenceNumber int    `xml:"fc2:ActivityNarrativeSequenceNumber"`
+	NarrativeText                   string `xml:"fc2:NarrativeText"`
+}
+
+// SARBatch renders filings as a FinCEN SAR (form SARX) batch file, one
+// activity per filing with the subject as its only subject party. Filings
+// are checked against the schema's required fields and limits first.
+func SARBatch(filer Filer, filings []sar.Filing, filingDate time.Time) ([]byte, error) {
+	if len(filings) == 0 {
+		return nil, fmt.Errorf("no SAR filings to export")
+	}
+	if err := filer.Validate(); err != nil {
+		return nil, err
+	}
+	for _, filing := range filings {
+		if err := ValidateSAR(filing); err != nil {
+			return nil, err
+		}
+	}
+
+	s := &seq{}
+	batch := sarBatch{
+		XmlnsFC2:       baseNamespace,
+		XmlnsXSI:       xsiNamespace,
+		SchemaLocation: baseNamespace + " https://www.fincen.gov/base/EFL_SARXBatchSchema.xsd",
+		FormTypeCode:   "SARX",
+	}
+
+	// The batch total is the sum of the amounts as reported, each rounded
+	// up on its own
+	var total int64
+	for _, filing := range filings {
+		activity := sarActivity{
+			SeqNum:              s.take(),
+			FilingDateText:      dateText(filingDate),
+			ActivityAssociation: activityAssociation{SeqNum: s.take(), InitialReportIndicator: "Y"},
+		}
+		activity.Parties = append(filerParties(filer, s), sarSubjectParty(filing.Subject, s))
+
+		activity.SuspiciousActivity = suspiciousActivity{
+			SeqNum:                         s.take(),
+			SuspiciousActivityFromDateText: parseDateText(filing.ActivityFrom),
+			SuspiciousActivityToDateText:   parseDateText(filing.ActivityTo),
+			TotalSuspiciousAmountText:      amountText(filing.TotalAmount),
+		}
+		for _, c := range classifications(filing.AlertTypes) {
+			c.SeqNum = s.take()
+			activity.SuspiciousActivity.SuspiciousActivityClassification = append(activity.SuspiciousActivity.SuspiciousActivityClassification, c)
+		}
+		activity.Narrative = narrative{
+			SeqNum:                          s.take(),
+			ActivityNarrativeSequenceNumber: 1,
+			NarrativeText:                   strings.TrimSpace(filing.Narrative),
+		}
+
+		batch.Activities = append(batch.Activities, activity)
+		batch.PartyCount += len(activity.Parties)
+		total += wholeDollars(filing.TotalAmount)
+	}
+	batch.ActivityCount = len(batch.Activities)
+	batch.TotalAmount = strconv.FormatInt(total, 10)
+
+	data, err := xml.MarshalIndent(batch, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode SAR batch: %v", err)
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// ValidateSAR checks a filing against the fields the SAR schema requires
+// and the lengths it allows
+func ValidateSAR(filing sar.Filing) error {
+	var problems []string
+	require := func(field, value string, max int) {
+		switch n := len([]rune(value)); {
+		case strings.TrimSpace(value) == "":
+			problems = append(problems, field+" is required")
+		case n > max:
+			problems = append(problems, fmt.Sprintf("%s is longer than %d characters", field, max))
+		}
+	}
+	require("subject last name", filing.Last, maxLastName)
+	require("subject first name", filing.First, maxFirstName)
+	require("subject street", filing.Street, maxStreet)
+	require("subject city", filing.City, maxCity)
+	require("subject ZIP code", digits(filing.Zip), maxZIP)
+	if parseDateText(filing.ActivityFrom) == "" || parseDateText(filing.ActivityTo) == "" {
+		problems = append(problems, "the activity period needs from and to dates")
+	} else if filing.ActivityFrom > filing.ActivityTo {
+		problems = append(problems, "the activity period ends before it starts")
+	}
+	if filing.TotalAmount <= 0 || len(amountText(filing.TotalAmount)) > maxAmount {
+		problems = append(problems, "the suspicious amount must be positive and at most 15 digits")
+	}
+	if len(filing.AlertTypes) == 0 {
+		problems = append(problems, "at least one suspicious activity type is required")
+	}
+	if err := sar.CheckNarrative(filing.Narrative); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("SAR filing %d is not valid: %s", filing.FilingID, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// classifications maps alert types to SAR activity types, once each.
+// Types without a specific classification are reported as other suspicious
+// activity with the alert type as its description.
+func classifications(alertTypes []string) []activityClassification {
+	seen := map[classification]bool{}
+	var list []activityClassification
+	var other []string
+	for _, alertType := range alertTypes {
+		c, ok := alertClassifications[alertType]
+		if !ok {
+			other = append(other, alertType)
+			continue
+		}
+		if !seen[c] {
+			seen[c] = true
+			list = append(list, activityClassification{SuspiciousActivityTypeID: c.typeID, SuspiciousActivitySubtypeID: c.subtypeID})
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool { return list[i].SuspiciousActivitySubtypeID < list[j].SuspiciousActivitySubtypeID })
+	if len(other) > 0 {
+		list = append(list, activityClassification{
+			SuspiciousActivityTypeID:        activityOther,
+			SuspiciousActivitySubtypeID:     activityOtherOther,
+			OtherSuspiciousActivityTypeText: strings.Join(other, ", "),
+		})
+	}
+	return list
+}
+
+// sarSubjectParty describes the subject of a SAR
+func sarSubjectParty(subject sar.Subject, s *seq) party {
+	p := party{
+		SeqNum:                  s.take(),
+		ActivityPartyTypeCode:   partySubject,
+		IndividualBirthDateText: parseDateText(subject.DOB),
+		PartyName: &partyName{
+			SeqNum:                      s.take(),
+			PartyNameTypeCode:           "L",
+			RawEntityIndividualLastName: subject.Last,
+			RawIndividualFirstName:      subject.First,
+		},
+		Address: &address{
+			SeqNum:                s.take(),
+			RawCityText:           subject.City,
+			RawCountryCodeText:    "US",
+			RawStateCodeText:      subject.State,
+			RawStreetAddress1Text: subject.Street,
+			RawZIPCode:            digits(subject.Zip),
+		},
+		Identifications: []partyIdentification{{SeqNum: s.take(), TINUnknownIndicator: "Y"}},
+	}
+	switch strings.ToUpper(subject.Gender) {
+	case "F":
+		p.FemaleGenderIndicator = "Y"
+	case "M":
+		p.MaleGenderIndicator = "Y"
+	default:
+		p.UnknownGenderIndicator = "Y"
+	}
+	if subject.Job != "" {
+		p.Occupation = &occupation{SeqNum: s.take(), OccupationBusinessText: subject.Job}
+	}
+	return p
+}
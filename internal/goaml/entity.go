@@ -0,0 +1,118 @@
+// Package goaml writes goAML XML reports (STR and CTR) for financial
+// intelligence units that receive filings through UNODC's goAML.
+package goaml
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// DefaultEntityConfig is where the tools look for the reporting entity
+const DefaultEntityConfig = "config/goaml_entity.json"
+
+// Person is the reporting person (usually the MLRO) of the entity
+type Person struct {
+	Gender     string `json:"gender"`
+	Title      string `json:"title"`
+	FirstName  string `json:"first_name"`
+	LastName   string `json:"last_name"`
+	Email      string `json:"email"`
+	Occupation string `json:"occupation"`
+}
+
+// Location is the address of the reporting entity
+type Location struct {
+	AddressType string `json:"address_type"`
+	Address     string `json:"address"`
+	City        string `json:"city"`
+	Zip         string `json:"zip"`
+	CountryCode string `json:"country_code"`
+	State       string `json:"state"`
+}
+
+// Entity describes the reporting entity as registered with the FIU and the
+// lookup codes its goAML installation uses. It is loaded from a JSON file
+// (see config/goaml_entity.example.json).
+type Entity struct {
+	RentityID         int64  `json:"rentity_id"`
+	RentityBranch     string `json:"rentity_branch"`
+	SubmissionCode    string `json:"submission_code"` // E (electronic) or M (manual)
+	CurrencyCodeLocal string `json:"currency_code_local"`
+	// USDExchangeRate converts the USD transaction amounts to the local
+	// currency; it must be set when the local currency is not USD
+	USDExchangeRate float64 `json:"usd_exchange_rate"`
+	// CountryCode is where the reported customers and merchants are
+	CountryCode     string   `json:"country_code"`
+	TransmodeCode   string   `json:"transmode_code"` // conduction type of card payments
+	FundsCode       string   `json:"funds_code"`     // type of funds of card payments
+	AddressType     string   `json:"address_type"`   // of the customers' addresses
+	ReportingPerson Person   `json:"reporting_person"`
+	Location        Location `json:"location"`
+	// AlertIndicators maps alert types to the FIU's report indicator codes
+	AlertIndicators map[string]string `json:"alert_indicators"`
+	STRIndicator    string            `json:"str_indicator"` // when no alert type maps to one
+	CTRIndicator    string            `json:"ctr_indicator"`
+}
+
+var (
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+	countryPattern  = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// LoadEntity reads and validates a reporting entity configuration file
+func LoadEntity(path string) (Entity, error) {
+	var entity Entity
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return entity, fmt.Errorf("failed to read goAML entity config (copy config/goaml_entity.example.json to %s): %v", path, err)
+	}
+	if err := json.Unmarshal(data, &entity); err != nil {
+		return entity, fmt.Errorf("invalid goAML entity config %s: %v", path, err)
+	}
+	if entity.SubmissionCode == "" {
+		entity.SubmissionCode = "E"
+	}
+	if entity.CurrencyCodeLocal == "USD" && entity.USDExchangeRate == 0 {
+		entity.USDExchangeRate = 1
+	}
+	return entity, entity.Validate()
+}
+
+// Validate checks the fields every goAML report requires of the entity
+func (e Entity) Validate() error {
+	var problems []string
+	if e.RentityID <= 0 {
+		problems = append(problems, "rentity_id must be the ID the FIU registered the entity under")
+	}
+	if e.SubmissionCode != "E" && e.SubmissionCode != "M" {
+		problems = append(problems, "submission_code must be E or M")
+	}
+	if !currencyPattern.MatchString(e.CurrencyCodeLocal) {
+		problems = append(problems, "currency_code_local must be an ISO 4217 code")
+	}
+	if e.USDExchangeRate <= 0 {
+		problems = append(problems, "usd_exchange_rate must be positive")
+	}
+	if !countryPattern.MatchString(e.CountryCode) || !countryPattern.MatchString(e.Location.CountryCode) {
+		problems = append(problems, "country_code and location.country_code must be ISO 3166 codes")
+	}
+	if e.TransmodeCode == "" || e.FundsCode == "" || e.AddressType == "" {
+		problems = append(problems, "transmode_code, funds_code and address_type are required")
+	}
+	if strings.TrimSpace(e.ReportingPerson.FirstName) == "" || strings.TrimSpace(e.ReportingPerson.LastName) == "" {
+		problems = append(problems, "reporting_person needs a first_name and last_name")
+	}
+	if e.Location.AddressType == "" || e.Location.Address == "" || e.Location.City == "" {
+		problems = append(problems, "location needs an address_type, address and city")
+	}
+	if e.STRIndicator == "" || e.CTRIndicator == "" {
+		problems = append(problems, "str_indicator and ctr_indicator are required")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid goAML entity config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
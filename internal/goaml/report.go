@@ -0,0 +1,329 @@
+package goaml
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/ctr"
+	"aml-system/internal/sar"
+)
+
+// Report codes
+const (
+	ReportSTR = "STR" // suspicious transaction report
+	ReportCTR = "CTR" // cash transaction report
+)
+
+// dateTimeLayout is goAML's xs:dateTime without a zone
+const dateTimeLayout = "2006-01-02T15:04:05"
+
+// Lengths the goAML schema allows
+const (
+	maxReference   = 255
+	maxName        = 100
+	maxAddress     = 100
+	maxCity        = 255
+	maxZip         = 10
+	maxText        = 4000 // reason, action and transaction description
+	maxTransNumber = 50
+)
+
+// Report is what a goAML report covers: the subject as the bank's client,
+// the transactions they conducted and, for an STR, why they are reported
+type Report struct {
+	Code            string
+	EntityReference string // our reference the FIU quotes back
+	Subject         sar.Subject
+	Transactions    []aml.Transaction
+	AlertTypes      []string
+	Reason          string // grounds for suspicion (STR)
+	Action          string // action taken (STR)
+}
+
+// STR reports the activity of a case, as assembled for a SAR
+func STR(r sar.Report, reason, action string) Report {
+	return Report{
+		Code:            ReportSTR,
+		EntityReference: fmt.Sprintf("CASE-%d", r.CaseID),
+		Subject:         r.Subject,
+		Transactions:    r.Transactions,
+		AlertTypes:      r.AlertTypes,
+		Reason:          strings.TrimSpace(reason),
+		Action:          strings.TrimSpace(action),
+	}
+}
+
+// CTR reports a CTR candidate's business day, given the subject's
+// transactions
+func CTR(c ctr.Candidate, transactions []aml.Transaction) (Report, error) {
+	included := map[string]bool{}
+	for _, num := range c.TransNums {
+		included[num] = true
+	}
+	r := Report{
+		Code:            ReportCTR,
+		EntityReference: fmt.Sprintf("CTR-%d", c.CandidateID),
+		Subject: sar.Subject{
+			CustomerID: c.CustomerID,
+			First:      c.First,
+			Last:       c.Last,
+			DOB:        c.DOB,
+			Gender:     c.Gender,
+			Street:     c.Street,
+			City:       c.City,
+			State:      c.State,
+			Zip:        c.Zip,
+			Job:        c.Job,
+			Cards:      c.Cards,
+		},
+	}
+	for _, txn := range transactions {
+		if included[txn.TransNum] {
+			r.Transactions = append(r.Transactions, txn)
+		}
+	}
+	if len(r.Transactions) != len(c.TransNums) {
+		return r, fmt.Errorf("found %d of the %d transactions of CTR candidate %d", len(r.Transactions), len(c.TransNums), c.CandidateID)
+	}
+	sort.SliceStable(r.Transactions, func(i, j int) bool {
+		return r.Transactions[i].TransDateTransTime.Before(r.Transactions[j].TransDateTransTime)
+	})
+	return r, nil
+}
+
+type report struct {
+	XMLName           xml.Name        `xml:"report"`
+	RentityID         int64           `xml:"rentity_id"`
+	RentityBranch     string          `xml:"rentity_branch,omitempty"`
+	SubmissionCode    string          `xml:"submission_code"`
+	ReportCode        string          `xml:"report_code"`
+	EntityReference   string          `xml:"entity_reference"`
+	SubmissionDate    string          `xml:"submission_date"`
+	CurrencyCodeLocal string          `xml:"currency_code_local"`
+	ReportingPerson   reportingPerson `xml:"reporting_person"`
+	Location          address         `xml:"location"`
+	Reason            string          `xml:"reason,omitempty"`
+	Action            string          `xml:"action,omitempty"`
+	Transactions      []transaction   `xml:"transaction"`
+	Indicators        []string        `xml:"report_indicators>indicator"`
+}
+
+type reportingPerson struct {
+	Gender     string `xml:"gender,omitempty"`
+	Title      string `xml:"title,omitempty"`
+	FirstName  string `xml:"first_name"`
+	LastName   string `xml:"last_name"`
+	Email      string `xml:"email,omitempty"`
+	Occupation string `xml:"occupation,omitempty"`
+}
+
+type address struct {
+	AddressType string `xml:"address_type"`
+	Address     string `xml:"address"`
+	City        string `xml:"city"`
+	Zip         string `xml:"zip,omitempty"`
+	CountryCode string `xml:"country_code"`
+	State       string `xml:"state,omitempty"`
+}
+
+type transaction struct {
+	TransactionNumber      string     `xml:"transactionnumber"`
+	TransactionDescription string     `xml:"transaction_description"`
+	DateTransaction        string     `xml:"date_transaction"`
+	TransmodeCode          string     `xml:"transmode_code"`
+	AmountLocal            string     `xml:"amount_local"`
+	From                   fromClient `xml:"t_from_my_client"`
+	To                     to         `xml:"t_to"`
+}
+
+type fromClient struct {
+	FundsCode       string           `xml:"from_funds_code"`
+	ForeignCurrency *foreignCurrency `xml:"from_foreign_currency"`
+	Person          person           `xml:"from_person"`
+	Country         string           `xml:"from_country"`
+}
+
+type foreignCurrency struct {
+	CurrencyCode string `xml:"foreign_currency_code"`
+	Amount       string `xml:"foreign_amount"`
+	ExchangeRate string `xml:"foreign_exchange_rate"`
+}
+
+type person struct {
+	Gender     string    `xml:"gender,omitempty"`
+	FirstName  string    `xml:"first_name"`
+	LastName   string    `xml:"last_name"`
+	Birthdate  string    `xml:"birthdate,omitempty"`
+	Addresses  []address `xml:"addresses>address"`
+	Occupation string    `xml:"occupation,omitempty"`
+}
+
+type to struct {
+	FundsCode string      `xml:"to_funds_code"`
+	Entity    merchantOrg `xml:"to_entity"`
+	Country   string      `xml:"to_country"`
+}
+
+type merchantOrg struct {
+	Name     string `xml:"name"`
+	Business string `xml:"business,omitempty"`
+}
+
+// Marshal renders a report as a goAML XML document. The report is checked
+// against the schema's required fields and limits first.
+func Marshal(entity Entity, r Report, submitted time.Time) ([]byte, error) {
+	if err := entity.Validate(); err != nil {
+		return nil, err
+	}
+	if err := Validate(r); err != nil {
+		return nil, err
+	}
+
+	doc := report{
+		RentityID:         entity.RentityID,
+		RentityBranch:     entity.RentityBranch,
+		SubmissionCode:    entity.SubmissionCode,
+		ReportCode:        r.Code,
+		EntityReference:   r.EntityReference,
+		SubmissionDate:    submitted.Format(dateTimeLayout),
+		CurrencyCodeLocal: entity.CurrencyCodeLocal,
+		ReportingPerson:   reportingPerson(entity.ReportingPerson),
+		Location:          address(entity.Location),
+		Reason:            r.Reason,
+		Action:            r.Action,
+		Indicators:        indicators(entity, r),
+	}
+	client := person{
+		Gender:     strings.ToUpper(r.Subject.Gender),
+		FirstName:  r.Subject.First,
+		LastName:   r.Subject.Last,
+		Birthdate:  birthdate(r.Subject.DOB),
+		Occupation: r.Subject.Job,
+		Addresses: []address{{
+			AddressType: entity.AddressType,
+			Address:     r.Subject.Street,
+			City:        r.Subject.City,
+			Zip:         r.Subject.Zip,
+			CountryCode: entity.CountryCode,
+			State:       r.Subject.State,
+		}},
+	}
+	for _, txn := range r.Transactions {
+		t := transaction{
+			TransactionNumber:      txn.TransNum,
+			TransactionDescription: fmt.Sprintf("Card payment with %s at %s (%s)", ctr.MaskCard(txn.CCNum), txn.Merchant, txn.Category),
+			DateTransaction:        txn.TransDateTransTime.Format(dateTimeLayout),
+			TransmodeCode:          entity.TransmodeCode,
+			AmountLocal:            money(txn.Amount * entity.USDExchangeRate),
+			From: fromClient{
+				FundsCode: entity.FundsCode,
+				Person:    client,
+				Country:   entity.CountryCode,
+			},
+			To: to{
+				FundsCode: entity.FundsCode,
+				Entity:    merchantOrg{Name: txn.Merchant, Business: txn.Category},
+				Country:   entity.CountryCode,
+			},
+		}
+		if entity.CurrencyCodeLocal != "USD" {
+			t.From.ForeignCurrency = &foreignCurrency{
+				CurrencyCode: "USD",
+				Amount:       money(txn.Amount),
+				ExchangeRate: fmt.Sprintf("%.6f", entity.USDExchangeRate),
+			}
+		}
+		doc.Transactions = append(doc.Transactions, t)
+	}
+
+	data, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode goAML report: %v", err)
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// Validate checks a report against the fields the goAML schema requires
+// and the lengths it allows
+func Validate(r Report) error {
+	var problems []string
+	require := func(field, value string, max int) {
+		switch n := len([]rune(value)); {
+		case strings.TrimSpace(value) == "":
+			problems = append(problems, field+" is required")
+		case n > max:
+			problems = append(problems, fmt.Sprintf("%s is longer than %d characters", field, max))
+		}
+	}
+	if r.Code != ReportSTR && r.Code != ReportCTR {
+		problems = append(problems, fmt.Sprintf("report code %q is not %s or %s", r.Code, ReportSTR, ReportCTR))
+	}
+	require("entity reference", r.EntityReference, maxReference)
+	require("subject first name", r.Subject.First, maxName)
+	require("subject last name", r.Subject.Last, maxName)
+	require("subject address", r.Subject.Street, maxAddress)
+	require("subject city", r.Subject.City, maxCity)
+	if len([]rune(r.Subject.Zip)) > maxZip {
+		problems = append(problems, fmt.Sprintf("subject zip is longer than %d characters", maxZip))
+	}
+	if r.Code == ReportSTR {
+		require("reason", r.Reason, maxText)
+		if strings.Contains(r.Reason, sar.Placeholder) {
+			problems = append(problems, fmt.Sprintf("the reason still has %s ... ]] parts to complete", sar.Placeholder))
+		}
+		if len([]rune(r.Action)) > maxText {
+			problems = append(problems, fmt.Sprintf("action is longer than %d characters", maxText))
+		}
+	}
+	if len(r.Transactions) == 0 {
+		problems = append(problems, "at least one transaction is required")
+	}
+	for _, txn := range r.Transactions {
+		if txn.TransNum == "" || len(txn.TransNum) > maxTransNumber {
+			problems = append(problems, fmt.Sprintf("transaction number %q must have 1-%d characters", txn.TransNum, maxTransNumber))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("goAML %s %s is not valid: %s", r.Code, r.EntityReference, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// indicators returns the report indicators: the entity's codes for the
+// report's alert types, or its default for the report code
+func indicators(entity Entity, r Report) []string {
+	if r.Code == ReportCTR {
+		return []string{entity.CTRIndicator}
+	}
+	seen := map[string]bool{}
+	var codes []string
+	for _, alertType := range r.AlertTypes {
+		if code, ok := entity.AlertIndicators[alertType]; ok && !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+	if len(codes) == 0 {
+		return []string{entity.STRIndicator}
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// birthdate converts a YYYY-MM-DD DATE value to goAML's dateTime, or "" if
+// it is not a date
+func birthdate(value string) string {
+	t, err := time.Parse(aml.DateLayout, value)
+	if err != nil {
+		return ""
+	}
+	return t.Format(dateTimeLayout)
+}
+
+func money(amount float64) string {
+	return fmt.Sprintf("%.2f", amount)
+}
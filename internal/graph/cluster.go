@@ -0,0 +1,262 @@
+package graph
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ClustersTable holds one row per customer in a network cluster
+const ClustersTable = "network_clusters"
+
+// Thresholds decide which components are clusters and which are rings
+type Thresholds struct {
+	// MinClusterSize is the smallest number of linked customers reported
+	MinClusterSize int
+
+	// A ring is a cluster of at least RingMinSize customers in which at
+	// least RingDensity of all customer pairs are directly linked
+	RingMinSize int
+	RingDensity float64
+}
+
+// Cluster is a connected component of customers linked by shared attributes
+type Cluster struct {
+	ID      string
+	Members []int // customer node indexes, by node ID
+	Links   []int // attribute edge indexes
+
+	// LinkedPairs is the number of distinct member pairs sharing at least
+	// one attribute, and Density their share of all pairs
+	LinkedPairs int
+	Density     float64
+	LinkTypes   []string
+	IsRing      bool
+
+	// SharedMerchants are the merchants paid by at least two members
+	SharedMerchants []int
+	Transactions    int64
+	Amount          float64
+}
+
+// Clusters finds the connected components of the shared-attribute graph with
+// at least MinClusterSize customers, largest first. Payments are left out:
+// popular merchants would otherwise join most customers into one component.
+func Clusters(g *Graph, thresholds Thresholds) []Cluster {
+	parent := make([]int, len(g.Nodes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for _, edge := range g.Edges {
+		if edge.Kind != EdgePayment {
+			if a, b := find(edge.Source), find(edge.Target); a != b {
+				parent[a] = b
+			}
+		}
+	}
+
+	components := map[int][]int{}
+	for i, node := range g.Nodes {
+		if node.Kind == KindCustomer {
+			root := find(i)
+			components[root] = append(components[root], i)
+		}
+	}
+
+	var clusters []Cluster
+	for _, members := range components {
+		if len(members) < thresholds.MinClusterSize || len(members) < 2 {
+			continue
+		}
+		clusters = append(clusters, g.cluster(members, thresholds))
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if len(clusters[i].Members) != len(clusters[j].Members) {
+			return len(clusters[i].Members) > len(clusters[j].Members)
+		}
+		return clusters[i].ID < clusters[j].ID
+	})
+	return clusters
+}
+
+func (g *Graph) cluster(members []int, thresholds Thresholds) Cluster {
+	sort.Slice(members, func(i, j int) bool {
+		return g.Nodes[members[i]].ID < g.Nodes[members[j]].ID
+	})
+	c := Cluster{Members: members}
+
+	ids := make([]string, len(members))
+	for k, i := range members {
+		ids[k] = g.Nodes[i].ID
+		c.Transactions += g.Nodes[i].Transactions
+		c.Amount += g.Nodes[i].Amount
+	}
+	sum := sha1.Sum([]byte(strings.Join(ids, "\n")))
+	c.ID = "NC-" + hex.EncodeToString(sum[:])[:10]
+
+	pairs := map[[2]int]bool{}
+	types := map[string]bool{}
+	payers := map[int]int{}
+	for _, i := range members {
+		for _, e := range g.adjacency[i] {
+			edge := g.Edges[e]
+			if edge.Kind == EdgePayment {
+				payers[edge.Target]++
+				continue
+			}
+			if edge.Source == i {
+				c.Links = append(c.Links, e)
+				pairs[[2]int{edge.Source, edge.Target}] = true
+				types[edge.Kind] = true
+			}
+		}
+	}
+	for kind := range types {
+		c.LinkTypes = append(c.LinkTypes, kind)
+	}
+	sort.Strings(c.LinkTypes)
+	for merchant, count := range payers {
+		if count >= 2 {
+			c.SharedMerchants = append(c.SharedMerchants, merchant)
+		}
+	}
+	sort.Slice(c.SharedMerchants, func(i, j int) bool {
+		return g.Nodes[c.SharedMerchants[i]].ID < g.Nodes[c.SharedMerchants[j]].ID
+	})
+
+	n := len(members)
+	c.LinkedPairs = len(pairs)
+	c.Density = float64(c.LinkedPairs) / float64(n*(n-1)/2)
+	c.IsRing = n >= thresholds.RingMinSize && c.Density >= thresholds.RingDensity
+	return c
+}
+
+// ClusterMember is a row of network_clusters
+type ClusterMember struct {
+	ClusterID        string    `json:"cluster_id"`
+	PersonKey        string    `json:"person_key"`
+	CustomerID       string    `json:"customer_id"`
+	ClusterSize      int64     `json:"cluster_size"`
+	LinkedPairs      int64     `json:"linked_pairs"`
+	Density          float64   `json:"density"`
+	LinkTypes        string    `json:"link_types"`
+	IsRing           bool      `json:"is_ring"`
+	SharedMerchants  int64     `json:"shared_merchants"`
+	ClusterAmount    float64   `json:"cluster_amount"`
+	LinkedCustomers  string    `json:"linked_customers"`
+	Transactions     int64     `json:"transactions"`
+	TotalAmount      float64   `json:"total_amount"`
+	Degree           int64     `json:"degree"`
+	DegreeCentrality float64   `json:"degree_centrality"`
+	PageRank         float64   `json:"pagerank"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Rows returns the network_clusters rows of every cluster member.
+// LinkedCustomers lists the members the customer shares an attribute with.
+func Rows(g *Graph, clusters []Cluster, now time.Time) []ClusterMember {
+	var rows []ClusterMember
+	for _, c := range clusters {
+		for _, i := range c.Members {
+			node := g.Nodes[i]
+			rows = append(rows, ClusterMember{
+				ClusterID:        c.ID,
+				PersonKey:        node.PersonKey(),
+				CustomerID:       node.Label,
+				ClusterSize:      int64(len(c.Members)),
+				LinkedPairs:      int64(c.LinkedPairs),
+				Density:          c.Density,
+				LinkTypes:        strings.Join(c.LinkTypes, ","),
+				IsRing:           c.IsRing,
+				SharedMerchants:  int64(len(c.SharedMerchants)),
+				ClusterAmount:    c.Amount,
+				LinkedCustomers:  strings.Join(g.LinkedCustomers(i), ","),
+				Transactions:     node.Transactions,
+				TotalAmount:      node.Amount,
+				Degree:           node.Degree,
+				DegreeCentrality: node.DegreeCentrality,
+				PageRank:         node.PageRank,
+				UpdatedAt:        now,
+			})
+		}
+	}
+	return rows
+}
+
+// LinkedCustomers returns the customer IDs directly linked to customer node
+// i by a shared attribute
+func (g *Graph) LinkedCustomers(i int) []string {
+	seen := map[string]bool{}
+	var linked []string
+	for _, e := range g.adjacency[i] {
+		edge := g.Edges[e]
+		if edge.Kind == EdgePayment {
+			continue
+		}
+		if label := g.Nodes[edge.Other(i)].Label; !seen[label] {
+			seen[label] = true
+			linked = append(linked, label)
+		}
+	}
+	sort.Strings(linked)
+	return linked
+}
+
+// Links describes the attributes customer node i shares, as "address 123
+// MAIN ST, ..." or "card ****1234", one per distinct attribute
+func (g *Graph) Links(i int) []string {
+	seen := map[string]bool{}
+	var links []string
+	for _, e := range g.adjacency[i] {
+		edge := g.Edges[e]
+		if edge.Kind == EdgePayment {
+			continue
+		}
+		if link := strings.ToLower(edge.Kind) + " " + edge.Detail; !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+	sort.Strings(links)
+	return links
+}
+
+// Subgraph returns the cluster's members, the merchants they share and the
+// edges among them, for export
+func (g *Graph) Subgraph(c Cluster) *Graph {
+	keep := map[int]bool{}
+	for _, i := range c.Members {
+		keep[i] = true
+	}
+	for _, i := range c.SharedMerchants {
+		keep[i] = true
+	}
+
+	sub := &Graph{index: map[string]int{}}
+	remap := map[int]int{}
+	for i, node := range g.Nodes {
+		if keep[i] {
+			remap[i] = sub.node(node.ID, node.Kind, node.Label)
+			sub.Nodes[remap[i]] = node
+		}
+	}
+	for _, edge := range g.Edges {
+		source, ok1 := remap[edge.Source]
+		target, ok2 := remap[edge.Target]
+		if ok1 && ok2 {
+			e := sub.edge(source, target, edge.Kind, edge.Detail)
+			sub.Edges[e].Transactions = edge.Transactions
+			sub.Edges[e].Amount = edge.Amount
+		}
+	}
+	return sub
+}
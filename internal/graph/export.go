@@ -0,0 +1,249 @@
+package graph
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Export formats
+const (
+	FormatGraphML = "graphml"
+	FormatGEXF    = "gexf"
+)
+
+// Write writes the graph in format (graphml or gexf)
+func Write(w io.Writer, g *Graph, format string, now time.Time) error {
+	var document interface{}
+	switch format {
+	case FormatGraphML:
+		document = graphML(g)
+	case FormatGEXF:
+		document = gexf(g, now)
+	default:
+		return fmt.Errorf("unknown graph format %q (use graphml or gexf)", format)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return fmt.Errorf("failed to write %s: %v", format, err)
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// attribute is a node or edge attribute shared by both formats
+type attribute struct {
+	name     string
+	forNodes bool
+	kind     string // GraphML/GEXF type: string, long or double
+}
+
+var attributes = []attribute{
+	{"kind", true, "string"},
+	{"label", true, "string"},
+	{"transactions", true, "long"},
+	{"amount", true, "double"},
+	{"degree", true, "long"},
+	{"degree_centrality", true, "double"},
+	{"pagerank", true, "double"},
+	{"kind", false, "string"},
+	{"detail", false, "string"},
+	{"transactions", false, "long"},
+	{"amount", false, "double"},
+}
+
+func nodeValues(node Node) []string {
+	return []string{
+		node.Kind,
+		node.Label,
+		strconv.FormatInt(node.Transactions, 10),
+		strconv.FormatFloat(node.Amount, 'f', 2, 64),
+		strconv.FormatInt(node.Degree, 10),
+		strconv.FormatFloat(node.DegreeCentrality, 'g', 6, 64),
+		strconv.FormatFloat(node.PageRank, 'g', 6, 64),
+	}
+}
+
+func edgeValues(edge Edge) []string {
+	return []string{
+		edge.Kind,
+		edge.Detail,
+		strconv.FormatInt(edge.Transactions, 10),
+		strconv.FormatFloat(edge.Amount, 'f', 2, 64),
+	}
+}
+
+func nodeID(i int) string { return "n" + strconv.Itoa(i) }
+func edgeID(e int) string { return "e" + strconv.Itoa(e) }
+
+type graphMLDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	ID     string        `xml:"id,attr"`
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphMLData `xml:"data"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+func graphML(g *Graph) graphMLDocument {
+	doc := graphMLDocument{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Graph: graphMLGraph{ID: "transactions", EdgeDefault: "undirected"},
+	}
+	var nodeKeys, edgeKeys []string
+	for k, a := range attributes {
+		key := graphMLKey{ID: "d" + strconv.Itoa(k), For: "edge", Name: a.name, Type: a.kind}
+		if a.forNodes {
+			key.For = "node"
+			nodeKeys = append(nodeKeys, key.ID)
+		} else {
+			edgeKeys = append(edgeKeys, key.ID)
+		}
+		doc.Keys = append(doc.Keys, key)
+	}
+
+	for i, node := range g.Nodes {
+		n := graphMLNode{ID: nodeID(i)}
+		for k, value := range nodeValues(node) {
+			n.Data = append(n.Data, graphMLData{Key: nodeKeys[k], Value: value})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, n)
+	}
+	for e, edge := range g.Edges {
+		x := graphMLEdge{ID: edgeID(e), Source: nodeID(edge.Source), Target: nodeID(edge.Target)}
+		for k, value := range edgeValues(edge) {
+			x.Data = append(x.Data, graphMLData{Key: edgeKeys[k], Value: value})
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, x)
+	}
+	return doc
+}
+
+type gexfDocument struct {
+	XMLName xml.Name  `xml:"gexf"`
+	Xmlns   string    `xml:"xmlns,attr"`
+	Version string    `xml:"version,attr"`
+	Meta    gexfMeta  `xml:"meta"`
+	Graph   gexfGraph `xml:"graph"`
+}
+
+type gexfMeta struct {
+	LastModified string `xml:"lastmodifieddate,attr"`
+	Creator      string `xml:"creator"`
+	Description  string `xml:"description"`
+}
+
+type gexfGraph struct {
+	Mode            string           `xml:"mode,attr"`
+	DefaultEdgeType string           `xml:"defaultedgetype,attr"`
+	Attributes      []gexfAttributes `xml:"attributes"`
+	Nodes           []gexfNode       `xml:"nodes>node"`
+	Edges           []gexfEdge       `xml:"edges>edge"`
+}
+
+type gexfAttributes struct {
+	Class      string          `xml:"class,attr"`
+	Attributes []gexfAttribute `xml:"attribute"`
+}
+
+type gexfAttribute struct {
+	ID    string `xml:"id,attr"`
+	Title string `xml:"title,attr"`
+	Type  string `xml:"type,attr"`
+}
+
+type gexfNode struct {
+	ID        string         `xml:"id,attr"`
+	Label     string         `xml:"label,attr"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfEdge struct {
+	ID        string         `xml:"id,attr"`
+	Source    string         `xml:"source,attr"`
+	Target    string         `xml:"target,attr"`
+	Weight    int64          `xml:"weight,attr,omitempty"`
+	AttValues []gexfAttValue `xml:"attvalues>attvalue"`
+}
+
+type gexfAttValue struct {
+	For   string `xml:"for,attr"`
+	Value string `xml:"value,attr"`
+}
+
+func gexf(g *Graph, now time.Time) gexfDocument {
+	doc := gexfDocument{
+		Xmlns:   "http://gexf.net/1.3",
+		Version: "1.3",
+		Meta: gexfMeta{
+			LastModified: now.Format("2006-01-02"),
+			Creator:      "aml-system",
+			Description:  "Customer-merchant payments and shared customer attributes",
+		},
+		Graph: gexfGraph{Mode: "static", DefaultEdgeType: "undirected"},
+	}
+	nodeAttributes := gexfAttributes{Class: "node"}
+	edgeAttributes := gexfAttributes{Class: "edge"}
+	for _, a := range attributes {
+		if a.forNodes {
+			nodeAttributes.Attributes = append(nodeAttributes.Attributes,
+				gexfAttribute{ID: "n" + strconv.Itoa(len(nodeAttributes.Attributes)), Title: a.name, Type: a.kind})
+		} else {
+			edgeAttributes.Attributes = append(edgeAttributes.Attributes,
+				gexfAttribute{ID: "e" + strconv.Itoa(len(edgeAttributes.Attributes)), Title: a.name, Type: a.kind})
+		}
+	}
+	doc.Graph.Attributes = []gexfAttributes{nodeAttributes, edgeAttributes}
+
+	for i, node := range g.Nodes {
+		n := gexfNode{ID: nodeID(i), Label: node.Label}
+		for k, value := range nodeValues(node) {
+			n.AttValues = append(n.AttValues, gexfAttValue{For: nodeAttributes.Attributes[k].ID, Value: value})
+		}
+		doc.Graph.Nodes = append(doc.Graph.Nodes, n)
+	}
+	for e, edge := range g.Edges {
+		x := gexfEdge{ID: edgeID(e), Source: nodeID(edge.Source), Target: nodeID(edge.Target), Weight: edge.Transactions}
+		for k, value := range edgeValues(edge) {
+			x.AttValues = append(x.AttValues, gexfAttValue{For: edgeAttributes.Attributes[k].ID, Value: value})
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, x)
+	}
+	return doc
+}
@@ -0,0 +1,259 @@
+// Package graph builds the transaction network for link analysis: a
+// bipartite graph of customers and the merchants they pay, and links between
+// customers who share an attribute such as an address or a card. It finds
+// clusters of linked customers, scores node centrality and writes the graph
+// for investigation tools.
+package graph
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/ctr"
+)
+
+// Node kinds
+const (
+	KindCustomer = "CUSTOMER"
+	KindMerchant = "MERCHANT"
+)
+
+// Edge kinds. PAYMENT joins a customer to a merchant; the others join two
+// customers sharing that attribute. The transactions carry no phone number,
+// so there are no phone links.
+const (
+	EdgePayment = "PAYMENT"
+	EdgeAddress = "ADDRESS"
+	EdgeCard    = "CARD"
+)
+
+// Node is a customer (resolved by name and date of birth) or a merchant
+type Node struct {
+	ID           string
+	Kind         string
+	Label        string
+	Transactions int64
+	Amount       float64
+
+	// Centrality, filled in by Build
+	Degree           int64
+	DegreeCentrality float64
+	PageRank         float64
+}
+
+// Edge joins two nodes. For attribute links Detail is the shared address or
+// masked card.
+type Edge struct {
+	Source       int
+	Target       int
+	Kind         string
+	Detail       string
+	Transactions int64
+	Amount       float64
+}
+
+// Graph is the combined customer-merchant and shared-attribute graph
+type Graph struct {
+	Nodes []Node
+	Edges []Edge
+
+	index map[string]int
+	// adjacency lists edge indexes per node
+	adjacency [][]int
+}
+
+// CustomerID is the node ID of a customer
+func CustomerID(personKey string) string {
+	return "customer:" + personKey
+}
+
+// MerchantID is the node ID of a merchant
+func MerchantID(merchant string) string {
+	return "merchant:" + merchant
+}
+
+// PersonKey is the resolved customer of a customer node
+func (n Node) PersonKey() string {
+	return strings.TrimPrefix(n.ID, "customer:")
+}
+
+// Build creates the graph from transactions and scores centrality
+func Build(transactions []aml.Transaction) *Graph {
+	g := &Graph{index: map[string]int{}}
+
+	payments := map[[2]int]int{}
+	addresses := map[string]map[int]bool{}
+	cards := map[int64]map[int]bool{}
+	for _, txn := range transactions {
+		customer := g.node(CustomerID(txn.PersonKey()), KindCustomer, txn.CustomerID())
+		merchant := g.node(MerchantID(txn.Merchant), KindMerchant, txn.Merchant)
+		for _, i := range []int{customer, merchant} {
+			g.Nodes[i].Transactions++
+			g.Nodes[i].Amount += txn.Amount
+		}
+
+		key := [2]int{customer, merchant}
+		e, ok := payments[key]
+		if !ok {
+			e = g.edge(customer, merchant, EdgePayment, "")
+			payments[key] = e
+		}
+		g.Edges[e].Transactions++
+		g.Edges[e].Amount += txn.Amount
+
+		if address := addressKey(txn); address != "" {
+			if addresses[address] == nil {
+				addresses[address] = map[int]bool{}
+			}
+			addresses[address][customer] = true
+		}
+		if cards[txn.CCNum] == nil {
+			cards[txn.CCNum] = map[int]bool{}
+		}
+		cards[txn.CCNum][customer] = true
+	}
+
+	for _, address := range sortedKeys(addresses) {
+		g.link(addresses[address], EdgeAddress, address)
+	}
+	cardNums := make([]int64, 0, len(cards))
+	for card := range cards {
+		cardNums = append(cardNums, card)
+	}
+	sort.Slice(cardNums, func(i, j int) bool { return cardNums[i] < cardNums[j] })
+	for _, card := range cardNums {
+		g.link(cards[card], EdgeCard, ctr.MaskCard(card))
+	}
+
+	g.scoreCentrality()
+	return g
+}
+
+func (g *Graph) node(id, kind, label string) int {
+	if i, ok := g.index[id]; ok {
+		return i
+	}
+	g.Nodes = append(g.Nodes, Node{ID: id, Kind: kind, Label: label})
+	g.adjacency = append(g.adjacency, nil)
+	g.index[id] = len(g.Nodes) - 1
+	return len(g.Nodes) - 1
+}
+
+func (g *Graph) edge(source, target int, kind, detail string) int {
+	g.Edges = append(g.Edges, Edge{Source: source, Target: target, Kind: kind, Detail: detail})
+	e := len(g.Edges) - 1
+	g.adjacency[source] = append(g.adjacency[source], e)
+	g.adjacency[target] = append(g.adjacency[target], e)
+	return e
+}
+
+// link joins every pair of customers sharing an attribute
+func (g *Graph) link(customers map[int]bool, kind, detail string) {
+	if len(customers) < 2 {
+		return
+	}
+	members := make([]int, 0, len(customers))
+	for i := range customers {
+		members = append(members, i)
+	}
+	sort.Ints(members)
+	for a := 0; a < len(members); a++ {
+		for b := a + 1; b < len(members); b++ {
+			g.edge(members[a], members[b], kind, detail)
+		}
+	}
+}
+
+// Node returns the index of the node with id
+func (g *Graph) Node(id string) (int, bool) {
+	i, ok := g.index[id]
+	return i, ok
+}
+
+// Neighbours returns the edges of node i
+func (g *Graph) Neighbours(i int) []int {
+	return g.adjacency[i]
+}
+
+// Other returns the node at the other end of edge e from node i
+func (e Edge) Other(i int) int {
+	if e.Source == i {
+		return e.Target
+	}
+	return e.Source
+}
+
+// scoreCentrality sets each node's degree (distinct neighbours), degree
+// centrality (degree over the other nodes) and PageRank over the undirected
+// graph with a damping factor of 0.85
+func (g *Graph) scoreCentrality() {
+	n := len(g.Nodes)
+	if n == 0 {
+		return
+	}
+	neighbours := make([][]int, n)
+	for i := range g.Nodes {
+		seen := map[int]bool{}
+		for _, e := range g.adjacency[i] {
+			j := g.Edges[e].Other(i)
+			if !seen[j] {
+				seen[j] = true
+				neighbours[i] = append(neighbours[i], j)
+			}
+		}
+		g.Nodes[i].Degree = int64(len(neighbours[i]))
+		if n > 1 {
+			g.Nodes[i].DegreeCentrality = float64(len(neighbours[i])) / float64(n-1)
+		}
+	}
+
+	const damping, iterations = 0.85, 50
+	rank := make([]float64, n)
+	for i := range rank {
+		rank[i] = 1 / float64(n)
+	}
+	for iter := 0; iter < iterations; iter++ {
+		next := make([]float64, n)
+		var dangling float64
+		for i, r := range rank {
+			if len(neighbours[i]) == 0 {
+				dangling += r
+				continue
+			}
+			share := r / float64(len(neighbours[i]))
+			for _, j := range neighbours[i] {
+				next[j] += share
+			}
+		}
+		for i := range next {
+			next[i] = (1-damping)/float64(n) + damping*(next[i]+dangling/float64(n))
+		}
+		rank = next
+	}
+	for i := range g.Nodes {
+		g.Nodes[i].PageRank = rank[i]
+	}
+}
+
+// addressKey normalises the cardholder address so that differently cased or
+// spaced copies match
+func addressKey(txn aml.Transaction) string {
+	if strings.TrimSpace(txn.Street) == "" {
+		return ""
+	}
+	normalise := func(s string) string {
+		return strings.Join(strings.Fields(strings.ToUpper(s)), " ")
+	}
+	return fmt.Sprintf("%s, %s %s %s", normalise(txn.Street), normalise(txn.City), normalise(txn.State), normalise(txn.Zip))
+}
+
+func sortedKeys(m map[string]map[int]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
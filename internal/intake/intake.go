@@ -0,0 +1,135 @@
+// Package intake is the one path alerts take into the store, whichever
+// command raised them: alerts repeating one already stored and those an
+// approved suppression rule covers are dropped, the rest are inserted, the
+// run that raised them is recorded with its suppression hits and the new
+// alerts are consolidated into cases.
+package intake
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/cases"
+	"aml-system/internal/configstore"
+	"aml-system/internal/store"
+	"aml-system/internal/suppress"
+)
+
+// Batch is the alerts of one run on their way into the store
+type Batch struct {
+	Run configstore.Run
+	// Raised are the alerts as raised, each with the ID it is stored under
+	// once inserted: its own, or that of the stored alert it repeats. A
+	// suppressed alert has none.
+	Raised []aml.Alert
+	// Alerts are the alerts to insert
+	Alerts   []aml.Alert
+	Repeated int
+	Hits     []suppress.Hit
+	Links    []cases.Link // the case links of the inserted alerts
+
+	kept []int // index in Raised of each of Alerts
+}
+
+// Prepare drops the raised alerts that repeat one already stored with the
+// same detector, customer and window (see aml.Alert.DedupKey), then those a
+// suppression rule in force at now covers, judged on the transactions the
+// alerts were raised on. Repeats within alerts are kept: a detector can
+// raise several alerts for a window.
+func Prepare(ctx context.Context, st store.Store, run configstore.Run, alerts []aml.Alert, transactions []aml.Transaction, now time.Time) (*Batch, error) {
+	b := &Batch{Run: run, Raised: alerts}
+	if len(alerts) == 0 {
+		return b, nil
+	}
+	var stored []aml.Alert
+	if err := st.Load(ctx, store.AlertsTable, &stored); err != nil {
+		return nil, fmt.Errorf("failed to load alerts: %v", err)
+	}
+	seen := make(map[string]int64, len(stored))
+	for _, alert := range stored {
+		seen[alert.DedupKey()] = alert.AlertID
+	}
+
+	var fresh []aml.Alert
+	var index []int
+	for i, alert := range alerts {
+		if id, ok := seen[alert.DedupKey()]; ok {
+			b.Raised[i].AlertID = id
+			b.Repeated++
+			continue
+		}
+		fresh = append(fresh, alert)
+		index = append(index, i)
+	}
+
+	suppressions, err := suppress.Load(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	suppressed, hits := suppressions.Apply(fresh, suppress.NewActivity(transactions), run.Source, now)
+	b.Hits = hits
+	for i, alert := range fresh {
+		if !suppressed[i] {
+			b.Alerts = append(b.Alerts, alert)
+			b.kept = append(b.kept, index[i])
+		}
+	}
+	return b, nil
+}
+
+// Notes describes what Prepare dropped, for commands to print
+func (b *Batch) Notes() []string {
+	var notes []string
+	if b.Repeated > 0 {
+		notes = append(notes, fmt.Sprintf("Dropped %d alerts already raised by earlier runs", b.Repeated))
+	}
+	if len(b.Hits) > 0 {
+		notes = append(notes, fmt.Sprintf("Suppressed %d alerts by %d rules", len(b.Hits), len(suppress.Counts(b.Hits))))
+	}
+	return notes
+}
+
+// Insert stores the batch's alerts, its run and the run's suppression hits,
+// then consolidates the new alerts into cases
+func (b *Batch) Insert(ctx context.Context, st store.Store, now time.Time) error {
+	inserted, err := store.InsertAlerts(ctx, st, b.Alerts)
+	if err != nil {
+		return err
+	}
+	ids := make([]int64, len(inserted))
+	for i, alert := range inserted {
+		b.Raised[b.kept[i]].AlertID = alert.AlertID
+		ids[i] = alert.AlertID
+	}
+
+	b.Run.FinishedAt = now
+	b.Run.Alerts = int64(len(inserted))
+	b.Run.Suppressed = int64(len(b.Hits))
+	if len(inserted) > 0 {
+		b.Run.FirstAlertID = inserted[0].AlertID
+		b.Run.LastAlertID = inserted[len(inserted)-1].AlertID
+	}
+	if b.Run, err = configstore.RecordRun(ctx, st, b.Run, ids); err != nil {
+		return err
+	}
+	if err := suppress.RecordHits(ctx, st, b.Hits, b.Run.RunID); err != nil {
+		return err
+	}
+
+	if len(inserted) == 0 {
+		return nil
+	}
+	b.Links, err = cases.ConsolidateNew(ctx, st, now)
+	return err
+}
+
+// Cases is the number of cases the inserted alerts were linked to
+func (b *Batch) Cases() int {
+	seen := map[int64]bool{}
+	for _, link := range b.Links {
+		seen[link.CaseID] = true
+	}
+	return len(seen)
+}
@@ -0,0 +1,129 @@
+package merchant
+
+import (
+	"sort"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// ProfilesTable holds one risk profile per merchant, the merchant-side
+// counterpart of customer_risk_profiles_level2
+const ProfilesTable = "merchant_risk_profiles"
+
+// Profile is a row of merchant_risk_profiles
+type Profile struct {
+	Merchant             string    `json:"merchant"`
+	Category             string    `json:"category"`
+	TotalTransactions    int64     `json:"total_transactions"`
+	TotalAmount          float64   `json:"total_amount"`
+	AvgAmount            float64   `json:"avg_amount"`
+	UniqueCustomers      int64     `json:"unique_customers"`
+	UniqueCards          int64     `json:"unique_cards"`
+	ActiveDays           int64     `json:"active_days"`
+	AvgDailyAmount       float64   `json:"avg_daily_amount"`
+	PeakDailyAmount      float64   `json:"peak_daily_amount"`
+	PeakDate             string    `json:"peak_date"`
+	AnomalyAlerts        int64     `json:"anomaly_alerts"`
+	MaxAnomalyScore      int64     `json:"max_anomaly_score"`
+	MerchantRiskScore    int64     `json:"merchant_risk_score"`
+	RiskScore            int64     `json:"risk_score"`
+	RiskCategory         string    `json:"risk_category"`
+	FirstTransactionDate string    `json:"first_transaction_date"`
+	LastTransactionDate  string    `json:"last_transaction_date"`
+	ProfileGeneratedDate time.Time `json:"profile_generated_date"`
+}
+
+// BuildProfiles summarises every merchant's activity. The risk score is the
+// larger of the merchant's score in merchant_risk_scores and its highest
+// MERCHANT_ANOMALY alert; the category uses the customer profile bands.
+func BuildProfiles(transactions []aml.Transaction, alerts []aml.Alert, scores []Score, now time.Time) []Profile {
+	type stats struct {
+		profile    *Profile
+		customers  map[string]bool
+		cards      map[int64]bool
+		categories map[string]int
+		days       map[string]float64
+	}
+	merchants := map[string]*stats{}
+	for _, txn := range transactions {
+		s, ok := merchants[txn.Merchant]
+		if !ok {
+			s = &stats{
+				profile:    &Profile{Merchant: txn.Merchant, FirstTransactionDate: txn.Date(), LastTransactionDate: txn.Date()},
+				customers:  map[string]bool{},
+				cards:      map[int64]bool{},
+				categories: map[string]int{},
+				days:       map[string]float64{},
+			}
+			merchants[txn.Merchant] = s
+		}
+		p := s.profile
+		p.TotalTransactions++
+		p.TotalAmount += txn.Amount
+		date := txn.Date()
+		if date < p.FirstTransactionDate {
+			p.FirstTransactionDate = date
+		}
+		if date > p.LastTransactionDate {
+			p.LastTransactionDate = date
+		}
+		s.customers[txn.PersonKey()] = true
+		s.cards[txn.CCNum] = true
+		s.categories[txn.Category]++
+		s.days[txn.Date()] += txn.Amount
+	}
+
+	for _, alert := range alerts {
+		merchant, ok := alert.Merchant()
+		if !ok || alert.AlertType != aml.AlertMerchantAnomaly {
+			continue
+		}
+		s, ok := merchants[merchant]
+		if !ok {
+			continue
+		}
+		s.profile.AnomalyAlerts++
+		if alert.RiskScore > s.profile.MaxAnomalyScore {
+			s.profile.MaxAnomalyScore = alert.RiskScore
+		}
+	}
+	for _, score := range scores {
+		if s, ok := merchants[score.Merchant]; ok {
+			s.profile.MerchantRiskScore = score.RiskScore
+		}
+	}
+
+	profiles := make([]Profile, 0, len(merchants))
+	for _, s := range merchants {
+		p := s.profile
+		p.Category = mostCommon(s.categories)
+		p.AvgAmount = p.TotalAmount / float64(p.TotalTransactions)
+		p.UniqueCustomers = int64(len(s.customers))
+		p.UniqueCards = int64(len(s.cards))
+		p.ActiveDays = int64(len(s.days))
+		p.AvgDailyAmount = p.TotalAmount / float64(p.ActiveDays)
+		for date, amount := range s.days {
+			if amount > p.PeakDailyAmount || (amount == p.PeakDailyAmount && date < p.PeakDate) {
+				p.PeakDailyAmount = amount
+				p.PeakDate = date
+			}
+		}
+
+		p.RiskScore = p.MerchantRiskScore
+		if p.MaxAnomalyScore > p.RiskScore {
+			p.RiskScore = p.MaxAnomalyScore
+		}
+		p.RiskCategory = aml.RiskCategoryForScore(p.RiskScore)
+		p.ProfileGeneratedDate = now
+		profiles = append(profiles, *p)
+	}
+
+	sort.Slice(profiles, func(i, j int) bool {
+		if profiles[i].RiskScore != profiles[j].RiskScore {
+			return profiles[i].RiskScore > profiles[j].RiskScore
+		}
+		return profiles[i].Merchant < profiles[j].Merchant
+	})
+	return profiles
+}
@@ -0,0 +1,168 @@
+// Package merchant scores merchants by configured category and merchant
+// risk weights and by how many of their customers raise alerts.
+package merchant
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// DefaultRegistryPath is where the tools look for the merchant risk registry
+const DefaultRegistryPath = "config/merchant_risk.json"
+
+// ScoresTable holds the latest risk score of every merchant
+const ScoresTable = "merchant_risk_scores"
+
+// Registry assigns static risk weights (0-100) to merchant categories and to
+// individual merchants. A merchant weight overrides its category weight.
+type Registry struct {
+	// HighRiskThreshold is the risk score from which a merchant is high risk
+	HighRiskThreshold int64 `json:"high_risk_threshold"`
+
+	// AlertDensityWeight scales the share of a merchant's customers with
+	// alerts into a risk score. AlertDensitySmoothing is added to the
+	// customer count so merchants with few customers are not over-scored.
+	AlertDensityWeight    float64 `json:"alert_density_weight"`
+	AlertDensitySmoothing float64 `json:"alert_density_smoothing"`
+
+	Categories map[string]int64 `json:"categories"`
+	Merchants  map[string]int64 `json:"merchants"`
+}
+
+// LoadRegistry reads a registry file
+func LoadRegistry(path string) (Registry, error) {
+	registry := Registry{
+		HighRiskThreshold:     70,
+		AlertDensityWeight:    100,
+		AlertDensitySmoothing: 2,
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return registry, fmt.Errorf("failed to read merchant risk registry: %v", err)
+	}
+	if err := json.Unmarshal(data, &registry); err != nil {
+		return registry, fmt.Errorf("invalid merchant risk registry %s: %v", path, err)
+	}
+	if registry.HighRiskThreshold < 1 || registry.HighRiskThreshold > 100 {
+		return registry, fmt.Errorf("high_risk_threshold must be between 1 and 100")
+	}
+	if registry.AlertDensityWeight < 0 || registry.AlertDensitySmoothing < 0 {
+		return registry, fmt.Errorf("alert_density_weight and alert_density_smoothing must not be negative")
+	}
+	return registry, nil
+}
+
+// Weight returns the configured weight of a merchant, falling back to its
+// category, and whether the merchant itself is listed
+func (r Registry) Weight(merchant, category string) (int64, bool) {
+	if weight, ok := r.Merchants[merchant]; ok {
+		return weight, true
+	}
+	return r.Categories[category], false
+}
+
+// Score is a row of merchant_risk_scores
+type Score struct {
+	Merchant         string    `json:"merchant"`
+	Category         string    `json:"category"`
+	RegistryWeight   int64     `json:"registry_weight"`
+	ListedMerchant   bool      `json:"listed_merchant"`
+	Customers        int64     `json:"customers"`
+	AlertedCustomers int64     `json:"alerted_customers"`
+	AlertDensity     float64   `json:"alert_density"`
+	DensityScore     int64     `json:"density_score"`
+	RiskScore        int64     `json:"risk_score"`
+	IsHighRisk       bool      `json:"is_high_risk"`
+	ScoredAt         time.Time `json:"scored_at"`
+}
+
+// ScoreMerchants scores every merchant in transactions. The risk score is
+// the larger of the registry weight and the alert density score, where
+// density is the share of the merchant's customers with at least one alert
+// of a type other than HIGH_RISK_MERCHANT (which would feed back on itself)
+// or MERCHANT_ANOMALY (which is raised on the merchant, not a customer).
+func ScoreMerchants(registry Registry, transactions []aml.Transaction, alerts []aml.Alert, now time.Time) []Score {
+	alerted := map[string]bool{}
+	for _, alert := range alerts {
+		if alert.AlertType != aml.AlertHighRiskMerchant && alert.AlertType != aml.AlertMerchantAnomaly {
+			alerted[alert.CustomerID] = true
+		}
+	}
+
+	type stats struct {
+		customers  map[string]bool
+		categories map[string]int
+	}
+	merchants := map[string]*stats{}
+	for _, txn := range transactions {
+		s, ok := merchants[txn.Merchant]
+		if !ok {
+			s = &stats{customers: map[string]bool{}, categories: map[string]int{}}
+			merchants[txn.Merchant] = s
+		}
+		s.customers[txn.CustomerID()] = true
+		s.categories[txn.Category]++
+	}
+
+	scores := make([]Score, 0, len(merchants))
+	for name, s := range merchants {
+		score := Score{
+			Merchant:  name,
+			Category:  mostCommon(s.categories),
+			Customers: int64(len(s.customers)),
+			ScoredAt:  now,
+		}
+		for customer := range s.customers {
+			if alerted[customer] {
+				score.AlertedCustomers++
+			}
+		}
+		score.RegistryWeight, score.ListedMerchant = registry.Weight(name, score.Category)
+		score.AlertDensity = float64(score.AlertedCustomers) / (float64(score.Customers) + registry.AlertDensitySmoothing)
+		score.DensityScore = aml.ClampScore(int64(math.Round(score.AlertDensity * registry.AlertDensityWeight)))
+
+		score.RiskScore = score.DensityScore
+		if weight := aml.ClampScore(score.RegistryWeight); weight > score.RiskScore {
+			score.RiskScore = weight
+		}
+		score.IsHighRisk = score.RiskScore >= registry.HighRiskThreshold
+		scores = append(scores, score)
+	}
+
+	sort.Slice(scores, func(i, j int) bool {
+		if scores[i].RiskScore != scores[j].RiskScore {
+			return scores[i].RiskScore > scores[j].RiskScore
+		}
+		return scores[i].Merchant < scores[j].Merchant
+	})
+	return scores
+}
+
+// HighRisk returns the risk score of every high-risk merchant
+func HighRisk(scores []Score) map[string]int64 {
+	high := map[string]int64{}
+	for _, score := range scores {
+		if score.IsHighRisk {
+			high[score.Merchant] = score.RiskScore
+		}
+	}
+	return high
+}
+
+// mostCommon returns the most frequent key, breaking ties alphabetically
+func mostCommon(counts map[string]int) string {
+	best, bestCount := "", 0
+	for key, count := range counts {
+		if count > bestCount || (count == bestCount && key < best) {
+			best, bestCount = key, count
+		}
+	}
+	return best
+}
@@ -0,0 +1,97 @@
+package model
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// AnomalyScoresTable holds the isolation forest score of every scored
+// customer-day
+const AnomalyScoresTable = "anomaly_scores"
+
+// AnomalyScore is a row of anomaly_scores
+type AnomalyScore struct {
+	PersonKey    string    `json:"person_key"`
+	CustomerID   string    `json:"customer_id"`
+	ScoreDate    string    `json:"score_date"`
+	AnomalyScore float64   `json:"anomaly_score"`
+	Threshold    float64   `json:"threshold"`
+	IsAnomaly    bool      `json:"is_anomaly"`
+	TopFeatures  string    `json:"top_features"`
+	ModelVersion string    `json:"model_version"`
+	ScoredAt     time.Time `json:"scored_at"`
+}
+
+// ScoreDays scores the customer-days after since (all of them when since is
+// empty) and returns their anomaly_scores rows and an ML_ANOMALY alert for
+// each day at or above the model threshold
+func (f *IsolationForest) ScoreDays(days []CustomerDay, since string, now time.Time) ([]AnomalyScore, []aml.Alert) {
+	var scores []AnomalyScore
+	var alerts []aml.Alert
+	for _, day := range days {
+		if day.Date <= since {
+			continue
+		}
+		score := f.Score(day.Values)
+		top := f.Explain(day.Values, 3)
+		described := make([]string, len(top))
+		for i, feature := range top {
+			described[i] = FormatFeature(feature, day.Values[feature])
+		}
+
+		row := AnomalyScore{
+			PersonKey:    day.PersonKey,
+			CustomerID:   day.CustomerID,
+			ScoreDate:    day.Date,
+			AnomalyScore: score,
+			Threshold:    f.Threshold,
+			IsAnomaly:    score >= f.Threshold,
+			TopFeatures:  strings.Join(described, "; "),
+			ModelVersion: f.Version,
+			ScoredAt:     now,
+		}
+		scores = append(scores, row)
+		if row.IsAnomaly {
+			alerts = append(alerts, f.anomalyAlert(day, row))
+		}
+	}
+	return scores, alerts
+}
+
+// anomalyAlert scores the alert as the anomaly score in percent
+func (f *IsolationForest) anomalyAlert(day CustomerDay, row AnomalyScore) aml.Alert {
+	risk := aml.ClampScore(int64(math.Round(row.AnomalyScore * 100)))
+	return aml.Alert{
+		CustomerID: day.CustomerID,
+		AlertDate:  day.Date,
+		AlertType:  aml.AlertMLAnomaly,
+		RiskScore:  risk,
+		Description: fmt.Sprintf("Customer-day anomaly score %.2f at or above the model threshold of %.2f (%s); most isolating: %s",
+			row.AnomalyScore, row.Threshold, f.Version, row.TopFeatures),
+		Priority:    aml.PriorityForScore(risk),
+		TotalAmount: day.Amount,
+		TransNums:   day.TransNums,
+	}
+}
+
+// MergeAnomalyScores returns stored with the rows of the customer-days in
+// scores replaced by them, so rescoring with the same model version does
+// not repeat a day. Rows of other model versions are kept.
+func MergeAnomalyScores(stored, scores []AnomalyScore) []AnomalyScore {
+	key := func(s AnomalyScore) string { return s.ModelVersion + "|" + s.PersonKey + "|" + s.ScoreDate }
+	rescored := map[string]bool{}
+	for _, s := range scores {
+		rescored[key(s)] = true
+	}
+	merged := make([]AnomalyScore, 0, len(stored)+len(scores))
+	for _, s := range stored {
+		if !rescored[key(s)] {
+			merged = append(merged, s)
+		}
+	}
+	return append(merged, scores...)
+}
@@ -0,0 +1,155 @@
+// Package model trains and applies statistical scoring models over
+// engineered per-customer-day features, as an alternative to the hand-tuned
+// weights of the rule detectors.
+package model
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// Features are the per-customer-day features, in model input order
+var Features = []string{
+	"transactions",
+	"total_amount",
+	"max_amount",
+	"unique_merchants",
+	"unique_categories",
+	"unique_cards",
+	"night_share",
+	"online_share",
+	"max_distance_km",
+	"amount_ratio",
+	"days_since_previous",
+}
+
+// CustomerDay is one customer's activity on one day
+type CustomerDay struct {
+	PersonKey  string
+	CustomerID string
+	Date       string
+	Values     []float64 // one per Features entry
+	TransNums  []string
+	Amount     float64
+	IsFraud    bool // any of the day's transactions is labelled fraud
+}
+
+// CustomerDays engineers features for every customer and day in
+// transactions, which must be in time order. amount_ratio compares the day's
+// total with the customer's average over earlier active days (1 without
+// history) and days_since_previous counts days since their previous active
+// day (0 for the first). Days are ordered by customer and date.
+func CustomerDays(transactions []aml.Transaction) []CustomerDay {
+	type personDays struct {
+		customerID string
+		dates      []string
+		days       map[string][]aml.Transaction
+	}
+	people := map[string]*personDays{}
+	for _, txn := range transactions {
+		key := txn.PersonKey()
+		p, ok := people[key]
+		if !ok {
+			p = &personDays{customerID: txn.CustomerID(), days: map[string][]aml.Transaction{}}
+			people[key] = p
+		}
+		date := txn.Date()
+		if _, ok := p.days[date]; !ok {
+			p.dates = append(p.dates, date)
+		}
+		p.days[date] = append(p.days[date], txn)
+	}
+
+	keys := make([]string, 0, len(people))
+	for key := range people {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var days []CustomerDay
+	for _, key := range keys {
+		p := people[key]
+		var history float64
+		var previous time.Time
+		for k, date := range p.dates {
+			day := customerDay(key, p.customerID, date, p.days[date])
+
+			ratio := 1.0
+			if k > 0 && history > 0 {
+				ratio = day.Amount / (history / float64(k))
+			}
+			var gap float64
+			current, _ := time.Parse(aml.DateLayout, date)
+			if k > 0 {
+				gap = current.Sub(previous).Hours() / 24
+			}
+			day.Values = append(day.Values, ratio, gap)
+
+			history += day.Amount
+			previous = current
+			days = append(days, day)
+		}
+	}
+	return days
+}
+
+// customerDay computes the features that depend on the day alone
+func customerDay(personKey, customerID, date string, txns []aml.Transaction) CustomerDay {
+	day := CustomerDay{PersonKey: personKey, CustomerID: customerID, Date: date}
+	merchants := map[string]bool{}
+	categories := map[string]bool{}
+	cards := map[int64]bool{}
+	var maxAmount, maxDistance float64
+	var nights, online int
+	for _, txn := range txns {
+		day.Amount += txn.Amount
+		day.TransNums = append(day.TransNums, txn.TransNum)
+		day.IsFraud = day.IsFraud || txn.IsFraud
+		merchants[txn.Merchant] = true
+		categories[txn.Category] = true
+		cards[txn.CCNum] = true
+		if txn.Amount > maxAmount {
+			maxAmount = txn.Amount
+		}
+		if d := txn.HomeDistance(); d > maxDistance {
+			maxDistance = d
+		}
+		if hour := txn.TransDateTransTime.Hour(); hour >= 22 || hour < 6 {
+			nights++
+		}
+		if strings.HasSuffix(txn.Category, "_net") {
+			online++
+		}
+	}
+	n := float64(len(txns))
+	day.Values = []float64{
+		n,
+		day.Amount,
+		maxAmount,
+		float64(len(merchants)),
+		float64(len(categories)),
+		float64(len(cards)),
+		float64(nights) / n,
+		float64(online) / n,
+		maxDistance,
+	}
+	return day
+}
+
+// FormatFeature renders a feature value for descriptions
+func FormatFeature(feature int, value float64) string {
+	switch Features[feature] {
+	case "total_amount", "max_amount":
+		return fmt.Sprintf("%s $%s", Features[feature], aml.FormatAmount(value))
+	case "night_share", "online_share":
+		return fmt.Sprintf("%s %.0f%%", Features[feature], value*100)
+	case "amount_ratio":
+		return fmt.Sprintf("%s %.1fx", Features[feature], value)
+	default:
+		return fmt.Sprintf("%s %.0f", Features[feature], value)
+	}
+}
@@ -0,0 +1,105 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// FraudScoresTable holds the calibrated fraud probability of every scored
+// transaction
+const FraudScoresTable = "fraud_scores"
+
+// FraudCoefficientsTable holds the current fraud model's coefficients, one
+// row per feature, for the SQL scoring in the incremental processing
+const FraudCoefficientsTable = "fraud_model_coefficients"
+
+// FraudScore is a row of fraud_scores
+type FraudScore struct {
+	TransNum           string    `json:"trans_num"`
+	PersonKey          string    `json:"person_key"`
+	CustomerID         string    `json:"customer_id"`
+	TransDateTransTime time.Time `json:"trans_date_trans_time"`
+	FraudProbability   float64   `json:"fraud_probability"`
+	TopFactors         string    `json:"top_factors"`
+	ModelVersion       string    `json:"model_version"`
+	ScoredAt           time.Time `json:"scored_at"`
+}
+
+// FraudCoefficient is a row of fraud_model_coefficients. The intercept and
+// calibration are repeated on every row.
+type FraudCoefficient struct {
+	ModelVersion string    `json:"model_version"`
+	Feature      string    `json:"feature"`
+	Mean         float64   `json:"mean"`
+	Scale        float64   `json:"scale"`
+	Weight       float64   `json:"weight"`
+	Importance   float64   `json:"importance"`
+	Intercept    float64   `json:"intercept"`
+	CalibrationA float64   `json:"calibration_a"`
+	CalibrationB float64   `json:"calibration_b"`
+	TrainedAt    time.Time `json:"trained_at"`
+}
+
+// Coefficients returns the model's fraud_model_coefficients rows
+func (m *FraudModel) Coefficients() []FraudCoefficient {
+	importance := map[string]float64{}
+	for _, fi := range m.Importance() {
+		importance[fi.Feature] = fi.Importance
+	}
+	rows := make([]FraudCoefficient, len(m.Features))
+	for j, feature := range m.Features {
+		rows[j] = FraudCoefficient{
+			ModelVersion: m.Version,
+			Feature:      feature,
+			Mean:         m.Means[j],
+			Scale:        m.Scales[j],
+			Weight:       m.Weights[j],
+			Importance:   importance[feature],
+			Intercept:    m.Intercept,
+			CalibrationA: m.CalibrationA,
+			CalibrationB: m.CalibrationB,
+			TrainedAt:    m.TrainedAt,
+		}
+	}
+	return rows
+}
+
+// ScoreTransactions scores the vectors after since (all of them when since
+// is zero)
+func (m *FraudModel) ScoreTransactions(vectors []TransactionVector, since, now time.Time) []FraudScore {
+	var scores []FraudScore
+	for _, v := range vectors {
+		if !v.Time.After(since) {
+			continue
+		}
+		scores = append(scores, FraudScore{
+			TransNum:           v.TransNum,
+			PersonKey:          v.PersonKey,
+			CustomerID:         v.CustomerID,
+			TransDateTransTime: v.Time,
+			FraudProbability:   m.Probability(v.Values),
+			TopFactors:         strings.Join(m.Factors(v.Values, 3), ", "),
+			ModelVersion:       m.Version,
+			ScoredAt:           now,
+		})
+	}
+	return scores
+}
+
+// MergeFraudScores returns stored with the rows of the transactions in
+// scores replaced by them, so rescoring with the same model version does
+// not repeat a transaction. Rows of other model versions are kept.
+func MergeFraudScores(stored, scores []FraudScore) []FraudScore {
+	key := func(s FraudScore) string { return s.ModelVersion + "|" + s.TransNum }
+	rescored := map[string]bool{}
+	for _, s := range scores {
+		rescored[key(s)] = true
+	}
+	merged := make([]FraudScore, 0, len(stored)+len(scores))
+	for _, s := range stored {
+		if !rescored[key(s)] {
+			merged = append(merged, s)
+		}
+	}
+	return append(merged, scores...)
+}
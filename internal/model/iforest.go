@@ -0,0 +1,269 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// DefaultAnomalyModelPath is where the tools keep the isolation forest
+const DefaultAnomalyModelPath = "models/isolation_forest.json"
+
+// ForestConfig controls isolation forest training
+type ForestConfig struct {
+	Trees      int
+	SampleSize int
+	Seed       int64
+
+	// Contamination is the expected share of anomalous customer-days. The
+	// threshold is the training score at that upper quantile.
+	Contamination float64
+}
+
+// DefaultForestConfig returns the usual isolation forest settings
+func DefaultForestConfig() ForestConfig {
+	return ForestConfig{Trees: 100, SampleSize: 256, Seed: 42, Contamination: 0.01}
+}
+
+// TreeNode is a node of an isolation tree, stored in a flat slice. Leaves
+// have Feature -1 and the number of training rows that reached them.
+type TreeNode struct {
+	Feature int     `json:"f"`
+	Split   float64 `json:"s,omitempty"`
+	Left    int     `json:"l,omitempty"`
+	Right   int     `json:"r,omitempty"`
+	Size    int     `json:"n,omitempty"`
+}
+
+// IsolationForest is a trained, serialisable isolation forest. Anomalous
+// customer-days are isolated by fewer random splits than normal ones; the
+// score 2^(-E[h(x)]/c(n)) approaches 1 for anomalies and stays near or below
+// 0.5 for normal activity.
+type IsolationForest struct {
+	Version        string       `json:"version"`
+	Features       []string     `json:"features"`
+	Trees          [][]TreeNode `json:"trees"`
+	SampleSize     int          `json:"sample_size"`
+	Seed           int64        `json:"seed"`
+	Contamination  float64      `json:"contamination"`
+	Threshold      float64      `json:"threshold"`
+	TrainingRows   int          `json:"training_rows"`
+	TrainedThrough string       `json:"trained_through"`
+	TrainedAt      time.Time    `json:"trained_at"`
+}
+
+// TrainForest fits an isolation forest to the days' features. The same days
+// and seed always give the same trees.
+func TrainForest(days []CustomerDay, config ForestConfig, now time.Time) (*IsolationForest, error) {
+	if len(days) < 2 {
+		return nil, fmt.Errorf("need at least 2 customer-days to train, got %d", len(days))
+	}
+	if config.Trees < 1 || config.SampleSize < 2 || config.Contamination <= 0 || config.Contamination >= 0.5 {
+		return nil, fmt.Errorf("trees must be at least 1, sample size at least 2 and contamination between 0 and 0.5")
+	}
+
+	sampleSize := config.SampleSize
+	if sampleSize > len(days) {
+		sampleSize = len(days)
+	}
+	maxDepth := int(math.Ceil(math.Log2(float64(sampleSize))))
+	random := rand.New(rand.NewSource(config.Seed))
+
+	forest := &IsolationForest{
+		Features:      Features,
+		SampleSize:    sampleSize,
+		Seed:          config.Seed,
+		Contamination: config.Contamination,
+		TrainingRows:  len(days),
+		TrainedAt:     now,
+	}
+	for _, day := range days {
+		if day.Date > forest.TrainedThrough {
+			forest.TrainedThrough = day.Date
+		}
+	}
+
+	for t := 0; t < config.Trees; t++ {
+		sample := make([][]float64, sampleSize)
+		for i, k := range random.Perm(len(days))[:sampleSize] {
+			sample[i] = days[k].Values
+		}
+		var tree []TreeNode
+		grow(&tree, sample, 0, maxDepth, random)
+		forest.Trees = append(forest.Trees, tree)
+	}
+
+	scores := make([]float64, len(days))
+	for i, day := range days {
+		scores[i] = forest.Score(day.Values)
+	}
+	sort.Float64s(scores)
+	forest.Threshold = scores[int(math.Floor(float64(len(scores)-1)*(1-config.Contamination)))]
+
+	trees, _ := json.Marshal(forest.Trees)
+	sum := sha256.Sum256(trees)
+	forest.Version = "iforest-" + hex.EncodeToString(sum[:])[:12]
+	return forest, nil
+}
+
+// grow appends the subtree isolating rows and returns its index
+func grow(tree *[]TreeNode, rows [][]float64, depth, maxDepth int, random *rand.Rand) int {
+	index := len(*tree)
+	*tree = append(*tree, TreeNode{Feature: -1, Size: len(rows)})
+	if depth >= maxDepth || len(rows) <= 1 {
+		return index
+	}
+
+	// Pick a random feature that still varies, then a random split within
+	// its range
+	features := random.Perm(len(rows[0]))
+	for _, feature := range features {
+		low, high := rows[0][feature], rows[0][feature]
+		for _, row := range rows {
+			low = math.Min(low, row[feature])
+			high = math.Max(high, row[feature])
+		}
+		if low == high {
+			continue
+		}
+		split := low + random.Float64()*(high-low)
+		var left, right [][]float64
+		for _, row := range rows {
+			if row[feature] < split {
+				left = append(left, row)
+			} else {
+				right = append(right, row)
+			}
+		}
+		l := grow(tree, left, depth+1, maxDepth, random)
+		r := grow(tree, right, depth+1, maxDepth, random)
+		(*tree)[index] = TreeNode{Feature: feature, Split: split, Left: l, Right: r}
+		return index
+	}
+	return index
+}
+
+// averagePathLength is c(n), the average path length of an unsuccessful
+// binary search tree lookup among n items
+func averagePathLength(n int) float64 {
+	switch {
+	case n <= 1:
+		return 0
+	case n == 2:
+		return 1
+	default:
+		return 2*(math.Log(float64(n-1))+0.5772156649) - 2*float64(n-1)/float64(n)
+	}
+}
+
+// pathLength follows x down a tree, counting how often each feature split
+// on the way in isolated
+func pathLength(tree []TreeNode, x []float64, isolated []float64) float64 {
+	var depth float64
+	node := tree[0]
+	for node.Feature >= 0 {
+		if isolated != nil {
+			isolated[node.Feature] += 1 / (depth + 1)
+		}
+		depth++
+		if x[node.Feature] < node.Split {
+			node = tree[node.Left]
+		} else {
+			node = tree[node.Right]
+		}
+	}
+	return depth + averagePathLength(node.Size)
+}
+
+// Score returns the anomaly score of a feature vector, from 0 to 1
+func (f *IsolationForest) Score(x []float64) float64 {
+	var total float64
+	for _, tree := range f.Trees {
+		total += pathLength(tree, x, nil)
+	}
+	mean := total / float64(len(f.Trees))
+	return math.Pow(2, -mean/averagePathLength(f.SampleSize))
+}
+
+// Explain ranks the features by how much they contributed to isolating x,
+// weighting splits near the root most, and returns the top n
+func (f *IsolationForest) Explain(x []float64, n int) []int {
+	isolated := make([]float64, len(f.Features))
+	for _, tree := range f.Trees {
+		pathLength(tree, x, isolated)
+	}
+	order := make([]int, len(isolated))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return isolated[order[i]] > isolated[order[j]]
+	})
+	if n < len(order) {
+		order = order[:n]
+	}
+	return order
+}
+
+// SaveForest writes the model as JSON, creating the directory if needed
+func SaveForest(path string, forest *IsolationForest) error {
+	return writeModel(path, forest)
+}
+
+// LoadForest reads a model written by SaveForest and checks that it was
+// trained on the current features
+func LoadForest(path string) (*IsolationForest, error) {
+	var forest IsolationForest
+	if err := readModel(path, &forest); err != nil {
+		return nil, err
+	}
+	if len(forest.Trees) == 0 || !equalStrings(forest.Features, Features) {
+		return nil, fmt.Errorf("model %s was trained on different features; retrain it", path)
+	}
+	return &forest, nil
+}
+
+// writeModel writes a model as JSON, creating the directory if needed
+func writeModel(path string, model interface{}) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create model directory: %v", err)
+	}
+	data, err := json.Marshal(model)
+	if err != nil {
+		return fmt.Errorf("failed to encode model: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write model: %v", err)
+	}
+	return nil
+}
+
+func readModel(path string, model interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read model: %v", err)
+	}
+	if err := json.Unmarshal(data, model); err != nil {
+		return fmt.Errorf("invalid model %s: %v", path, err)
+	}
+	return nil
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,394 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultFraudModelPath is where the tools keep the current fraud model.
+// Every trained model is also kept next to it under its version.
+const DefaultFraudModelPath = "models/fraud_model.json"
+
+// FraudModelConfig controls fraud model training
+type FraudModelConfig struct {
+	Iterations   int
+	LearningRate float64
+	L2           float64
+
+	// Holdout is the share of the most recent transactions kept out of
+	// training. The model is calibrated and evaluated on them.
+	Holdout float64
+}
+
+// DefaultFraudModelConfig returns the usual fraud model settings
+func DefaultFraudModelConfig() FraudModelConfig {
+	return FraudModelConfig{Iterations: 1000, LearningRate: 0.5, L2: 0.01, Holdout: 0.2}
+}
+
+// FraudMetrics measure the calibrated model on the holdout transactions
+type FraudMetrics struct {
+	AUC     float64 `json:"auc"`
+	LogLoss float64 `json:"log_loss"`
+	Brier   float64 `json:"brier"`
+}
+
+// FraudModel is a trained, serialisable logistic regression over
+// standardised transaction features. Training weighs frauds and legitimate
+// transactions equally, which skews the raw output, so the probability is
+// Platt-calibrated on the holdout: p = 1 / (1 + exp(-(A*z + B))) where z is
+// the regression's log-odds.
+type FraudModel struct {
+	Version        string       `json:"version"`
+	Features       []string     `json:"features"`
+	Means          []float64    `json:"means"`
+	Scales         []float64    `json:"scales"`
+	Weights        []float64    `json:"weights"`
+	Intercept      float64      `json:"intercept"`
+	CalibrationA   float64      `json:"calibration_a"`
+	CalibrationB   float64      `json:"calibration_b"`
+	Iterations     int          `json:"iterations"`
+	LearningRate   float64      `json:"learning_rate"`
+	L2             float64      `json:"l2"`
+	TrainingRows   int          `json:"training_rows"`
+	TrainingFrauds int          `json:"training_frauds"`
+	HoldoutRows    int          `json:"holdout_rows"`
+	HoldoutFrauds  int          `json:"holdout_frauds"`
+	Metrics        FraudMetrics `json:"metrics"`
+	TrainedThrough string       `json:"trained_through"`
+	TrainedAt      time.Time    `json:"trained_at"`
+}
+
+// TrainFraudModel fits the model to the vectors, which must be in time
+// order. Full-batch gradient descent needs no random seed, so the same
+// vectors and config always give the same model.
+func TrainFraudModel(vectors []TransactionVector, config FraudModelConfig, now time.Time) (*FraudModel, error) {
+	if config.Iterations < 1 || config.LearningRate <= 0 || config.L2 < 0 || config.Holdout <= 0 || config.Holdout >= 1 {
+		return nil, fmt.Errorf("iterations and learning rate must be positive, l2 non-negative and holdout between 0 and 1")
+	}
+	split := len(vectors) - int(math.Round(float64(len(vectors))*config.Holdout))
+	training, holdout := vectors[:split], vectors[split:]
+	trainingFrauds, holdoutFrauds := countFrauds(training), countFrauds(holdout)
+	if trainingFrauds == 0 || trainingFrauds == len(training) {
+		return nil, fmt.Errorf("training transactions need both fraud and legitimate labels (%d of %d are fraud)", trainingFrauds, len(training))
+	}
+	if holdoutFrauds == 0 || holdoutFrauds == len(holdout) {
+		return nil, fmt.Errorf("holdout transactions need both fraud and legitimate labels (%d of %d are fraud); change -holdout", holdoutFrauds, len(holdout))
+	}
+
+	m := &FraudModel{
+		Features:       FraudFeatures,
+		Iterations:     config.Iterations,
+		LearningRate:   config.LearningRate,
+		L2:             config.L2,
+		TrainingRows:   len(training),
+		TrainingFrauds: trainingFrauds,
+		HoldoutRows:    len(holdout),
+		HoldoutFrauds:  holdoutFrauds,
+		TrainedThrough: training[len(training)-1].Time.Format(time.RFC3339),
+		TrainedAt:      now,
+	}
+	m.standardise(training)
+	m.fit(training, config)
+
+	logOdds := make([]float64, len(holdout))
+	labels := make([]bool, len(holdout))
+	for i, v := range holdout {
+		logOdds[i] = m.logOdds(v.Values)
+		labels[i] = v.IsFraud
+	}
+	m.CalibrationA, m.CalibrationB = plattScale(logOdds, labels)
+	m.Metrics = m.evaluate(holdout)
+
+	params, _ := json.Marshal([]interface{}{m.Means, m.Scales, m.Weights, m.Intercept, m.CalibrationA, m.CalibrationB})
+	sum := sha256.Sum256(params)
+	m.Version = "logit-" + hex.EncodeToString(sum[:])[:12]
+	return m, nil
+}
+
+func countFrauds(vectors []TransactionVector) int {
+	var frauds int
+	for _, v := range vectors {
+		if v.IsFraud {
+			frauds++
+		}
+	}
+	return frauds
+}
+
+// standardise records each feature's training mean and standard deviation;
+// constant features get scale 1
+func (m *FraudModel) standardise(vectors []TransactionVector) {
+	n := float64(len(vectors))
+	m.Means = make([]float64, len(m.Features))
+	m.Scales = make([]float64, len(m.Features))
+	for _, v := range vectors {
+		for j, x := range v.Values {
+			m.Means[j] += x / n
+		}
+	}
+	for _, v := range vectors {
+		for j, x := range v.Values {
+			m.Scales[j] += (x - m.Means[j]) * (x - m.Means[j]) / n
+		}
+	}
+	for j := range m.Scales {
+		m.Scales[j] = math.Sqrt(m.Scales[j])
+		if m.Scales[j] == 0 {
+			m.Scales[j] = 1
+		}
+	}
+}
+
+// fit runs gradient descent on the class-balanced, L2-regularised log loss
+func (m *FraudModel) fit(vectors []TransactionVector, config FraudModelConfig) {
+	frauds := countFrauds(vectors)
+	fraudWeight := float64(len(vectors)) / (2 * float64(frauds))
+	legitimateWeight := float64(len(vectors)) / (2 * float64(len(vectors)-frauds))
+
+	rows := make([][]float64, len(vectors))
+	for i, v := range vectors {
+		rows[i] = m.scaled(v.Values)
+	}
+	n := float64(len(vectors))
+	m.Weights = make([]float64, len(m.Features))
+	gradient := make([]float64, len(m.Features))
+	for iteration := 0; iteration < config.Iterations; iteration++ {
+		for j := range gradient {
+			gradient[j] = config.L2 * m.Weights[j]
+		}
+		var interceptGradient float64
+		for i, x := range rows {
+			weight, label := legitimateWeight, 0.0
+			if vectors[i].IsFraud {
+				weight, label = fraudWeight, 1
+			}
+			residual := weight * (sigmoid(m.linear(x)) - label) / n
+			for j, value := range x {
+				gradient[j] += residual * value
+			}
+			interceptGradient += residual
+		}
+		for j := range m.Weights {
+			m.Weights[j] -= config.LearningRate * gradient[j]
+		}
+		m.Intercept -= config.LearningRate * interceptGradient
+	}
+}
+
+func (m *FraudModel) scaled(values []float64) []float64 {
+	x := make([]float64, len(values))
+	for j, value := range values {
+		x[j] = (value - m.Means[j]) / m.Scales[j]
+	}
+	return x
+}
+
+func (m *FraudModel) linear(x []float64) float64 {
+	z := m.Intercept
+	for j, value := range x {
+		z += m.Weights[j] * value
+	}
+	return z
+}
+
+// logOdds is the uncalibrated regression output for raw feature values
+func (m *FraudModel) logOdds(values []float64) float64 {
+	return m.linear(m.scaled(values))
+}
+
+// Probability returns the calibrated fraud probability of a transaction
+func (m *FraudModel) Probability(values []float64) float64 {
+	return sigmoid(m.CalibrationA*m.logOdds(values) + m.CalibrationB)
+}
+
+// Factors returns up to n features that raise the transaction's calibrated
+// probability the most, largest first
+func (m *FraudModel) Factors(values []float64, n int) []string {
+	x := m.scaled(values)
+	contribution := make([]float64, len(x))
+	var order []int
+	for j := range x {
+		contribution[j] = m.CalibrationA * m.Weights[j] * x[j]
+		if contribution[j] > 0 {
+			order = append(order, j)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return contribution[order[a]] > contribution[order[b]]
+	})
+	if n < len(order) {
+		order = order[:n]
+	}
+	factors := make([]string, len(order))
+	for i, j := range order {
+		factors[i] = m.Features[j]
+	}
+	return factors
+}
+
+// FeatureImportance is a feature's share of the model's total absolute
+// standardised weight. Effect is the weight after calibration: positive
+// when higher values raise the fraud probability.
+type FeatureImportance struct {
+	Feature    string
+	Effect     float64
+	Importance float64
+}
+
+// Importance ranks the features by the absolute weight on their
+// standardised values, which makes the weights comparable across features
+func (m *FraudModel) Importance() []FeatureImportance {
+	var total float64
+	for _, w := range m.Weights {
+		total += math.Abs(w)
+	}
+	importance := make([]FeatureImportance, len(m.Features))
+	for j, feature := range m.Features {
+		importance[j] = FeatureImportance{Feature: feature, Effect: m.CalibrationA * m.Weights[j]}
+		if total > 0 {
+			importance[j].Importance = math.Abs(m.Weights[j]) / total
+		}
+	}
+	sort.SliceStable(importance, func(a, b int) bool {
+		return importance[a].Importance > importance[b].Importance
+	})
+	return importance
+}
+
+// evaluate measures the calibrated probabilities against the labels
+func (m *FraudModel) evaluate(vectors []TransactionVector) FraudMetrics {
+	type scored struct {
+		p     float64
+		fraud bool
+	}
+	rows := make([]scored, len(vectors))
+	var metrics FraudMetrics
+	for i, v := range vectors {
+		p := m.Probability(v.Values)
+		rows[i] = scored{p, v.IsFraud}
+		label := 0.0
+		if v.IsFraud {
+			label = 1
+		}
+		clamped := math.Min(math.Max(p, 1e-15), 1-1e-15)
+		metrics.LogLoss -= (label*math.Log(clamped) + (1-label)*math.Log(1-clamped)) / float64(len(vectors))
+		metrics.Brier += (p - label) * (p - label) / float64(len(vectors))
+	}
+
+	// AUC as the share of fraud/legitimate pairs ranked correctly, ties
+	// counting half
+	sort.SliceStable(rows, func(a, b int) bool { return rows[a].p < rows[b].p })
+	var frauds, legitimate, below float64
+	for i := 0; i < len(rows); {
+		k := i
+		var tiedFrauds, tiedLegitimate float64
+		for ; k < len(rows) && rows[k].p == rows[i].p; k++ {
+			if rows[k].fraud {
+				tiedFrauds++
+			} else {
+				tiedLegitimate++
+			}
+		}
+		below += tiedFrauds * (legitimate + tiedLegitimate/2)
+		frauds += tiedFrauds
+		legitimate += tiedLegitimate
+		i = k
+	}
+	if frauds > 0 && legitimate > 0 {
+		metrics.AUC = below / (frauds * legitimate)
+	}
+	return metrics
+}
+
+// plattScale fits p = sigmoid(a*z + b) to the labels by Newton's method,
+// with Platt's smoothed targets so that a handful of labels cannot produce
+// probabilities of exactly 0 or 1
+func plattScale(z []float64, labels []bool) (float64, float64) {
+	var frauds, legitimate float64
+	for _, fraud := range labels {
+		if fraud {
+			frauds++
+		} else {
+			legitimate++
+		}
+	}
+	high := (frauds + 1) / (frauds + 2)
+	low := 1 / (legitimate + 2)
+
+	a, b := 1.0, 0.0
+	for iteration := 0; iteration < 100; iteration++ {
+		var gA, gB, hAA, hAB, hBB float64
+		for i, x := range z {
+			target := low
+			if labels[i] {
+				target = high
+			}
+			p := sigmoid(a*x + b)
+			d := p - target
+			w := p * (1 - p)
+			gA += d * x
+			gB += d
+			hAA += w * x * x
+			hAB += w * x
+			hBB += w
+		}
+		// A small ridge keeps the Hessian invertible when z barely varies
+		hAA += 1e-9
+		hBB += 1e-9
+		det := hAA*hBB - hAB*hAB
+		if det <= 0 {
+			break
+		}
+		stepA := (hBB*gA - hAB*gB) / det
+		stepB := (hAA*gB - hAB*gA) / det
+		a -= stepA
+		b -= stepB
+		if math.Abs(stepA) < 1e-10 && math.Abs(stepB) < 1e-10 {
+			break
+		}
+	}
+	return a, b
+}
+
+func sigmoid(z float64) float64 {
+	return 1 / (1 + math.Exp(-z))
+}
+
+// VersionedPath is where a model version is archived next to path, e.g.
+// models/fraud_model-logit-0123456789ab.json
+func VersionedPath(path, version string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + version + ext
+}
+
+// SaveFraudModel writes the model to path and archives it under its
+// version, returning the archive path
+func SaveFraudModel(path string, m *FraudModel) (string, error) {
+	archive := VersionedPath(path, m.Version)
+	for _, p := range []string{archive, path} {
+		if err := writeModel(p, m); err != nil {
+			return "", err
+		}
+	}
+	return archive, nil
+}
+
+// LoadFraudModel reads a model written by SaveFraudModel and checks that it
+// was trained on the current features
+func LoadFraudModel(path string) (*FraudModel, error) {
+	var m FraudModel
+	if err := readModel(path, &m); err != nil {
+		return nil, err
+	}
+	if len(m.Weights) != len(FraudFeatures) || !equalStrings(m.Features, FraudFeatures) {
+		return nil, fmt.Errorf("model %s was trained on different features; retrain it", path)
+	}
+	return &m, nil
+}
@@ -0,0 +1,139 @@
+package model
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// FraudCategories are the merchant categories of the source data; each gets
+// a 0/1 feature so the fraud model can learn per-category risk
+var FraudCategories = []string{
+	"entertainment",
+	"food_dining",
+	"gas_transport",
+	"grocery_net",
+	"grocery_pos",
+	"health_fitness",
+	"home",
+	"kids_pets",
+	"misc_net",
+	"misc_pos",
+	"personal_care",
+	"shopping_net",
+	"shopping_pos",
+	"travel",
+}
+
+// FraudFeatures are the per-transaction features, in model input order. The
+// incremental processing computes the same features in SQL, so a change here
+// must be made in the fraud scoring section of
+// sql/incremental_aml_processing.sql as well.
+var FraudFeatures = append([]string{
+	"log_amount",
+	"amount_ratio",
+	"night",
+	"online",
+	"distance_km",
+	"age",
+	"log_city_pop",
+	"transactions_24h",
+}, categoryFeatures()...)
+
+func categoryFeatures() []string {
+	features := make([]string, len(FraudCategories))
+	for i, category := range FraudCategories {
+		features[i] = "category_" + category
+	}
+	return features
+}
+
+// TransactionVector is one transaction's features and label
+type TransactionVector struct {
+	TransNum   string
+	PersonKey  string
+	CustomerID string
+	Time       time.Time
+	Values     []float64 // one per FraudFeatures entry
+	IsFraud    bool
+}
+
+// TransactionVectors engineers features for every transaction, which must be
+// in time order. amount_ratio compares the amount with the cardholder's
+// average over earlier transactions (1 without history) and
+// transactions_24h counts their transactions in the 24 hours before, up to
+// the previous second. Vectors keep the order of transactions.
+func TransactionVectors(transactions []aml.Transaction) []TransactionVector {
+	type history struct {
+		count int
+		total float64
+		times []time.Time
+	}
+	people := map[string]*history{}
+	categories := make(map[string]int, len(FraudCategories))
+	for i, category := range FraudCategories {
+		categories[category] = i
+	}
+
+	vectors := make([]TransactionVector, len(transactions))
+	for i, txn := range transactions {
+		key := txn.PersonKey()
+		h, ok := people[key]
+		if !ok {
+			h = &history{}
+			people[key] = h
+		}
+
+		ratio := 1.0
+		if h.count > 0 && h.total > 0 {
+			ratio = txn.Amount / (h.total / float64(h.count))
+		}
+		from := txn.TransDateTransTime.Add(-24 * time.Hour)
+		to := txn.TransDateTransTime.Add(-time.Second)
+		first := sort.Search(len(h.times), func(k int) bool { return !h.times[k].Before(from) })
+		last := sort.Search(len(h.times), func(k int) bool { return h.times[k].After(to) })
+		var age float64
+		if dob, err := time.Parse(aml.DateLayout, txn.DOB); err == nil {
+			date, _ := time.Parse(aml.DateLayout, txn.Date())
+			age = date.Sub(dob).Hours() / 24 / 365.25
+		}
+		var night, online float64
+		if hour := txn.TransDateTransTime.Hour(); hour >= 22 || hour < 6 {
+			night = 1
+		}
+		if strings.HasSuffix(txn.Category, "_net") {
+			online = 1
+		}
+
+		values := make([]float64, len(FraudFeatures))
+		copy(values, []float64{
+			math.Log1p(txn.Amount),
+			ratio,
+			night,
+			online,
+			txn.HomeDistance(),
+			age,
+			math.Log1p(float64(txn.CityPop)),
+			float64(last - first),
+		})
+		if k, ok := categories[txn.Category]; ok {
+			values[len(FraudFeatures)-len(FraudCategories)+k] = 1
+		}
+
+		vectors[i] = TransactionVector{
+			TransNum:   txn.TransNum,
+			PersonKey:  key,
+			CustomerID: txn.CustomerID(),
+			Time:       txn.TransDateTransTime,
+			Values:     values,
+			IsFraud:    txn.IsFraud,
+		}
+		h.count++
+		h.total += txn.Amount
+		h.times = append(h.times, txn.TransDateTransTime)
+	}
+	return vectors
+}
@@ -0,0 +1,247 @@
+package rules
+
+import (
+	"cmp"
+	"math"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// Name implements detect.Detector
+func (c *Compiled) Name() string {
+	return c.Rule.Name
+}
+
+// Lookback implements detect.Detector. Calendar day windows are evaluated
+// on their own.
+func (c *Compiled) Lookback() time.Duration {
+	return c.window
+}
+
+// Detect implements detect.Detector. Every filtered transaction ending
+// after since closes a window over the transactions of its group from the
+// window before it up to and including its timestamp. Windows meeting the
+// conditions are scored and, as in the SQL, only the highest scoring window
+// of each group and day is reported, the earliest on a tie.
+func (c *Compiled) Detect(transactions []aml.Transaction, since time.Time) []aml.Alert {
+	index := map[string]int{}
+	var groups [][]aml.Transaction
+	for _, txn := range transactions {
+		if !c.keep(txn) {
+			continue
+		}
+		key := c.groupKey(txn)
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], txn)
+	}
+
+	var alerts []aml.Alert
+	for _, txns := range groups {
+		best := map[string]aml.Alert{}
+		var days []string
+		start := 0
+		for end := 0; end < len(txns); end++ {
+			endTime := txns[end].TransDateTransTime
+			// Transactions sharing the end timestamp fall in the same window
+			last := end
+			for last+1 < len(txns) && txns[last+1].TransDateTransTime.Equal(endTime) {
+				last++
+			}
+			if !endTime.After(since) {
+				continue
+			}
+			for start < end && !c.inWindow(txns[start].TransDateTransTime, endTime) {
+				start++
+			}
+
+			window := txns[start : last+1]
+			values := c.aggregate(window)
+			if !c.matches(values) {
+				continue
+			}
+			alert := c.alert(txns[end], window, values)
+			if current, ok := best[alert.AlertDate]; !ok {
+				days = append(days, alert.AlertDate)
+				best[alert.AlertDate] = alert
+			} else if alert.RiskScore > current.RiskScore {
+				best[alert.AlertDate] = alert
+			}
+		}
+		for _, day := range days {
+			alerts = append(alerts, best[day])
+		}
+	}
+	return alerts
+}
+
+func (c *Compiled) keep(txn aml.Transaction) bool {
+	for _, p := range c.filter {
+		if !p.holds(txn) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p predicate) holds(txn aml.Transaction) bool {
+	in := false
+	if p.field.numeric() {
+		v := p.field.number(txn)
+		if p.Op != "in" && p.Op != "not_in" {
+			return compare(v, p.Op, p.numbers[0])
+		}
+		for _, n := range p.numbers {
+			in = in || v == n
+		}
+	} else {
+		v := p.field.text(txn)
+		if p.Op != "in" && p.Op != "not_in" {
+			return compare(v, p.Op, p.texts[0])
+		}
+		for _, s := range p.texts {
+			in = in || v == s
+		}
+	}
+	return in == (p.Op == "in")
+}
+
+func compare[T cmp.Ordered](a T, op string, b T) bool {
+	switch op {
+	case "=":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	default:
+		return a >= b
+	}
+}
+
+func (c *Compiled) groupKey(txn aml.Transaction) string {
+	parts := make([]string, len(c.keys))
+	for i, key := range c.keys {
+		parts[i] = key.value(txn)
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// inWindow reports whether t is within the window ending at end
+func (c *Compiled) inWindow(t, end time.Time) bool {
+	if c.window == 0 {
+		return t.Format(aml.DateLayout) == end.Format(aml.DateLayout)
+	}
+	return t.After(end.Add(-c.window))
+}
+
+func (c *Compiled) aggregate(window []aml.Transaction) map[string]float64 {
+	values := make(map[string]float64, len(c.Rule.Aggregations))
+	for _, a := range c.Rule.Aggregations {
+		f := fields[a.Field]
+		switch a.Function {
+		case "count":
+			values[a.Name] = float64(len(window))
+		case "count_distinct":
+			distinct := map[string]bool{}
+			for _, txn := range window {
+				distinct[f.value(txn)] = true
+			}
+			values[a.Name] = float64(len(distinct))
+		default:
+			result := f.number(window[0])
+			for _, txn := range window[1:] {
+				v := f.number(txn)
+				switch a.Function {
+				case "sum", "avg":
+					result += v
+				case "min":
+					result = math.Min(result, v)
+				case "max":
+					result = math.Max(result, v)
+				}
+			}
+			if a.Function == "avg" {
+				result /= float64(len(window))
+			}
+			values[a.Name] = result
+		}
+	}
+	return values
+}
+
+func (c *Compiled) matches(values map[string]float64) bool {
+	for _, condition := range c.Rule.Conditions {
+		if !compare(values[condition.Aggregation], condition.Op, condition.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Score evaluates the rule's score formula, rounded half away from zero
+// like BigQuery's ROUND and clamped to 0-100
+func (c *Compiled) Score(values map[string]float64) int64 {
+	score := math.Round(c.score.eval(values))
+	switch {
+	case math.IsNaN(score) || score < 0:
+		return 0
+	case score > 100:
+		return 100
+	}
+	return int64(score)
+}
+
+// Priority bands a score with the rule's bands
+func (c *Compiled) Priority(score int64) string {
+	switch {
+	case score >= c.Rule.Priority.High:
+		return aml.PriorityHigh
+	case score >= c.Rule.Priority.Medium:
+		return aml.PriorityMedium
+	default:
+		return aml.PriorityLow
+	}
+}
+
+// Describe fills the description placeholders the way FORMAT('%\'.0f') does
+// in the SQL
+func (c *Compiled) Describe(values map[string]float64) string {
+	return placeholderPattern.ReplaceAllStringFunc(c.Rule.Description, func(placeholder string) string {
+		return aml.FormatAmount(values[placeholder[1:len(placeholder)-1]])
+	})
+}
+
+func (c *Compiled) alert(end aml.Transaction, window []aml.Transaction, values map[string]float64) aml.Alert {
+	score := c.Score(values)
+	subject := end.CustomerID()
+	if c.Rule.Subject == SubjectMerchant {
+		subject = end.Merchant
+	}
+	var total float64
+	nums := make([]string, len(window))
+	for i, txn := range window {
+		total += txn.Amount
+		nums[i] = txn.TransNum
+	}
+	return aml.Alert{
+		CustomerID:  subject,
+		AlertDate:   end.Date(),
+		AlertType:   c.Rule.Name,
+		RiskScore:   score,
+		Description: c.Describe(values),
+		Priority:    c.Priority(score),
+		TotalAmount: total,
+		TransNums:   nums,
+	}
+}
@@ -0,0 +1,252 @@
+package rules
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expr is a parsed score formula over aggregation names
+type expr interface {
+	eval(values map[string]float64) float64
+	sql() string
+}
+
+type number float64
+
+func (n number) eval(map[string]float64) float64 { return float64(n) }
+func (n number) sql() string                     { return strconv.FormatFloat(float64(n), 'f', -1, 64) }
+
+type name string
+
+func (n name) eval(values map[string]float64) float64 { return values[string(n)] }
+func (n name) sql() string                            { return string(n) }
+
+type negate struct{ operand expr }
+
+func (n negate) eval(values map[string]float64) float64 { return -n.operand.eval(values) }
+
+// sql parenthesises the operand: a negated negation would otherwise render
+// as --x, which BigQuery reads as a comment
+func (n negate) sql() string { return "-(" + n.operand.sql() + ")" }
+
+type binary struct {
+	op          byte
+	left, right expr
+}
+
+// eval divides like SAFE_DIVIDE in the compiled SQL: by zero gives 0
+func (b binary) eval(values map[string]float64) float64 {
+	l, r := b.left.eval(values), b.right.eval(values)
+	switch b.op {
+	case '+':
+		return l + r
+	case '-':
+		return l - r
+	case '*':
+		return l * r
+	default:
+		if r == 0 {
+			return 0
+		}
+		return l / r
+	}
+}
+
+func (b binary) sql() string {
+	if b.op == '/' {
+		return fmt.Sprintf("IFNULL(SAFE_DIVIDE(%s, %s), 0)", b.left.sql(), b.right.sql())
+	}
+	return fmt.Sprintf("(%s %c %s)", b.left.sql(), b.op, b.right.sql())
+}
+
+type call struct {
+	function string
+	args     []expr
+}
+
+func (c call) eval(values map[string]float64) float64 {
+	result := c.args[0].eval(values)
+	for _, arg := range c.args[1:] {
+		v := arg.eval(values)
+		if (c.function == "min" && v < result) || (c.function == "max" && v > result) {
+			result = v
+		}
+	}
+	return result
+}
+
+func (c call) sql() string {
+	args := make([]string, len(c.args))
+	for i, arg := range c.args {
+		args[i] = arg.sql()
+	}
+	function := "LEAST"
+	if c.function == "max" {
+		function = "GREATEST"
+	}
+	return fmt.Sprintf("%s(%s)", function, strings.Join(args, ", "))
+}
+
+// parser reads formulas of numbers, aggregation names, + - * /, parentheses
+// and min(...) / max(...)
+type parser struct {
+	tokens []string
+	pos    int
+	names  map[string]bool
+}
+
+func parseExpr(source string, names map[string]bool) (expr, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens, names: names}
+	e, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in %q", p.tokens[p.pos], source)
+	}
+	return e, nil
+}
+
+func tokenize(source string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case strings.ContainsRune("+-*/(),", c):
+			tokens = append(tokens, string(c))
+			i++
+		case unicode.IsDigit(c) || c == '.':
+			j := i
+			for j < len(source) && (unicode.IsDigit(rune(source[j])) || source[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, source[i:j])
+			i = j
+		case unicode.IsLetter(c) || c == '_':
+			j := i
+			for j < len(source) && (unicode.IsLetter(rune(source[j])) || unicode.IsDigit(rune(source[j])) || source[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, source[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q in %q", c, source)
+		}
+	}
+	return tokens, nil
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) sum() (expr, error) {
+	left, err := p.product()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "+" || p.peek() == "-" {
+		op := p.tokens[p.pos][0]
+		p.pos++
+		right, err := p.product()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op, left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) product() (expr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "*" || p.peek() == "/" {
+		op := p.tokens[p.pos][0]
+		p.pos++
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		left = binary{op, left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) unary() (expr, error) {
+	if p.peek() == "-" {
+		p.pos++
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return negate{operand}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (expr, error) {
+	token := p.peek()
+	p.pos++
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("formula ends early")
+	case token == "(":
+		e, err := p.sum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return e, nil
+	case unicode.IsDigit(rune(token[0])) || token[0] == '.':
+		v, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", token)
+		}
+		return number(v), nil
+	case token == "min" || token == "max":
+		if p.peek() != "(" {
+			return nil, fmt.Errorf("%s needs arguments", token)
+		}
+		p.pos++
+		c := call{function: token}
+		for {
+			arg, err := p.sum()
+			if err != nil {
+				return nil, err
+			}
+			c.args = append(c.args, arg)
+			if p.peek() == "," {
+				p.pos++
+				continue
+			}
+			if p.peek() != ")" {
+				return nil, fmt.Errorf("missing ) after %s arguments", token)
+			}
+			p.pos++
+			return c, nil
+		}
+	case unicode.IsLetter(rune(token[0])) || token[0] == '_':
+		if !p.names[token] {
+			return nil, fmt.Errorf("unknown aggregation %q", token)
+		}
+		return name(token), nil
+	default:
+		return nil, fmt.Errorf("unexpected %q", token)
+	}
+}
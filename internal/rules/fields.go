@@ -0,0 +1,60 @@
+package rules
+
+import (
+	"strconv"
+
+	"aml-system/internal/aml"
+)
+
+// field is a transaction attribute a rule can filter, group or aggregate
+// on, with its SQL expression over the transactions table aliased t
+type field struct {
+	sql    string
+	number func(aml.Transaction) float64
+	text   func(aml.Transaction) string
+}
+
+func (f field) numeric() bool {
+	return f.number != nil
+}
+
+// value renders the field for grouping and distinct counts
+func (f field) value(txn aml.Transaction) string {
+	if f.numeric() {
+		return strconv.FormatFloat(f.number(txn), 'f', -1, 64)
+	}
+	return f.text(txn)
+}
+
+// fields can be used in filters and aggregations
+var fields = map[string]field{
+	"amt":      {sql: "t.amt", number: func(t aml.Transaction) float64 { return t.Amount }},
+	"city_pop": {sql: "t.city_pop", number: func(t aml.Transaction) float64 { return float64(t.CityPop) }},
+	"hour": {sql: "EXTRACT(HOUR FROM t.trans_date_trans_time)",
+		number: func(t aml.Transaction) float64 { return float64(t.TransDateTransTime.Hour()) }},
+	"distance_km": {sql: "ST_DISTANCE(ST_GEOGPOINT(t.long, t.lat), ST_GEOGPOINT(t.merch_long, t.merch_lat)) / 1000",
+		number: func(t aml.Transaction) float64 { return t.HomeDistance() }},
+	"customer": {sql: "CONCAT(t.first, '_', t.last)", text: aml.Transaction.CustomerID},
+	"cc_num": {sql: "CAST(t.cc_num AS STRING)",
+		text: func(t aml.Transaction) string { return strconv.FormatInt(t.CCNum, 10) }},
+	"merchant": {sql: "t.merchant", text: func(t aml.Transaction) string { return t.Merchant }},
+	"category": {sql: "t.category", text: func(t aml.Transaction) string { return t.Category }},
+	"state":    {sql: "t.state", text: func(t aml.Transaction) string { return t.State }},
+	"city":     {sql: "t.city", text: func(t aml.Transaction) string { return t.City }},
+	"zip":      {sql: "CAST(t.zip AS STRING)", text: func(t aml.Transaction) string { return t.Zip }},
+	"job":      {sql: "t.job", text: func(t aml.Transaction) string { return t.Job }},
+	"gender":   {sql: "t.gender", text: func(t aml.Transaction) string { return t.Gender }},
+}
+
+// keys can be used in group_by. person separates namesakes by date of birth
+// the way the built-in detectors do; customer is the customer_id.
+var keys = map[string]field{
+	"person":   {sql: "CONCAT(t.first, '|', t.last, '|', CAST(t.dob AS STRING))", text: aml.Transaction.PersonKey},
+	"customer": fields["customer"],
+	"card":     fields["cc_num"],
+	"merchant": fields["merchant"],
+	"category": fields["category"],
+	"state":    fields["state"],
+	"city":     fields["city"],
+	"zip":      fields["zip"],
+}
@@ -0,0 +1,438 @@
+// Package rules implements analyst-authored detection scenarios. A rule is a
+// YAML file describing a filter, grouping keys, a window, aggregations,
+// conditions, a score formula and priority bands; it compiles both to a
+// detect.Detector for the Go engine and to a BigQuery INSERT statement, so a
+// new scenario needs a reviewed rule file rather than a code change.
+package rules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"aml-system/internal/aml"
+)
+
+// DefaultDir holds the rule files loaded by the detection engine
+const DefaultDir = "config/rules"
+
+// Rule is one scenario as written in its YAML file
+type Rule struct {
+	// Name is the alert type the rule raises, e.g. RAPID_ONLINE_SPEND
+	Name string `yaml:"name" json:"name"`
+	// Description is the alert description; {aggregation} placeholders are
+	// replaced with the aggregated values
+	Description string `yaml:"description" json:"description"`
+	// Filter selects the transactions the rule looks at; all must hold
+	Filter []Predicate `yaml:"filter" json:"filter"`
+	// GroupBy are the keys activity is aggregated over: person, customer,
+	// card, merchant, category, state, city or zip
+	GroupBy []string `yaml:"group_by" json:"group_by"`
+	// Window is the trailing period ending at each transaction, e.g. 60m,
+	// 24h or 7d, or "day" for the calendar day so far
+	Window string `yaml:"window" json:"window"`
+	// Aggregations are computed over each window
+	Aggregations []Aggregation `yaml:"aggregations" json:"aggregations"`
+	// Conditions on the aggregations that must all hold to alert
+	Conditions []Condition `yaml:"conditions" json:"conditions"`
+	// Score is a formula over aggregation names, clamped to 0-100
+	Score string `yaml:"score" json:"score"`
+	// Priority bands the score; defaults to the engine's 80 / 50
+	Priority Priority `yaml:"priority" json:"priority"`
+	// Subject is whose ID the alert is raised against: customer (default)
+	// or merchant
+	Subject string `yaml:"subject" json:"subject"`
+}
+
+// Predicate compares a transaction field with a value or list of values
+type Predicate struct {
+	Field  string        `yaml:"field" json:"field"`
+	Op     string        `yaml:"op" json:"op"`
+	Value  interface{}   `yaml:"value" json:"value,omitempty"`
+	Values []interface{} `yaml:"values" json:"values,omitempty"`
+}
+
+// Aggregation is a named aggregate over the transactions of a window
+type Aggregation struct {
+	Name     string `yaml:"name" json:"name"`
+	Function string `yaml:"function" json:"function"`
+	Field    string `yaml:"field" json:"field,omitempty"`
+}
+
+// Condition compares an aggregation with a number
+type Condition struct {
+	Aggregation string  `yaml:"aggregation" json:"aggregation"`
+	Op          string  `yaml:"op" json:"op"`
+	Value       float64 `yaml:"value" json:"value"`
+}
+
+// Priority holds the minimum scores of the HIGH and MEDIUM bands
+type Priority struct {
+	High   int64 `yaml:"high" json:"high"`
+	Medium int64 `yaml:"medium" json:"medium"`
+}
+
+// Subjects of an alert
+const (
+	SubjectCustomer = "customer"
+	SubjectMerchant = "merchant"
+)
+
+// Aggregation functions
+var functions = map[string]string{
+	"count":          "COUNT",
+	"count_distinct": "COUNT",
+	"sum":            "SUM",
+	"avg":            "AVG",
+	"min":            "MIN",
+	"max":            "MAX",
+}
+
+// comparisons are the operators of predicates and conditions besides in /
+// not_in, with their SQL spelling
+var comparisons = map[string]string{
+	"=":  "=",
+	"!=": "!=",
+	"<":  "<",
+	"<=": "<=",
+	">":  ">",
+	">=": ">=",
+}
+
+// reserved are the column names of the compiled SQL and the formula functions
+var reserved = map[string]bool{
+	"end_trans_num": true,
+	"window_end":    true,
+	"customer_id":   true,
+	"merchant_name": true,
+	"window_amount": true,
+	"risk_score":    true,
+	"min":           true,
+	"max":           true,
+}
+
+var namePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+var aggregationPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+var placeholderPattern = regexp.MustCompile(`\{([a-z][a-z0-9_]*)\}`)
+
+// Compiled is a validated rule ready to run or to render as SQL
+type Compiled struct {
+	Rule Rule
+	// Path is the file the rule was loaded from
+	Path string
+
+	window time.Duration // 0 for the calendar day
+	filter []predicate
+	keys   []field
+	score  expr
+}
+
+type predicate struct {
+	Predicate
+	field   field
+	numbers []float64
+	texts   []string
+}
+
+// Load reads and compiles one rule file
+func Load(path string) (*Compiled, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rule file %s: %v", path, err)
+	}
+	return Parse(path, data)
+}
+
+// Parse compiles the YAML of a rule file; path names it in errors
+func Parse(path string, data []byte) (*Compiled, error) {
+	var rule Rule
+	if err := yaml.Unmarshal(data, &rule); err != nil {
+		return nil, fmt.Errorf("failed to parse rule file %s: %v", path, err)
+	}
+	compiled, err := Compile(rule)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rule file %s: %v", path, err)
+	}
+	compiled.Path = path
+	return compiled, nil
+}
+
+// Files returns the .yaml / .yml files of dir in name order. A missing dir
+// has none.
+func Files(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules directory %s: %v", dir, err)
+	}
+	var paths []string
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if !entry.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// LoadDir compiles every rule file of dir in name order
+func LoadDir(dir string) ([]*Compiled, error) {
+	paths, err := Files(dir)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*Compiled
+	for _, path := range paths {
+		rule, err := Load(path)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	if err := CheckNames(rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// CheckNames rejects rules sharing a name or named after a built-in alert
+// type
+func CheckNames(rules []*Compiled) error {
+	seen := map[string]string{}
+	for _, alertType := range builtinAlertTypes {
+		seen[alertType] = "a built-in detector"
+	}
+	for _, rule := range rules {
+		if other, ok := seen[rule.Rule.Name]; ok {
+			return fmt.Errorf("rule %s in %s is already defined by %s", rule.Rule.Name, rule.Path, other)
+		}
+		seen[rule.Rule.Name] = rule.Path
+	}
+	return nil
+}
+
+var builtinAlertTypes = []string{
+	aml.AlertVelocity, aml.AlertStructuring, aml.AlertGeographic, aml.AlertSanctionsHit,
+	aml.AlertWatchlist, aml.AlertCardTesting, aml.AlertDormancy, aml.AlertHighRiskMerchant,
+	aml.AlertMerchantAnomaly, aml.AlertBehaviourDeviation, aml.AlertPeerOutlier,
+	aml.AlertGraphCluster, aml.AlertMLAnomaly,
+}
+
+// Compile validates a rule
+func Compile(rule Rule) (*Compiled, error) {
+	c := &Compiled{Rule: rule}
+	if !namePattern.MatchString(rule.Name) {
+		return nil, fmt.Errorf("name %q must be upper case letters, digits and underscores", rule.Name)
+	}
+	if strings.TrimSpace(rule.Description) == "" {
+		return nil, fmt.Errorf("description is required")
+	}
+
+	switch rule.Subject {
+	case "":
+		c.Rule.Subject = SubjectCustomer
+	case SubjectCustomer, SubjectMerchant:
+	default:
+		return nil, fmt.Errorf("subject must be %s or %s", SubjectCustomer, SubjectMerchant)
+	}
+	if rule.Priority == (Priority{}) {
+		c.Rule.Priority = Priority{High: 80, Medium: 50}
+	}
+	if p := c.Rule.Priority; p.Medium < 0 || p.High < p.Medium || p.High > 100 {
+		return nil, fmt.Errorf("priority bands must satisfy 0 <= medium <= high <= 100")
+	}
+
+	window, err := parseWindow(rule.Window)
+	if err != nil {
+		return nil, err
+	}
+	c.window = window
+
+	for _, p := range rule.Filter {
+		compiled, err := compilePredicate(p)
+		if err != nil {
+			return nil, err
+		}
+		c.filter = append(c.filter, compiled)
+	}
+
+	if len(rule.GroupBy) == 0 {
+		return nil, fmt.Errorf("group_by needs at least one key")
+	}
+	for _, key := range rule.GroupBy {
+		f, ok := keys[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown group_by key %q (one of %s)", key, strings.Join(sortedNames(keys), ", "))
+		}
+		c.keys = append(c.keys, f)
+	}
+
+	if len(rule.Aggregations) == 0 {
+		return nil, fmt.Errorf("at least one aggregation is required")
+	}
+	names := map[string]bool{}
+	for _, a := range rule.Aggregations {
+		if !aggregationPattern.MatchString(a.Name) {
+			return nil, fmt.Errorf("aggregation name %q must be lower case letters, digits and underscores", a.Name)
+		}
+		if names[a.Name] {
+			return nil, fmt.Errorf("aggregation %s is defined twice", a.Name)
+		}
+		if reserved[a.Name] || strings.HasPrefix(a.Name, "key_") || strings.HasPrefix(a.Name, "field_") {
+			return nil, fmt.Errorf("aggregation name %s is reserved", a.Name)
+		}
+		if _, ok := functions[a.Function]; !ok {
+			return nil, fmt.Errorf("aggregation %s: unknown function %q (one of %s)", a.Name, a.Function, strings.Join(sortedNames(functions), ", "))
+		}
+		switch {
+		case a.Function == "count":
+			if a.Field != "" {
+				return nil, fmt.Errorf("aggregation %s: count takes no field (use count_distinct)", a.Name)
+			}
+		case a.Function == "count_distinct":
+			if _, ok := fields[a.Field]; !ok {
+				return nil, fmt.Errorf("aggregation %s: unknown field %q", a.Name, a.Field)
+			}
+		default:
+			if f, ok := fields[a.Field]; !ok || !f.numeric() {
+				return nil, fmt.Errorf("aggregation %s: %s needs a numeric field (one of %s)", a.Name, a.Function, strings.Join(numericFields(), ", "))
+			}
+		}
+		names[a.Name] = true
+	}
+
+	if len(rule.Conditions) == 0 {
+		return nil, fmt.Errorf("at least one condition is required")
+	}
+	for _, condition := range rule.Conditions {
+		if !names[condition.Aggregation] {
+			return nil, fmt.Errorf("condition on unknown aggregation %q", condition.Aggregation)
+		}
+		if _, ok := comparisons[condition.Op]; !ok {
+			return nil, fmt.Errorf("condition on %s: unknown operator %q", condition.Aggregation, condition.Op)
+		}
+	}
+
+	if strings.TrimSpace(rule.Score) == "" {
+		return nil, fmt.Errorf("score formula is required")
+	}
+	if c.score, err = parseExpr(rule.Score, names); err != nil {
+		return nil, fmt.Errorf("score: %v", err)
+	}
+	for _, match := range placeholderPattern.FindAllStringSubmatch(rule.Description, -1) {
+		if !names[match[1]] {
+			return nil, fmt.Errorf("description refers to unknown aggregation {%s}", match[1])
+		}
+	}
+	return c, nil
+}
+
+// parseWindow accepts "day" or a duration with an m, h or d unit
+func parseWindow(window string) (time.Duration, error) {
+	if window == "day" {
+		return 0, nil
+	}
+	if len(window) < 2 {
+		return 0, fmt.Errorf("window must be \"day\" or a duration such as 60m, 24h or 7d")
+	}
+	n, err := strconv.Atoi(window[:len(window)-1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid window %q", window)
+	}
+	switch window[len(window)-1] {
+	case 'm':
+		return time.Duration(n) * time.Minute, nil
+	case 'h':
+		return time.Duration(n) * time.Hour, nil
+	case 'd':
+		return time.Duration(n) * 24 * time.Hour, nil
+	default:
+		return 0, fmt.Errorf("window %q must end in m, h or d", window)
+	}
+}
+
+func compilePredicate(p Predicate) (predicate, error) {
+	f, ok := fields[p.Field]
+	if !ok {
+		return predicate{}, fmt.Errorf("filter on unknown field %q (one of %s)", p.Field, strings.Join(sortedNames(fields), ", "))
+	}
+	compiled := predicate{Predicate: p, field: f}
+
+	var values []interface{}
+	switch p.Op {
+	case "in", "not_in":
+		if len(p.Values) == 0 {
+			return predicate{}, fmt.Errorf("filter on %s: %s needs values", p.Field, p.Op)
+		}
+		values = p.Values
+	default:
+		if _, ok := comparisons[p.Op]; !ok {
+			return predicate{}, fmt.Errorf("filter on %s: unknown operator %q", p.Field, p.Op)
+		}
+		if p.Value == nil {
+			return predicate{}, fmt.Errorf("filter on %s: %s needs a value", p.Field, p.Op)
+		}
+		values = []interface{}{p.Value}
+	}
+
+	for _, value := range values {
+		if f.numeric() {
+			n, err := toNumber(value)
+			if err != nil {
+				return predicate{}, fmt.Errorf("filter on %s: %v", p.Field, err)
+			}
+			compiled.numbers = append(compiled.numbers, n)
+		} else {
+			compiled.texts = append(compiled.texts, fmt.Sprint(value))
+		}
+	}
+	return compiled, nil
+}
+
+func toNumber(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case float64:
+		return v, nil
+	case string:
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", v)
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("%v is not a number", value)
+	}
+}
+
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func numericFields() []string {
+	var names []string
+	for _, name := range sortedNames(fields) {
+		if fields[name].numeric() {
+			names = append(names, name)
+		}
+	}
+	return names
+}
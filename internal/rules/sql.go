@@ -0,0 +1,184 @@
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SQL renders the rule as a BigQuery INSERT into aml_alerts_level1 with the
+// same semantics as Detect. tableRef qualifies table names. With
+// incremental set, only windows ending after the script's
+// last_processed_time variable are evaluated, as the detectors of
+// sql/incremental_aml_processing.sql do.
+func (c *Compiled) SQL(tableRef func(string) string, incremental bool) string {
+	var b strings.Builder
+	r := c.Rule
+	fmt.Fprintf(&b, "-- Rule %s", r.Name)
+	if c.Path != "" {
+		fmt.Fprintf(&b, " (%s)", c.Path)
+	}
+	fmt.Fprintf(&b, "\n-- %s\n", r.Description)
+	fmt.Fprintf(&b, "INSERT INTO %s\n", tableRef("aml_alerts_level1"))
+
+	// Filtered transactions with their group keys and aggregated fields
+	b.WriteString("WITH rule_transactions AS (\n  SELECT\n")
+	b.WriteString("    t.trans_num,\n    t.trans_date_trans_time,\n    t.amt,\n")
+	b.WriteString("    CONCAT(t.first, '_', t.last) as customer_id,\n    t.merchant as merchant_name")
+	for i, key := range c.keys {
+		fmt.Fprintf(&b, ",\n    %s as key_%d", key.sql, i)
+	}
+	for _, a := range r.Aggregations {
+		if a.Field != "" {
+			fmt.Fprintf(&b, ",\n    %s as field_%s", fields[a.Field].sql, a.Name)
+		}
+	}
+	fmt.Fprintf(&b, "\n  FROM %s t\n", tableRef("credit_card_transactions"))
+	var where []string
+	for _, p := range c.filter {
+		where = append(where, p.sql())
+	}
+	if incremental {
+		// The windows ending after last_processed_time need the history before it
+		lookback := "TIMESTAMP(DATE(last_processed_time))"
+		if c.window > 0 {
+			lookback = fmt.Sprintf("TIMESTAMP_SUB(last_processed_time, INTERVAL %d SECOND)", int64(c.window.Seconds()))
+		}
+		where = append(where, "t.trans_date_trans_time >= "+lookback)
+	}
+	if len(where) > 0 {
+		fmt.Fprintf(&b, "  WHERE %s\n", strings.Join(where, "\n    AND "))
+	}
+	b.WriteString("),\n\n")
+
+	// Each transaction closes a window over its group
+	b.WriteString("-- Each transaction closes a window over the earlier activity of its group\n")
+	b.WriteString("rule_windows AS (\n  SELECT\n")
+	b.WriteString("    e.trans_num as end_trans_num,\n    e.trans_date_trans_time as window_end,\n")
+	b.WriteString("    e.customer_id,\n    e.merchant_name")
+	var groupBy, partition []string
+	for i := range c.keys {
+		fmt.Fprintf(&b, ",\n    e.key_%d", i)
+		groupBy = append(groupBy, fmt.Sprintf("e.key_%d", i))
+		partition = append(partition, fmt.Sprintf("key_%d", i))
+	}
+	b.WriteString(",\n    SUM(w.amt) as window_amount")
+	for _, a := range r.Aggregations {
+		fmt.Fprintf(&b, ",\n    %s as %s", aggregateSQL(a), a.Name)
+	}
+	b.WriteString("\n  FROM rule_transactions e\n  JOIN rule_transactions w\n")
+	var on []string
+	for i := range c.keys {
+		on = append(on, fmt.Sprintf("w.key_%d = e.key_%d", i, i))
+	}
+	if c.window > 0 {
+		on = append(on, fmt.Sprintf("w.trans_date_trans_time > TIMESTAMP_SUB(e.trans_date_trans_time, INTERVAL %d SECOND)", int64(c.window.Seconds())))
+	} else {
+		on = append(on, "DATE(w.trans_date_trans_time) = DATE(e.trans_date_trans_time)")
+	}
+	on = append(on, "w.trans_date_trans_time <= e.trans_date_trans_time")
+	fmt.Fprintf(&b, "    ON %s\n", strings.Join(on, "\n    AND "))
+	if incremental {
+		b.WriteString("  WHERE e.trans_date_trans_time > last_processed_time\n")
+	}
+	fmt.Fprintf(&b, "  GROUP BY e.trans_num, e.trans_date_trans_time, e.customer_id, e.merchant_name, %s\n", strings.Join(groupBy, ", "))
+	b.WriteString("),\n\n")
+
+	// Qualifying windows are scored and the best of each group and day kept
+	b.WriteString("-- Qualifying windows are scored; the best window of each group and day is reported\n")
+	b.WriteString("rule_scored AS (\n  SELECT\n    *,\n")
+	fmt.Fprintf(&b, "    CAST(LEAST(GREATEST(ROUND(%s), 0), 100) AS INT64) as risk_score\n", c.score.sql())
+	b.WriteString("  FROM rule_windows\n")
+	var conditions []string
+	for _, condition := range r.Conditions {
+		conditions = append(conditions, fmt.Sprintf("%s %s %s", condition.Aggregation, comparisons[condition.Op], number(condition.Value).sql()))
+	}
+	fmt.Fprintf(&b, "  WHERE %s\n", strings.Join(conditions, "\n    AND "))
+	b.WriteString("),\n\n")
+	b.WriteString("rule_alerts AS (\n  SELECT *\n  FROM rule_scored\n  WHERE TRUE\n")
+	fmt.Fprintf(&b, "  QUALIFY ROW_NUMBER() OVER (PARTITION BY %s, DATE(window_end) ORDER BY risk_score DESC, window_end) = 1\n",
+		strings.Join(partition, ", "))
+	b.WriteString(")\n\n")
+
+	subject := "customer_id"
+	if r.Subject == SubjectMerchant {
+		subject = "merchant_name"
+	}
+	b.WriteString("SELECT \n")
+	fmt.Fprintf(&b, "  (SELECT IFNULL(MAX(alert_id), 0) FROM %s) + \n", tableRef("aml_alerts_level1"))
+	b.WriteString("  ROW_NUMBER() OVER (ORDER BY risk_score DESC, window_end) as alert_id,\n")
+	fmt.Fprintf(&b, "  %s as customer_id,\n", subject)
+	b.WriteString("  DATE(window_end) as alert_date,\n")
+	fmt.Fprintf(&b, "  %s as alert_type,\n", quote(r.Name))
+	b.WriteString("  risk_score,\n")
+	fmt.Fprintf(&b, "  %s as description,\n", c.descriptionSQL())
+	b.WriteString("  CASE \n")
+	fmt.Fprintf(&b, "    WHEN risk_score >= %d THEN 'HIGH'\n", r.Priority.High)
+	fmt.Fprintf(&b, "    WHEN risk_score >= %d THEN 'MEDIUM'\n", r.Priority.Medium)
+	b.WriteString("    ELSE 'LOW'\n  END as priority,\n")
+	b.WriteString("  window_amount as total_amount,\n")
+	b.WriteString("  'OPEN' as status,\n  CURRENT_DATE() as detection_date,\n  CURRENT_TIMESTAMP() as created_at\n")
+	b.WriteString("FROM rule_alerts;\n")
+	return b.String()
+}
+
+func aggregateSQL(a Aggregation) string {
+	switch a.Function {
+	case "count":
+		return "COUNT(*)"
+	case "count_distinct":
+		return fmt.Sprintf("COUNT(DISTINCT w.field_%s)", a.Name)
+	default:
+		return fmt.Sprintf("%s(w.field_%s)", functions[a.Function], a.Name)
+	}
+}
+
+func (p predicate) sql() string {
+	var values []string
+	if p.field.numeric() {
+		for _, n := range p.numbers {
+			values = append(values, number(n).sql())
+		}
+	} else {
+		for _, s := range p.texts {
+			values = append(values, quote(s))
+		}
+	}
+	switch p.Op {
+	case "in":
+		return fmt.Sprintf("%s IN (%s)", p.field.sql, strings.Join(values, ", "))
+	case "not_in":
+		return fmt.Sprintf("%s NOT IN (%s)", p.field.sql, strings.Join(values, ", "))
+	default:
+		return fmt.Sprintf("%s %s %s", p.field.sql, comparisons[p.Op], values[0])
+	}
+}
+
+// descriptionSQL builds the description CONCAT, formatting placeholders the
+// way aml.FormatAmount does
+func (c *Compiled) descriptionSQL() string {
+	var parts []string
+	description := c.Rule.Description
+	last := 0
+	for _, match := range placeholderPattern.FindAllStringSubmatchIndex(description, -1) {
+		if match[0] > last {
+			parts = append(parts, quote(description[last:match[0]]))
+		}
+		parts = append(parts, fmt.Sprintf("FORMAT('%%\\'.0f', CAST(%s AS FLOAT64))", description[match[2]:match[3]]))
+		last = match[1]
+	}
+	if last < len(description) {
+		parts = append(parts, quote(description[last:]))
+	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+	return "CONCAT(\n    " + strings.Join(parts, ",\n    ") + "\n  )"
+}
+
+var quoteEscaper = regexp.MustCompile(`['\\]`)
+
+// quote renders a BigQuery string literal
+func quote(s string) string {
+	return "'" + quoteEscaper.ReplaceAllString(s, `\$0`) + "'"
+}
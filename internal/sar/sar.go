@@ -0,0 +1,343 @@
+// Package sar prepares Suspicious Activity Reports on escalated cases. It
+// assembles the subject, activity period, transactions and alert evidence
+// of a case, drafts the narrative from a template for the analyst to
+// complete, and tracks the filings through export and FinCEN's
+// acknowledgement.
+package sar
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/cases"
+	"aml-system/internal/ctr"
+	"aml-system/internal/store"
+)
+
+// FilingsTable stores every SAR filing
+const FilingsTable = "sar_filings"
+
+// DefaultTemplatePath is the narrative template the tools use
+const DefaultTemplatePath = "config/sar_narrative.tmpl"
+
+// MaxNarrative is the number of characters FinCEN accepts in a narrative
+const MaxNarrative = 17000
+
+// Placeholder opens the parts of a drafted narrative the analyst must
+// write; a narrative containing one cannot be exported
+const Placeholder = "[["
+
+// Filing statuses
+const (
+	StatusDraft        = "DRAFT"        // narrative drafted, not yet exported
+	StatusExported     = "EXPORTED"     // written to a batch file for BSA E-Filing
+	StatusAcknowledged = "ACKNOWLEDGED" // FinCEN accepted it and returned a BSA ID
+)
+
+// Subject is the person the report is about, as known from their
+// transactions
+type Subject struct {
+	CustomerID string   `json:"customer_id"`
+	First      string   `json:"first"`
+	Last       string   `json:"last"`
+	DOB        string   `json:"dob"`
+	Gender     string   `json:"gender"`
+	Street     string   `json:"street"`
+	City       string   `json:"city"`
+	State      string   `json:"state"`
+	Zip        string   `json:"zip"`
+	Job        string   `json:"job"`
+	Cards      []string `json:"cards"` // masked card numbers
+}
+
+// Count is the activity at one merchant or in one category
+type Count struct {
+	Name         string
+	Transactions int
+	Amount       float64
+}
+
+// Report is everything known about a case's suspicious activity
+type Report struct {
+	CaseID         int64
+	Subject        Subject
+	From           string // first day of the activity
+	To             string // last day of the activity
+	TotalAmount    float64
+	AggregateScore int64
+	AlertTypes     []string
+	Alerts         []aml.Alert
+	Transactions   []aml.Transaction // oldest first
+	Merchants      []Count           // largest amount first
+	Categories     []Count           // largest amount first
+	Notes          []cases.Event
+	Institution    string
+	PreparedBy     string
+	PreparedOn     string
+}
+
+// Filing is a SAR prepared from a case, stored in sar_filings. It keeps
+// what was reported so the filing stands on its own when the case changes.
+type Filing struct {
+	FilingID int64  `json:"filing_id"`
+	CaseID   int64  `json:"case_id"`
+	Status   string `json:"status"`
+	Subject
+	ActivityFrom   string    `json:"activity_from"`
+	ActivityTo     string    `json:"activity_to"`
+	TotalAmount    float64   `json:"total_amount"`
+	AlertIDs       []int64   `json:"alert_ids"`
+	AlertTypes     []string  `json:"alert_types"`
+	TransNums      []string  `json:"trans_nums"`
+	Narrative      string    `json:"narrative"`
+	PreparedBy     string    `json:"prepared_by"`
+	PreparedAt     time.Time `json:"prepared_at"`
+	BatchID        string    `json:"batch_id"`
+	File           string    `json:"file"`
+	Checksum       string    `json:"checksum"` // SHA-256 of the batch file
+	ExportedAt     time.Time `json:"exported_at"`
+	BSAID          string    `json:"bsa_id"` // assigned by FinCEN on acceptance
+	AcknowledgedAt time.Time `json:"acknowledged_at"`
+}
+
+// Reportable reports whether a case in status may be reported: one
+// escalated for a SAR or closed with one filed
+func Reportable(status string) bool {
+	return status == aml.StatusEscalated || status == aml.StatusSARFiled
+}
+
+// Assemble gathers the report of a case from the subject's transactions.
+// The activity is the transactions that triggered the case's alerts or,
+// for alerts that did not record them, the subject's transactions on the
+// case's alert dates.
+func Assemble(c *cases.Case, transactions []aml.Transaction, links []aml.AlertTransaction) (Report, error) {
+	if !Reportable(c.Status) {
+		return Report{}, fmt.Errorf("case %d is %s; SARs are prepared for %s or %s cases",
+			c.CaseID, c.Status, aml.StatusEscalated, aml.StatusSARFiled)
+	}
+
+	members := map[int64]bool{}
+	for _, id := range c.AlertIDs {
+		members[id] = true
+	}
+	triggering := map[string]bool{}
+	for _, link := range links {
+		if members[link.AlertID] {
+			triggering[link.TransNum] = true
+		}
+	}
+	dates := map[string]bool{}
+	for _, alert := range c.Alerts {
+		dates[alert.AlertDate] = true
+	}
+
+	var activity []aml.Transaction
+	for _, txn := range transactions {
+		if txn.CustomerID() != c.CustomerID {
+			continue
+		}
+		if triggering[txn.TransNum] || (len(triggering) == 0 && dates[txn.Date()]) {
+			activity = append(activity, txn)
+		}
+	}
+	if len(activity) == 0 {
+		return Report{}, fmt.Errorf("no transactions of %s found for case %d", c.CustomerID, c.CaseID)
+	}
+	sort.SliceStable(activity, func(i, j int) bool {
+		return activity[i].TransDateTransTime.Before(activity[j].TransDateTransTime)
+	})
+
+	latest := activity[len(activity)-1]
+	r := Report{
+		CaseID: c.CaseID,
+		Subject: Subject{
+			CustomerID: c.CustomerID,
+			First:      latest.First,
+			Last:       latest.Last,
+			DOB:        latest.DOB,
+			Gender:     latest.Gender,
+			Street:     latest.Street,
+			City:       latest.City,
+			State:      latest.State,
+			Zip:        latest.Zip,
+			Job:        latest.Job,
+		},
+		From:           activity[0].Date(),
+		To:             latest.Date(),
+		AggregateScore: c.AggregateScore,
+		AlertTypes:     c.AlertTypes,
+		Alerts:         c.Alerts,
+		Transactions:   activity,
+		Notes:          c.Notes(),
+	}
+	cards := map[string]bool{}
+	merchants := map[string]*Count{}
+	categories := map[string]*Count{}
+	for _, txn := range activity {
+		r.TotalAmount += txn.Amount
+		if card := ctr.MaskCard(txn.CCNum); !cards[card] {
+			cards[card] = true
+			r.Subject.Cards = append(r.Subject.Cards, card)
+		}
+		tally(merchants, txn.Merchant, txn.Amount)
+		tally(categories, txn.Category, txn.Amount)
+	}
+	sort.Strings(r.Subject.Cards)
+	r.Merchants = ranked(merchants)
+	r.Categories = ranked(categories)
+	return r, nil
+}
+
+// AssembleCase loads a case, its subject's transactions and the alerts'
+// triggering transactions from the store and assembles its report
+func AssembleCase(ctx context.Context, st store.Store, caseID int64) (Report, error) {
+	book, err := cases.Load(ctx, st)
+	if err != nil {
+		return Report{}, err
+	}
+	c, err := book.Get(caseID)
+	if err != nil {
+		return Report{}, err
+	}
+	if merchant, ok := c.Alert.Merchant(); ok {
+		return Report{}, fmt.Errorf("case %d was raised on merchant %s: a SAR is filed on the customers involved, so escalate their cases instead", c.CaseID, merchant)
+	}
+	transactions, err := st.CustomerTransactions(ctx, c.CustomerID, time.Time{})
+	if err != nil {
+		return Report{}, err
+	}
+	var links []aml.AlertTransaction
+	if err := st.Load(ctx, store.AlertTransactionsTable, &links); err != nil {
+		return Report{}, fmt.Errorf("failed to load alert transactions: %v", err)
+	}
+	return Assemble(c, transactions, links)
+}
+
+func tally(counts map[string]*Count, name string, amount float64) {
+	count, ok := counts[name]
+	if !ok {
+		count = &Count{Name: name}
+		counts[name] = count
+	}
+	count.Transactions++
+	count.Amount += amount
+}
+
+// ranked orders counts by amount, largest first
+func ranked(counts map[string]*Count) []Count {
+	list := make([]Count, 0, len(counts))
+	for _, count := range counts {
+		list = append(list, *count)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Amount != list[j].Amount {
+			return list[i].Amount > list[j].Amount
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// templateFuncs are available to narrative templates
+var templateFuncs = template.FuncMap{
+	"amount": aml.FormatAmount,
+	"join":   strings.Join,
+	"date":   func(t time.Time) string { return t.Format(aml.DateLayout) },
+	"top": func(n int, counts []Count) []Count {
+		if len(counts) > n {
+			return counts[:n]
+		}
+		return counts
+	},
+}
+
+// LoadTemplate reads a narrative template
+func LoadTemplate(path string) (*template.Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read narrative template: %v", err)
+	}
+	tmpl, err := template.New(path).Funcs(templateFuncs).Parse(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid narrative template %s: %v", path, err)
+	}
+	return tmpl, nil
+}
+
+// Narrative drafts the narrative of a report
+func Narrative(tmpl *template.Template, r Report) (string, error) {
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, r); err != nil {
+		return "", fmt.Errorf("failed to draft narrative: %v", err)
+	}
+	text := strings.TrimSpace(b.String())
+	if n := len([]rune(text)); n > MaxNarrative {
+		return "", fmt.Errorf("the drafted narrative has %d characters, more than the %d FinCEN accepts", n, MaxNarrative)
+	}
+	return text, nil
+}
+
+// CheckNarrative reports whether a narrative is complete enough to file
+func CheckNarrative(text string) error {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return fmt.Errorf("the narrative is empty")
+	}
+	if strings.Contains(text, Placeholder) {
+		return fmt.Errorf("the narrative still has %s ... ]] parts to complete", Placeholder)
+	}
+	if n := len([]rune(text)); n > MaxNarrative {
+		return fmt.Errorf("the narrative has %d characters, more than the %d FinCEN accepts", n, MaxNarrative)
+	}
+	return nil
+}
+
+// NewFiling records what a report covers as a draft filing
+func NewFiling(r Report, narrative, preparedBy string, now time.Time) Filing {
+	f := Filing{
+		CaseID:       r.CaseID,
+		Status:       StatusDraft,
+		Subject:      r.Subject,
+		ActivityFrom: r.From,
+		ActivityTo:   r.To,
+		TotalAmount:  r.TotalAmount,
+		AlertTypes:   r.AlertTypes,
+		Narrative:    narrative,
+		PreparedBy:   preparedBy,
+		PreparedAt:   now,
+	}
+	for _, alert := range r.Alerts {
+		f.AlertIDs = append(f.AlertIDs, alert.AlertID)
+	}
+	for _, txn := range r.Transactions {
+		f.TransNums = append(f.TransNums, txn.TransNum)
+	}
+	return f
+}
+
+// Draft returns the index of the case's filing still in DRAFT, or -1
+func Draft(filings []Filing, caseID int64) int {
+	for i, f := range filings {
+		if f.CaseID == caseID && f.Status == StatusDraft {
+			return i
+		}
+	}
+	return -1
+}
+
+// Find returns the index of a filing, or an error
+func Find(filings []Filing, filingID int64) (int, error) {
+	for i, f := range filings {
+		if f.FilingID == filingID {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("no SAR filing %d", filingID)
+}
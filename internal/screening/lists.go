@@ -0,0 +1,497 @@
+package screening
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Tables used by sanctions screening
+const (
+	EntriesTable = "sanctions_list_entries"
+	MatchesTable = "sanctions_matches"
+)
+
+// Sanctions list sources
+const (
+	SourceOFACSDN = "OFAC_SDN"
+	SourceEU      = "EU_CONSOLIDATED"
+	SourceUN      = "UN_CONSOLIDATED"
+)
+
+// Entry types
+const (
+	EntryIndividual = "INDIVIDUAL"
+	EntryEntity     = "ENTITY"
+	EntryVessel     = "VESSEL"
+	EntryAircraft   = "AIRCRAFT"
+)
+
+// Entry is one designated party, stored in sanctions_list_entries
+type Entry struct {
+	Source    string   `json:"source"`
+	UID       string   `json:"uid"`
+	Name      string   `json:"name"`
+	Aliases   []string `json:"aliases"`
+	EntryType string   `json:"entry_type"`
+	Programs  []string `json:"programs"`
+}
+
+// Names returns the primary name followed by all aliases
+func (e Entry) Names() []string {
+	return append([]string{e.Name}, e.Aliases...)
+}
+
+// Sources lists the accepted values for the import -source flag
+func Sources() []string {
+	return []string{SourceOFACSDN, SourceEU, SourceUN}
+}
+
+// LoadFile parses a downloaded list file. The format is chosen from the
+// source and the file extension.
+func LoadFile(path, source string) ([]Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open list file: %v", err)
+	}
+	defer file.Close()
+
+	isCSV := strings.EqualFold(filepath.Ext(path), ".csv")
+	switch {
+	case source == SourceOFACSDN && isCSV:
+		entries, err := ParseOFACCSV(file)
+		if err != nil {
+			return nil, err
+		}
+		// OFAC publishes aliases in a separate alt.csv next to sdn.csv
+		altPath := filepath.Join(filepath.Dir(path), "alt.csv")
+		if altFile, err := os.Open(altPath); err == nil {
+			defer altFile.Close()
+			if err := MergeOFACAliasesCSV(altFile, entries); err != nil {
+				return nil, fmt.Errorf("%s: %v", altPath, err)
+			}
+		}
+		return entries, nil
+	case source == SourceOFACSDN:
+		return ParseOFACXML(file)
+	case source == SourceEU && isCSV:
+		return ParseEUCSV(file)
+	case source == SourceEU:
+		return ParseEUXML(file)
+	case source == SourceUN && isCSV:
+		return nil, fmt.Errorf("the UN consolidated list is only published as XML")
+	case source == SourceUN:
+		return ParseUNXML(file)
+	default:
+		return nil, fmt.Errorf("unknown list source %q (expected one of %s)", source, strings.Join(Sources(), ", "))
+	}
+}
+
+// ============================================================================
+// OFAC SDN
+// ============================================================================
+
+type ofacEntry struct {
+	UID       string   `xml:"uid"`
+	FirstName string   `xml:"firstName"`
+	LastName  string   `xml:"lastName"`
+	SDNType   string   `xml:"sdnType"`
+	Programs  []string `xml:"programList>program"`
+	AKAs      []struct {
+		FirstName string `xml:"firstName"`
+		LastName  string `xml:"lastName"`
+	} `xml:"akaList>aka"`
+}
+
+// ParseOFACXML reads the OFAC sdn.xml file
+func ParseOFACXML(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	err := decodeElements(r, "sdnEntry", func(d *xml.Decoder, start xml.StartElement) error {
+		var raw ofacEntry
+		if err := d.DecodeElement(&raw, &start); err != nil {
+			return err
+		}
+
+		entry := Entry{
+			Source:    SourceOFACSDN,
+			UID:       strings.TrimSpace(raw.UID),
+			Name:      joinName(raw.FirstName, raw.LastName),
+			EntryType: ofacEntryType(raw.SDNType),
+			Programs:  raw.Programs,
+		}
+		for _, aka := range raw.AKAs {
+			if alias := joinName(aka.FirstName, aka.LastName); alias != "" {
+				entry.Aliases = append(entry.Aliases, alias)
+			}
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse OFAC SDN XML: %v", err)
+	}
+	return entries, nil
+}
+
+// ParseOFACCSV reads the OFAC sdn.csv file (no header row)
+func ParseOFACCSV(r io.Reader) ([]Entry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	var entries []Entry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse OFAC SDN CSV: %v", err)
+		}
+		// The file ends with a single EOF control character
+		if len(record) < 4 {
+			continue
+		}
+
+		entries = append(entries, Entry{
+			Source:    SourceOFACSDN,
+			UID:       ofacField(record[0]),
+			Name:      ofacField(record[1]),
+			EntryType: ofacEntryType(ofacField(record[2])),
+			Programs:  splitPrograms(ofacField(record[3])),
+		})
+	}
+	return entries, nil
+}
+
+// MergeOFACAliasesCSV adds the names from OFAC alt.csv to matching entries
+func MergeOFACAliasesCSV(r io.Reader, entries []Entry) error {
+	byUID := make(map[string]*Entry, len(entries))
+	for i := range entries {
+		byUID[entries[i].UID] = &entries[i]
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse OFAC alias CSV: %v", err)
+		}
+		if len(record) < 4 {
+			continue
+		}
+		if entry, ok := byUID[ofacField(record[0])]; ok {
+			if alias := ofacField(record[3]); alias != "" {
+				entry.Aliases = append(entry.Aliases, alias)
+			}
+		}
+	}
+}
+
+// ofacField trims a CSV value and maps OFAC's "-0-" null marker to ""
+func ofacField(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "-0-" {
+		return ""
+	}
+	return value
+}
+
+func ofacEntryType(sdnType string) string {
+	switch strings.ToLower(strings.TrimSpace(sdnType)) {
+	case "individual":
+		return EntryIndividual
+	case "vessel":
+		return EntryVessel
+	case "aircraft":
+		return EntryAircraft
+	default:
+		return EntryEntity
+	}
+}
+
+// splitPrograms turns "SDGT] [IRGC" into ["SDGT", "IRGC"]
+func splitPrograms(value string) []string {
+	var programs []string
+	for _, program := range strings.Split(value, "] [") {
+		program = strings.Trim(program, "[] ")
+		if program != "" {
+			programs = append(programs, program)
+		}
+	}
+	return programs
+}
+
+// ============================================================================
+// EU CONSOLIDATED FINANCIAL SANCTIONS LIST
+// ============================================================================
+
+type euEntity struct {
+	LogicalID   string `xml:"logicalId,attr"`
+	SubjectType struct {
+		Code string `xml:"code,attr"`
+	} `xml:"subjectType"`
+	Regulations []struct {
+		Programme string `xml:"programme,attr"`
+	} `xml:"regulation"`
+	NameAliases []struct {
+		WholeName string `xml:"wholeName,attr"`
+		FirstName string `xml:"firstName,attr"`
+		LastName  string `xml:"lastName,attr"`
+	} `xml:"nameAlias"`
+}
+
+// ParseEUXML reads the EU consolidated list XML (export format 1.1)
+func ParseEUXML(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	err := decodeElements(r, "sanctionEntity", func(d *xml.Decoder, start xml.StartElement) error {
+		var raw euEntity
+		if err := d.DecodeElement(&raw, &start); err != nil {
+			return err
+		}
+
+		entry := Entry{
+			Source:    SourceEU,
+			UID:       raw.LogicalID,
+			EntryType: euEntryType(raw.SubjectType.Code),
+		}
+		for _, regulation := range raw.Regulations {
+			entry.Programs = appendUnique(entry.Programs, regulation.Programme)
+		}
+		for _, alias := range raw.NameAliases {
+			name := strings.TrimSpace(alias.WholeName)
+			if name == "" {
+				name = joinName(alias.FirstName, alias.LastName)
+			}
+			entry.addName(name)
+		}
+		if entry.Name != "" {
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse EU consolidated XML: %v", err)
+	}
+	return entries, nil
+}
+
+// ParseEUCSV reads the semicolon separated EU consolidated list CSV, which
+// has one row per name alias. Both the current and the legacy column names
+// are accepted.
+func ParseEUCSV(r io.Reader) ([]Entry, error) {
+	reader := csv.NewReader(r)
+	reader.Comma = ';'
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read EU CSV header: %v", err)
+	}
+	column := func(names ...string) int {
+		for i, h := range header {
+			for _, name := range names {
+				if strings.EqualFold(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")), name) {
+					return i
+				}
+			}
+		}
+		return -1
+	}
+	idCol := column("Entity_LogicalId", "Entity_logical_id")
+	nameCol := column("NameAlias_WholeName", "Naal_wholename")
+	typeCol := column("Entity_SubjectType", "Subject_type")
+	programmeCol := column("Entity_Regulation_Programme", "Programme")
+	if idCol < 0 || nameCol < 0 {
+		return nil, fmt.Errorf("EU CSV is missing the logical id or whole name column")
+	}
+
+	value := func(record []string, i int) string {
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var entries []Entry
+	index := map[string]int{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse EU CSV: %v", err)
+		}
+
+		id := value(record, idCol)
+		if id == "" {
+			continue
+		}
+		i, ok := index[id]
+		if !ok {
+			i = len(entries)
+			index[id] = i
+			entries = append(entries, Entry{
+				Source:    SourceEU,
+				UID:       id,
+				EntryType: euEntryType(value(record, typeCol)),
+			})
+		}
+		entries[i].addName(value(record, nameCol))
+		entries[i].Programs = appendUnique(entries[i].Programs, value(record, programmeCol))
+	}
+
+	named := entries[:0]
+	for _, entry := range entries {
+		if entry.Name != "" {
+			named = append(named, entry)
+		}
+	}
+	return named, nil
+}
+
+func euEntryType(code string) string {
+	switch strings.ToLower(strings.TrimSpace(code)) {
+	case "person", "p":
+		return EntryIndividual
+	default:
+		return EntryEntity
+	}
+}
+
+// ============================================================================
+// UN SECURITY COUNCIL CONSOLIDATED LIST
+// ============================================================================
+
+type unParty struct {
+	DataID     string    `xml:"DATAID"`
+	FirstName  string    `xml:"FIRST_NAME"`
+	SecondName string    `xml:"SECOND_NAME"`
+	ThirdName  string    `xml:"THIRD_NAME"`
+	FourthName string    `xml:"FOURTH_NAME"`
+	ListType   string    `xml:"UN_LIST_TYPE"`
+	Original   string    `xml:"NAME_ORIGINAL_SCRIPT"`
+	Aliases    []unAlias `xml:"INDIVIDUAL_ALIAS"`
+	EntityAKAs []unAlias `xml:"ENTITY_ALIAS"`
+}
+
+type unAlias struct {
+	Name string `xml:"ALIAS_NAME"`
+}
+
+// ParseUNXML reads the UN consolidated.xml file
+func ParseUNXML(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	handle := func(entryType string) func(*xml.Decoder, xml.StartElement) error {
+		return func(d *xml.Decoder, start xml.StartElement) error {
+			var raw unParty
+			if err := d.DecodeElement(&raw, &start); err != nil {
+				return err
+			}
+
+			entry := Entry{
+				Source:    SourceUN,
+				UID:       strings.TrimSpace(raw.DataID),
+				Name:      joinName(raw.FirstName, raw.SecondName, raw.ThirdName, raw.FourthName),
+				EntryType: entryType,
+				Programs:  appendUnique(nil, strings.TrimSpace(raw.ListType)),
+			}
+			entry.addName(raw.Original)
+			for _, alias := range append(raw.Aliases, raw.EntityAKAs...) {
+				entry.addName(alias.Name)
+			}
+			if entry.Name != "" {
+				entries = append(entries, entry)
+			}
+			return nil
+		}
+	}
+
+	err := decodeElementsByName(r, map[string]func(*xml.Decoder, xml.StartElement) error{
+		"INDIVIDUAL": handle(EntryIndividual),
+		"ENTITY":     handle(EntryEntity),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse UN consolidated XML: %v", err)
+	}
+	return entries, nil
+}
+
+// ============================================================================
+// HELPERS
+// ============================================================================
+
+// decodeElements streams r and calls handle for every element named local
+func decodeElements(r io.Reader, local string, handle func(*xml.Decoder, xml.StartElement) error) error {
+	return decodeElementsByName(r, map[string]func(*xml.Decoder, xml.StartElement) error{local: handle})
+}
+
+func decodeElementsByName(r io.Reader, handlers map[string]func(*xml.Decoder, xml.StartElement) error) error {
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if handle, ok := handlers[start.Name.Local]; ok {
+			if err := handle(decoder, start); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// addName sets the primary name or records an additional alias
+func (e *Entry) addName(name string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return
+	}
+	if e.Name == "" {
+		e.Name = name
+		return
+	}
+	if name != e.Name {
+		e.Aliases = appendUnique(e.Aliases, name)
+	}
+}
+
+func joinName(parts ...string) string {
+	var words []string
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			words = append(words, part)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func appendUnique(values []string, value string) []string {
+	if value == "" {
+		return values
+	}
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
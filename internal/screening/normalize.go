@@ -0,0 +1,94 @@
+package screening
+
+import (
+	"strings"
+	"unicode"
+)
+
+// transliterations maps non-ASCII letters to their Latin spelling. It covers
+// Latin diacritics plus the Cyrillic and Greek alphabets, which between them
+// account for almost all non-Latin names on the OFAC, EU and UN lists.
+var transliterations = map[rune]string{
+	// Latin with diacritics and ligatures
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae", 'ç': "c", 'ć': "c", 'č': "c", 'ĉ': "c", 'ċ': "c", 'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ğ': "g", 'ģ': "g", 'ĥ': "h", 'ħ': "h",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i", 'ı': "i",
+	'ĵ': "j", 'ķ': "k", 'ĺ': "l", 'ļ': "l", 'ľ': "l", 'ł': "l",
+	'ñ': "n", 'ń': "n", 'ņ': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o", 'œ': "oe",
+	'ŕ': "r", 'ř': "r", 'ś': "s", 'ş': "s", 'š': "s", 'ș': "s", 'ß': "ss",
+	'ţ': "t", 'ť': "t", 'ț': "t", 'þ': "th",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+
+	// Cyrillic (BGN/PCGN style, as used by OFAC)
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "u",
+
+	// Greek
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th",
+	'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p",
+	'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps",
+	'ω': "o", 'ά': "a", 'έ': "e", 'ή': "i", 'ί': "i", 'ό': "o", 'ύ': "y", 'ώ': "o",
+}
+
+// noiseWords are dropped before matching because they appear in so many
+// entity names that they only add false similarity
+var noiseWords = map[string]bool{
+	"and": true, "the": true, "of": true, "co": true, "company": true,
+	"inc": true, "incorporated": true, "llc": true, "ltd": true, "limited": true,
+	"corp": true, "corporation": true, "plc": true, "group": true, "sa": true,
+	"gmbh": true, "ag": true, "bv": true, "jsc": true, "ojsc": true, "ooo": true,
+	"mr": true, "mrs": true, "ms": true, "dr": true,
+}
+
+// Transliterate lowercases s and rewrites accented and non-Latin letters in ASCII
+func Transliterate(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range strings.ToLower(s) {
+		if r < unicode.MaxASCII {
+			b.WriteRune(r)
+			continue
+		}
+		if latin, ok := transliterations[r]; ok {
+			b.WriteString(latin)
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune(' ')
+		}
+	}
+	return b.String()
+}
+
+// Tokens transliterates a name and splits it into lowercase words, dropping
+// punctuation and noise words
+func Tokens(name string) []string {
+	fields := strings.FieldsFunc(Transliterate(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+
+	tokens := make([]string, 0, len(fields))
+	for _, field := range fields {
+		field = strings.ReplaceAll(field, "'", "")
+		if field == "" || noiseWords[field] {
+			continue
+		}
+		tokens = append(tokens, field)
+	}
+	return tokens
+}
+
+// Normalize returns the canonical matching form of a name: transliterated,
+// lowercase words separated by single spaces
+func Normalize(name string) string {
+	return strings.Join(Tokens(name), " ")
+}
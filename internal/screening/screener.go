@@ -0,0 +1,149 @@
+package screening
+
+import (
+	"sort"
+	"strings"
+)
+
+// DefaultThreshold is the minimum score for a name to count as a hit
+const DefaultThreshold = 0.90
+
+// Match is a list entry whose name scored above the threshold for a subject
+type Match struct {
+	Entry       Entry
+	MatchedName string
+	Score       float64
+	JaroWinkler float64
+	TokenSet    float64
+}
+
+type indexedName struct {
+	entry      int
+	name       string
+	normalized string
+	tokens     []string
+}
+
+// Screener fuzzy-matches names against the loaded list entries
+type Screener struct {
+	Threshold float64
+
+	entries []Entry
+	names   []indexedName
+	blocks  map[string][]int
+}
+
+// NewScreener indexes the entries for screening at the given threshold
+func NewScreener(entries []Entry, threshold float64) *Screener {
+	s := &Screener{
+		Threshold: threshold,
+		entries:   entries,
+		blocks:    map[string][]int{},
+	}
+
+	for i, entry := range entries {
+		for _, name := range entry.Names() {
+			tokens := Tokens(name)
+			if len(tokens) == 0 {
+				continue
+			}
+
+			id := len(s.names)
+			s.names = append(s.names, indexedName{
+				entry:      i,
+				name:       name,
+				normalized: strings.Join(tokens, " "),
+				tokens:     tokens,
+			})
+			for _, key := range blockKeys(tokens) {
+				s.blocks[key] = append(s.blocks[key], id)
+			}
+		}
+	}
+	return s
+}
+
+// Size returns the number of entries and indexed names
+func (s *Screener) Size() (entries, names int) {
+	return len(s.entries), len(s.names)
+}
+
+// Screen returns the best-scoring name of every entry that matches name,
+// ordered from the strongest match. Customers are only compared against
+// individuals; merchants and counterparties are compared against every entry.
+func (s *Screener) Screen(subjectType, name string) []Match {
+	tokens := Tokens(name)
+	if len(tokens) == 0 {
+		return nil
+	}
+	normalized := strings.Join(tokens, " ")
+
+	candidates := map[int]bool{}
+	for _, key := range blockKeys(tokens) {
+		for _, id := range s.blocks[key] {
+			candidates[id] = true
+		}
+	}
+
+	best := map[int]Match{}
+	for id := range candidates {
+		indexed := s.names[id]
+		entry := s.entries[indexed.entry]
+		if subjectType == SubjectCustomer && entry.EntryType != EntryIndividual {
+			continue
+		}
+
+		jw := JaroWinkler(normalized, indexed.normalized)
+		ts := TokenSetScore(tokens, indexed.tokens)
+		score := max(jw, ts)
+		if score < s.Threshold {
+			continue
+		}
+		if previous, ok := best[indexed.entry]; ok && previous.Score >= score {
+			continue
+		}
+		best[indexed.entry] = Match{
+			Entry:       entry,
+			MatchedName: indexed.name,
+			Score:       score,
+			JaroWinkler: jw,
+			TokenSet:    ts,
+		}
+	}
+
+	matches := make([]Match, 0, len(best))
+	for _, match := range best {
+		matches = append(matches, match)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Entry.UID < matches[j].Entry.UID
+	})
+	return matches
+}
+
+// blockKeys returns the candidate keys for a name: each token's first
+// letter followed by up to two more consonants, skipping h, w, y and a
+// repeat of the letter before. Spelling variants such as Mohammed and
+// Muhammad share a key (md), so they land in the same block while
+// unrelated names are never compared.
+func blockKeys(tokens []string) []string {
+	keys := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if len(token) < 2 {
+			continue
+		}
+		skeleton := []byte{token[0]}
+		for i := 1; i < len(token) && len(skeleton) < 3; i++ {
+			c := token[i]
+			if strings.IndexByte("aeiouyhw", c) >= 0 || c == skeleton[len(skeleton)-1] {
+				continue
+			}
+			skeleton = append(skeleton, c)
+		}
+		keys = append(keys, string(skeleton))
+	}
+	return keys
+}
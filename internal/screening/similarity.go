@@ -0,0 +1,135 @@
+package screening
+
+import (
+	"sort"
+	"strings"
+)
+
+// JaroWinkler returns the Jaro-Winkler similarity of a and b in [0, 1]
+func JaroWinkler(a, b string) float64 {
+	jaro := Jaro(a, b)
+	if jaro == 0 {
+		return 0
+	}
+
+	prefix := 0
+	for i := 0; i < len(a) && i < len(b) && i < 4; i++ {
+		if a[i] != b[i] {
+			break
+		}
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
+
+// Jaro returns the Jaro similarity of a and b in [0, 1]
+func Jaro(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	window := max(len(a), len(b))/2 - 1
+	if window < 0 {
+		window = 0
+	}
+
+	aMatched := make([]bool, len(a))
+	bMatched := make([]bool, len(b))
+	matches := 0
+	for i := 0; i < len(a); i++ {
+		lo := max(0, i-window)
+		hi := min(len(b), i+window+1)
+		for j := lo; j < hi; j++ {
+			if bMatched[j] || a[i] != b[j] {
+				continue
+			}
+			aMatched[i] = true
+			bMatched[j] = true
+			matches++
+			break
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	transpositions := 0
+	j := 0
+	for i := 0; i < len(a); i++ {
+		if !aMatched[i] {
+			continue
+		}
+		for !bMatched[j] {
+			j++
+		}
+		if a[i] != b[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	return (m/float64(len(a)) + m/float64(len(b)) + (m-float64(transpositions)/2)/m) / 3
+}
+
+// TokenSetScore compares two token lists independent of word order and
+// duplicated words. The shared tokens are compared against each side's
+// full token set, and the best Jaro-Winkler score of the three pairings is
+// returned, so "SMITH John" matches "John Andrew Smith" strongly.
+func TokenSetScore(a, b []string) float64 {
+	setA := uniqueSorted(a)
+	setB := uniqueSorted(b)
+	if len(setA) == 0 || len(setB) == 0 {
+		return 0
+	}
+
+	inB := make(map[string]bool, len(setB))
+	for _, token := range setB {
+		inB[token] = true
+	}
+
+	var common, onlyA, onlyB []string
+	for _, token := range setA {
+		if inB[token] {
+			common = append(common, token)
+			delete(inB, token)
+		} else {
+			onlyA = append(onlyA, token)
+		}
+	}
+	for _, token := range setB {
+		if inB[token] {
+			onlyB = append(onlyB, token)
+		}
+	}
+
+	intersection := strings.Join(common, " ")
+	combinedA := strings.TrimSpace(intersection + " " + strings.Join(onlyA, " "))
+	combinedB := strings.TrimSpace(intersection + " " + strings.Join(onlyB, " "))
+
+	best := JaroWinkler(combinedA, combinedB)
+	if len(common) >= 2 {
+		// Only trust a subset match when it covers the shorter name entirely
+		// and is more than a single shared surname
+		if len(onlyA) == 0 || len(onlyB) == 0 {
+			best = max(best, JaroWinkler(intersection, combinedA), JaroWinkler(intersection, combinedB))
+		}
+	}
+	return best
+}
+
+func uniqueSorted(tokens []string) []string {
+	seen := make(map[string]bool, len(tokens))
+	result := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if !seen[token] {
+			seen[token] = true
+			result = append(result, token)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
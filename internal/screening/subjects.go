@@ -0,0 +1,281 @@
+package screening
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// Subject types
+const (
+	SubjectCustomer     = "CUSTOMER"
+	SubjectMerchant     = "MERCHANT"
+	SubjectCounterparty = "COUNTERPARTY"
+)
+
+// ReviewPending is the review_status of a newly stored match
+const ReviewPending = "PENDING_REVIEW"
+
+// Party is a customer exposed to a screened name, with the activity that
+// links them to it
+type Party struct {
+	CustomerID string
+	Amount     float64
+	LastDate   string
+	TransNums  []string
+}
+
+// Subject is a name to screen together with the customers it affects
+type Subject struct {
+	Type    string
+	Name    string
+	Parties []Party
+}
+
+// Wire is an outgoing wire transfer from a wires CSV export
+type Wire struct {
+	WireID       string
+	CustomerID   string
+	Counterparty string
+	Amount       float64
+	Date         string
+}
+
+// MerchantName strips the "fraud_" prefix the source dataset puts on every merchant
+func MerchantName(merchant string) string {
+	return strings.TrimPrefix(merchant, "fraud_")
+}
+
+// CustomerSubjects builds one subject per cardholder name
+func CustomerSubjects(transactions []aml.Transaction) []Subject {
+	parties := map[string]*Party{}
+	names := map[string]string{}
+	for _, txn := range transactions {
+		id := txn.CustomerID()
+		names[id] = txn.First + " " + txn.Last
+		addActivity(parties, id, txn.Amount, txn.Date(), txn.TransNum)
+	}
+
+	subjects := make([]Subject, 0, len(parties))
+	for id, party := range parties {
+		subjects = append(subjects, Subject{Type: SubjectCustomer, Name: names[id], Parties: []Party{*party}})
+	}
+	sortSubjects(subjects)
+	return subjects
+}
+
+// MerchantSubjects builds one subject per merchant, listing every customer
+// who paid it
+func MerchantSubjects(transactions []aml.Transaction) []Subject {
+	byMerchant := map[string]map[string]*Party{}
+	for _, txn := range transactions {
+		name := MerchantName(txn.Merchant)
+		if byMerchant[name] == nil {
+			byMerchant[name] = map[string]*Party{}
+		}
+		addActivity(byMerchant[name], txn.CustomerID(), txn.Amount, txn.Date(), txn.TransNum)
+	}
+	return groupedSubjects(SubjectMerchant, byMerchant)
+}
+
+// CounterpartySubjects builds one subject per wire beneficiary
+func CounterpartySubjects(wires []Wire) []Subject {
+	byCounterparty := map[string]map[string]*Party{}
+	for _, wire := range wires {
+		if byCounterparty[wire.Counterparty] == nil {
+			byCounterparty[wire.Counterparty] = map[string]*Party{}
+		}
+		addActivity(byCounterparty[wire.Counterparty], wire.CustomerID, wire.Amount, wire.Date, wire.WireID)
+	}
+	return groupedSubjects(SubjectCounterparty, byCounterparty)
+}
+
+// ReadWiresCSV loads wires from a CSV with the columns wire_id, customer_id,
+// counterparty_name, amount and wire_date
+func ReadWiresCSV(path string) ([]Wire, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open wires file: %v", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read wires header: %v", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, required := range []string{"wire_id", "customer_id", "counterparty_name", "amount", "wire_date"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("wires CSV is missing required column %q", required)
+		}
+	}
+
+	var wires []Wire
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse wires CSV: %v", err)
+		}
+
+		amount, err := strconv.ParseFloat(record[columns["amount"]], 64)
+		if err != nil {
+			return nil, fmt.Errorf("wire %s: invalid amount: %v", record[columns["wire_id"]], err)
+		}
+		wires = append(wires, Wire{
+			WireID:       record[columns["wire_id"]],
+			CustomerID:   record[columns["customer_id"]],
+			Counterparty: record[columns["counterparty_name"]],
+			Amount:       amount,
+			Date:         record[columns["wire_date"]],
+		})
+	}
+	return wires, nil
+}
+
+// Hit is a subject with at least one match, ready to become alerts
+type Hit struct {
+	Subject Subject
+	Matches []Match
+}
+
+// MatchRecord is a row of sanctions_matches kept for analyst review
+type MatchRecord struct {
+	AlertID       int64     `json:"alert_id"`
+	CustomerID    string    `json:"customer_id"`
+	SubjectType   string    `json:"subject_type"`
+	SubjectName   string    `json:"subject_name"`
+	MatchRank     int64     `json:"match_rank"`
+	ListSource    string    `json:"list_source"`
+	ListUID       string    `json:"list_uid"`
+	ListName      string    `json:"list_name"`
+	MatchedName   string    `json:"matched_name"`
+	EntryType     string    `json:"entry_type"`
+	Programs      []string  `json:"programs"`
+	Score         float64   `json:"score"`
+	JaroWinkler   float64   `json:"jaro_winkler"`
+	TokenSetScore float64   `json:"token_set_score"`
+	ReviewStatus  string    `json:"review_status"`
+	ScreenedAt    time.Time `json:"screened_at"`
+}
+
+// Alerts turns hits into one SANCTIONS_HIT alert per affected customer.
+// The returned slice of matches lines up with the alerts.
+func Alerts(hits []Hit) ([]aml.Alert, [][]Match) {
+	var alerts []aml.Alert
+	var matches [][]Match
+	for _, hit := range hits {
+		best := hit.Matches[0]
+		score := aml.ClampScore(int64(best.Score*100 + 0.5))
+
+		for _, party := range hit.Subject.Parties {
+			alerts = append(alerts, aml.Alert{
+				CustomerID:  party.CustomerID,
+				AlertDate:   party.LastDate,
+				AlertType:   aml.AlertSanctionsHit,
+				RiskScore:   score,
+				Description: describeHit(hit.Subject, best, len(hit.Matches)),
+				Priority:    aml.PriorityHigh,
+				TotalAmount: party.Amount,
+				TransNums:   party.TransNums,
+			})
+			matches = append(matches, hit.Matches)
+		}
+	}
+	return alerts, matches
+}
+
+// MatchRecords builds the review rows for a stored alert
+func MatchRecords(alert aml.Alert, subject Subject, matches []Match, screenedAt time.Time) []MatchRecord {
+	records := make([]MatchRecord, 0, len(matches))
+	for i, match := range matches {
+		records = append(records, MatchRecord{
+			AlertID:       alert.AlertID,
+			CustomerID:    alert.CustomerID,
+			SubjectType:   subject.Type,
+			SubjectName:   subject.Name,
+			MatchRank:     int64(i + 1),
+			ListSource:    match.Entry.Source,
+			ListUID:       match.Entry.UID,
+			ListName:      match.Entry.Name,
+			MatchedName:   match.MatchedName,
+			EntryType:     match.Entry.EntryType,
+			Programs:      match.Entry.Programs,
+			Score:         match.Score,
+			JaroWinkler:   match.JaroWinkler,
+			TokenSetScore: match.TokenSet,
+			ReviewStatus:  ReviewPending,
+			ScreenedAt:    screenedAt,
+		})
+	}
+	return records
+}
+
+func describeHit(subject Subject, best Match, count int) string {
+	var who string
+	switch subject.Type {
+	case SubjectMerchant:
+		who = fmt.Sprintf("Paid merchant '%s' which", subject.Name)
+	case SubjectCounterparty:
+		who = fmt.Sprintf("Sent wires to '%s' which", subject.Name)
+	default:
+		who = fmt.Sprintf("Cardholder name '%s'", subject.Name)
+	}
+
+	description := fmt.Sprintf("%s matches %s entry '%s' (%s) with score %.2f",
+		who, best.Entry.Source, best.MatchedName, strings.Join(best.Entry.Programs, ", "), best.Score)
+	if count > 1 {
+		description += fmt.Sprintf("; %d list entries matched", count)
+	}
+	return description
+}
+
+func addActivity(parties map[string]*Party, customerID string, amount float64, date, ref string) {
+	party, ok := parties[customerID]
+	if !ok {
+		party = &Party{CustomerID: customerID}
+		parties[customerID] = party
+	}
+	party.Amount += amount
+	if date > party.LastDate {
+		party.LastDate = date
+	}
+	if ref != "" {
+		party.TransNums = append(party.TransNums, ref)
+	}
+}
+
+func groupedSubjects(subjectType string, grouped map[string]map[string]*Party) []Subject {
+	subjects := make([]Subject, 0, len(grouped))
+	for name, parties := range grouped {
+		subject := Subject{Type: subjectType, Name: name}
+		for _, party := range parties {
+			subject.Parties = append(subject.Parties, *party)
+		}
+		sort.Slice(subject.Parties, func(i, j int) bool {
+			return subject.Parties[i].CustomerID < subject.Parties[j].CustomerID
+		})
+		subjects = append(subjects, subject)
+	}
+	sortSubjects(subjects)
+	return subjects
+}
+
+func sortSubjects(subjects []Subject) {
+	sort.Slice(subjects, func(i, j int) bool {
+		return subjects[i].Name < subjects[j].Name
+	})
+}
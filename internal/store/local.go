@@ -0,0 +1,226 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// Local is a file-backed Store for development. Each table is a JSON array
+// in <dir>/<table>.json; transactions are read from <dir>/credit_card_transactions.csv.
+type Local struct {
+	dir string
+	mu  sync.Mutex
+
+	transactions []aml.Transaction
+}
+
+// OpenLocal opens (and creates if needed) a local store directory
+func OpenLocal(dir string) (*Local, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create local store %s: %v", dir, err)
+	}
+	return &Local{dir: dir}, nil
+}
+
+// Dir returns the directory backing the store
+func (l *Local) Dir() string {
+	return l.dir
+}
+
+func (l *Local) path(table string) string {
+	return filepath.Join(l.dir, table+".json")
+}
+
+// Transactions reads the transactions CSV once and filters it by time
+func (l *Local) Transactions(ctx context.Context, since time.Time) ([]aml.Transaction, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.transactions == nil {
+		csvPath := filepath.Join(l.dir, TransactionsTable+".csv")
+		transactions, err := aml.ReadTransactionsCSV(csvPath)
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(transactions, func(i, j int) bool {
+			return transactions[i].TransDateTransTime.Before(transactions[j].TransDateTransTime)
+		})
+		l.transactions = transactions
+	}
+
+	start := sort.Search(len(l.transactions), func(i int) bool {
+		return l.transactions[i].TransDateTransTime.After(since)
+	})
+	result := make([]aml.Transaction, len(l.transactions)-start)
+	copy(result, l.transactions[start:])
+	return result, nil
+}
+
+// CustomerTransactions filters the transactions by customer ID
+func (l *Local) CustomerTransactions(ctx context.Context, customerID string, since time.Time) ([]aml.Transaction, error) {
+	transactions, err := l.Transactions(ctx, since)
+	if err != nil {
+		return nil, err
+	}
+	var result []aml.Transaction
+	for _, txn := range transactions {
+		if txn.CustomerID() == customerID {
+			result = append(result, txn)
+		}
+	}
+	return result, nil
+}
+
+// Load decodes the table file into dst. A missing table loads as empty.
+func (l *Local) Load(ctx context.Context, table string, dst interface{}) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.load(table, dst)
+}
+
+func (l *Local) load(table string, dst interface{}) error {
+	data, err := os.ReadFile(l.path(table))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read table %s: %v", table, err)
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return fmt.Errorf("failed to decode table %s: %v", table, err)
+	}
+	return nil
+}
+
+// Append adds rows to the end of the table file
+func (l *Local) Append(ctx context.Context, table string, rows interface{}) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var existing []json.RawMessage
+	if err := l.load(table, &existing); err != nil {
+		return err
+	}
+
+	added, err := toRawRows(rows)
+	if err != nil {
+		return fmt.Errorf("failed to encode rows for %s: %v", table, err)
+	}
+
+	return l.write(table, append(existing, added...))
+}
+
+// Replace overwrites the table file with rows
+func (l *Local) Replace(ctx context.Context, table string, rows interface{}) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	replaced, err := toRawRows(rows)
+	if err != nil {
+		return fmt.Errorf("failed to encode rows for %s: %v", table, err)
+	}
+
+	return l.write(table, replaced)
+}
+
+// Update rewrites the table file with the matching rows changed
+func (l *Local) Update(ctx context.Context, table, keyColumn, column string, values map[int64]string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var rows []json.RawMessage
+	if err := l.load(table, &rows); err != nil {
+		return err
+	}
+	for i, raw := range rows {
+		var row map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &row); err != nil {
+			return fmt.Errorf("failed to decode table %s: %v", table, err)
+		}
+		var key int64
+		if err := json.Unmarshal(row[keyColumn], &key); err != nil {
+			continue
+		}
+		value, ok := values[key]
+		if !ok {
+			continue
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return fmt.Errorf("failed to encode rows for %s: %v", table, err)
+		}
+		row[column] = encoded
+		if rows[i], err = json.Marshal(row); err != nil {
+			return fmt.Errorf("failed to encode rows for %s: %v", table, err)
+		}
+	}
+	return l.write(table, rows)
+}
+
+// NextID scans the table for the largest numeric value in column
+func (l *Local) NextID(ctx context.Context, table, column string) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var rows []map[string]interface{}
+	if err := l.load(table, &rows); err != nil {
+		return 0, err
+	}
+
+	var maxID int64
+	for _, row := range rows {
+		if value, ok := row[column].(float64); ok && int64(value) > maxID {
+			maxID = int64(value)
+		}
+	}
+	return maxID + 1, nil
+}
+
+// Close is a no-op for the local store
+func (l *Local) Close() error {
+	return nil
+}
+
+func (l *Local) write(table string, rows []json.RawMessage) error {
+	if rows == nil {
+		rows = []json.RawMessage{}
+	}
+	data, err := json.MarshalIndent(rows, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode table %s: %v", table, err)
+	}
+
+	tmp := l.path(table) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write table %s: %v", table, err)
+	}
+	return os.Rename(tmp, l.path(table))
+}
+
+// toRawRows encodes each element of a slice as its own JSON document
+func toRawRows(rows interface{}) ([]json.RawMessage, error) {
+	value := reflect.ValueOf(rows)
+	if value.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("rows must be a slice, got %T", rows)
+	}
+
+	raw := make([]json.RawMessage, 0, value.Len())
+	for i := 0; i < value.Len(); i++ {
+		data, err := json.Marshal(value.Index(i).Interface())
+		if err != nil {
+			return nil, err
+		}
+		raw = append(raw, data)
+	}
+	return raw, nil
+}
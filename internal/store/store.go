@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"aml-system/internal/aml"
+)
+
+// Table names shared by the BigQuery dataset and the local store
+const (
+	TransactionsTable      = "credit_card_transactions"
+	AlertsTable            = "aml_alerts_level1"
+	AlertTransactionsTable = "alert_transactions"
+	ProcessingMetadata     = "processing_metadata"
+)
+
+// Store is the persistence layer used by the Go tools. Rows are passed as
+// slices of structs with json tags matching the table columns.
+type Store interface {
+	// Transactions returns transactions strictly after since, ordered by time
+	Transactions(ctx context.Context, since time.Time) ([]aml.Transaction, error)
+
+	// CustomerTransactions returns one customer's transactions strictly
+	// after since, ordered by time
+	CustomerTransactions(ctx context.Context, customerID string, since time.Time) ([]aml.Transaction, error)
+
+	// Load reads every row of table into dst, a pointer to a slice
+	Load(ctx context.Context, table string, dst interface{}) error
+
+	// Append adds rows to table
+	Append(ctx context.Context, table string, rows interface{}) error
+
+	// Replace overwrites the contents of table with rows
+	Replace(ctx context.Context, table string, rows interface{}) error
+
+	// Update sets column to values[key] in the rows of table whose
+	// keyColumn is key, leaving every other row as it is
+	Update(ctx context.Context, table, keyColumn, column string, values map[int64]string) error
+
+	// NextID returns one more than the largest value of column in table
+	NextID(ctx context.Context, table, column string) (int64, error)
+
+	Close() error
+}
+
+// InsertAlerts assigns alert IDs, fills in the standard status columns and
+// writes the alerts together with their triggering transactions
+func InsertAlerts(ctx context.Context, s Store, alerts []aml.Alert) ([]aml.Alert, error) {
+	if len(alerts) == 0 {
+		return alerts, nil
+	}
+
+	nextID, err := s.NextID(ctx, AlertsTable, "alert_id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate alert IDs: %v", err)
+	}
+
+	now := time.Now().UTC()
+	var links []aml.AlertTransaction
+	for i := range alerts {
+		alert := &alerts[i]
+		alert.AlertID = nextID + int64(i)
+		if alert.Status == "" {
+			alert.Status = aml.StatusOpen
+		}
+		if alert.Priority == "" {
+			alert.Priority = aml.PriorityForScore(alert.RiskScore)
+		}
+		if alert.DetectionDate == "" {
+			alert.DetectionDate = now.Format(aml.DateLayout)
+		}
+		if alert.CreatedAt.IsZero() {
+			alert.CreatedAt = now
+		}
+		for _, transNum := range alert.TransNums {
+			links = append(links, aml.AlertTransaction{AlertID: alert.AlertID, TransNum: transNum})
+		}
+	}
+
+	if err := s.Append(ctx, AlertsTable, alerts); err != nil {
+		return nil, fmt.Errorf("failed to insert alerts: %v", err)
+	}
+	if len(links) > 0 {
+		if err := s.Append(ctx, AlertTransactionsTable, links); err != nil {
+			return nil, fmt.Errorf("failed to insert alert transactions: %v", err)
+		}
+	}
+
+	return alerts, nil
+}
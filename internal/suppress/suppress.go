@@ -0,0 +1,408 @@
+// Package suppress keeps the rules that suppress alerts on activity known
+// to be legitimate, such as a travelling salesperson tripping GEOGRAPHIC or
+// payroll paid through one merchant. A rule is scoped to a customer and/or
+// merchant, optionally narrowed to a detector and a range of alert dates,
+// and carries a reason and an expiry. It applies once approved by someone
+// other than its author, until it expires or is revoked. Matching alerts are
+// dropped before insertion and recorded in suppressed_alerts.
+package suppress
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/store"
+)
+
+// Tables of the suppression rules
+const (
+	RulesTable  = "suppression_rules"
+	EventsTable = "suppression_rule_events"
+	HitsTable   = "suppressed_alerts"
+)
+
+// MaxDays is the longest a rule may run before it has to be renewed
+const MaxDays = 366
+
+// Rule statuses, derived from the events of a rule
+const (
+	StatusProposed = "PROPOSED"
+	StatusActive   = "ACTIVE"
+	StatusExpired  = "EXPIRED"
+	StatusRevoked  = "REVOKED"
+)
+
+// Event actions
+const (
+	ActionApprove = "APPROVE"
+	ActionRevoke  = "REVOKE"
+)
+
+// Rule is one suppression rule, stored in suppression_rules. Empty scope
+// fields match anything; a rule names a customer, a merchant or both.
+type Rule struct {
+	RuleID     int64     `json:"rule_id"`
+	CustomerID string    `json:"customer_id"`
+	Merchant   string    `json:"merchant"`
+	Detector   string    `json:"detector"`            // alert type
+	FromDate   string    `json:"from_date,omitempty"` // first alert date covered
+	ToDate     string    `json:"to_date,omitempty"`   // last alert date covered
+	Reason     string    `json:"reason"`
+	Author     string    `json:"author"`
+	Expires    string    `json:"expires"` // last day the rule applies
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Event records the approval or revocation of a rule, stored in
+// suppression_rule_events. Events are only ever appended.
+type Event struct {
+	RuleID  int64     `json:"rule_id"`
+	Action  string    `json:"action"`
+	Actor   string    `json:"actor"`
+	Comment string    `json:"comment"`
+	At      time.Time `json:"at"`
+}
+
+// Hit is an alert a rule suppressed, stored in suppressed_alerts with the
+// detection run it was raised in
+type Hit struct {
+	RuleID       int64     `json:"rule_id"`
+	Source       string    `json:"source"`
+	RunID        int64     `json:"run_id"`
+	CustomerID   string    `json:"customer_id"`
+	AlertType    string    `json:"alert_type"`
+	AlertDate    string    `json:"alert_date"`
+	RiskScore    int64     `json:"risk_score"`
+	TotalAmount  float64   `json:"total_amount"`
+	Description  string    `json:"description"`
+	SuppressedAt time.Time `json:"suppressed_at"`
+}
+
+// unsuppressible alert types are screening hits, which are reviewed rather
+// than suppressed
+var unsuppressible = map[string]bool{
+	aml.AlertSanctionsHit: true,
+	aml.AlertWatchlist:    true,
+}
+
+// Scope describes what the rule covers, e.g. "customer Jane_Doe, GEOGRAPHIC"
+func (r Rule) Scope() string {
+	var parts []string
+	if r.CustomerID != "" {
+		parts = append(parts, "customer "+r.CustomerID)
+	}
+	if r.Merchant != "" {
+		parts = append(parts, "merchant "+r.Merchant)
+	}
+	if r.Detector != "" {
+		parts = append(parts, r.Detector)
+	} else {
+		parts = append(parts, "all detectors")
+	}
+	switch {
+	case r.FromDate != "" && r.ToDate != "":
+		parts = append(parts, r.FromDate+" to "+r.ToDate)
+	case r.FromDate != "":
+		parts = append(parts, "from "+r.FromDate)
+	case r.ToDate != "":
+		parts = append(parts, "to "+r.ToDate)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Validate checks a new rule on date now
+func (r Rule) Validate(now time.Time) error {
+	if r.CustomerID == "" && r.Merchant == "" {
+		return fmt.Errorf("a rule needs a customer or a merchant")
+	}
+	if unsuppressible[r.Detector] {
+		return fmt.Errorf("%s alerts cannot be suppressed", r.Detector)
+	}
+	if strings.TrimSpace(r.Reason) == "" {
+		return fmt.Errorf("a reason is required")
+	}
+	if strings.TrimSpace(r.Author) == "" {
+		return fmt.Errorf("an author is required")
+	}
+	for _, date := range []string{r.FromDate, r.ToDate} {
+		if _, err := time.Parse(aml.DateLayout, date); date != "" && err != nil {
+			return fmt.Errorf("invalid date %q: %v", date, err)
+		}
+	}
+	if r.FromDate != "" && r.ToDate != "" && r.FromDate > r.ToDate {
+		return fmt.Errorf("from date %s is after to date %s", r.FromDate, r.ToDate)
+	}
+	expires, err := time.Parse(aml.DateLayout, r.Expires)
+	if err != nil {
+		return fmt.Errorf("an expiry date is required (YYYY-MM-DD)")
+	}
+	today := now.Format(aml.DateLayout)
+	if r.Expires < today {
+		return fmt.Errorf("expiry %s is in the past", r.Expires)
+	}
+	if limit := now.AddDate(0, 0, MaxDays); expires.After(limit) {
+		return fmt.Errorf("expiry %s is more than %d days ahead; rules must be renewed", r.Expires, MaxDays)
+	}
+	return nil
+}
+
+// Activity gives the merchants behind alerts: those of the alert's
+// triggering transactions or, for alerts that list none, of the customer's
+// transactions on the alert date
+type Activity struct {
+	merchants map[string]string          // trans_num → merchant
+	days      map[string]map[string]bool // customer|date → merchants
+}
+
+// NewActivity indexes the transactions alerts were raised on
+func NewActivity(transactions []aml.Transaction) *Activity {
+	a := &Activity{
+		merchants: make(map[string]string, len(transactions)),
+		days:      map[string]map[string]bool{},
+	}
+	for _, txn := range transactions {
+		a.merchants[txn.TransNum] = txn.Merchant
+		key := txn.CustomerID() + "|" + txn.Date()
+		if a.days[key] == nil {
+			a.days[key] = map[string]bool{}
+		}
+		a.days[key][txn.Merchant] = true
+	}
+	return a
+}
+
+// onlyAt reports whether all the activity behind alert was at merchant.
+// An alert raised on a merchant is at that merchant alone.
+func (a *Activity) onlyAt(alert aml.Alert, merchant string) bool {
+	if m, ok := alert.Merchant(); ok {
+		return m == merchant
+	}
+	seen := map[string]bool{}
+	for _, transNum := range alert.TransNums {
+		if m, ok := a.merchants[transNum]; ok {
+			seen[m] = true
+		}
+	}
+	if len(seen) == 0 {
+		seen = a.days[alert.CustomerID+"|"+alert.AlertDate]
+	}
+	if len(seen) == 0 {
+		return false
+	}
+	for m := range seen {
+		if m != merchant {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches reports whether the rule covers alert
+func (r Rule) Matches(alert aml.Alert, activity *Activity) bool {
+	if unsuppressible[alert.AlertType] {
+		return false
+	}
+	if r.CustomerID != "" && r.CustomerID != alert.CustomerID {
+		return false
+	}
+	if r.Detector != "" && r.Detector != alert.AlertType {
+		return false
+	}
+	if r.FromDate != "" && alert.AlertDate < r.FromDate {
+		return false
+	}
+	if r.ToDate != "" && alert.AlertDate > r.ToDate {
+		return false
+	}
+	return r.Merchant == "" || activity.onlyAt(alert, r.Merchant)
+}
+
+// Registry holds every rule and event
+type Registry struct {
+	Rules  []Rule
+	Events []Event
+}
+
+// Load reads the suppression rules
+func Load(ctx context.Context, st store.Store) (*Registry, error) {
+	r := &Registry{}
+	if err := st.Load(ctx, RulesTable, &r.Rules); err != nil {
+		return nil, fmt.Errorf("failed to load suppression rules: %v", err)
+	}
+	if err := st.Load(ctx, EventsTable, &r.Events); err != nil {
+		return nil, fmt.Errorf("failed to load suppression rule events: %v", err)
+	}
+	sort.Slice(r.Rules, func(i, j int) bool { return r.Rules[i].RuleID < r.Rules[j].RuleID })
+	sort.SliceStable(r.Events, func(i, j int) bool { return r.Events[i].At.Before(r.Events[j].At) })
+	return r, nil
+}
+
+// Get returns a rule by ID
+func (r *Registry) Get(ruleID int64) (Rule, error) {
+	for _, rule := range r.Rules {
+		if rule.RuleID == ruleID {
+			return rule, nil
+		}
+	}
+	return Rule{}, fmt.Errorf("no suppression rule %d", ruleID)
+}
+
+// Decision returns the latest event of an action on a rule
+func (r *Registry) Decision(ruleID int64, action string) (Event, bool) {
+	var found Event
+	ok := false
+	for _, e := range r.Events {
+		if e.RuleID == ruleID && e.Action == action {
+			found, ok = e, true
+		}
+	}
+	return found, ok
+}
+
+// Status derives a rule's status on date from its events and expiry
+func (r *Registry) Status(rule Rule, date time.Time) string {
+	if _, revoked := r.Decision(rule.RuleID, ActionRevoke); revoked {
+		return StatusRevoked
+	}
+	if rule.Expires < date.Format(aml.DateLayout) {
+		return StatusExpired
+	}
+	if _, approved := r.Decision(rule.RuleID, ActionApprove); approved {
+		return StatusActive
+	}
+	return StatusProposed
+}
+
+// Active returns the rules in force on date
+func (r *Registry) Active(date time.Time) []Rule {
+	var active []Rule
+	for _, rule := range r.Rules {
+		if r.Status(rule, date) == StatusActive {
+			active = append(active, rule)
+		}
+	}
+	return active
+}
+
+// Apply finds the alerts an active rule covers. It reports whether each
+// alert is suppressed, and returns a hit, credited to the lowest-numbered
+// matching rule, for each one that is.
+func (r *Registry) Apply(alerts []aml.Alert, activity *Activity, source string, now time.Time) ([]bool, []Hit) {
+	suppressed := make([]bool, len(alerts))
+	active := r.Active(now)
+	if len(active) == 0 {
+		return suppressed, nil
+	}
+	var hits []Hit
+	for i, alert := range alerts {
+		for _, rule := range active {
+			if rule.Matches(alert, activity) {
+				hits = append(hits, Hit{
+					RuleID:       rule.RuleID,
+					Source:       source,
+					CustomerID:   alert.CustomerID,
+					AlertType:    alert.AlertType,
+					AlertDate:    alert.AlertDate,
+					RiskScore:    alert.RiskScore,
+					TotalAmount:  alert.TotalAmount,
+					Description:  alert.Description,
+					SuppressedAt: now,
+				})
+				suppressed[i] = true
+				break
+			}
+		}
+	}
+	return suppressed, hits
+}
+
+// Propose stores a new rule. It applies once approved.
+func Propose(ctx context.Context, st store.Store, r *Registry, rule Rule, now time.Time) (Rule, error) {
+	rule.Detector = strings.ToUpper(rule.Detector)
+	if err := rule.Validate(now); err != nil {
+		return Rule{}, err
+	}
+	next, err := st.NextID(ctx, RulesTable, "rule_id")
+	if err != nil {
+		return Rule{}, fmt.Errorf("failed to allocate a suppression rule ID: %v", err)
+	}
+	rule.RuleID = next
+	rule.CreatedAt = now
+	if err := st.Append(ctx, RulesTable, []Rule{rule}); err != nil {
+		return Rule{}, fmt.Errorf("failed to store suppression rule: %v", err)
+	}
+	r.Rules = append(r.Rules, rule)
+	return rule, nil
+}
+
+// Approve puts a proposed rule in force. The approver must not be the
+// author.
+func Approve(ctx context.Context, st store.Store, r *Registry, ruleID int64, approver, comment string, now time.Time) (Event, error) {
+	rule, err := r.Get(ruleID)
+	if err != nil {
+		return Event{}, err
+	}
+	if strings.TrimSpace(approver) == "" {
+		return Event{}, fmt.Errorf("an approver is required")
+	}
+	if strings.EqualFold(approver, rule.Author) {
+		return Event{}, fmt.Errorf("rule %d was proposed by %s and needs another approver", ruleID, rule.Author)
+	}
+	if status := r.Status(rule, now); status != StatusProposed {
+		return Event{}, fmt.Errorf("rule %d is %s, not %s", ruleID, status, StatusProposed)
+	}
+	return appendEvent(ctx, st, r, Event{RuleID: ruleID, Action: ActionApprove, Actor: approver, Comment: comment, At: now})
+}
+
+// Revoke withdraws a rule before its expiry. A reason is required.
+func Revoke(ctx context.Context, st store.Store, r *Registry, ruleID int64, actor, comment string, now time.Time) (Event, error) {
+	rule, err := r.Get(ruleID)
+	if err != nil {
+		return Event{}, err
+	}
+	if strings.TrimSpace(actor) == "" {
+		return Event{}, fmt.Errorf("the revoking user is required")
+	}
+	if strings.TrimSpace(comment) == "" {
+		return Event{}, fmt.Errorf("a revocation needs a comment")
+	}
+	if status := r.Status(rule, now); status == StatusRevoked || status == StatusExpired {
+		return Event{}, fmt.Errorf("rule %d is already %s", ruleID, status)
+	}
+	return appendEvent(ctx, st, r, Event{RuleID: ruleID, Action: ActionRevoke, Actor: actor, Comment: comment, At: now})
+}
+
+func appendEvent(ctx context.Context, st store.Store, r *Registry, e Event) (Event, error) {
+	if err := st.Append(ctx, EventsTable, []Event{e}); err != nil {
+		return Event{}, fmt.Errorf("failed to record %s of rule %d: %v", strings.ToLower(e.Action), e.RuleID, err)
+	}
+	r.Events = append(r.Events, e)
+	return e, nil
+}
+
+// RecordHits stores the suppressed alerts of a run
+func RecordHits(ctx context.Context, st store.Store, hits []Hit, runID int64) error {
+	if len(hits) == 0 {
+		return nil
+	}
+	for i := range hits {
+		hits[i].RunID = runID
+	}
+	if err := st.Append(ctx, HitsTable, hits); err != nil {
+		return fmt.Errorf("failed to store suppressed alerts: %v", err)
+	}
+	return nil
+}
+
+// Counts returns the number of hits per rule
+func Counts(hits []Hit) map[int64]int {
+	counts := map[int64]int{}
+	for _, hit := range hits {
+		counts[hit.RuleID]++
+	}
+	return counts
+}
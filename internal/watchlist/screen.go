@@ -0,0 +1,122 @@
+package watchlist
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/screening"
+)
+
+// Hit is a customer matched to a watchlist entry, stored in watchlist_hits.
+// The risk profile SQL reads the risk_category of unresolved hits as a floor.
+type Hit struct {
+	AlertID      int64     `json:"alert_id"`
+	CustomerID   string    `json:"customer_id"`
+	CustomerName string    `json:"customer_name"`
+	ListName     string    `json:"list_name"`
+	Category     string    `json:"category"`
+	EntryID      string    `json:"entry_id"`
+	EntryName    string    `json:"entry_name"`
+	MatchedName  string    `json:"matched_name"`
+	Score        float64   `json:"score"`
+	RiskCategory string    `json:"risk_category"`
+	ReviewStatus string    `json:"review_status"`
+	ScreenedAt   time.Time `json:"screened_at"`
+}
+
+// Screen matches customers against all entries and returns one WATCHLIST
+// alert per customer and list, skipping customer/entry pairs already in
+// previous. hits[i] belongs to alerts[i]; its AlertID is set once stored.
+func Screen(customers []screening.Subject, lists []List, entries []Entry, threshold float64, previous []Hit) ([]aml.Alert, []Hit) {
+	listsByName := map[string]List{}
+	for _, list := range lists {
+		listsByName[list.ListName] = list
+	}
+	seen := map[string]bool{}
+	for _, hit := range previous {
+		seen[hit.CustomerID+"|"+hit.ListName+"|"+hit.EntryID] = true
+	}
+
+	screener := screening.NewScreener(ScreeningEntries(entries), threshold)
+	screenedAt := time.Now().UTC()
+
+	var alerts []aml.Alert
+	var hits []Hit
+	for _, customer := range customers {
+		party := customer.Parties[0]
+
+		// Keep the strongest match per list
+		best := map[string]screening.Match{}
+		for _, match := range screener.Screen(screening.SubjectCustomer, customer.Name) {
+			if seen[party.CustomerID+"|"+match.Entry.Source+"|"+match.Entry.UID] {
+				continue
+			}
+			if _, ok := best[match.Entry.Source]; !ok {
+				best[match.Entry.Source] = match
+			}
+		}
+
+		listNames := make([]string, 0, len(best))
+		for name := range best {
+			listNames = append(listNames, name)
+		}
+		sort.Strings(listNames)
+
+		for _, name := range listNames {
+			match := best[name]
+			list := listsByName[name]
+			score := aml.ClampScore(int64(match.Score*categoryWeight[list.Category] + 0.5))
+
+			alerts = append(alerts, aml.Alert{
+				CustomerID: party.CustomerID,
+				AlertDate:  party.LastDate,
+				AlertType:  aml.AlertWatchlist,
+				RiskScore:  score,
+				Description: fmt.Sprintf("Customer '%s' matches %s watchlist '%s' entry '%s' with score %.2f; risk category raised to %s",
+					customer.Name, list.Category, list.ListName, match.MatchedName, match.Score, list.RiskCategory),
+				TotalAmount: party.Amount,
+			})
+			hits = append(hits, Hit{
+				CustomerID:   party.CustomerID,
+				CustomerName: customer.Name,
+				ListName:     list.ListName,
+				Category:     list.Category,
+				EntryID:      match.Entry.UID,
+				EntryName:    match.Entry.Name,
+				MatchedName:  match.MatchedName,
+				Score:        match.Score,
+				RiskCategory: list.RiskCategory,
+				ReviewStatus: screening.ReviewPending,
+				ScreenedAt:   screenedAt,
+			})
+		}
+	}
+	return alerts, hits
+}
+
+// RaiseProfiles lifts the risk_category of profile rows to the floor set by
+// hits and returns how many rows changed. Rows are decoded generically so
+// columns this package does not know about are preserved.
+func RaiseProfiles(profiles []map[string]interface{}, hits []Hit) int {
+	floors := map[string]string{}
+	for _, hit := range hits {
+		floors[hit.CustomerID] = aml.RaiseRiskCategory(floors[hit.CustomerID], hit.RiskCategory)
+	}
+
+	changed := 0
+	for _, profile := range profiles {
+		id, _ := profile["customer_id"].(string)
+		floor, ok := floors[id]
+		if !ok {
+			continue
+		}
+		current, _ := profile["risk_category"].(string)
+		if raised := aml.RaiseRiskCategory(current, floor); raised != current {
+			profile["risk_category"] = raised
+			changed++
+		}
+	}
+	return changed
+}
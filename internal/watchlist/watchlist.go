@@ -0,0 +1,285 @@
+// Package watchlist implements PEP, adverse media and internal watchlists.
+// Lists share one generic model and are matched with the sanctions screener.
+package watchlist
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"aml-system/internal/aml"
+	"aml-system/internal/screening"
+)
+
+// Tables used by watchlist screening
+const (
+	ListsTable   = "watchlists"
+	EntriesTable = "watchlist_entries"
+	HitsTable    = "watchlist_hits"
+)
+
+// Watchlist categories
+const (
+	CategoryPEP          = "PEP"
+	CategoryAdverseMedia = "ADVERSE_MEDIA"
+	CategoryInternal     = "INTERNAL"
+)
+
+// defaultRiskCategory is the customer risk category a hit raises a profile
+// to when the list does not set one
+var defaultRiskCategory = map[string]string{
+	CategoryPEP:          aml.RiskHigh,
+	CategoryAdverseMedia: aml.RiskHigh,
+	CategoryInternal:     aml.RiskCritical,
+}
+
+// categoryWeight scales the match score into an alert risk score, so an
+// exact internal blacklist hit scores 100 and an exact PEP hit scores 80
+var categoryWeight = map[string]float64{
+	CategoryPEP:          80,
+	CategoryAdverseMedia: 90,
+	CategoryInternal:     100,
+}
+
+// entryTypes are the entry types a list file may give
+var entryTypes = []string{screening.EntryIndividual, screening.EntryEntity, screening.EntryVessel, screening.EntryAircraft}
+
+// Categories lists the supported watchlist categories
+func Categories() []string {
+	return []string{CategoryPEP, CategoryAdverseMedia, CategoryInternal}
+}
+
+// List describes one watchlist, stored in watchlists
+type List struct {
+	ListName     string    `json:"list_name"`
+	Category     string    `json:"category"`
+	RiskCategory string    `json:"risk_category"`
+	Description  string    `json:"description"`
+	EntryCount   int64     `json:"entry_count"`
+	ImportedAt   time.Time `json:"imported_at"`
+}
+
+// Entry is a person or organisation on a watchlist, stored in watchlist_entries
+type Entry struct {
+	ListName  string   `json:"list_name"`
+	Category  string   `json:"category"`
+	EntryID   string   `json:"entry_id"`
+	Name      string   `json:"name"`
+	Aliases   []string `json:"aliases"`
+	EntryType string   `json:"entry_type"`
+	Notes     string   `json:"notes"`
+}
+
+// jsonFile is the layout accepted by LoadFile for .json lists. Settings in
+// the file override the command line.
+type jsonFile struct {
+	ListName     string `json:"list_name"`
+	Category     string `json:"category"`
+	RiskCategory string `json:"risk_category"`
+	Description  string `json:"description"`
+	Entries      []struct {
+		ID        string   `json:"id"`
+		Name      string   `json:"name"`
+		Aliases   []string `json:"aliases"`
+		EntryType string   `json:"entry_type"`
+		Notes     string   `json:"notes"`
+	} `json:"entries"`
+}
+
+// Validate checks the list settings and fills in the default risk category
+func (l *List) Validate() error {
+	if l.ListName == "" {
+		return fmt.Errorf("list name is required")
+	}
+	if _, ok := categoryWeight[l.Category]; !ok {
+		return fmt.Errorf("unknown category %q (expected one of %s)", l.Category, strings.Join(Categories(), ", "))
+	}
+	if l.RiskCategory == "" {
+		l.RiskCategory = defaultRiskCategory[l.Category]
+	}
+	if !aml.ValidRiskCategory(l.RiskCategory) {
+		return fmt.Errorf("invalid risk category %q", l.RiskCategory)
+	}
+	return nil
+}
+
+// LoadFile reads a CSV or JSON list file. CSV files need the columns
+// entry_id and name; aliases (separated by |), entry_type and notes are optional.
+func LoadFile(path string, list List) (List, []Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return list, nil, fmt.Errorf("failed to open list file: %v", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		list, entries, err = parseJSON(file, list)
+	case ".csv":
+		entries, err = parseCSV(file)
+	default:
+		return list, nil, fmt.Errorf("unsupported list file %s (expected .csv or .json)", path)
+	}
+	if err != nil {
+		return list, nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if err := list.Validate(); err != nil {
+		return list, nil, err
+	}
+	for i := range entries {
+		entries[i].ListName = list.ListName
+		entries[i].Category = list.Category
+		if entries[i].EntryID == "" {
+			entries[i].EntryID = fmt.Sprintf("%s-%d", list.ListName, i+1)
+		}
+	}
+	list.EntryCount = int64(len(entries))
+	return list, entries, nil
+}
+
+func parseJSON(r io.Reader, list List) (List, []Entry, error) {
+	var raw jsonFile
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return list, nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+
+	if raw.ListName != "" {
+		list.ListName = raw.ListName
+	}
+	if raw.Category != "" {
+		list.Category = strings.ToUpper(raw.Category)
+	}
+	if raw.RiskCategory != "" {
+		list.RiskCategory = strings.ToUpper(raw.RiskCategory)
+	}
+	if raw.Description != "" {
+		list.Description = raw.Description
+	}
+
+	entries := make([]Entry, 0, len(raw.Entries))
+	for i, e := range raw.Entries {
+		if strings.TrimSpace(e.Name) == "" {
+			continue
+		}
+		entryType, err := parseEntryType(e.EntryType)
+		if err != nil {
+			return list, nil, fmt.Errorf("entry %d: %v", i+1, err)
+		}
+		entries = append(entries, Entry{
+			EntryID:   e.ID,
+			Name:      strings.TrimSpace(e.Name),
+			Aliases:   e.Aliases,
+			EntryType: entryType,
+			Notes:     e.Notes,
+		})
+	}
+	return list, entries, nil
+}
+
+func parseCSV(r io.Reader) ([]Entry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+	}
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["name"]; !ok {
+		return nil, fmt.Errorf("CSV is missing required column \"name\"")
+	}
+
+	var entries []Entry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %v", err)
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		name := field("name")
+		if name == "" {
+			continue
+		}
+		var aliases []string
+		for _, alias := range strings.Split(field("aliases"), "|") {
+			if alias = strings.TrimSpace(alias); alias != "" {
+				aliases = append(aliases, alias)
+			}
+		}
+		entryType, err := parseEntryType(field("entry_type"))
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		entries = append(entries, Entry{
+			EntryID:   field("entry_id"),
+			Name:      name,
+			Aliases:   aliases,
+			EntryType: entryType,
+			Notes:     field("notes"),
+		})
+	}
+	return entries, nil
+}
+
+// parseEntryType normalises an entry_type, refusing types the screener does
+// not know. Entries without one are individuals.
+func parseEntryType(value string) (string, error) {
+	entryType := strings.ToUpper(value)
+	if entryType == "" {
+		return screening.EntryIndividual, nil
+	}
+	for _, known := range entryTypes {
+		if entryType == known {
+			return entryType, nil
+		}
+	}
+	return "", fmt.Errorf("unknown entry_type %q (expected one of %s)", value, strings.Join(entryTypes, ", "))
+}
+
+// ScreeningEntries converts watchlist entries for use with screening.Screener.
+// The list name becomes the source so hits can be traced back to their list.
+func ScreeningEntries(entries []Entry) []screening.Entry {
+	converted := make([]screening.Entry, 0, len(entries))
+	for _, entry := range entries {
+		converted = append(converted, screening.Entry{
+			Source:    entry.ListName,
+			UID:       entry.EntryID,
+			Name:      entry.Name,
+			Aliases:   entry.Aliases,
+			EntryType: entry.EntryType,
+			Programs:  []string{entry.Category},
+		})
+	}
+	return converted
+}
+
+// SortLists orders lists by category then name
+func SortLists(lists []List) {
+	sort.Slice(lists, func(i, j int) bool {
+		if lists[i].Category != lists[j].Category {
+			return lists[i].Category < lists[j].Category
+		}
+		return lists[i].ListName < lists[j].ListName
+	})
+}
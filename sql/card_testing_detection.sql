@@ -0,0 +1,83 @@
+-- ============================================================================
+-- CARD TESTING DETECTION - BigQuery SQL
+-- Detects bursts of micro transactions on one card followed by a large charge
+-- ============================================================================
+
+-- Detector configuration (mirrors config/aml_config.json)
+DECLARE card_testing_max_amount FLOAT64 DEFAULT 5;
+DECLARE card_testing_min_burst INT64 DEFAULT 5;
+DECLARE card_testing_window_minutes INT64 DEFAULT 60;
+DECLARE card_testing_large_amount FLOAT64 DEFAULT 100;
+DECLARE card_testing_weight INT64 DEFAULT 15;
+
+INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+WITH card_transactions AS (
+  SELECT 
+    cc_num,
+    CONCAT(first, '_', last) as customer_id,
+    trans_date_trans_time,
+    amt
+  FROM `anlaytics-465216.aml_data.credit_card_transactions`
+),
+
+-- Each large charge looks back for a burst of micro transactions on the same card
+card_bursts AS (
+  SELECT 
+    l.cc_num,
+    l.customer_id,
+    l.trans_date_trans_time as charge_time,
+    l.amt as charge_amount,
+    MIN(m.trans_date_trans_time) as burst_start,
+    COUNT(*) as micro_count,
+    SUM(m.amt) as micro_amount
+  FROM card_transactions l
+  JOIN card_transactions m
+    ON m.cc_num = l.cc_num
+    AND m.amt <= card_testing_max_amount
+    AND m.trans_date_trans_time >= TIMESTAMP_SUB(l.trans_date_trans_time, INTERVAL card_testing_window_minutes MINUTE)
+    AND m.trans_date_trans_time < l.trans_date_trans_time
+  WHERE l.amt >= card_testing_large_amount
+  GROUP BY l.cc_num, l.customer_id, l.trans_date_trans_time, l.amt
+  HAVING COUNT(*) >= card_testing_min_burst
+),
+
+-- Large charges following the same burst are reported together
+card_testing_analysis AS (
+  SELECT 
+    cc_num,
+    ANY_VALUE(customer_id) as customer_id,
+    burst_start,
+    micro_count,
+    ANY_VALUE(micro_amount) as micro_amount,
+    MIN(charge_time) as first_charge,
+    MAX(charge_time) as last_charge,
+    COUNT(*) as charge_count,
+    SUM(charge_amount) as charge_amount
+  FROM card_bursts
+  GROUP BY cc_num, burst_start, micro_count
+)
+
+SELECT 
+  (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+  ROW_NUMBER() OVER (ORDER BY micro_count DESC) as alert_id,
+  customer_id,
+  DATE(last_charge) as alert_date,
+  'CARD_TESTING' as alert_type,
+  LEAST(micro_count * card_testing_weight, 100) as risk_score,
+  CONCAT(
+    'Card ending ', RIGHT(CAST(cc_num AS STRING), 4),
+    ' had ', micro_count, ' transactions under $', FORMAT('%.2f', card_testing_max_amount),
+    ' within ', TIMESTAMP_DIFF(first_charge, burst_start, MINUTE), ' minutes',
+    ' followed by ', charge_count, IF(charge_count = 1, ' large charge', ' large charges'),
+    ' totaling $', FORMAT('%\'.0f', charge_amount)
+  ) as description,
+  CASE 
+    WHEN micro_count * card_testing_weight >= 80 THEN 'HIGH'
+    WHEN micro_count * card_testing_weight >= 50 THEN 'MEDIUM'
+    ELSE 'LOW'
+  END as priority,
+  micro_amount + charge_amount as total_amount,
+  'OPEN' as status,
+  CURRENT_DATE() as detection_date
+FROM card_testing_analysis
+ORDER BY risk_score DESC;
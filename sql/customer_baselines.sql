@@ -0,0 +1,33 @@
+-- ============================================================================
+-- CUSTOMER BEHAVIOURAL BASELINES - BigQuery SQL
+-- Rebuilds every customer's baseline from the full history. The incremental
+-- processing keeps it up to date and raises BEHAVIOUR_DEVIATION alerts.
+-- ============================================================================
+
+CREATE OR REPLACE TABLE `anlaytics-465216.aml_data.customer_baselines` AS
+WITH baseline_transactions AS (
+  SELECT 
+    CONCAT(first, '|', last, '|', CAST(dob AS STRING)) as person_key,
+    CONCAT(first, '_', last) as customer_id,
+    trans_date_trans_time,
+    amt,
+    category,
+    CAST(EXTRACT(HOUR FROM trans_date_trans_time) AS STRING) as transaction_hour,
+    ST_DISTANCE(ST_GEOGPOINT(long, lat), ST_GEOGPOINT(merch_long, merch_lat)) / 1000 as home_distance,
+    MAX(trans_date_trans_time) OVER (PARTITION BY first, last, dob) as last_transaction
+  FROM `anlaytics-465216.aml_data.credit_card_transactions`
+)
+SELECT person_key, customer_id, 'AMOUNT' as dimension, '' as bucket,
+  COUNT(*) as transaction_count, SUM(amt) as value_sum, SUM(amt * amt) as value_sum_squares,
+  MAX(last_transaction) as last_transaction, CURRENT_TIMESTAMP() as updated_at
+FROM baseline_transactions GROUP BY person_key, customer_id
+UNION ALL
+SELECT person_key, customer_id, 'DISTANCE', '',
+  COUNT(*), SUM(home_distance), SUM(home_distance * home_distance), MAX(last_transaction), CURRENT_TIMESTAMP()
+FROM baseline_transactions GROUP BY person_key, customer_id
+UNION ALL
+SELECT person_key, customer_id, 'CATEGORY', category, COUNT(*), 0.0, 0.0, MAX(last_transaction), CURRENT_TIMESTAMP()
+FROM baseline_transactions GROUP BY person_key, customer_id, category
+UNION ALL
+SELECT person_key, customer_id, 'HOUR', transaction_hour, COUNT(*), 0.0, 0.0, MAX(last_transaction), CURRENT_TIMESTAMP()
+FROM baseline_transactions GROUP BY person_key, customer_id, transaction_hour;
\ No newline at end of file
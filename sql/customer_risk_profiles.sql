@@ -39,64 +39,107 @@ customer_alerts AS (
     MAX(risk_score) as max_risk_score
   FROM `anlaytics-465216.aml_data.aml_alerts_level1`
   GROUP BY customer_id
-)
+),
 
-SELECT 
-  m.customer_id,
-  m.total_transactions,
-  m.total_amount,
-  m.avg_amount,
-  m.max_amount,
-  m.unique_merchants,
-  m.unique_states,
-  
-  -- Risk score calculation
-  LEAST(
-    (IFNULL(a.total_alerts, 0) * 20) +
-    (m.high_amount_transactions * 5) +
-    (m.round_amount_transactions * 3) +
-    (m.night_transactions * 2) +
-    (m.unique_states * 10),
-    100
-  ) as risk_score,
-  
-  -- Risk category
-  CASE 
-    WHEN LEAST(
-      (IFNULL(a.total_alerts, 0) * 20) +
-      (m.high_amount_transactions * 5) +
-      (m.round_amount_transactions * 3) +
-      (m.night_transactions * 2) +
-      (m.unique_states * 10),
-      100
-    ) >= 80 THEN 'CRITICAL'
-    WHEN LEAST(
-      (IFNULL(a.total_alerts, 0) * 20) +
-      (m.high_amount_transactions * 5) +
-      (m.round_amount_transactions * 3) +
-      (m.night_transactions * 2) +
-      (m.unique_states * 10),
-      100
-    ) >= 60 THEN 'HIGH'
-    WHEN LEAST(
+-- Watchlist hits set a minimum risk category until reviewed as false positives
+watchlist_floor AS (
+  SELECT 
+    customer_id,
+    MAX(CASE risk_category
+      WHEN 'CRITICAL' THEN 4
+      WHEN 'HIGH' THEN 3
+      WHEN 'MEDIUM' THEN 2
+      ELSE 1
+    END) as floor_rank
+  FROM `anlaytics-465216.aml_data.watchlist_hits`
+  WHERE review_status != 'FALSE_POSITIVE'
+  GROUP BY customer_id
+),
+
+-- Transactions at merchants scored high risk (refreshed by: merchants score)
+merchant_exposure AS (
+  SELECT 
+    CONCAT(t.first, '_', t.last) as customer_id,
+    COUNT(*) as high_risk_merchant_transactions
+  FROM `anlaytics-465216.aml_data.credit_card_transactions` t
+  JOIN `anlaytics-465216.aml_data.merchant_risk_scores` m
+    ON m.merchant = t.merchant
+  WHERE m.is_high_risk
+  GROUP BY customer_id
+),
+
+-- Highest isolation forest score of any customer-day (written by: model score)
+ml_anomalies AS (
+  SELECT 
+    customer_id,
+    MAX(anomaly_score) as ml_anomaly_score
+  FROM `anlaytics-465216.aml_data.anomaly_scores`
+  GROUP BY customer_id
+),
+
+-- Peer group outliers (refreshed by: detect peers, or the peer outlier step)
+peer_outliers AS (
+  SELECT 
+    customer_id,
+    MAX(outlier_metrics) as peer_outlier_metrics
+  FROM `anlaytics-465216.aml_data.customer_peer_metrics`
+  GROUP BY customer_id
+),
+
+scored AS (
+  SELECT 
+    m.*,
+    IFNULL(a.total_alerts, 0) as total_alerts,
+    IFNULL(a.high_priority_alerts, 0) as high_priority_alerts,
+    IFNULL(a.max_risk_score, 0) as max_alert_risk_score,
+    IFNULL(e.high_risk_merchant_transactions, 0) as high_risk_merchant_transactions,
+    IFNULL(p.peer_outlier_metrics, 0) as peer_outlier_metrics,
+    x.ml_anomaly_score,
+    IFNULL(w.floor_rank, 1) as floor_rank,
+    LEAST(
       (IFNULL(a.total_alerts, 0) * 20) +
       (m.high_amount_transactions * 5) +
       (m.round_amount_transactions * 3) +
       (m.night_transactions * 2) +
-      (m.unique_states * 10),
+      (m.unique_states * 10) +
+      (IFNULL(e.high_risk_merchant_transactions, 0) * 5) +
+      (IFNULL(p.peer_outlier_metrics, 0) * 10),
       100
-    ) >= 40 THEN 'MEDIUM'
+    ) as risk_score
+  FROM customer_metrics m
+  LEFT JOIN customer_alerts a ON m.customer_id = a.customer_id
+  LEFT JOIN watchlist_floor w ON m.customer_id = w.customer_id
+  LEFT JOIN merchant_exposure e ON m.customer_id = e.customer_id
+  LEFT JOIN peer_outliers p ON m.customer_id = p.customer_id
+  LEFT JOIN ml_anomalies x ON m.customer_id = x.customer_id
+)
+
+SELECT 
+  customer_id,
+  total_transactions,
+  total_amount,
+  avg_amount,
+  max_amount,
+  unique_merchants,
+  unique_states,
+  risk_score,
+  
+  -- Risk category (watchlist hits set a floor)
+  CASE 
+    WHEN floor_rank >= 4 OR risk_score >= 80 THEN 'CRITICAL'
+    WHEN floor_rank >= 3 OR risk_score >= 60 THEN 'HIGH'
+    WHEN floor_rank >= 2 OR risk_score >= 40 THEN 'MEDIUM'
     ELSE 'LOW'
   END as risk_category,
   
-  IFNULL(a.total_alerts, 0) as total_alerts,
-  IFNULL(a.high_priority_alerts, 0) as high_priority_alerts,
-  IFNULL(a.max_risk_score, 0) as max_alert_risk_score,
-  
-  m.first_transaction_date,
-  m.last_transaction_date,
+  total_alerts,
+  high_priority_alerts,
+  max_alert_risk_score,
+  high_risk_merchant_transactions,
+  peer_outlier_metrics,
+  ml_anomaly_score,
+  first_transaction_date,
+  last_transaction_date,
   CURRENT_TIMESTAMP() as profile_generated_date
-
-FROM customer_metrics m
-LEFT JOIN customer_alerts a ON m.customer_id = a.customer_id
+FROM scored
 ORDER BY risk_score DESC;
\ No newline at end of file
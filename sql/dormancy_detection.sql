@@ -0,0 +1,111 @@
+-- ============================================================================
+-- DORMANCY REACTIVATION DETECTION - BigQuery SQL
+-- Detects customers who move large amounts soon after a long idle period
+-- ============================================================================
+
+-- Detector configuration (mirrors config/aml_config.json)
+DECLARE dormancy_min_days INT64 DEFAULT 90;
+DECLARE dormancy_window_days INT64 DEFAULT 7;
+DECLARE dormancy_min_amount FLOAT64 DEFAULT 5000;
+DECLARE dormancy_weight INT64 DEFAULT 20;
+
+INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+WITH customer_activity AS (
+  SELECT 
+    CONCAT(first, '_', last) as customer_id,
+    CONCAT(first, '|', last, '|', CAST(dob AS STRING)) as person_key,
+    trans_date_trans_time,
+    DATE(trans_date_trans_time) as transaction_date,
+    amt,
+    -- The previous activity can be arbitrarily old, so the full history is read
+    LAG(DATE(trans_date_trans_time)) OVER (
+      PARTITION BY CONCAT(first, '|', last, '|', CAST(dob AS STRING)) 
+      ORDER BY trans_date_trans_time
+    ) as previous_date
+  FROM `anlaytics-465216.aml_data.credit_card_transactions`
+),
+
+reactivations AS (
+  SELECT 
+    person_key,
+    customer_id,
+    transaction_date as reactivation_date,
+    previous_date,
+    DATE_DIFF(transaction_date, previous_date, DAY) as dormant_days
+  FROM customer_activity
+  WHERE DATE_DIFF(transaction_date, previous_date, DAY) >= dormancy_min_days
+),
+
+-- Activity in the window after each reactivation, with a running total to
+-- find the transaction that crosses the amount threshold
+reactivation_window AS (
+  SELECT 
+    r.person_key,
+    r.customer_id,
+    r.reactivation_date,
+    r.previous_date,
+    r.dormant_days,
+    a.trans_date_trans_time,
+    a.amt,
+    SUM(a.amt) OVER (
+      PARTITION BY r.person_key, r.reactivation_date 
+      ORDER BY a.trans_date_trans_time 
+      ROWS UNBOUNDED PRECEDING
+    ) as running_amount
+  FROM reactivations r
+  JOIN customer_activity a
+    ON a.person_key = r.person_key
+    AND a.transaction_date >= r.reactivation_date
+    AND DATE_DIFF(a.transaction_date, r.reactivation_date, DAY) < dormancy_window_days
+),
+
+dormancy_analysis AS (
+  SELECT 
+    person_key,
+    customer_id,
+    reactivation_date,
+    previous_date,
+    dormant_days,
+    COUNT(*) as transaction_count,
+    SUM(amt) as total_amount,
+    MIN(IF(running_amount >= dormancy_min_amount, trans_date_trans_time, NULL)) as crossing_time
+  FROM reactivation_window
+  GROUP BY person_key, customer_id, reactivation_date, previous_date, dormant_days
+  HAVING SUM(amt) >= dormancy_min_amount
+),
+
+dormancy_scores AS (
+  SELECT 
+    *,
+    LEAST(
+      dormancy_weight * (DIV(dormant_days, dormancy_min_days) + CAST(FLOOR(total_amount / dormancy_min_amount) AS INT64)),
+      100
+    ) as risk_score
+  FROM dormancy_analysis
+  WHERE TRUE
+)
+
+SELECT 
+  (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+  ROW_NUMBER() OVER (ORDER BY dormant_days DESC) as alert_id,
+  customer_id,
+  reactivation_date as alert_date,
+  'DORMANCY_REACTIVATION' as alert_type,
+  risk_score,
+  CONCAT(
+    'Customer reactivated after ', dormant_days, ' days dormant',
+    ' (last activity ', CAST(previous_date AS STRING), ')',
+    ' and moved $', FORMAT('%\'.0f', total_amount),
+    ' in ', transaction_count, IF(transaction_count = 1, ' transaction', ' transactions'),
+    ' within ', dormancy_window_days, IF(dormancy_window_days = 1, ' day', ' days')
+  ) as description,
+  CASE 
+    WHEN risk_score >= 80 THEN 'HIGH'
+    WHEN risk_score >= 50 THEN 'MEDIUM'
+    ELSE 'LOW'
+  END as priority,
+  total_amount,
+  'OPEN' as status,
+  CURRENT_DATE() as detection_date
+FROM dormancy_scores
+ORDER BY risk_score DESC;
@@ -3,6 +3,11 @@
 -- Detects transactions across multiple states/cities in one day
 -- ============================================================================
 
+-- Detector configuration (mirrors config/aml_config.json)
+DECLARE geographic_max_states INT64 DEFAULT 2;
+DECLARE geographic_max_cities INT64 DEFAULT 5;
+DECLARE geographic_weight INT64 DEFAULT 15;
+
 INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
 WITH geographic_analysis AS (
   SELECT 
@@ -17,8 +22,8 @@ WITH geographic_analysis AS (
   FROM `anlaytics-465216.aml_data.credit_card_transactions`
   GROUP BY customer_id, transaction_date
   HAVING 
-    COUNT(DISTINCT state) > 2 OR 
-    (COUNT(DISTINCT city) > 5 AND COUNT(*) > 5)
+    COUNT(DISTINCT state) > geographic_max_states OR 
+    (COUNT(DISTINCT city) > geographic_max_cities AND COUNT(*) > geographic_max_cities)
 )
 
 SELECT 
@@ -27,14 +32,14 @@ SELECT
   customer_id,
   transaction_date as alert_date,
   'GEOGRAPHIC' as alert_type,
-  LEAST((unique_states * 15) + (unique_cities * 3), 100) as risk_score,
+  LEAST((unique_states * geographic_weight) + (unique_cities * 3), 100) as risk_score,
   CONCAT(
     'Customer transacted in ', unique_states, ' states and ', 
     unique_cities, ' cities in one day'
   ) as description,
   CASE 
-    WHEN (unique_states * 15) + (unique_cities * 3) >= 80 THEN 'HIGH'
-    WHEN (unique_states * 15) + (unique_cities * 3) >= 50 THEN 'MEDIUM'
+    WHEN (unique_states * geographic_weight) + (unique_cities * 3) >= 80 THEN 'HIGH'
+    WHEN (unique_states * geographic_weight) + (unique_cities * 3) >= 50 THEN 'MEDIUM'
     ELSE 'LOW'
   END as priority,
   total_amount,
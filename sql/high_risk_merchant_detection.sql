@@ -0,0 +1,66 @@
+-- ============================================================================
+-- HIGH-RISK MERCHANT DETECTION - BigQuery SQL
+-- Detects payments to merchants scored high risk in merchant_risk_scores
+-- (refresh the scores first with: merchants score)
+-- ============================================================================
+
+-- Detector configuration (mirrors config/aml_config.json)
+DECLARE high_risk_merchant_weight INT64 DEFAULT 5;
+
+INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+WITH high_risk_transactions AS (
+  SELECT 
+    CONCAT(t.first, '_', t.last) as customer_id,
+    CONCAT(t.first, '|', t.last, '|', CAST(t.dob AS STRING)) as person_key,
+    DATE(t.trans_date_trans_time) as transaction_date,
+    t.merchant,
+    t.amt,
+    m.risk_score as merchant_risk_score
+  FROM `anlaytics-465216.aml_data.credit_card_transactions` t
+  JOIN `anlaytics-465216.aml_data.merchant_risk_scores` m
+    ON m.merchant = t.merchant
+  WHERE m.is_high_risk
+),
+
+high_risk_merchant_analysis AS (
+  SELECT 
+    person_key,
+    customer_id,
+    transaction_date,
+    COUNT(*) as transaction_count,
+    SUM(amt) as total_amount,
+    MAX(merchant_risk_score) as top_merchant_score,
+    STRING_AGG(DISTINCT merchant, ', ' ORDER BY merchant) as merchants
+  FROM high_risk_transactions
+  GROUP BY person_key, customer_id, transaction_date
+),
+
+high_risk_merchant_scores AS (
+  SELECT 
+    *,
+    LEAST(top_merchant_score + (transaction_count - 1) * high_risk_merchant_weight, 100) as risk_score
+  FROM high_risk_merchant_analysis
+)
+
+SELECT 
+  (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+  ROW_NUMBER() OVER (ORDER BY risk_score DESC) as alert_id,
+  customer_id,
+  transaction_date as alert_date,
+  'HIGH_RISK_MERCHANT' as alert_type,
+  risk_score,
+  CONCAT(
+    'Customer made ', transaction_count, IF(transaction_count = 1, ' transaction', ' transactions'),
+    ' totaling $', FORMAT('%\'.0f', total_amount),
+    ' at high-risk merchants (', merchants, ')'
+  ) as description,
+  CASE 
+    WHEN risk_score >= 80 THEN 'HIGH'
+    WHEN risk_score >= 50 THEN 'MEDIUM'
+    ELSE 'LOW'
+  END as priority,
+  total_amount,
+  'OPEN' as status,
+  CURRENT_DATE() as detection_date
+FROM high_risk_merchant_scores
+ORDER BY risk_score DESC;
\ No newline at end of file
@@ -7,6 +7,60 @@ DECLARE last_processed_time TIMESTAMP;
 DECLARE processing_start_time TIMESTAMP DEFAULT CURRENT_TIMESTAMP();
 DECLARE new_records_count INT64;
 DECLARE alerts_created INT64 DEFAULT 0;
+DECLARE first_alert_id INT64;
+DECLARE run_id INT64;
+DECLARE config_version INT64;
+DECLARE config_checksum STRING;
+DECLARE active_config STRING;
+DECLARE run_config STRING;
+
+-- Detector configuration (mirrors AML_CONFIG and config/aml_config.json),
+-- replaced by the active config version's settings when there is one
+DECLARE structuring_threshold FLOAT64 DEFAULT 10000;
+DECLARE structuring_buffer FLOAT64 DEFAULT 0.9;
+DECLARE structuring_min_transactions INT64 DEFAULT 2;
+DECLARE structuring_windows ARRAY<INT64> DEFAULT [1, 3, 7, 30];
+DECLARE structuring_weight INT64 DEFAULT 25;
+DECLARE velocity_windows ARRAY<STRUCT<minutes INT64, min_transactions INT64, min_amount FLOAT64>> DEFAULT [
+  STRUCT(5 AS minutes, 5 AS min_transactions, 0.0 AS min_amount),
+  STRUCT(60 AS minutes, 10 AS min_transactions, 5000.0 AS min_amount),
+  STRUCT(1440 AS minutes, 25 AS min_transactions, 15000.0 AS min_amount)
+];
+DECLARE velocity_weight INT64 DEFAULT 20;
+DECLARE geographic_max_states INT64 DEFAULT 2;
+DECLARE geographic_max_cities INT64 DEFAULT 5;
+DECLARE geographic_weight INT64 DEFAULT 15;
+DECLARE card_testing_max_amount FLOAT64 DEFAULT 5;
+DECLARE card_testing_min_burst INT64 DEFAULT 5;
+DECLARE card_testing_window_minutes INT64 DEFAULT 60;
+DECLARE card_testing_large_amount FLOAT64 DEFAULT 100;
+DECLARE card_testing_weight INT64 DEFAULT 15;
+DECLARE dormancy_min_days INT64 DEFAULT 90;
+DECLARE dormancy_window_days INT64 DEFAULT 7;
+DECLARE dormancy_min_amount FLOAT64 DEFAULT 5000;
+DECLARE dormancy_weight INT64 DEFAULT 20;
+DECLARE high_risk_merchant_weight INT64 DEFAULT 5;
+DECLARE merchant_funnel_min_customers INT64 DEFAULT 5;
+DECLARE merchant_funnel_amount_tolerance FLOAT64 DEFAULT 0.01;
+DECLARE merchant_spike_baseline_days INT64 DEFAULT 30;
+DECLARE merchant_spike_multiplier FLOAT64 DEFAULT 5;
+DECLARE merchant_spike_min_transactions INT64 DEFAULT 10;
+DECLARE merchant_funnel_weight INT64 DEFAULT 10;
+DECLARE merchant_spike_weight INT64 DEFAULT 10;
+DECLARE behaviour_min_history INT64 DEFAULT 20;
+DECLARE behaviour_min_amount FLOAT64 DEFAULT 100;
+DECLARE behaviour_z_score FLOAT64 DEFAULT 3;
+DECLARE behaviour_rare_share FLOAT64 DEFAULT 0.05;
+DECLARE behaviour_min_deviations INT64 DEFAULT 2;
+DECLARE behaviour_weight INT64 DEFAULT 15;
+DECLARE peer_window_days INT64 DEFAULT 90;
+DECLARE peer_urban_population INT64 DEFAULT 50000;
+DECLARE peer_min_group_size INT64 DEFAULT 5;
+DECLARE peer_z_score FLOAT64 DEFAULT 3.5;
+DECLARE peer_outlier_weight INT64 DEFAULT 20;
+DECLARE duplicate_alerts INT64 DEFAULT 0;
+DECLARE case_period_days INT64 DEFAULT 7;
+DECLARE alerts_suppressed INT64 DEFAULT 0;
 
 -- Get last processed timestamp
 SET last_processed_time = (
@@ -35,111 +89,345 @@ IF new_records_count = 0 THEN
   
 ELSE
   -- Process new transactions
+
+  -- Alerts of this run get IDs after the current maximum
+  SET first_alert_id = (
+    SELECT IFNULL(MAX(alert_id), 0) + 1
+    FROM `anlaytics-465216.aml_data.aml_alerts_level1`
+  );
+
+  -- Config version in force (written by: configs activate). Its detector
+  -- settings replace the DECLAREs above, so the run uses the thresholds it
+  -- records; the generated rules (section 12) must still be regenerated
+  -- from it with configs checkout and make rules-sql when its rules change.
+  SET (config_version, config_checksum, active_config) = (
+    SELECT AS STRUCT IFNULL(MAX(version), 0), MAX(checksum), MAX(config)
+    FROM (
+      SELECT v.version, v.checksum, v.config
+      FROM `anlaytics-465216.aml_data.config_versions` v
+      JOIN `anlaytics-465216.aml_data.config_version_events` e
+        ON e.version = v.version AND e.action = 'ACTIVATE'
+      WHERE v.effective_date <= CURRENT_DATE()
+      ORDER BY v.effective_date DESC, e.at DESC
+      LIMIT 1
+    )
+  );
+
+  -- Without an active version the run uses the DECLAREs above and is
+  -- recorded with config_version 0, no checksum and those settings as its
+  -- config (see below)
+  IF active_config IS NOT NULL THEN
+    SET structuring_threshold = IFNULL(CAST(JSON_VALUE(active_config, '$.structuring_threshold') AS FLOAT64), structuring_threshold);
+    SET structuring_buffer = IFNULL(CAST(JSON_VALUE(active_config, '$.structuring_buffer') AS FLOAT64), structuring_buffer);
+    SET structuring_min_transactions = IFNULL(CAST(JSON_VALUE(active_config, '$.structuring_min_transactions') AS INT64), structuring_min_transactions);
+    SET geographic_max_states = IFNULL(CAST(JSON_VALUE(active_config, '$.geographic_max_states') AS INT64), geographic_max_states);
+    SET geographic_max_cities = IFNULL(CAST(JSON_VALUE(active_config, '$.geographic_max_cities') AS INT64), geographic_max_cities);
+    SET card_testing_max_amount = IFNULL(CAST(JSON_VALUE(active_config, '$.card_testing_max_amount') AS FLOAT64), card_testing_max_amount);
+    SET card_testing_min_burst = IFNULL(CAST(JSON_VALUE(active_config, '$.card_testing_min_burst') AS INT64), card_testing_min_burst);
+    SET card_testing_window_minutes = IFNULL(CAST(JSON_VALUE(active_config, '$.card_testing_window_minutes') AS INT64), card_testing_window_minutes);
+    SET card_testing_large_amount = IFNULL(CAST(JSON_VALUE(active_config, '$.card_testing_large_amount') AS FLOAT64), card_testing_large_amount);
+    SET dormancy_min_days = IFNULL(CAST(JSON_VALUE(active_config, '$.dormancy_min_days') AS INT64), dormancy_min_days);
+    SET dormancy_window_days = IFNULL(CAST(JSON_VALUE(active_config, '$.dormancy_window_days') AS INT64), dormancy_window_days);
+    SET dormancy_min_amount = IFNULL(CAST(JSON_VALUE(active_config, '$.dormancy_min_amount') AS FLOAT64), dormancy_min_amount);
+    SET merchant_funnel_min_customers = IFNULL(CAST(JSON_VALUE(active_config, '$.merchant_funnel_min_customers') AS INT64), merchant_funnel_min_customers);
+    SET merchant_funnel_amount_tolerance = IFNULL(CAST(JSON_VALUE(active_config, '$.merchant_funnel_amount_tolerance') AS FLOAT64), merchant_funnel_amount_tolerance);
+    SET merchant_spike_baseline_days = IFNULL(CAST(JSON_VALUE(active_config, '$.merchant_spike_baseline_days') AS INT64), merchant_spike_baseline_days);
+    SET merchant_spike_multiplier = IFNULL(CAST(JSON_VALUE(active_config, '$.merchant_spike_multiplier') AS FLOAT64), merchant_spike_multiplier);
+    SET merchant_spike_min_transactions = IFNULL(CAST(JSON_VALUE(active_config, '$.merchant_spike_min_transactions') AS INT64), merchant_spike_min_transactions);
+    SET behaviour_min_history = IFNULL(CAST(JSON_VALUE(active_config, '$.behaviour_min_history') AS INT64), behaviour_min_history);
+    SET behaviour_min_amount = IFNULL(CAST(JSON_VALUE(active_config, '$.behaviour_min_amount') AS FLOAT64), behaviour_min_amount);
+    SET behaviour_z_score = IFNULL(CAST(JSON_VALUE(active_config, '$.behaviour_z_score') AS FLOAT64), behaviour_z_score);
+    SET behaviour_rare_share = IFNULL(CAST(JSON_VALUE(active_config, '$.behaviour_rare_share') AS FLOAT64), behaviour_rare_share);
+    SET behaviour_min_deviations = IFNULL(CAST(JSON_VALUE(active_config, '$.behaviour_min_deviations') AS INT64), behaviour_min_deviations);
+    SET peer_window_days = IFNULL(CAST(JSON_VALUE(active_config, '$.peer_window_days') AS INT64), peer_window_days);
+    SET peer_urban_population = IFNULL(CAST(JSON_VALUE(active_config, '$.peer_urban_population') AS INT64), peer_urban_population);
+    SET peer_min_group_size = IFNULL(CAST(JSON_VALUE(active_config, '$.peer_min_group_size') AS INT64), peer_min_group_size);
+    SET peer_z_score = IFNULL(CAST(JSON_VALUE(active_config, '$.peer_z_score') AS FLOAT64), peer_z_score);
+    SET structuring_weight = IFNULL(CAST(JSON_VALUE(active_config, '$.risk_score_weights.structuring') AS INT64), structuring_weight);
+    SET velocity_weight = IFNULL(CAST(JSON_VALUE(active_config, '$.risk_score_weights.velocity') AS INT64), velocity_weight);
+    SET geographic_weight = IFNULL(CAST(JSON_VALUE(active_config, '$.risk_score_weights.geographic') AS INT64), geographic_weight);
+    SET card_testing_weight = IFNULL(CAST(JSON_VALUE(active_config, '$.risk_score_weights.card_testing') AS INT64), card_testing_weight);
+    SET dormancy_weight = IFNULL(CAST(JSON_VALUE(active_config, '$.risk_score_weights.dormancy') AS INT64), dormancy_weight);
+    SET high_risk_merchant_weight = IFNULL(CAST(JSON_VALUE(active_config, '$.risk_score_weights.high_risk_merchant') AS INT64), high_risk_merchant_weight);
+    SET merchant_funnel_weight = IFNULL(CAST(JSON_VALUE(active_config, '$.risk_score_weights.merchant_funnel') AS INT64), merchant_funnel_weight);
+    SET merchant_spike_weight = IFNULL(CAST(JSON_VALUE(active_config, '$.risk_score_weights.merchant_spike') AS INT64), merchant_spike_weight);
+    SET behaviour_weight = IFNULL(CAST(JSON_VALUE(active_config, '$.risk_score_weights.behaviour') AS INT64), behaviour_weight);
+    SET peer_outlier_weight = IFNULL(CAST(JSON_VALUE(active_config, '$.risk_score_weights.peer_outlier') AS INT64), peer_outlier_weight);
+    IF JSON_QUERY_ARRAY(active_config, '$.structuring_windows') IS NOT NULL THEN
+      SET structuring_windows = ARRAY(
+        SELECT CAST(JSON_VALUE(w) AS INT64)
+        FROM UNNEST(JSON_QUERY_ARRAY(active_config, '$.structuring_windows')) AS w
+      );
+    END IF;
+    IF JSON_QUERY_ARRAY(active_config, '$.velocity_windows') IS NOT NULL THEN
+      SET velocity_windows = ARRAY(
+        SELECT AS STRUCT
+          CAST(JSON_VALUE(w, '$.minutes') AS INT64) AS minutes,
+          CAST(JSON_VALUE(w, '$.min_transactions') AS INT64) AS min_transactions,
+          IFNULL(CAST(JSON_VALUE(w, '$.min_amount') AS FLOAT64), 0) AS min_amount
+        FROM UNNEST(JSON_QUERY_ARRAY(active_config, '$.velocity_windows')) AS w
+      );
+    END IF;
+  ELSE
+    -- The settings of an unversioned run, in the keys of the detector
+    -- config file, so its alerts' evidence can show them
+    SET run_config = TO_JSON_STRING(STRUCT(
+      structuring_threshold AS structuring_threshold,
+      structuring_buffer AS structuring_buffer,
+      structuring_min_transactions AS structuring_min_transactions,
+      structuring_windows AS structuring_windows,
+      velocity_windows AS velocity_windows,
+      geographic_max_states AS geographic_max_states,
+      geographic_max_cities AS geographic_max_cities,
+      card_testing_max_amount AS card_testing_max_amount,
+      card_testing_min_burst AS card_testing_min_burst,
+      card_testing_window_minutes AS card_testing_window_minutes,
+      card_testing_large_amount AS card_testing_large_amount,
+      dormancy_min_days AS dormancy_min_days,
+      dormancy_window_days AS dormancy_window_days,
+      dormancy_min_amount AS dormancy_min_amount,
+      merchant_funnel_min_customers AS merchant_funnel_min_customers,
+      merchant_funnel_amount_tolerance AS merchant_funnel_amount_tolerance,
+      merchant_spike_baseline_days AS merchant_spike_baseline_days,
+      merchant_spike_multiplier AS merchant_spike_multiplier,
+      merchant_spike_min_transactions AS merchant_spike_min_transactions,
+      behaviour_min_history AS behaviour_min_history,
+      behaviour_min_amount AS behaviour_min_amount,
+      behaviour_z_score AS behaviour_z_score,
+      behaviour_rare_share AS behaviour_rare_share,
+      behaviour_min_deviations AS behaviour_min_deviations,
+      peer_window_days AS peer_window_days,
+      peer_urban_population AS peer_urban_population,
+      peer_min_group_size AS peer_min_group_size,
+      peer_z_score AS peer_z_score,
+      STRUCT(
+        structuring_weight AS structuring,
+        velocity_weight AS velocity,
+        geographic_weight AS geographic,
+        card_testing_weight AS card_testing,
+        dormancy_weight AS dormancy,
+        high_risk_merchant_weight AS high_risk_merchant,
+        merchant_funnel_weight AS merchant_funnel,
+        merchant_spike_weight AS merchant_spike,
+        behaviour_weight AS behaviour,
+        peer_outlier_weight AS peer_outlier
+      ) AS risk_score_weights
+    ), true);
+  END IF;
   
   -- ===========================================
   -- 1. VELOCITY DETECTION
+  -- Sliding windows measured from each new transaction, with enough
+  -- history loaded to fill the longest window
   -- ===========================================
   INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
-  WITH new_transactions AS (
+  WITH customer_transactions AS (
     SELECT 
       CONCAT(first, '_', last) as customer_id,
+      CONCAT(first, '|', last, '|', CAST(dob AS STRING)) as person_key,
       trans_date_trans_time,
-      amt,
-      merchant,
-      DATE(trans_date_trans_time) as transaction_date
+      amt
     FROM `anlaytics-465216.aml_data.credit_card_transactions`
-    WHERE trans_date_trans_time > last_processed_time
+    WHERE trans_date_trans_time > TIMESTAMP_SUB(
+        last_processed_time,
+        INTERVAL (SELECT MAX(w.minutes) FROM UNNEST(velocity_windows) AS w) MINUTE
+      )
   ),
-  
-  -- Get recent transactions for velocity analysis (include some history)
-  velocity_data AS (
+
+  -- One window of each size ends at every transaction and reaches back
+  -- from that transaction's timestamp, not from the current date
+  velocity_windows_analysis AS (
     SELECT 
-      CONCAT(first, '_', last) as customer_id,
-      trans_date_trans_time,
-      amt,
-      DATE(trans_date_trans_time) as transaction_date,
-      TIMESTAMP_DIFF(
-        trans_date_trans_time,
-        LAG(trans_date_trans_time) OVER (
-          PARTITION BY CONCAT(first, '_', last) 
-          ORDER BY trans_date_trans_time
-        ),
-        MINUTE
-      ) as minutes_since_last
-    FROM `anlaytics-465216.aml_data.credit_card_transactions`
-    WHERE trans_date_trans_time > TIMESTAMP_SUB(last_processed_time, INTERVAL 1 DAY)
+      e.person_key,
+      e.customer_id,
+      w.minutes,
+      w.min_amount,
+      e.trans_date_trans_time as window_end,
+      MIN(b.trans_date_trans_time) as window_start,
+      COUNT(*) as transaction_count,
+      SUM(b.amt) as total_amount
+    FROM customer_transactions e
+    CROSS JOIN UNNEST(velocity_windows) AS w
+    JOIN customer_transactions b
+      ON b.person_key = e.person_key
+      AND b.trans_date_trans_time BETWEEN TIMESTAMP_SUB(e.trans_date_trans_time, INTERVAL w.minutes MINUTE) 
+        AND e.trans_date_trans_time
+    WHERE e.trans_date_trans_time > last_processed_time  -- windows ending in new data
+    GROUP BY e.person_key, e.customer_id, w.minutes, w.min_transactions, w.min_amount, e.trans_date_trans_time
+    HAVING COUNT(*) >= w.min_transactions
+      OR (w.min_amount > 0 AND COUNT(*) >= 2 AND SUM(b.amt) >= w.min_amount)
   ),
-  
-  rapid_transactions AS (
+
+  -- Overlapping qualifying windows of one size form a single episode
+  velocity_episodes AS (
     SELECT 
-      customer_id,
-      transaction_date,
-      COUNT(*) as rapid_transaction_count,
-      SUM(amt) as total_amount,
-      MIN(minutes_since_last) as min_time_diff
-    FROM velocity_data
-    WHERE minutes_since_last <= 5 
-      AND transaction_date >= DATE_SUB(CURRENT_DATE(), INTERVAL 1 DAY)
-    GROUP BY customer_id, transaction_date
-    HAVING COUNT(*) >= 5
+      *,
+      COUNTIF(new_episode) OVER (
+        PARTITION BY person_key, minutes 
+        ORDER BY window_end 
+        ROWS UNBOUNDED PRECEDING
+      ) as episode
+    FROM (
+      SELECT 
+        *,
+        IFNULL(window_start > LAG(window_end) OVER (
+          PARTITION BY person_key, minutes 
+          ORDER BY window_end
+        ), TRUE) as new_episode
+      FROM velocity_windows_analysis
+    )
+  ),
+
+  -- Report each episode at its busiest window, and a larger window only when
+  -- it covers different transactions than a smaller one
+  velocity_analysis AS (
+    SELECT 
+      *
+    FROM (
+      SELECT 
+        *
+      FROM velocity_episodes
+      WHERE TRUE
+      QUALIFY ROW_NUMBER() OVER (
+        PARTITION BY person_key, minutes, episode 
+        ORDER BY transaction_count DESC, total_amount DESC, window_end
+      ) = 1
+    )
+    WHERE TRUE
+    QUALIFY ROW_NUMBER() OVER (
+      PARTITION BY person_key, window_start, window_end 
+      ORDER BY minutes
+    ) = 1
+  ),
+
+  velocity_scores AS (
+    SELECT 
+      *,
+      LEAST(
+        transaction_count * velocity_weight +
+        IF(min_amount > 0, CAST(FLOOR(total_amount / min_amount) AS INT64) * velocity_weight, 0),
+        100
+      ) as risk_score
+    FROM velocity_analysis
   )
-  
+
   SELECT 
     (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
-    ROW_NUMBER() OVER (ORDER BY rapid_transaction_count DESC) as alert_id,
+    ROW_NUMBER() OVER (ORDER BY transaction_count DESC) as alert_id,
     customer_id,
-    transaction_date as alert_date,
+    DATE(window_end) as alert_date,
     'VELOCITY' as alert_type,
-    LEAST(rapid_transaction_count * 20, 100) as risk_score,
+    risk_score,
     CONCAT(
-      'Customer made ', rapid_transaction_count, 
-      ' rapid transactions (≤5 mins) totaling $', 
-      FORMAT('%\'.0f', total_amount)
+      'Customer made ', transaction_count, 
+      ' transactions totaling $', FORMAT('%\'.0f', total_amount),
+      ' within ',
+      IF(MOD(minutes, 60) = 0,
+        CONCAT(DIV(minutes, 60), IF(minutes = 60, ' hour', ' hours')),
+        CONCAT(minutes, IF(minutes = 1, ' minute', ' minutes')))
     ) as description,
     CASE 
-      WHEN rapid_transaction_count * 20 >= 80 THEN 'HIGH'
-      WHEN rapid_transaction_count * 20 >= 50 THEN 'MEDIUM'
+      WHEN risk_score >= 80 THEN 'HIGH'
+      WHEN risk_score >= 50 THEN 'MEDIUM'
       ELSE 'LOW'
     END as priority,
     total_amount,
     'OPEN' as status,
     CURRENT_DATE() as detection_date,
     CURRENT_TIMESTAMP() as created_at
-  FROM rapid_transactions;
+  FROM velocity_scores;
   
   -- ===========================================
   -- 2. STRUCTURING DETECTION
+  -- Rolling windows across all cards of a resolved customer
+  -- (name and date of birth). Only maximal windows are reported, and a
+  -- larger window with the same transactions as a smaller one is dropped.
   -- ===========================================
   INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
-  WITH structuring_analysis AS (
+  WITH band_transactions AS (
     SELECT 
       CONCAT(first, '_', last) as customer_id,
+      CONCAT(first, '|', last, '|', CAST(dob AS STRING)) as person_key,
       DATE(trans_date_trans_time) as transaction_date,
-      COUNT(*) as transaction_count,
-      SUM(amt) as total_amount,
-      AVG(amt) as avg_amount
+      trans_date_trans_time,
+      cc_num,
+      amt
     FROM `anlaytics-465216.aml_data.credit_card_transactions`
-    WHERE trans_date_trans_time > last_processed_time
-      AND amt BETWEEN 9000 AND 9999  -- Just under $10K threshold
-    GROUP BY customer_id, transaction_date
-    HAVING COUNT(*) >= 2
+    WHERE trans_date_trans_time > TIMESTAMP_SUB(
+        last_processed_time,
+        INTERVAL (SELECT MAX(w) FROM UNNEST(structuring_windows) AS w) DAY
+      )
+      AND amt >= structuring_threshold * structuring_buffer
+      AND amt < structuring_threshold
+  ),
+  
+  window_ends AS (
+    SELECT 
+      person_key,
+      customer_id,
+      transaction_date as window_end,
+      LEAD(transaction_date) OVER (PARTITION BY person_key ORDER BY transaction_date) as next_date,
+      has_new
+    FROM (
+      SELECT 
+        person_key,
+        ANY_VALUE(customer_id) as customer_id,
+        transaction_date,
+        LOGICAL_OR(trans_date_trans_time > last_processed_time) as has_new
+      FROM band_transactions
+      GROUP BY person_key, transaction_date
+    )
+  ),
+  
+  structuring_windows_analysis AS (
+    SELECT 
+      e.person_key,
+      e.customer_id,
+      w as window_days,
+      e.window_end,
+      e.next_date,
+      e.has_new,
+      MIN(b.transaction_date) as window_start,
+      COUNT(*) as transaction_count,
+      COUNT(DISTINCT b.cc_num) as card_count,
+      SUM(b.amt) as total_amount
+    FROM window_ends e
+    CROSS JOIN UNNEST(structuring_windows) AS w
+    JOIN band_transactions b
+      ON b.person_key = e.person_key
+      AND b.transaction_date BETWEEN DATE_SUB(e.window_end, INTERVAL w - 1 DAY) AND e.window_end
+    GROUP BY e.person_key, e.customer_id, w, e.window_end, e.next_date, e.has_new
+    HAVING COUNT(*) >= structuring_min_transactions
+  ),
+  
+  structuring_analysis AS (
+    SELECT *
+    FROM structuring_windows_analysis
+    WHERE has_new
+      AND (next_date IS NULL OR DATE_SUB(next_date, INTERVAL window_days - 1 DAY) > window_start)
+    QUALIFY ROW_NUMBER() OVER (
+      PARTITION BY person_key, window_start, window_end 
+      ORDER BY window_days
+    ) = 1
   )
   
   SELECT 
     (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
     ROW_NUMBER() OVER (ORDER BY transaction_count DESC) as alert_id,
     customer_id,
-    transaction_date as alert_date,
+    window_end as alert_date,
     'STRUCTURING' as alert_type,
-    LEAST(transaction_count * 25, 100) as risk_score,
+    LEAST(transaction_count * structuring_weight, 100) as risk_score,
     CONCAT(
       'Customer made ', transaction_count, 
       ' transactions totaling $', FORMAT('%\'.0f', total_amount),
-      ' just under $10,000 threshold'
+      ' just under $', FORMAT('%\'.0f', structuring_threshold), ' threshold',
+      ' within ', window_days, IF(window_days = 1, ' day', ' days'),
+      ' on ', card_count, IF(card_count = 1, ' card', ' cards')
     ) as description,
     CASE 
-      WHEN transaction_count * 25 >= 80 THEN 'HIGH'
-      WHEN transaction_count * 25 >= 50 THEN 'MEDIUM'
+      WHEN transaction_count * structuring_weight >= 80 THEN 'HIGH'
+      WHEN transaction_count * structuring_weight >= 50 THEN 'MEDIUM'
       ELSE 'LOW'
     END as priority,
     total_amount,
@@ -165,8 +453,8 @@ ELSE
     WHERE trans_date_trans_time > last_processed_time
     GROUP BY customer_id, transaction_date
     HAVING 
-      COUNT(DISTINCT state) > 2 OR 
-      (COUNT(DISTINCT city) > 5 AND COUNT(*) > 5)
+      COUNT(DISTINCT state) > geographic_max_states OR 
+      (COUNT(DISTINCT city) > geographic_max_cities AND COUNT(*) > geographic_max_cities)
   )
   
   SELECT 
@@ -175,14 +463,14 @@ ELSE
     customer_id,
     transaction_date as alert_date,
     'GEOGRAPHIC' as alert_type,
-    LEAST((unique_states * 15) + (unique_cities * 3), 100) as risk_score,
+    LEAST((unique_states * geographic_weight) + (unique_cities * 3), 100) as risk_score,
     CONCAT(
       'Customer transacted in ', unique_states, ' states and ', 
       unique_cities, ' cities in one day'
     ) as description,
     CASE 
-      WHEN (unique_states * 15) + (unique_cities * 3) >= 80 THEN 'HIGH'
-      WHEN (unique_states * 15) + (unique_cities * 3) >= 50 THEN 'MEDIUM'
+      WHEN (unique_states * geographic_weight) + (unique_cities * 3) >= 80 THEN 'HIGH'
+      WHEN (unique_states * geographic_weight) + (unique_cities * 3) >= 50 THEN 'MEDIUM'
       ELSE 'LOW'
     END as priority,
     total_amount,
@@ -192,7 +480,1222 @@ ELSE
   FROM geographic_analysis;
   
   -- ===========================================
-  -- 4. UPDATE CUSTOMER RISK PROFILES
+  -- 4. CARD TESTING DETECTION
+  -- Micro transaction bursts followed by a large charge on the same card
+  -- ===========================================
+  INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+  WITH card_transactions AS (
+    SELECT 
+      cc_num,
+      CONCAT(first, '_', last) as customer_id,
+      trans_date_trans_time,
+      amt
+    FROM `anlaytics-465216.aml_data.credit_card_transactions`
+    WHERE trans_date_trans_time > TIMESTAMP_SUB(last_processed_time, INTERVAL card_testing_window_minutes MINUTE)
+  ),
+
+  -- Each large charge looks back for a burst of micro transactions on the same card
+  card_bursts AS (
+    SELECT 
+      l.cc_num,
+      l.customer_id,
+      l.trans_date_trans_time as charge_time,
+      l.amt as charge_amount,
+      MIN(m.trans_date_trans_time) as burst_start,
+      COUNT(*) as micro_count,
+      SUM(m.amt) as micro_amount
+    FROM card_transactions l
+    JOIN card_transactions m
+      ON m.cc_num = l.cc_num
+      AND m.amt <= card_testing_max_amount
+      AND m.trans_date_trans_time >= TIMESTAMP_SUB(l.trans_date_trans_time, INTERVAL card_testing_window_minutes MINUTE)
+      AND m.trans_date_trans_time < l.trans_date_trans_time
+    WHERE l.amt >= card_testing_large_amount
+      AND trans_date_trans_time > last_processed_time
+    GROUP BY l.cc_num, l.customer_id, l.trans_date_trans_time, l.amt
+    HAVING COUNT(*) >= card_testing_min_burst
+  ),
+
+  -- Large charges following the same burst are reported together
+  card_testing_analysis AS (
+    SELECT 
+      cc_num,
+      ANY_VALUE(customer_id) as customer_id,
+      burst_start,
+      micro_count,
+      ANY_VALUE(micro_amount) as micro_amount,
+      MIN(charge_time) as first_charge,
+      MAX(charge_time) as last_charge,
+      COUNT(*) as charge_count,
+      SUM(charge_amount) as charge_amount
+    FROM card_bursts
+    GROUP BY cc_num, burst_start, micro_count
+  )
+
+  SELECT 
+    (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+    ROW_NUMBER() OVER (ORDER BY micro_count DESC) as alert_id,
+    customer_id,
+    DATE(last_charge) as alert_date,
+    'CARD_TESTING' as alert_type,
+    LEAST(micro_count * card_testing_weight, 100) as risk_score,
+    CONCAT(
+      'Card ending ', RIGHT(CAST(cc_num AS STRING), 4),
+      ' had ', micro_count, ' transactions under $', FORMAT('%.2f', card_testing_max_amount),
+      ' within ', TIMESTAMP_DIFF(first_charge, burst_start, MINUTE), ' minutes',
+      ' followed by ', charge_count, IF(charge_count = 1, ' large charge', ' large charges'),
+      ' totaling $', FORMAT('%\'.0f', charge_amount)
+    ) as description,
+    CASE 
+      WHEN micro_count * card_testing_weight >= 80 THEN 'HIGH'
+      WHEN micro_count * card_testing_weight >= 50 THEN 'MEDIUM'
+      ELSE 'LOW'
+    END as priority,
+    micro_amount + charge_amount as total_amount,
+    'OPEN' as status,
+    CURRENT_DATE() as detection_date,
+    CURRENT_TIMESTAMP() as created_at
+  FROM card_testing_analysis;
+  
+  -- ===========================================
+  -- 5. DORMANCY REACTIVATION DETECTION
+  -- Large activity soon after a long idle period
+  -- ===========================================
+  INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+  WITH customer_activity AS (
+    SELECT 
+      CONCAT(first, '_', last) as customer_id,
+      CONCAT(first, '|', last, '|', CAST(dob AS STRING)) as person_key,
+      trans_date_trans_time,
+      DATE(trans_date_trans_time) as transaction_date,
+      amt,
+      -- The previous activity can be arbitrarily old, so the full history is read
+      LAG(DATE(trans_date_trans_time)) OVER (
+        PARTITION BY CONCAT(first, '|', last, '|', CAST(dob AS STRING)) 
+        ORDER BY trans_date_trans_time
+      ) as previous_date
+    FROM `anlaytics-465216.aml_data.credit_card_transactions`
+  ),
+
+  reactivations AS (
+    SELECT 
+      person_key,
+      customer_id,
+      transaction_date as reactivation_date,
+      previous_date,
+      DATE_DIFF(transaction_date, previous_date, DAY) as dormant_days
+    FROM customer_activity
+    WHERE DATE_DIFF(transaction_date, previous_date, DAY) >= dormancy_min_days
+  ),
+
+  -- Activity in the window after each reactivation, with a running total to
+  -- find the transaction that crosses the amount threshold
+  reactivation_window AS (
+    SELECT 
+      r.person_key,
+      r.customer_id,
+      r.reactivation_date,
+      r.previous_date,
+      r.dormant_days,
+      a.trans_date_trans_time,
+      a.amt,
+      SUM(a.amt) OVER (
+        PARTITION BY r.person_key, r.reactivation_date 
+        ORDER BY a.trans_date_trans_time 
+        ROWS UNBOUNDED PRECEDING
+      ) as running_amount
+    FROM reactivations r
+    JOIN customer_activity a
+      ON a.person_key = r.person_key
+      AND a.transaction_date >= r.reactivation_date
+      AND DATE_DIFF(a.transaction_date, r.reactivation_date, DAY) < dormancy_window_days
+  ),
+
+  dormancy_analysis AS (
+    SELECT 
+      person_key,
+      customer_id,
+      reactivation_date,
+      previous_date,
+      dormant_days,
+      COUNT(*) as transaction_count,
+      SUM(amt) as total_amount,
+      MIN(IF(running_amount >= dormancy_min_amount, trans_date_trans_time, NULL)) as crossing_time
+    FROM reactivation_window
+    GROUP BY person_key, customer_id, reactivation_date, previous_date, dormant_days
+    HAVING SUM(amt) >= dormancy_min_amount
+  ),
+
+  dormancy_scores AS (
+    SELECT 
+      *,
+      LEAST(
+        dormancy_weight * (DIV(dormant_days, dormancy_min_days) + CAST(FLOOR(total_amount / dormancy_min_amount) AS INT64)),
+        100
+      ) as risk_score
+    FROM dormancy_analysis
+    WHERE TRUE
+      AND crossing_time > last_processed_time  -- threshold crossed by new data
+  )
+
+  SELECT 
+    (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+    ROW_NUMBER() OVER (ORDER BY dormant_days DESC) as alert_id,
+    customer_id,
+    reactivation_date as alert_date,
+    'DORMANCY_REACTIVATION' as alert_type,
+    risk_score,
+    CONCAT(
+      'Customer reactivated after ', dormant_days, ' days dormant',
+      ' (last activity ', CAST(previous_date AS STRING), ')',
+      ' and moved $', FORMAT('%\'.0f', total_amount),
+      ' in ', transaction_count, IF(transaction_count = 1, ' transaction', ' transactions'),
+      ' within ', dormancy_window_days, IF(dormancy_window_days = 1, ' day', ' days')
+    ) as description,
+    CASE 
+      WHEN risk_score >= 80 THEN 'HIGH'
+      WHEN risk_score >= 50 THEN 'MEDIUM'
+      ELSE 'LOW'
+    END as priority,
+    total_amount,
+    'OPEN' as status,
+    CURRENT_DATE() as detection_date,
+    CURRENT_TIMESTAMP() as created_at
+  FROM dormancy_scores;
+  
+  -- ===========================================
+  -- 6. HIGH-RISK MERCHANT DETECTION
+  -- Payments to merchants scored high risk in merchant_risk_scores
+  -- (refreshed by: merchants score)
+  -- ===========================================
+  INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+  WITH high_risk_transactions AS (
+    SELECT 
+      CONCAT(t.first, '_', t.last) as customer_id,
+      CONCAT(t.first, '|', t.last, '|', CAST(t.dob AS STRING)) as person_key,
+      DATE(t.trans_date_trans_time) as transaction_date,
+      t.merchant,
+      t.amt,
+      m.risk_score as merchant_risk_score
+    FROM `anlaytics-465216.aml_data.credit_card_transactions` t
+    JOIN `anlaytics-465216.aml_data.merchant_risk_scores` m
+      ON m.merchant = t.merchant
+    WHERE m.is_high_risk
+      AND t.trans_date_trans_time > last_processed_time
+  ),
+
+  high_risk_merchant_analysis AS (
+    SELECT 
+      person_key,
+      customer_id,
+      transaction_date,
+      COUNT(*) as transaction_count,
+      SUM(amt) as total_amount,
+      MAX(merchant_risk_score) as top_merchant_score,
+      STRING_AGG(DISTINCT merchant, ', ' ORDER BY merchant) as merchants
+    FROM high_risk_transactions
+    GROUP BY person_key, customer_id, transaction_date
+  ),
+
+  high_risk_merchant_scores AS (
+    SELECT 
+      *,
+      LEAST(top_merchant_score + (transaction_count - 1) * high_risk_merchant_weight, 100) as risk_score
+    FROM high_risk_merchant_analysis
+  )
+
+  SELECT 
+    (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+    ROW_NUMBER() OVER (ORDER BY risk_score DESC) as alert_id,
+    customer_id,
+    transaction_date as alert_date,
+    'HIGH_RISK_MERCHANT' as alert_type,
+    risk_score,
+    CONCAT(
+      'Customer made ', transaction_count, IF(transaction_count = 1, ' transaction', ' transactions'),
+      ' totaling $', FORMAT('%\'.0f', total_amount),
+      ' at high-risk merchants (', merchants, ')'
+    ) as description,
+    CASE 
+      WHEN risk_score >= 80 THEN 'HIGH'
+      WHEN risk_score >= 50 THEN 'MEDIUM'
+      ELSE 'LOW'
+    END as priority,
+    total_amount,
+    'OPEN' as status,
+    CURRENT_DATE() as detection_date,
+    CURRENT_TIMESTAMP() as created_at
+  FROM high_risk_merchant_scores;
+  
+  -- ===========================================
+  -- 7. MERCHANT ANOMALY DETECTION
+  -- Funnels of near-identical payments from unrelated customers and
+  -- daily volume spikes, raised on the merchant
+  -- ===========================================
+  INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+  WITH merchant_transactions AS (
+    SELECT 
+      merchant,
+      CONCAT(first, '|', last, '|', CAST(dob AS STRING)) as person_key,
+      cc_num,
+      DATE(trans_date_trans_time) as transaction_date,
+      trans_date_trans_time,
+      amt
+    FROM `anlaytics-465216.aml_data.credit_card_transactions`
+    -- New data plus the baseline days before it
+    WHERE trans_date_trans_time > TIMESTAMP_SUB(last_processed_time, INTERVAL merchant_spike_baseline_days + 1 DAY)
+  ),
+
+  merchant_days AS (
+    SELECT 
+      merchant,
+      transaction_date,
+      COUNT(*) as transaction_count,
+      SUM(amt) as total_amount
+    FROM merchant_transactions
+    GROUP BY merchant, transaction_date
+    HAVING MAX(trans_date_trans_time) > last_processed_time  -- days with new data
+  ),
+
+  -- Funnels: the amount band [amt, amt * (1 + tolerance)] paid by the most
+  -- distinct customers on the day
+  funnel_bands AS (
+    SELECT 
+      b.merchant,
+      b.transaction_date,
+      b.amt as band_low,
+      MAX(a.amt) as band_high,
+      COUNT(*) as funnel_transactions,
+      COUNT(DISTINCT a.person_key) as funnel_customers,
+      COUNT(DISTINCT a.cc_num) as funnel_cards,
+      SUM(a.amt) as funnel_amount
+    FROM (SELECT DISTINCT merchant, transaction_date, amt FROM merchant_transactions) b
+    JOIN merchant_transactions a
+      ON a.merchant = b.merchant
+      AND a.transaction_date = b.transaction_date
+      AND a.amt BETWEEN b.amt AND b.amt * (1 + merchant_funnel_amount_tolerance)
+    GROUP BY b.merchant, b.transaction_date, b.amt
+    QUALIFY ROW_NUMBER() OVER (
+      PARTITION BY b.merchant, b.transaction_date 
+      ORDER BY COUNT(DISTINCT a.person_key) DESC, SUM(a.amt) DESC, b.amt
+    ) = 1
+  ),
+
+  -- Spikes: the day's takings against the daily average of the baseline days
+  -- before it
+  daily_baseline AS (
+    SELECT 
+      d.merchant,
+      d.transaction_date,
+      SUM(p.amt) / merchant_spike_baseline_days as baseline_daily_amount
+    FROM merchant_days d
+    JOIN merchant_transactions p
+      ON p.merchant = d.merchant
+      AND p.transaction_date BETWEEN DATE_SUB(d.transaction_date, INTERVAL merchant_spike_baseline_days DAY) 
+        AND DATE_SUB(d.transaction_date, INTERVAL 1 DAY)
+    GROUP BY d.merchant, d.transaction_date
+  ),
+
+  merchant_anomaly_analysis AS (
+    SELECT 
+      d.merchant,
+      d.transaction_date,
+      d.transaction_count,
+      d.total_amount,
+      f.band_low,
+      f.band_high,
+      f.funnel_transactions,
+      f.funnel_customers,
+      f.funnel_cards,
+      f.funnel_amount,
+      b.baseline_daily_amount,
+      d.total_amount / b.baseline_daily_amount as spike_ratio,
+      IFNULL(f.funnel_customers >= merchant_funnel_min_customers, FALSE) as is_funnel,
+      IFNULL(
+        d.transaction_count >= merchant_spike_min_transactions 
+        AND d.total_amount >= merchant_spike_multiplier * b.baseline_daily_amount,
+        FALSE
+      ) as is_spike
+    FROM merchant_days d
+    LEFT JOIN funnel_bands f
+      ON f.merchant = d.merchant AND f.transaction_date = d.transaction_date
+    LEFT JOIN daily_baseline b
+      ON b.merchant = d.merchant AND b.transaction_date = d.transaction_date
+  ),
+
+  merchant_anomaly_scores AS (
+    SELECT 
+      *,
+      LEAST(
+        IF(is_funnel, funnel_customers * merchant_funnel_weight, 0) +
+        IF(is_spike, CAST(FLOOR(spike_ratio) AS INT64) * merchant_spike_weight, 0),
+        100
+      ) as risk_score
+    FROM merchant_anomaly_analysis
+    WHERE is_funnel OR is_spike
+  )
+
+  SELECT 
+    (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+    ROW_NUMBER() OVER (ORDER BY risk_score DESC) as alert_id,
+    CONCAT('merchant:', merchant) as customer_id,  -- merchant alerts are raised on the merchant
+    transaction_date as alert_date,
+    'MERCHANT_ANOMALY' as alert_type,
+    risk_score,
+    ARRAY_TO_STRING([
+      IF(is_funnel, CONCAT(
+        'Merchant received ', funnel_transactions, ' payments ',
+        IF(band_low = band_high, 
+          CONCAT('of $', FORMAT('%.2f', band_low)),
+          CONCAT('between $', FORMAT('%.2f', band_low), ' and $', FORMAT('%.2f', band_high))),
+        ' from ', funnel_customers, ' unrelated customers on ', 
+        funnel_cards, IF(funnel_cards = 1, ' card', ' cards')
+      ), NULL),
+      IF(is_spike, CONCAT(
+        'Merchant took $', FORMAT('%\'.0f', total_amount),
+        ' in ', transaction_count, IF(transaction_count = 1, ' transaction', ' transactions'),
+        ', ', FORMAT('%.1f', spike_ratio), 'x its ', merchant_spike_baseline_days,
+        '-day daily average of $', FORMAT('%\'.0f', baseline_daily_amount)
+      ), NULL)
+    ], '; ') as description,
+    CASE 
+      WHEN risk_score >= 80 THEN 'HIGH'
+      WHEN risk_score >= 50 THEN 'MEDIUM'
+      ELSE 'LOW'
+    END as priority,
+    IF(is_spike, total_amount, funnel_amount) as total_amount,
+    'OPEN' as status,
+    CURRENT_DATE() as detection_date,
+    CURRENT_TIMESTAMP() as created_at
+  FROM merchant_anomaly_scores;
+  
+  -- ===========================================
+  -- 8. BEHAVIOURAL BASELINE DEVIATION DETECTION
+  -- New transactions against each customer's amount, distance from home,
+  -- category and hour-of-day baseline (customer_baselines)
+  -- ===========================================
+  INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+  WITH new_transactions AS (
+    SELECT 
+      CONCAT(first, '|', last, '|', CAST(dob AS STRING)) as person_key,
+      CONCAT(first, '_', last) as customer_id,
+      DATE(trans_date_trans_time) as transaction_date,
+      amt,
+      category,
+      EXTRACT(HOUR FROM trans_date_trans_time) as transaction_hour,
+      ST_DISTANCE(ST_GEOGPOINT(long, lat), ST_GEOGPOINT(merch_long, merch_lat)) / 1000 as home_distance
+    FROM `anlaytics-465216.aml_data.credit_card_transactions`
+    WHERE trans_date_trans_time > last_processed_time
+      AND amt >= behaviour_min_amount
+  ),
+
+  -- Baselines as they stood before this batch (updated in the next section)
+  baseline_moments AS (
+    SELECT 
+      person_key,
+      MAX(IF(dimension = 'AMOUNT', transaction_count, NULL)) as history,
+      MAX(IF(dimension = 'AMOUNT', value_sum / transaction_count, NULL)) as amount_mean,
+      MAX(IF(dimension = 'AMOUNT', 
+        SQRT(GREATEST(value_sum_squares / transaction_count - POW(value_sum / transaction_count, 2), 0)), NULL)) as amount_stddev,
+      MAX(IF(dimension = 'DISTANCE', value_sum / transaction_count, NULL)) as distance_mean,
+      MAX(IF(dimension = 'DISTANCE', 
+        SQRT(GREATEST(value_sum_squares / transaction_count - POW(value_sum / transaction_count, 2), 0)), NULL)) as distance_stddev
+    FROM `anlaytics-465216.aml_data.customer_baselines`
+    WHERE dimension IN ('AMOUNT', 'DISTANCE')
+    GROUP BY person_key
+  ),
+
+  -- Standard deviations are floored at 1 so very regular customers do not
+  -- produce infinite z-scores
+  transaction_scores AS (
+    SELECT 
+      t.*,
+      b.amount_mean,
+      b.distance_mean,
+      (t.amt - b.amount_mean) / GREATEST(b.amount_stddev, 1) as amount_z,
+      (t.home_distance - b.distance_mean) / GREATEST(b.distance_stddev, 1) as distance_z,
+      IFNULL(c.transaction_count, 0) / b.history as category_share,
+      IFNULL(h.transaction_count, 0) / b.history as hour_share
+    FROM new_transactions t
+    JOIN baseline_moments b
+      ON b.person_key = t.person_key
+      AND b.history >= behaviour_min_history
+    LEFT JOIN `anlaytics-465216.aml_data.customer_baselines` c
+      ON c.person_key = t.person_key AND c.dimension = 'CATEGORY' AND c.bucket = t.category
+    LEFT JOIN `anlaytics-465216.aml_data.customer_baselines` h
+      ON h.person_key = t.person_key AND h.dimension = 'HOUR' AND h.bucket = CAST(t.transaction_hour AS STRING)
+  ),
+
+  -- Expected versus observed for every dimension that deviates
+  transaction_deviations AS (
+    SELECT 
+      *,
+      ARRAY(
+        SELECT reason FROM UNNEST([
+          IF(amount_z >= behaviour_z_score, CONCAT(
+            'amount $', FORMAT('%\'.0f', amt), ' vs expected $', FORMAT('%\'.0f', amount_mean),
+            ' (z ', FORMAT('%.1f', amount_z), ')'), NULL),
+          IF(distance_z >= behaviour_z_score, CONCAT(
+            FORMAT('%.0f', home_distance), ' km from home vs expected ', FORMAT('%.0f', distance_mean),
+            ' km (z ', FORMAT('%.1f', distance_z), ')'), NULL),
+          IF(category_share < behaviour_rare_share, CONCAT(
+            'category ', category, ' in ', FORMAT('%.0f%%', category_share * 100),
+            ' of history vs at least ', FORMAT('%.0f%%', behaviour_rare_share * 100), ' expected'), NULL),
+          IF(hour_share < behaviour_rare_share, CONCAT(
+            'hour ', FORMAT('%02d:00', transaction_hour), ' in ', FORMAT('%.0f%%', hour_share * 100),
+            ' of history vs at least ', FORMAT('%.0f%%', behaviour_rare_share * 100), ' expected'), NULL)
+        ]) AS reason
+        WHERE reason IS NOT NULL
+      ) as reasons
+    FROM transaction_scores
+  ),
+
+  behaviour_analysis AS (
+    SELECT 
+      person_key,
+      customer_id,
+      transaction_date,
+      COUNT(*) as transaction_count,
+      SUM(amt) as total_amount,
+      SUM(ARRAY_LENGTH(reasons)) as deviation_count,
+      ARRAY_AGG(STRUCT(amt, reasons) ORDER BY ARRAY_LENGTH(reasons) DESC, amt DESC LIMIT 1)[OFFSET(0)] as most_unusual
+    FROM transaction_deviations
+    WHERE ARRAY_LENGTH(reasons) >= behaviour_min_deviations
+    GROUP BY person_key, customer_id, transaction_date
+  ),
+
+  behaviour_scores AS (
+    SELECT 
+      *,
+      LEAST(deviation_count * behaviour_weight, 100) as risk_score
+    FROM behaviour_analysis
+  )
+
+  SELECT 
+    (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+    ROW_NUMBER() OVER (ORDER BY risk_score DESC) as alert_id,
+    customer_id,
+    transaction_date as alert_date,
+    'BEHAVIOUR_DEVIATION' as alert_type,
+    risk_score,
+    IF(transaction_count = 1,
+      CONCAT(
+        'Transaction of $', FORMAT('%\'.0f', most_unusual.amt),
+        " deviated from the customer's baseline: ", ARRAY_TO_STRING(most_unusual.reasons, '; ')
+      ),
+      CONCAT(
+        transaction_count, ' transactions totaling $', FORMAT('%\'.0f', total_amount),
+        " deviated from the customer's baseline; most unusual: ", ARRAY_TO_STRING(most_unusual.reasons, '; ')
+      )
+    ) as description,
+    CASE 
+      WHEN risk_score >= 80 THEN 'HIGH'
+      WHEN risk_score >= 50 THEN 'MEDIUM'
+      ELSE 'LOW'
+    END as priority,
+    total_amount,
+    'OPEN' as status,
+    CURRENT_DATE() as detection_date,
+    CURRENT_TIMESTAMP() as created_at
+  FROM behaviour_scores;
+  
+  -- ===========================================
+  -- 9. UPDATE BEHAVIOURAL BASELINES
+  -- Fold the new transactions into customer_baselines
+  -- ===========================================
+  MERGE `anlaytics-465216.aml_data.customer_baselines` AS target
+  USING (
+    WITH baseline_transactions AS (
+      SELECT 
+        CONCAT(first, '|', last, '|', CAST(dob AS STRING)) as person_key,
+        CONCAT(first, '_', last) as customer_id,
+        trans_date_trans_time,
+        amt,
+        category,
+        CAST(EXTRACT(HOUR FROM trans_date_trans_time) AS STRING) as transaction_hour,
+        ST_DISTANCE(ST_GEOGPOINT(long, lat), ST_GEOGPOINT(merch_long, merch_lat)) / 1000 as home_distance,
+        MAX(trans_date_trans_time) OVER (PARTITION BY first, last, dob) as last_transaction
+      FROM `anlaytics-465216.aml_data.credit_card_transactions`
+      WHERE trans_date_trans_time > last_processed_time
+    )
+    SELECT person_key, customer_id, 'AMOUNT' as dimension, '' as bucket,
+      COUNT(*) as transaction_count, SUM(amt) as value_sum, SUM(amt * amt) as value_sum_squares,
+      MAX(last_transaction) as last_transaction, CURRENT_TIMESTAMP() as updated_at
+    FROM baseline_transactions GROUP BY person_key, customer_id
+    UNION ALL
+    SELECT person_key, customer_id, 'DISTANCE', '',
+      COUNT(*), SUM(home_distance), SUM(home_distance * home_distance), MAX(last_transaction), CURRENT_TIMESTAMP()
+    FROM baseline_transactions GROUP BY person_key, customer_id
+    UNION ALL
+    SELECT person_key, customer_id, 'CATEGORY', category, COUNT(*), 0.0, 0.0, MAX(last_transaction), CURRENT_TIMESTAMP()
+    FROM baseline_transactions GROUP BY person_key, customer_id, category
+    UNION ALL
+    SELECT person_key, customer_id, 'HOUR', transaction_hour, COUNT(*), 0.0, 0.0, MAX(last_transaction), CURRENT_TIMESTAMP()
+    FROM baseline_transactions GROUP BY person_key, customer_id, transaction_hour
+  ) AS source
+  ON target.person_key = source.person_key
+    AND target.dimension = source.dimension
+    AND target.bucket = source.bucket
+  WHEN MATCHED THEN
+    UPDATE SET
+      transaction_count = target.transaction_count + source.transaction_count,
+      value_sum = target.value_sum + source.value_sum,
+      value_sum_squares = target.value_sum_squares + source.value_sum_squares,
+      last_transaction = GREATEST(target.last_transaction, source.last_transaction),
+      updated_at = CURRENT_TIMESTAMP()
+  WHEN NOT MATCHED THEN
+    INSERT (person_key, customer_id, dimension, bucket, transaction_count, value_sum, value_sum_squares, last_transaction, updated_at)
+    VALUES (source.person_key, source.customer_id, source.dimension, source.bucket, source.transaction_count,
+      source.value_sum, source.value_sum_squares, source.last_transaction, CURRENT_TIMESTAMP());
+  
+  -- ===========================================
+  -- 10. PEER OUTLIER DETECTION
+  -- Rebuild customer_peer_metrics over the peer window, then alert
+  -- outliers with new activity
+  -- ===========================================
+  CREATE OR REPLACE TABLE `anlaytics-465216.aml_data.customer_peer_metrics` AS
+  WITH peer_window AS (
+    SELECT MAX(trans_date_trans_time) as window_end
+    FROM `anlaytics-465216.aml_data.credit_card_transactions`
+  ),
+
+  customer_activity AS (
+    SELECT 
+      CONCAT(t.first, '|', t.last, '|', CAST(t.dob AS STRING)) as person_key,
+      CONCAT(t.first, '_', t.last) as customer_id,
+      ANY_VALUE(t.job) as job,
+      ANY_VALUE(t.dob) as dob,
+      ANY_VALUE(t.city_pop) as city_pop,
+      SUM(t.amt) as total_amount,
+      COUNT(*) as transaction_count,
+      AVG(t.amt) as avg_amount,
+      COUNTIF(EXTRACT(HOUR FROM t.trans_date_trans_time) >= 22 
+        OR EXTRACT(HOUR FROM t.trans_date_trans_time) < 6) / COUNT(*) as night_share,
+      COUNTIF(ENDS_WITH(t.category, '_net')) / COUNT(*) as online_share,
+      MAX(t.trans_date_trans_time) as last_transaction,
+      ANY_VALUE(w.window_end) as window_end
+    FROM `anlaytics-465216.aml_data.credit_card_transactions` t
+    CROSS JOIN peer_window w
+    WHERE t.trans_date_trans_time > TIMESTAMP_SUB(w.window_end, INTERVAL peer_window_days DAY)
+    GROUP BY person_key, customer_id
+  ),
+
+  -- Age at the end of the window and urban/rural from city_pop
+  classified AS (
+    SELECT 
+      * EXCEPT (dob, city_pop),
+      CASE 
+        WHEN age < 25 THEN 'under 25'
+        WHEN age < 35 THEN '25-34'
+        WHEN age < 45 THEN '35-44'
+        WHEN age < 55 THEN '45-54'
+        WHEN age < 65 THEN '55-64'
+        ELSE '65+'
+      END as age_band,
+      IF(city_pop >= peer_urban_population, 'URBAN', 'RURAL') as area_type
+    FROM (
+      SELECT 
+        *,
+        DATE_DIFF(DATE(window_end), dob, YEAR) - 
+          IF(EXTRACT(DAYOFYEAR FROM window_end) < EXTRACT(DAYOFYEAR FROM dob), 1, 0) as age
+      FROM customer_activity
+    )
+  ),
+
+  -- Candidate groups from most to least specific, with their sizes
+  group_sizes AS (
+    SELECT 
+      *,
+      COUNT(*) OVER (PARTITION BY job, age_band, area_type) as job_group_size,
+      COUNT(*) OVER (PARTITION BY age_band, area_type) as age_group_size,
+      COUNT(*) OVER (PARTITION BY area_type) as area_group_size
+    FROM classified
+  ),
+
+  -- Each customer is compared with the most specific group of peer_min_group_size+
+  peer_groups AS (
+    SELECT 
+      * EXCEPT (job_group_size, age_group_size, area_group_size),
+      CASE 
+        WHEN job_group_size >= peer_min_group_size THEN 'JOB_AGE_AREA'
+        WHEN age_group_size >= peer_min_group_size THEN 'AGE_AREA'
+        WHEN area_group_size >= peer_min_group_size THEN 'AREA'
+      END as peer_level,
+      CASE 
+        WHEN job_group_size >= peer_min_group_size THEN CONCAT(job, ', ', age_band, ', ', LOWER(area_type))
+        WHEN age_group_size >= peer_min_group_size THEN CONCAT(age_band, ', ', LOWER(area_type))
+        WHEN area_group_size >= peer_min_group_size THEN LOWER(area_type)
+      END as peer_group,
+      CASE 
+        WHEN job_group_size >= peer_min_group_size THEN job_group_size
+        WHEN age_group_size >= peer_min_group_size THEN age_group_size
+        WHEN area_group_size >= peer_min_group_size THEN area_group_size
+      END as peer_group_size
+    FROM group_sizes
+  ),
+
+  peer_medians AS (
+    SELECT 
+      *,
+      PERCENTILE_CONT(total_amount, 0.5) OVER (PARTITION BY peer_group) as total_amount_median,
+      PERCENTILE_CONT(transaction_count, 0.5) OVER (PARTITION BY peer_group) as transaction_count_median,
+      PERCENTILE_CONT(avg_amount, 0.5) OVER (PARTITION BY peer_group) as avg_amount_median,
+      PERCENTILE_CONT(night_share, 0.5) OVER (PARTITION BY peer_group) as night_share_median,
+      PERCENTILE_CONT(online_share, 0.5) OVER (PARTITION BY peer_group) as online_share_median
+    FROM peer_groups
+  ),
+
+  peer_spreads AS (
+    SELECT 
+      *,
+      PERCENTILE_CONT(ABS(total_amount - total_amount_median), 0.5) OVER (PARTITION BY peer_group) as total_amount_mad,
+      PERCENTILE_CONT(ABS(transaction_count - transaction_count_median), 0.5) OVER (PARTITION BY peer_group) as transaction_count_mad,
+      PERCENTILE_CONT(ABS(avg_amount - avg_amount_median), 0.5) OVER (PARTITION BY peer_group) as avg_amount_mad,
+      PERCENTILE_CONT(ABS(night_share - night_share_median), 0.5) OVER (PARTITION BY peer_group) as night_share_mad,
+      PERCENTILE_CONT(ABS(online_share - online_share_median), 0.5) OVER (PARTITION BY peer_group) as online_share_mad
+    FROM peer_medians
+  ),
+
+  -- Robust z-scores: distance from the group median in units of 1.4826 times
+  -- the median absolute deviation, floored at 10% of the median
+  peer_scores AS (
+    SELECT 
+      *,
+      IF(peer_group IS NULL, 0, (total_amount - total_amount_median) / GREATEST(1.4826 * total_amount_mad, 0.1 * ABS(total_amount_median), 1)) as total_amount_z,
+      IF(peer_group IS NULL, 0, (transaction_count - transaction_count_median) / GREATEST(1.4826 * transaction_count_mad, 0.1 * ABS(transaction_count_median), 1)) as transaction_count_z,
+      IF(peer_group IS NULL, 0, (avg_amount - avg_amount_median) / GREATEST(1.4826 * avg_amount_mad, 0.1 * ABS(avg_amount_median), 1)) as avg_amount_z,
+      IF(peer_group IS NULL, 0, (night_share - night_share_median) / GREATEST(1.4826 * night_share_mad, 0.1 * ABS(night_share_median), 0.05)) as night_share_z,
+      IF(peer_group IS NULL, 0, (online_share - online_share_median) / GREATEST(1.4826 * online_share_mad, 0.1 * ABS(online_share_median), 0.05)) as online_share_z
+    FROM peer_spreads
+  )
+
+  SELECT 
+    person_key,
+    customer_id,
+    job,
+    age_band,
+    area_type,
+    peer_level,
+    peer_group,
+    peer_group_size,
+    total_amount,
+    transaction_count,
+    avg_amount,
+    night_share,
+    online_share,
+    total_amount_z,
+    transaction_count_z,
+    avg_amount_z,
+    night_share_z,
+    online_share_z,
+    IF(total_amount_z >= peer_z_score, 1, 0) +
+        IF(transaction_count_z >= peer_z_score, 1, 0) +
+        IF(avg_amount_z >= peer_z_score, 1, 0) +
+        IF(night_share_z >= peer_z_score, 1, 0) +
+        IF(online_share_z >= peer_z_score, 1, 0) as outlier_metrics,
+    last_transaction,
+    window_end
+  FROM peer_scores;
+
+  INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+  WITH peer_comparison AS (
+    SELECT 
+      *,
+      PERCENTILE_CONT(total_amount, 0.5) OVER (PARTITION BY peer_group) as total_amount_median,
+      PERCENTILE_CONT(transaction_count, 0.5) OVER (PARTITION BY peer_group) as transaction_count_median,
+      PERCENTILE_CONT(avg_amount, 0.5) OVER (PARTITION BY peer_group) as avg_amount_median,
+      PERCENTILE_CONT(night_share, 0.5) OVER (PARTITION BY peer_group) as night_share_median,
+      PERCENTILE_CONT(online_share, 0.5) OVER (PARTITION BY peer_group) as online_share_median
+    FROM `anlaytics-465216.aml_data.customer_peer_metrics`
+    WHERE peer_group IS NOT NULL
+  ),
+
+  peer_outliers AS (
+    SELECT 
+      *,
+      LEAST(outlier_metrics * peer_outlier_weight, 100) as risk_score,
+      -- Observed versus the peer median for every outlying metric
+      ARRAY_TO_STRING([
+        IF(total_amount_z >= peer_z_score, CONCAT(
+          'volume $', FORMAT('%\'.0f', total_amount), ' vs peer median $', FORMAT('%\'.0f', total_amount_median),
+          ' (z ', FORMAT('%.1f', total_amount_z), ')'), NULL),
+        IF(transaction_count_z >= peer_z_score, CONCAT(
+          'transactions ', transaction_count, ' vs peer median ', FORMAT('%.0f', transaction_count_median),
+          ' (z ', FORMAT('%.1f', transaction_count_z), ')'), NULL),
+        IF(avg_amount_z >= peer_z_score, CONCAT(
+          'average amount $', FORMAT('%\'.0f', avg_amount), ' vs peer median $', FORMAT('%\'.0f', avg_amount_median),
+          ' (z ', FORMAT('%.1f', avg_amount_z), ')'), NULL),
+        IF(night_share_z >= peer_z_score, CONCAT(
+          'night share ', FORMAT('%.0f%%', night_share * 100), ' vs peer median ', FORMAT('%.0f%%', night_share_median * 100),
+          ' (z ', FORMAT('%.1f', night_share_z), ')'), NULL),
+        IF(online_share_z >= peer_z_score, CONCAT(
+          'online share ', FORMAT('%.0f%%', online_share * 100), ' vs peer median ', FORMAT('%.0f%%', online_share_median * 100),
+          ' (z ', FORMAT('%.1f', online_share_z), ')'), NULL)
+      ], '; ') as reasons
+    FROM peer_comparison
+    WHERE outlier_metrics > 0
+      AND last_transaction > last_processed_time
+  )
+
+  SELECT 
+    (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+    ROW_NUMBER() OVER (ORDER BY risk_score DESC) as alert_id,
+    customer_id,
+    DATE(last_transaction) as alert_date,
+    'PEER_OUTLIER' as alert_type,
+    risk_score,
+    CONCAT(
+      "Customer's ", peer_window_days, '-day activity is an outlier among ', peer_group_size,
+      ' peers (', peer_group, '): ', reasons
+    ) as description,
+    CASE 
+      WHEN risk_score >= 80 THEN 'HIGH'
+      WHEN risk_score >= 50 THEN 'MEDIUM'
+      ELSE 'LOW'
+    END as priority,
+    total_amount,
+    'OPEN' as status,
+    CURRENT_DATE() as detection_date,
+    CURRENT_TIMESTAMP() as created_at
+  FROM peer_outliers;
+  
+  -- ===========================================
+  -- 11. FRAUD MODEL SCORING
+  -- Calibrated fraud probability of each new transaction from the
+  -- coefficients of the current model (written by: model fraud-train)
+  -- ===========================================
+  INSERT INTO `anlaytics-465216.aml_data.fraud_scores` 
+    (trans_num, person_key, customer_id, trans_date_trans_time, fraud_probability, top_factors, model_version, scored_at)
+  WITH new_cardholders AS (
+    SELECT DISTINCT first, last, dob
+    FROM `anlaytics-465216.aml_data.credit_card_transactions`
+    WHERE trans_date_trans_time > last_processed_time
+  ),
+
+  -- The features of model.TransactionVectors over each cardholder's full
+  -- history, so ratios and 24-hour counts see earlier batches
+  transaction_features AS (
+    SELECT 
+      t.trans_num,
+      CONCAT(t.first, '|', t.last, '|', CAST(t.dob AS STRING)) as person_key,
+      CONCAT(t.first, '_', t.last) as customer_id,
+      t.trans_date_trans_time,
+      t.category,
+      LN(1 + t.amt) as log_amount,
+      IFNULL(SAFE_DIVIDE(t.amt, AVG(t.amt) OVER (
+        PARTITION BY t.first, t.last, t.dob 
+        ORDER BY t.trans_date_trans_time
+        ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING
+      )), 1) as amount_ratio,
+      IF(EXTRACT(HOUR FROM t.trans_date_trans_time) >= 22 OR EXTRACT(HOUR FROM t.trans_date_trans_time) < 6, 1, 0) as night,
+      IF(ENDS_WITH(t.category, '_net'), 1, 0) as online,
+      ST_DISTANCE(ST_GEOGPOINT(t.long, t.lat), ST_GEOGPOINT(t.merch_long, t.merch_lat)) / 1000 as distance_km,
+      DATE_DIFF(DATE(t.trans_date_trans_time), t.dob, DAY) / 365.25 as age,
+      LN(1 + t.city_pop) as log_city_pop,
+      COUNT(*) OVER (
+        PARTITION BY t.first, t.last, t.dob 
+        ORDER BY UNIX_SECONDS(t.trans_date_trans_time)
+        RANGE BETWEEN 86400 PRECEDING AND 1 PRECEDING
+      ) as transactions_24h
+    FROM `anlaytics-465216.aml_data.credit_card_transactions` t
+    JOIN new_cardholders USING (first, last, dob)
+  ),
+
+  -- Each new transaction's standardised contribution to the log-odds, one row
+  -- per model feature
+  contributions AS (
+    SELECT 
+      f.trans_num,
+      f.person_key,
+      f.customer_id,
+      f.trans_date_trans_time,
+      c.model_version,
+      c.feature,
+      c.intercept,
+      c.calibration_a,
+      c.calibration_b,
+      c.weight * (
+        CASE c.feature
+          WHEN 'log_amount' THEN f.log_amount
+          WHEN 'amount_ratio' THEN f.amount_ratio
+          WHEN 'night' THEN f.night
+          WHEN 'online' THEN f.online
+          WHEN 'distance_km' THEN f.distance_km
+          WHEN 'age' THEN f.age
+          WHEN 'log_city_pop' THEN f.log_city_pop
+          WHEN 'transactions_24h' THEN f.transactions_24h
+          ELSE IF(c.feature = CONCAT('category_', f.category), 1, 0)
+        END - c.mean
+      ) / c.scale as contribution
+    FROM transaction_features f
+    CROSS JOIN `anlaytics-465216.aml_data.fraud_model_coefficients` c
+    WHERE f.trans_date_trans_time > last_processed_time
+  )
+
+  -- Platt-calibrated probability; top factors are the features raising it most
+  SELECT 
+    trans_num,
+    person_key,
+    customer_id,
+    trans_date_trans_time,
+    1 / (1 + EXP(-(ANY_VALUE(calibration_a) * (ANY_VALUE(intercept) + SUM(contribution)) + ANY_VALUE(calibration_b)))) as fraud_probability,
+    ARRAY_TO_STRING(ARRAY_AGG(
+      IF(calibration_a * contribution > 0, feature, NULL) IGNORE NULLS 
+      ORDER BY calibration_a * contribution DESC LIMIT 3
+    ), ', ') as top_factors,
+    model_version,
+    CURRENT_TIMESTAMP() as scored_at
+  FROM contributions
+  GROUP BY trans_num, person_key, customer_id, trans_date_trans_time, model_version;
+  
+  -- ===========================================
+  -- 12. RULE DETECTION
+  -- Scenarios defined in config/rules; the statements between the markers
+  -- are generated by: rules sql -into sql/incremental_aml_processing.sql
+  -- ===========================================
+  -- BEGIN GENERATED RULES (from config/rules by: rules sql -into)
+  -- Rule MERCHANT_HOPPING (config/rules/merchant_hopping.yaml)
+  -- Card used at {merchants} merchants in {categories} categories within an hour (${total_amount})
+  INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+  WITH rule_transactions AS (
+    SELECT
+      t.trans_num,
+      t.trans_date_trans_time,
+      t.amt,
+      CONCAT(t.first, '_', t.last) as customer_id,
+      t.merchant as merchant_name,
+      CAST(t.cc_num AS STRING) as key_0,
+      t.merchant as field_merchants,
+      t.category as field_categories,
+      t.amt as field_total_amount
+    FROM `anlaytics-465216.aml_data.credit_card_transactions` t
+    WHERE t.trans_date_trans_time >= TIMESTAMP_SUB(last_processed_time, INTERVAL 3600 SECOND)
+  ),
+
+  -- Each transaction closes a window over the earlier activity of its group
+  rule_windows AS (
+    SELECT
+      e.trans_num as end_trans_num,
+      e.trans_date_trans_time as window_end,
+      e.customer_id,
+      e.merchant_name,
+      e.key_0,
+      SUM(w.amt) as window_amount,
+      COUNT(DISTINCT w.field_merchants) as merchants,
+      COUNT(DISTINCT w.field_categories) as categories,
+      SUM(w.field_total_amount) as total_amount
+    FROM rule_transactions e
+    JOIN rule_transactions w
+      ON w.key_0 = e.key_0
+      AND w.trans_date_trans_time > TIMESTAMP_SUB(e.trans_date_trans_time, INTERVAL 3600 SECOND)
+      AND w.trans_date_trans_time <= e.trans_date_trans_time
+    WHERE e.trans_date_trans_time > last_processed_time
+    GROUP BY e.trans_num, e.trans_date_trans_time, e.customer_id, e.merchant_name, e.key_0
+  ),
+
+  -- Qualifying windows are scored; the best window of each group and day is reported
+  rule_scored AS (
+    SELECT
+      *,
+      CAST(LEAST(GREATEST(ROUND((((merchants * 12) + (categories * 5)) + LEAST(IFNULL(SAFE_DIVIDE(total_amount, 200), 0), 20))), 0), 100) AS INT64) as risk_score
+    FROM rule_windows
+    WHERE merchants >= 4
+  ),
+
+  rule_alerts AS (
+    SELECT *
+    FROM rule_scored
+    WHERE TRUE
+    QUALIFY ROW_NUMBER() OVER (PARTITION BY key_0, DATE(window_end) ORDER BY risk_score DESC, window_end) = 1
+  )
+
+  SELECT 
+    (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+    ROW_NUMBER() OVER (ORDER BY risk_score DESC, window_end) as alert_id,
+    customer_id as customer_id,
+    DATE(window_end) as alert_date,
+    'MERCHANT_HOPPING' as alert_type,
+    risk_score,
+    CONCAT(
+      'Card used at ',
+      FORMAT('%\'.0f', CAST(merchants AS FLOAT64)),
+      ' merchants in ',
+      FORMAT('%\'.0f', CAST(categories AS FLOAT64)),
+      ' categories within an hour ($',
+      FORMAT('%\'.0f', CAST(total_amount AS FLOAT64)),
+      ')'
+    ) as description,
+    CASE 
+      WHEN risk_score >= 80 THEN 'HIGH'
+      WHEN risk_score >= 50 THEN 'MEDIUM'
+      ELSE 'LOW'
+    END as priority,
+    window_amount as total_amount,
+    'OPEN' as status,
+    CURRENT_DATE() as detection_date,
+    CURRENT_TIMESTAMP() as created_at
+  FROM rule_alerts;
+
+  -- Rule NIGHT_ONLINE_SPEND (config/rules/night_online_spend.yaml)
+  -- {purchases} online purchases totalling ${total_amount} between 22:00 and 04:00 within 24 hours
+  INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+  WITH rule_transactions AS (
+    SELECT
+      t.trans_num,
+      t.trans_date_trans_time,
+      t.amt,
+      CONCAT(t.first, '_', t.last) as customer_id,
+      t.merchant as merchant_name,
+      CONCAT(t.first, '|', t.last, '|', CAST(t.dob AS STRING)) as key_0,
+      t.amt as field_total_amount,
+      t.amt as field_largest
+    FROM `anlaytics-465216.aml_data.credit_card_transactions` t
+    WHERE t.category IN ('shopping_net', 'misc_net', 'grocery_net')
+      AND EXTRACT(HOUR FROM t.trans_date_trans_time) IN (22, 23, 0, 1, 2, 3)
+      AND t.amt >= 200
+      AND t.trans_date_trans_time >= TIMESTAMP_SUB(last_processed_time, INTERVAL 86400 SECOND)
+  ),
+
+  -- Each transaction closes a window over the earlier activity of its group
+  rule_windows AS (
+    SELECT
+      e.trans_num as end_trans_num,
+      e.trans_date_trans_time as window_end,
+      e.customer_id,
+      e.merchant_name,
+      e.key_0,
+      SUM(w.amt) as window_amount,
+      COUNT(*) as purchases,
+      SUM(w.field_total_amount) as total_amount,
+      MAX(w.field_largest) as largest
+    FROM rule_transactions e
+    JOIN rule_transactions w
+      ON w.key_0 = e.key_0
+      AND w.trans_date_trans_time > TIMESTAMP_SUB(e.trans_date_trans_time, INTERVAL 86400 SECOND)
+      AND w.trans_date_trans_time <= e.trans_date_trans_time
+    WHERE e.trans_date_trans_time > last_processed_time
+    GROUP BY e.trans_num, e.trans_date_trans_time, e.customer_id, e.merchant_name, e.key_0
+  ),
+
+  -- Qualifying windows are scored; the best window of each group and day is reported
+  rule_scored AS (
+    SELECT
+      *,
+      CAST(LEAST(GREATEST(ROUND((((purchases * 15) + IFNULL(SAFE_DIVIDE(total_amount, 100), 0)) + IFNULL(SAFE_DIVIDE(GREATEST((largest - 500), 0), 50), 0))), 0), 100) AS INT64) as risk_score
+    FROM rule_windows
+    WHERE purchases >= 2
+      AND total_amount >= 1000
+  ),
+
+  rule_alerts AS (
+    SELECT *
+    FROM rule_scored
+    WHERE TRUE
+    QUALIFY ROW_NUMBER() OVER (PARTITION BY key_0, DATE(window_end) ORDER BY risk_score DESC, window_end) = 1
+  )
+
+  SELECT 
+    (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+    ROW_NUMBER() OVER (ORDER BY risk_score DESC, window_end) as alert_id,
+    customer_id as customer_id,
+    DATE(window_end) as alert_date,
+    'NIGHT_ONLINE_SPEND' as alert_type,
+    risk_score,
+    CONCAT(
+      FORMAT('%\'.0f', CAST(purchases AS FLOAT64)),
+      ' online purchases totalling $',
+      FORMAT('%\'.0f', CAST(total_amount AS FLOAT64)),
+      ' between 22:00 and 04:00 within 24 hours'
+    ) as description,
+    CASE 
+      WHEN risk_score >= 80 THEN 'HIGH'
+      WHEN risk_score >= 50 THEN 'MEDIUM'
+      ELSE 'LOW'
+    END as priority,
+    window_amount as total_amount,
+    'OPEN' as status,
+    CURRENT_DATE() as detection_date,
+    CURRENT_TIMESTAMP() as created_at
+  FROM rule_alerts;
+
+  -- END GENERATED RULES
+  
+  -- ===========================================
+  -- 13. DEDUPLICATE ALERTS
+  -- An alert repeating one raised by an earlier run for the same detector,
+  -- customer and window is dropped
+  -- ===========================================
+  DELETE FROM `anlaytics-465216.aml_data.aml_alerts_level1` n
+  WHERE n.alert_id >= first_alert_id
+    AND EXISTS (
+      SELECT 1
+      FROM `anlaytics-465216.aml_data.aml_alerts_level1` o
+      WHERE o.alert_id < first_alert_id
+        AND o.alert_type = n.alert_type
+        AND o.customer_id = n.customer_id
+        AND o.alert_date = n.alert_date
+    );
+
+  SET duplicate_alerts = @@row_count;
+  
+  -- ===========================================
+  -- 14. APPLY SUPPRESSION RULES
+  -- New alerts covered by an approved, unexpired and unrevoked rule are
+  -- recorded in suppressed_alerts and dropped. The SQL does not record
+  -- triggering transactions, so a merchant rule covers alerts on the merchant
+  -- itself and customer alerts on a day the customer only used the merchant.
+  -- Sanctions and watchlist hits are never suppressed.
+  -- ===========================================
+  INSERT INTO `anlaytics-465216.aml_data.suppressed_alerts`
+    (rule_id, source, run_id, customer_id, alert_type, alert_date, risk_score,
+     total_amount, description, suppressed_at)
+  WITH rules AS (
+    SELECT r.*
+    FROM `anlaytics-465216.aml_data.suppression_rules` r
+    WHERE r.expires >= CURRENT_DATE()
+      AND EXISTS (
+        SELECT 1 FROM `anlaytics-465216.aml_data.suppression_rule_events` e
+        WHERE e.rule_id = r.rule_id AND e.action = 'APPROVE'
+      )
+      AND NOT EXISTS (
+        SELECT 1 FROM `anlaytics-465216.aml_data.suppression_rule_events` e
+        WHERE e.rule_id = r.rule_id AND e.action = 'REVOKE'
+      )
+  ),
+  new_alerts AS (
+    SELECT *
+    FROM `anlaytics-465216.aml_data.aml_alerts_level1`
+    WHERE alert_id >= first_alert_id
+      AND alert_type NOT IN ('SANCTIONS_HIT', 'WATCHLIST')
+  ),
+  day_merchants AS (
+    SELECT
+      CONCAT(first, '_', last) as customer_id,
+      DATE(trans_date_trans_time) as alert_date,
+      ARRAY_AGG(DISTINCT merchant) as merchants
+    FROM `anlaytics-465216.aml_data.credit_card_transactions`
+    WHERE DATE(trans_date_trans_time) IN (SELECT alert_date FROM new_alerts)
+    GROUP BY customer_id, alert_date
+  ),
+  matches AS (
+    SELECT
+      a.alert_id,
+      MIN(r.rule_id) as rule_id
+    FROM new_alerts a
+    JOIN rules r
+      ON IFNULL(r.customer_id, '') IN ('', a.customer_id)
+      AND IFNULL(r.detector, '') IN ('', a.alert_type)
+      AND (r.from_date IS NULL OR a.alert_date >= r.from_date)
+      AND (r.to_date IS NULL OR a.alert_date <= r.to_date)
+    LEFT JOIN day_merchants d
+      ON d.customer_id = a.customer_id AND d.alert_date = a.alert_date
+    WHERE IFNULL(r.merchant, '') = ''
+      OR a.customer_id = CONCAT('merchant:', r.merchant)
+      OR (ARRAY_LENGTH(d.merchants) = 1 AND d.merchants[OFFSET(0)] = r.merchant)
+    GROUP BY a.alert_id
+  )
+  SELECT
+    m.rule_id,
+    'incremental_sql',
+    (SELECT IFNULL(MAX(run_id), 0) + 1 FROM `anlaytics-465216.aml_data.detection_runs`),
+    a.customer_id,
+    a.alert_type,
+    a.alert_date,
+    a.risk_score,
+    a.total_amount,
+    a.description,
+    processing_start_time
+  FROM matches m
+  JOIN new_alerts a ON a.alert_id = m.alert_id;
+
+  DELETE FROM `anlaytics-465216.aml_data.aml_alerts_level1` a
+  WHERE a.alert_id >= first_alert_id
+    AND EXISTS (
+      SELECT 1
+      FROM `anlaytics-465216.aml_data.suppressed_alerts` s
+      WHERE s.source = 'incremental_sql'
+        AND s.suppressed_at = processing_start_time
+        AND s.customer_id = a.customer_id
+        AND s.alert_type = a.alert_type
+        AND s.alert_date = a.alert_date
+        AND s.description = a.description
+    );
+
+  SET alerts_suppressed = @@row_count;
+  
+  -- ===========================================
+  -- 15. CONSOLIDATE ALERTS INTO CASES
+  -- A new alert joins the customer's latest open case with alerts within
+  -- case_period_days of its own and takes the case's status. The rest open
+  -- cases of their own: a customer's alerts no more than case_period_days
+  -- apart share the case of the first of them.
+  -- ===========================================
+  INSERT INTO `anlaytics-465216.aml_data.case_alerts` (case_id, alert_id, linked_at)
+  WITH open_cases AS (
+    SELECT
+      c.case_id,
+      lead.customer_id,
+      MIN(a.alert_date) as first_date,
+      MAX(a.alert_date) as last_date
+    FROM `anlaytics-465216.aml_data.case_alerts` c
+    JOIN `anlaytics-465216.aml_data.aml_alerts_level1` a ON a.alert_id = c.alert_id
+    JOIN `anlaytics-465216.aml_data.aml_alerts_level1` lead ON lead.alert_id = c.case_id
+    WHERE lead.status NOT IN ('CLOSED_FALSE_POSITIVE', 'CLOSED_SAR_FILED')
+    GROUP BY c.case_id, lead.customer_id
+  ),
+  new_alerts AS (
+    SELECT alert_id, customer_id, alert_date
+    FROM `anlaytics-465216.aml_data.aml_alerts_level1`
+    WHERE alert_id >= first_alert_id
+  ),
+  joined AS (
+    SELECT
+      n.alert_id,
+      MAX(c.case_id) as case_id
+    FROM new_alerts n
+    JOIN open_cases c
+      ON c.customer_id = n.customer_id
+      AND n.alert_date BETWEEN DATE_SUB(c.first_date, INTERVAL case_period_days DAY)
+                           AND DATE_ADD(c.last_date, INTERVAL case_period_days DAY)
+    GROUP BY n.alert_id
+  ),
+  gaps AS (
+    SELECT
+      n.*,
+      IFNULL(DATE_DIFF(n.alert_date, LAG(n.alert_date) OVER (PARTITION BY n.customer_id ORDER BY n.alert_date, n.alert_id), DAY) > case_period_days, TRUE) as starts_case
+    FROM new_alerts n
+    LEFT JOIN joined j ON j.alert_id = n.alert_id
+    WHERE j.alert_id IS NULL
+  ),
+  islands AS (
+    SELECT
+      *,
+      COUNTIF(starts_case) OVER (PARTITION BY customer_id ORDER BY alert_date, alert_id) as island
+    FROM gaps
+  )
+  SELECT case_id, alert_id, CURRENT_TIMESTAMP() as linked_at FROM joined
+  UNION ALL
+  SELECT
+    MIN(alert_id) OVER (PARTITION BY customer_id, island) as case_id,
+    alert_id,
+    CURRENT_TIMESTAMP() as linked_at
+  FROM islands;
+
+  -- Alerts joining a case already under review take its status
+  UPDATE `anlaytics-465216.aml_data.aml_alerts_level1` a
+  SET status = s.status
+  FROM (
+    SELECT c.alert_id, lead.status
+    FROM `anlaytics-465216.aml_data.case_alerts` c
+    JOIN `anlaytics-465216.aml_data.aml_alerts_level1` lead ON lead.alert_id = c.case_id
+    WHERE c.alert_id >= first_alert_id
+      AND c.case_id < first_alert_id
+      AND lead.status != 'OPEN'
+  ) s
+  WHERE a.alert_id = s.alert_id;
+  
+  -- ===========================================
+  -- 16. UPDATE CUSTOMER RISK PROFILES
   -- ===========================================
   CREATE OR REPLACE TABLE `anlaytics-465216.aml_data.customer_risk_profiles_level2` AS
   WITH customer_metrics AS (
@@ -219,60 +1722,209 @@ ELSE
       MAX(risk_score) as max_alert_risk_score
     FROM `anlaytics-465216.aml_data.aml_alerts_level1`
     GROUP BY customer_id
+  ),
+
+  -- Watchlist hits set a minimum risk category until reviewed as false positives
+  watchlist_floor AS (
+    SELECT 
+      customer_id,
+      MAX(CASE risk_category
+        WHEN 'CRITICAL' THEN 4
+        WHEN 'HIGH' THEN 3
+        WHEN 'MEDIUM' THEN 2
+        ELSE 1
+      END) as floor_rank
+    FROM `anlaytics-465216.aml_data.watchlist_hits`
+    WHERE review_status != 'FALSE_POSITIVE'
+    GROUP BY customer_id
+  ),
+
+  -- Transactions at merchants scored high risk (refreshed by: merchants score)
+  merchant_exposure AS (
+    SELECT 
+      CONCAT(t.first, '_', t.last) as customer_id,
+      COUNT(*) as high_risk_merchant_transactions
+    FROM `anlaytics-465216.aml_data.credit_card_transactions` t
+    JOIN `anlaytics-465216.aml_data.merchant_risk_scores` m
+      ON m.merchant = t.merchant
+    WHERE m.is_high_risk
+    GROUP BY customer_id
+  ),
+  
+  -- Highest isolation forest score of any customer-day (written by: model score)
+  ml_anomalies AS (
+    SELECT 
+      customer_id,
+      MAX(anomaly_score) as ml_anomaly_score
+    FROM `anlaytics-465216.aml_data.anomaly_scores`
+    GROUP BY customer_id
+  ),
+  
+  -- Peer group outliers (refreshed by section 10)
+  peer_outliers AS (
+    SELECT 
+      customer_id,
+      MAX(outlier_metrics) as peer_outlier_metrics
+    FROM `anlaytics-465216.aml_data.customer_peer_metrics`
+    GROUP BY customer_id
+  ),
+  
+  scored AS (
+    SELECT 
+      m.*,
+      IFNULL(a.total_alerts, 0) as total_alerts,
+      IFNULL(a.high_priority_alerts, 0) as high_priority_alerts,
+      IFNULL(a.max_alert_risk_score, 0) as max_alert_risk_score,
+      IFNULL(e.high_risk_merchant_transactions, 0) as high_risk_merchant_transactions,
+      IFNULL(p.peer_outlier_metrics, 0) as peer_outlier_metrics,
+      x.ml_anomaly_score,
+      IFNULL(w.floor_rank, 1) as floor_rank,
+      LEAST(
+        (IFNULL(a.total_alerts, 0) * 20) +
+        (m.high_amount_transactions * 5) +
+        (m.unique_states * 10) +
+        (IFNULL(e.high_risk_merchant_transactions, 0) * 5) +
+        (IFNULL(p.peer_outlier_metrics, 0) * 10),
+        100
+      ) as risk_score
+    FROM customer_metrics m
+    LEFT JOIN customer_alerts a ON m.customer_id = a.customer_id
+    LEFT JOIN watchlist_floor w ON m.customer_id = w.customer_id
+    LEFT JOIN merchant_exposure e ON m.customer_id = e.customer_id
+    LEFT JOIN peer_outliers p ON m.customer_id = p.customer_id
+    LEFT JOIN ml_anomalies x ON m.customer_id = x.customer_id
   )
   
   SELECT 
-    m.customer_id,
-    m.total_transactions,
-    m.total_amount,
-    m.avg_amount,
-    m.max_amount,
-    m.unique_merchants,
-    m.unique_states,
+    customer_id,
+    total_transactions,
+    total_amount,
+    avg_amount,
+    max_amount,
+    unique_merchants,
+    unique_states,
+    risk_score,
+    
+    -- Assign risk category (watchlist hits set a floor)
+    CASE 
+      WHEN floor_rank >= 4 OR risk_score >= 80 THEN 'CRITICAL'
+      WHEN floor_rank >= 3 OR risk_score >= 60 THEN 'HIGH'
+      WHEN floor_rank >= 2 OR risk_score >= 40 THEN 'MEDIUM'
+      ELSE 'LOW'
+    END as risk_category,
     
-    -- Calculate risk score
-    LEAST(
-      (IFNULL(a.total_alerts, 0) * 20) +
-      (m.high_amount_transactions * 5) +
-      (m.unique_states * 10),
-      100
-    ) as risk_score,
+    total_alerts,
+    high_priority_alerts,
+    max_alert_risk_score,
+    high_risk_merchant_transactions,
+    peer_outlier_metrics,
+    ml_anomaly_score,
+    first_transaction_date,
+    last_transaction_date,
+    CURRENT_TIMESTAMP() as profile_generated_date
+  FROM scored
+  ORDER BY risk_score DESC;
+  
+  -- ===========================================
+  -- 17. UPDATE MERCHANT RISK PROFILES
+  -- ===========================================
+  CREATE OR REPLACE TABLE `anlaytics-465216.aml_data.merchant_risk_profiles` AS
+  WITH merchant_days AS (
+    SELECT 
+      merchant,
+      DATE(trans_date_trans_time) as transaction_date,
+      SUM(amt) as daily_amount
+    FROM `anlaytics-465216.aml_data.credit_card_transactions`
+    GROUP BY merchant, transaction_date
+  ),
+
+  merchant_metrics AS (
+    SELECT 
+      merchant,
+      APPROX_TOP_COUNT(category, 1)[OFFSET(0)].value as category,
+      COUNT(*) as total_transactions,
+      SUM(amt) as total_amount,
+      AVG(amt) as avg_amount,
+      COUNT(DISTINCT CONCAT(first, '|', last, '|', CAST(dob AS STRING))) as unique_customers,
+      COUNT(DISTINCT cc_num) as unique_cards,
+      MIN(DATE(trans_date_trans_time)) as first_transaction_date,
+      MAX(DATE(trans_date_trans_time)) as last_transaction_date
+    FROM `anlaytics-465216.aml_data.credit_card_transactions`
+    GROUP BY merchant
+  ),
+
+  daily_metrics AS (
+    SELECT 
+      merchant,
+      COUNT(*) as active_days,
+      AVG(daily_amount) as avg_daily_amount,
+      MAX(daily_amount) as peak_daily_amount,
+      ARRAY_AGG(transaction_date ORDER BY daily_amount DESC, transaction_date LIMIT 1)[OFFSET(0)] as peak_date
+    FROM merchant_days
+    GROUP BY merchant
+  ),
+
+  -- MERCHANT_ANOMALY alerts carry the merchant in customer_id as merchant:<name>
+  merchant_alerts AS (
+    SELECT 
+      SUBSTR(customer_id, LENGTH('merchant:') + 1) as merchant,
+      COUNT(*) as anomaly_alerts,
+      MAX(risk_score) as max_anomaly_score
+    FROM `anlaytics-465216.aml_data.aml_alerts_level1`
+    WHERE alert_type = 'MERCHANT_ANOMALY'
+    GROUP BY merchant
+  ),
+
+  scored AS (
+    SELECT 
+      m.*,
+      d.active_days,
+      d.avg_daily_amount,
+      d.peak_daily_amount,
+      d.peak_date,
+      IFNULL(a.anomaly_alerts, 0) as anomaly_alerts,
+      IFNULL(a.max_anomaly_score, 0) as max_anomaly_score,
+      IFNULL(s.risk_score, 0) as merchant_risk_score,
+      GREATEST(IFNULL(s.risk_score, 0), IFNULL(a.max_anomaly_score, 0)) as risk_score
+    FROM merchant_metrics m
+    JOIN daily_metrics d ON m.merchant = d.merchant
+    LEFT JOIN merchant_alerts a ON m.merchant = a.merchant
+    LEFT JOIN `anlaytics-465216.aml_data.merchant_risk_scores` s ON m.merchant = s.merchant
+  )
+
+  SELECT 
+    merchant,
+    category,
+    total_transactions,
+    total_amount,
+    avg_amount,
+    unique_customers,
+    unique_cards,
+    active_days,
+    avg_daily_amount,
+    peak_daily_amount,
+    peak_date,
+    anomaly_alerts,
+    max_anomaly_score,
+    merchant_risk_score,
+    risk_score,
     
-    -- Assign risk category
+    -- Same bands as the customer risk profiles
     CASE 
-      WHEN LEAST(
-        (IFNULL(a.total_alerts, 0) * 20) +
-        (m.high_amount_transactions * 5) +
-        (m.unique_states * 10),
-        100
-      ) >= 80 THEN 'CRITICAL'
-      WHEN LEAST(
-        (IFNULL(a.total_alerts, 0) * 20) +
-        (m.high_amount_transactions * 5) +
-        (m.unique_states * 10),
-        100
-      ) >= 60 THEN 'HIGH'
-      WHEN LEAST(
-        (IFNULL(a.total_alerts, 0) * 20) +
-        (m.high_amount_transactions * 5) +
-        (m.unique_states * 10),
-        100
-      ) >= 40 THEN 'MEDIUM'
+      WHEN risk_score >= 80 THEN 'CRITICAL'
+      WHEN risk_score >= 60 THEN 'HIGH'
+      WHEN risk_score >= 40 THEN 'MEDIUM'
       ELSE 'LOW'
     END as risk_category,
     
-    IFNULL(a.total_alerts, 0) as total_alerts,
-    IFNULL(a.high_priority_alerts, 0) as high_priority_alerts,
-    IFNULL(a.max_alert_risk_score, 0) as max_alert_risk_score,
-    m.first_transaction_date,
-    m.last_transaction_date,
+    first_transaction_date,
+    last_transaction_date,
     CURRENT_TIMESTAMP() as profile_generated_date
-  FROM customer_metrics m
-  LEFT JOIN customer_alerts a ON m.customer_id = a.customer_id
+  FROM scored
   ORDER BY risk_score DESC;
   
   -- ===========================================
-  -- 5. UPDATE PROCESSING METADATA
+  -- 18. UPDATE PROCESSING METADATA
   -- ===========================================
   SET alerts_created = (
     SELECT COUNT(*)
@@ -293,13 +1945,49 @@ ELSE
     status = 'COMPLETED',
     updated_at = CURRENT_TIMESTAMP()
   WHERE process_name = 'aml_processing';
+
+  -- Record the run and the config version behind its alerts
+  SET run_id = (
+    SELECT IFNULL(MAX(run_id), 0) + 1
+    FROM `anlaytics-465216.aml_data.detection_runs`
+  );
+  INSERT INTO `anlaytics-465216.aml_data.detection_runs`
+    (run_id, source, config_version, config_checksum, config, since, started_at, finished_at,
+     transactions, alerts, suppressed, first_alert_id, last_alert_id)
+  SELECT
+    run_id,
+    'incremental_sql',
+    config_version,
+    config_checksum,
+    run_config,
+    last_processed_time,
+    processing_start_time,
+    CURRENT_TIMESTAMP(),
+    new_records_count,
+    alerts_created,
+    alerts_suppressed,
+    first_alert_id,
+    (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`);
+
+  -- Link the run to the alerts this script inserted. Alerts another tool
+  -- inserted meanwhile are linked to their own run, so they are left out.
+  INSERT INTO `anlaytics-465216.aml_data.detection_run_alerts` (run_id, alert_id)
+  SELECT run_id, a.alert_id
+  FROM `anlaytics-465216.aml_data.aml_alerts_level1` a
+  WHERE a.alert_id >= first_alert_id
+    AND a.created_at >= processing_start_time
+    AND a.alert_id NOT IN (
+      SELECT alert_id FROM `anlaytics-465216.aml_data.detection_run_alerts`
+    );
   
   -- ===========================================
-  -- 6. PROCESSING SUMMARY
+  -- 19. PROCESSING SUMMARY
   -- ===========================================
   SELECT 
     CONCAT('Processed ', new_records_count, ' new transactions') as processing_summary,
     CONCAT('Generated ', alerts_created, ' new alerts') as alerts_summary,
+    CONCAT('Dropped ', duplicate_alerts, ' alerts repeating earlier runs') as duplicates_summary,
+    CONCAT('Suppressed ', alerts_suppressed, ' alerts by suppression rules') as suppression_summary,
     TIMESTAMP_DIFF(CURRENT_TIMESTAMP(), processing_start_time, SECOND) as processing_time_seconds,
     CURRENT_TIMESTAMP() as completed_at;
     
@@ -0,0 +1,148 @@
+-- ============================================================================
+-- MERCHANT ANOMALY DETECTION - BigQuery SQL
+-- Detects merchants receiving near-identical payments from many unrelated
+-- customers (funnels) or sudden daily volume spikes
+-- ============================================================================
+
+-- Detector configuration (mirrors config/aml_config.json)
+DECLARE merchant_funnel_min_customers INT64 DEFAULT 5;
+DECLARE merchant_funnel_amount_tolerance FLOAT64 DEFAULT 0.01;
+DECLARE merchant_spike_baseline_days INT64 DEFAULT 30;
+DECLARE merchant_spike_multiplier FLOAT64 DEFAULT 5;
+DECLARE merchant_spike_min_transactions INT64 DEFAULT 10;
+DECLARE merchant_funnel_weight INT64 DEFAULT 10;
+DECLARE merchant_spike_weight INT64 DEFAULT 10;
+
+INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+WITH merchant_transactions AS (
+  SELECT 
+    merchant,
+    CONCAT(first, '|', last, '|', CAST(dob AS STRING)) as person_key,
+    cc_num,
+    DATE(trans_date_trans_time) as transaction_date,
+    trans_date_trans_time,
+    amt
+  FROM `anlaytics-465216.aml_data.credit_card_transactions`
+),
+
+merchant_days AS (
+  SELECT 
+    merchant,
+    transaction_date,
+    COUNT(*) as transaction_count,
+    SUM(amt) as total_amount
+  FROM merchant_transactions
+  GROUP BY merchant, transaction_date
+),
+
+-- Funnels: the amount band [amt, amt * (1 + tolerance)] paid by the most
+-- distinct customers on the day
+funnel_bands AS (
+  SELECT 
+    b.merchant,
+    b.transaction_date,
+    b.amt as band_low,
+    MAX(a.amt) as band_high,
+    COUNT(*) as funnel_transactions,
+    COUNT(DISTINCT a.person_key) as funnel_customers,
+    COUNT(DISTINCT a.cc_num) as funnel_cards,
+    SUM(a.amt) as funnel_amount
+  FROM (SELECT DISTINCT merchant, transaction_date, amt FROM merchant_transactions) b
+  JOIN merchant_transactions a
+    ON a.merchant = b.merchant
+    AND a.transaction_date = b.transaction_date
+    AND a.amt BETWEEN b.amt AND b.amt * (1 + merchant_funnel_amount_tolerance)
+  GROUP BY b.merchant, b.transaction_date, b.amt
+  QUALIFY ROW_NUMBER() OVER (
+    PARTITION BY b.merchant, b.transaction_date 
+    ORDER BY COUNT(DISTINCT a.person_key) DESC, SUM(a.amt) DESC, b.amt
+  ) = 1
+),
+
+-- Spikes: the day's takings against the daily average of the baseline days
+-- before it
+daily_baseline AS (
+  SELECT 
+    d.merchant,
+    d.transaction_date,
+    SUM(p.amt) / merchant_spike_baseline_days as baseline_daily_amount
+  FROM merchant_days d
+  JOIN merchant_transactions p
+    ON p.merchant = d.merchant
+    AND p.transaction_date BETWEEN DATE_SUB(d.transaction_date, INTERVAL merchant_spike_baseline_days DAY) 
+      AND DATE_SUB(d.transaction_date, INTERVAL 1 DAY)
+  GROUP BY d.merchant, d.transaction_date
+),
+
+merchant_anomaly_analysis AS (
+  SELECT 
+    d.merchant,
+    d.transaction_date,
+    d.transaction_count,
+    d.total_amount,
+    f.band_low,
+    f.band_high,
+    f.funnel_transactions,
+    f.funnel_customers,
+    f.funnel_cards,
+    f.funnel_amount,
+    b.baseline_daily_amount,
+    d.total_amount / b.baseline_daily_amount as spike_ratio,
+    IFNULL(f.funnel_customers >= merchant_funnel_min_customers, FALSE) as is_funnel,
+    IFNULL(
+      d.transaction_count >= merchant_spike_min_transactions 
+      AND d.total_amount >= merchant_spike_multiplier * b.baseline_daily_amount,
+      FALSE
+    ) as is_spike
+  FROM merchant_days d
+  LEFT JOIN funnel_bands f
+    ON f.merchant = d.merchant AND f.transaction_date = d.transaction_date
+  LEFT JOIN daily_baseline b
+    ON b.merchant = d.merchant AND b.transaction_date = d.transaction_date
+),
+
+merchant_anomaly_scores AS (
+  SELECT 
+    *,
+    LEAST(
+      IF(is_funnel, funnel_customers * merchant_funnel_weight, 0) +
+      IF(is_spike, CAST(FLOOR(spike_ratio) AS INT64) * merchant_spike_weight, 0),
+      100
+    ) as risk_score
+  FROM merchant_anomaly_analysis
+  WHERE is_funnel OR is_spike
+)
+
+SELECT 
+  (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+  ROW_NUMBER() OVER (ORDER BY risk_score DESC) as alert_id,
+  CONCAT('merchant:', merchant) as customer_id,  -- merchant alerts are raised on the merchant
+  transaction_date as alert_date,
+  'MERCHANT_ANOMALY' as alert_type,
+  risk_score,
+  ARRAY_TO_STRING([
+    IF(is_funnel, CONCAT(
+      'Merchant received ', funnel_transactions, ' payments ',
+      IF(band_low = band_high, 
+        CONCAT('of $', FORMAT('%.2f', band_low)),
+        CONCAT('between $', FORMAT('%.2f', band_low), ' and $', FORMAT('%.2f', band_high))),
+      ' from ', funnel_customers, ' unrelated customers on ', 
+      funnel_cards, IF(funnel_cards = 1, ' card', ' cards')
+    ), NULL),
+    IF(is_spike, CONCAT(
+      'Merchant took $', FORMAT('%\'.0f', total_amount),
+      ' in ', transaction_count, IF(transaction_count = 1, ' transaction', ' transactions'),
+      ', ', FORMAT('%.1f', spike_ratio), 'x its ', merchant_spike_baseline_days,
+      '-day daily average of $', FORMAT('%\'.0f', baseline_daily_amount)
+    ), NULL)
+  ], '; ') as description,
+  CASE 
+    WHEN risk_score >= 80 THEN 'HIGH'
+    WHEN risk_score >= 50 THEN 'MEDIUM'
+    ELSE 'LOW'
+  END as priority,
+  IF(is_spike, total_amount, funnel_amount) as total_amount,
+  'OPEN' as status,
+  CURRENT_DATE() as detection_date
+FROM merchant_anomaly_scores
+ORDER BY risk_score DESC;
\ No newline at end of file
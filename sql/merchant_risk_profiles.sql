@@ -0,0 +1,99 @@
+-- ============================================================================
+-- MERCHANT RISK PROFILES - BigQuery SQL
+-- Creates the merchant-side risk assessment alongside the customer profiles
+-- ============================================================================
+
+CREATE OR REPLACE TABLE `anlaytics-465216.aml_data.merchant_risk_profiles` AS
+WITH merchant_days AS (
+  SELECT 
+    merchant,
+    DATE(trans_date_trans_time) as transaction_date,
+    SUM(amt) as daily_amount
+  FROM `anlaytics-465216.aml_data.credit_card_transactions`
+  GROUP BY merchant, transaction_date
+),
+
+merchant_metrics AS (
+  SELECT 
+    merchant,
+    APPROX_TOP_COUNT(category, 1)[OFFSET(0)].value as category,
+    COUNT(*) as total_transactions,
+    SUM(amt) as total_amount,
+    AVG(amt) as avg_amount,
+    COUNT(DISTINCT CONCAT(first, '|', last, '|', CAST(dob AS STRING))) as unique_customers,
+    COUNT(DISTINCT cc_num) as unique_cards,
+    MIN(DATE(trans_date_trans_time)) as first_transaction_date,
+    MAX(DATE(trans_date_trans_time)) as last_transaction_date
+  FROM `anlaytics-465216.aml_data.credit_card_transactions`
+  GROUP BY merchant
+),
+
+daily_metrics AS (
+  SELECT 
+    merchant,
+    COUNT(*) as active_days,
+    AVG(daily_amount) as avg_daily_amount,
+    MAX(daily_amount) as peak_daily_amount,
+    ARRAY_AGG(transaction_date ORDER BY daily_amount DESC, transaction_date LIMIT 1)[OFFSET(0)] as peak_date
+  FROM merchant_days
+  GROUP BY merchant
+),
+
+-- MERCHANT_ANOMALY alerts carry the merchant in customer_id as merchant:<name>
+merchant_alerts AS (
+  SELECT 
+    SUBSTR(customer_id, LENGTH('merchant:') + 1) as merchant,
+    COUNT(*) as anomaly_alerts,
+    MAX(risk_score) as max_anomaly_score
+  FROM `anlaytics-465216.aml_data.aml_alerts_level1`
+  WHERE alert_type = 'MERCHANT_ANOMALY'
+  GROUP BY merchant
+),
+
+scored AS (
+  SELECT 
+    m.*,
+    d.active_days,
+    d.avg_daily_amount,
+    d.peak_daily_amount,
+    d.peak_date,
+    IFNULL(a.anomaly_alerts, 0) as anomaly_alerts,
+    IFNULL(a.max_anomaly_score, 0) as max_anomaly_score,
+    IFNULL(s.risk_score, 0) as merchant_risk_score,
+    GREATEST(IFNULL(s.risk_score, 0), IFNULL(a.max_anomaly_score, 0)) as risk_score
+  FROM merchant_metrics m
+  JOIN daily_metrics d ON m.merchant = d.merchant
+  LEFT JOIN merchant_alerts a ON m.merchant = a.merchant
+  LEFT JOIN `anlaytics-465216.aml_data.merchant_risk_scores` s ON m.merchant = s.merchant
+)
+
+SELECT 
+  merchant,
+  category,
+  total_transactions,
+  total_amount,
+  avg_amount,
+  unique_customers,
+  unique_cards,
+  active_days,
+  avg_daily_amount,
+  peak_daily_amount,
+  peak_date,
+  anomaly_alerts,
+  max_anomaly_score,
+  merchant_risk_score,
+  risk_score,
+  
+  -- Same bands as the customer risk profiles
+  CASE 
+    WHEN risk_score >= 80 THEN 'CRITICAL'
+    WHEN risk_score >= 60 THEN 'HIGH'
+    WHEN risk_score >= 40 THEN 'MEDIUM'
+    ELSE 'LOW'
+  END as risk_category,
+  
+  first_transaction_date,
+  last_transaction_date,
+  CURRENT_TIMESTAMP() as profile_generated_date
+FROM scored
+ORDER BY risk_score DESC;
\ No newline at end of file
@@ -0,0 +1,220 @@
+-- ============================================================================
+-- PEER OUTLIER DETECTION - BigQuery SQL
+-- Compares each customer's recent activity with peers of the same
+-- occupation, age band and urban/rural area
+-- ============================================================================
+
+-- Detector configuration (mirrors config/aml_config.json)
+DECLARE peer_window_days INT64 DEFAULT 90;
+DECLARE peer_urban_population INT64 DEFAULT 50000;
+DECLARE peer_min_group_size INT64 DEFAULT 5;
+DECLARE peer_z_score FLOAT64 DEFAULT 3.5;
+DECLARE peer_outlier_weight INT64 DEFAULT 20;
+
+-- Peer metrics for every customer active in the window; customers without a
+-- group of peer_min_group_size+ at any level have no peer_group
+CREATE OR REPLACE TABLE `anlaytics-465216.aml_data.customer_peer_metrics` AS
+WITH peer_window AS (
+  SELECT MAX(trans_date_trans_time) as window_end
+  FROM `anlaytics-465216.aml_data.credit_card_transactions`
+),
+
+customer_activity AS (
+  SELECT 
+    CONCAT(t.first, '|', t.last, '|', CAST(t.dob AS STRING)) as person_key,
+    CONCAT(t.first, '_', t.last) as customer_id,
+    ANY_VALUE(t.job) as job,
+    ANY_VALUE(t.dob) as dob,
+    ANY_VALUE(t.city_pop) as city_pop,
+    SUM(t.amt) as total_amount,
+    COUNT(*) as transaction_count,
+    AVG(t.amt) as avg_amount,
+    COUNTIF(EXTRACT(HOUR FROM t.trans_date_trans_time) >= 22 
+      OR EXTRACT(HOUR FROM t.trans_date_trans_time) < 6) / COUNT(*) as night_share,
+    COUNTIF(ENDS_WITH(t.category, '_net')) / COUNT(*) as online_share,
+    MAX(t.trans_date_trans_time) as last_transaction,
+    ANY_VALUE(w.window_end) as window_end
+  FROM `anlaytics-465216.aml_data.credit_card_transactions` t
+  CROSS JOIN peer_window w
+  WHERE t.trans_date_trans_time > TIMESTAMP_SUB(w.window_end, INTERVAL peer_window_days DAY)
+  GROUP BY person_key, customer_id
+),
+
+-- Age at the end of the window and urban/rural from city_pop
+classified AS (
+  SELECT 
+    * EXCEPT (dob, city_pop),
+    CASE 
+      WHEN age < 25 THEN 'under 25'
+      WHEN age < 35 THEN '25-34'
+      WHEN age < 45 THEN '35-44'
+      WHEN age < 55 THEN '45-54'
+      WHEN age < 65 THEN '55-64'
+      ELSE '65+'
+    END as age_band,
+    IF(city_pop >= peer_urban_population, 'URBAN', 'RURAL') as area_type
+  FROM (
+    SELECT 
+      *,
+      DATE_DIFF(DATE(window_end), dob, YEAR) - 
+        IF(EXTRACT(DAYOFYEAR FROM window_end) < EXTRACT(DAYOFYEAR FROM dob), 1, 0) as age
+    FROM customer_activity
+  )
+),
+
+-- Candidate groups from most to least specific, with their sizes
+group_sizes AS (
+  SELECT 
+    *,
+    COUNT(*) OVER (PARTITION BY job, age_band, area_type) as job_group_size,
+    COUNT(*) OVER (PARTITION BY age_band, area_type) as age_group_size,
+    COUNT(*) OVER (PARTITION BY area_type) as area_group_size
+  FROM classified
+),
+
+-- Each customer is compared with the most specific group of peer_min_group_size+
+peer_groups AS (
+  SELECT 
+    * EXCEPT (job_group_size, age_group_size, area_group_size),
+    CASE 
+      WHEN job_group_size >= peer_min_group_size THEN 'JOB_AGE_AREA'
+      WHEN age_group_size >= peer_min_group_size THEN 'AGE_AREA'
+      WHEN area_group_size >= peer_min_group_size THEN 'AREA'
+    END as peer_level,
+    CASE 
+      WHEN job_group_size >= peer_min_group_size THEN CONCAT(job, ', ', age_band, ', ', LOWER(area_type))
+      WHEN age_group_size >= peer_min_group_size THEN CONCAT(age_band, ', ', LOWER(area_type))
+      WHEN area_group_size >= peer_min_group_size THEN LOWER(area_type)
+    END as peer_group,
+    CASE 
+      WHEN job_group_size >= peer_min_group_size THEN job_group_size
+      WHEN age_group_size >= peer_min_group_size THEN age_group_size
+      WHEN area_group_size >= peer_min_group_size THEN area_group_size
+    END as peer_group_size
+  FROM group_sizes
+),
+
+peer_medians AS (
+  SELECT 
+    *,
+    PERCENTILE_CONT(total_amount, 0.5) OVER (PARTITION BY peer_group) as total_amount_median,
+    PERCENTILE_CONT(transaction_count, 0.5) OVER (PARTITION BY peer_group) as transaction_count_median,
+    PERCENTILE_CONT(avg_amount, 0.5) OVER (PARTITION BY peer_group) as avg_amount_median,
+    PERCENTILE_CONT(night_share, 0.5) OVER (PARTITION BY peer_group) as night_share_median,
+    PERCENTILE_CONT(online_share, 0.5) OVER (PARTITION BY peer_group) as online_share_median
+  FROM peer_groups
+),
+
+peer_spreads AS (
+  SELECT 
+    *,
+    PERCENTILE_CONT(ABS(total_amount - total_amount_median), 0.5) OVER (PARTITION BY peer_group) as total_amount_mad,
+    PERCENTILE_CONT(ABS(transaction_count - transaction_count_median), 0.5) OVER (PARTITION BY peer_group) as transaction_count_mad,
+    PERCENTILE_CONT(ABS(avg_amount - avg_amount_median), 0.5) OVER (PARTITION BY peer_group) as avg_amount_mad,
+    PERCENTILE_CONT(ABS(night_share - night_share_median), 0.5) OVER (PARTITION BY peer_group) as night_share_mad,
+    PERCENTILE_CONT(ABS(online_share - online_share_median), 0.5) OVER (PARTITION BY peer_group) as online_share_mad
+  FROM peer_medians
+),
+
+-- Robust z-scores: distance from the group median in units of 1.4826 times
+-- the median absolute deviation, floored at 10% of the median
+peer_scores AS (
+  SELECT 
+    *,
+    IF(peer_group IS NULL, 0, (total_amount - total_amount_median) / GREATEST(1.4826 * total_amount_mad, 0.1 * ABS(total_amount_median), 1)) as total_amount_z,
+    IF(peer_group IS NULL, 0, (transaction_count - transaction_count_median) / GREATEST(1.4826 * transaction_count_mad, 0.1 * ABS(transaction_count_median), 1)) as transaction_count_z,
+    IF(peer_group IS NULL, 0, (avg_amount - avg_amount_median) / GREATEST(1.4826 * avg_amount_mad, 0.1 * ABS(avg_amount_median), 1)) as avg_amount_z,
+    IF(peer_group IS NULL, 0, (night_share - night_share_median) / GREATEST(1.4826 * night_share_mad, 0.1 * ABS(night_share_median), 0.05)) as night_share_z,
+    IF(peer_group IS NULL, 0, (online_share - online_share_median) / GREATEST(1.4826 * online_share_mad, 0.1 * ABS(online_share_median), 0.05)) as online_share_z
+  FROM peer_spreads
+)
+
+SELECT 
+  person_key,
+  customer_id,
+  job,
+  age_band,
+  area_type,
+  peer_level,
+  peer_group,
+  peer_group_size,
+  total_amount,
+  transaction_count,
+  avg_amount,
+  night_share,
+  online_share,
+  total_amount_z,
+  transaction_count_z,
+  avg_amount_z,
+  night_share_z,
+  online_share_z,
+  IF(total_amount_z >= peer_z_score, 1, 0) +
+      IF(transaction_count_z >= peer_z_score, 1, 0) +
+      IF(avg_amount_z >= peer_z_score, 1, 0) +
+      IF(night_share_z >= peer_z_score, 1, 0) +
+      IF(online_share_z >= peer_z_score, 1, 0) as outlier_metrics,
+  last_transaction,
+  window_end
+FROM peer_scores;
+
+INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+WITH peer_comparison AS (
+  SELECT 
+    *,
+    PERCENTILE_CONT(total_amount, 0.5) OVER (PARTITION BY peer_group) as total_amount_median,
+    PERCENTILE_CONT(transaction_count, 0.5) OVER (PARTITION BY peer_group) as transaction_count_median,
+    PERCENTILE_CONT(avg_amount, 0.5) OVER (PARTITION BY peer_group) as avg_amount_median,
+    PERCENTILE_CONT(night_share, 0.5) OVER (PARTITION BY peer_group) as night_share_median,
+    PERCENTILE_CONT(online_share, 0.5) OVER (PARTITION BY peer_group) as online_share_median
+  FROM `anlaytics-465216.aml_data.customer_peer_metrics`
+  WHERE peer_group IS NOT NULL
+),
+
+peer_outliers AS (
+  SELECT 
+    *,
+    LEAST(outlier_metrics * peer_outlier_weight, 100) as risk_score,
+    -- Observed versus the peer median for every outlying metric
+    ARRAY_TO_STRING([
+      IF(total_amount_z >= peer_z_score, CONCAT(
+        'volume $', FORMAT('%\'.0f', total_amount), ' vs peer median $', FORMAT('%\'.0f', total_amount_median),
+        ' (z ', FORMAT('%.1f', total_amount_z), ')'), NULL),
+      IF(transaction_count_z >= peer_z_score, CONCAT(
+        'transactions ', transaction_count, ' vs peer median ', FORMAT('%.0f', transaction_count_median),
+        ' (z ', FORMAT('%.1f', transaction_count_z), ')'), NULL),
+      IF(avg_amount_z >= peer_z_score, CONCAT(
+        'average amount $', FORMAT('%\'.0f', avg_amount), ' vs peer median $', FORMAT('%\'.0f', avg_amount_median),
+        ' (z ', FORMAT('%.1f', avg_amount_z), ')'), NULL),
+      IF(night_share_z >= peer_z_score, CONCAT(
+        'night share ', FORMAT('%.0f%%', night_share * 100), ' vs peer median ', FORMAT('%.0f%%', night_share_median * 100),
+        ' (z ', FORMAT('%.1f', night_share_z), ')'), NULL),
+      IF(online_share_z >= peer_z_score, CONCAT(
+        'online share ', FORMAT('%.0f%%', online_share * 100), ' vs peer median ', FORMAT('%.0f%%', online_share_median * 100),
+        ' (z ', FORMAT('%.1f', online_share_z), ')'), NULL)
+    ], '; ') as reasons
+  FROM peer_comparison
+  WHERE outlier_metrics > 0
+)
+
+SELECT 
+  (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+  ROW_NUMBER() OVER (ORDER BY risk_score DESC) as alert_id,
+  customer_id,
+  DATE(last_transaction) as alert_date,
+  'PEER_OUTLIER' as alert_type,
+  risk_score,
+  CONCAT(
+    "Customer's ", peer_window_days, '-day activity is an outlier among ', peer_group_size,
+    ' peers (', peer_group, '): ', reasons
+  ) as description,
+  CASE 
+    WHEN risk_score >= 80 THEN 'HIGH'
+    WHEN risk_score >= 50 THEN 'MEDIUM'
+    ELSE 'LOW'
+  END as priority,
+  total_amount,
+  'OPEN' as status,
+  CURRENT_DATE() as detection_date,
+  CURRENT_TIMESTAMP() as created_at
+FROM peer_outliers
+ORDER BY risk_score DESC;
@@ -0,0 +1,163 @@
+-- ============================================================================
+-- RULE DETECTION - generated from config/rules by `rules sql`; edit the rule files
+-- ============================================================================
+
+-- Rule MERCHANT_HOPPING (config/rules/merchant_hopping.yaml)
+-- Card used at {merchants} merchants in {categories} categories within an hour (${total_amount})
+INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+WITH rule_transactions AS (
+  SELECT
+    t.trans_num,
+    t.trans_date_trans_time,
+    t.amt,
+    CONCAT(t.first, '_', t.last) as customer_id,
+    t.merchant as merchant_name,
+    CAST(t.cc_num AS STRING) as key_0,
+    t.merchant as field_merchants,
+    t.category as field_categories,
+    t.amt as field_total_amount
+  FROM `anlaytics-465216.aml_data.credit_card_transactions` t
+),
+
+-- Each transaction closes a window over the earlier activity of its group
+rule_windows AS (
+  SELECT
+    e.trans_num as end_trans_num,
+    e.trans_date_trans_time as window_end,
+    e.customer_id,
+    e.merchant_name,
+    e.key_0,
+    SUM(w.amt) as window_amount,
+    COUNT(DISTINCT w.field_merchants) as merchants,
+    COUNT(DISTINCT w.field_categories) as categories,
+    SUM(w.field_total_amount) as total_amount
+  FROM rule_transactions e
+  JOIN rule_transactions w
+    ON w.key_0 = e.key_0
+    AND w.trans_date_trans_time > TIMESTAMP_SUB(e.trans_date_trans_time, INTERVAL 3600 SECOND)
+    AND w.trans_date_trans_time <= e.trans_date_trans_time
+  GROUP BY e.trans_num, e.trans_date_trans_time, e.customer_id, e.merchant_name, e.key_0
+),
+
+-- Qualifying windows are scored; the best window of each group and day is reported
+rule_scored AS (
+  SELECT
+    *,
+    CAST(LEAST(GREATEST(ROUND((((merchants * 12) + (categories * 5)) + LEAST(IFNULL(SAFE_DIVIDE(total_amount, 200), 0), 20))), 0), 100) AS INT64) as risk_score
+  FROM rule_windows
+  WHERE merchants >= 4
+),
+
+rule_alerts AS (
+  SELECT *
+  FROM rule_scored
+  WHERE TRUE
+  QUALIFY ROW_NUMBER() OVER (PARTITION BY key_0, DATE(window_end) ORDER BY risk_score DESC, window_end) = 1
+)
+
+SELECT 
+  (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+  ROW_NUMBER() OVER (ORDER BY risk_score DESC, window_end) as alert_id,
+  customer_id as customer_id,
+  DATE(window_end) as alert_date,
+  'MERCHANT_HOPPING' as alert_type,
+  risk_score,
+  CONCAT(
+    'Card used at ',
+    FORMAT('%\'.0f', CAST(merchants AS FLOAT64)),
+    ' merchants in ',
+    FORMAT('%\'.0f', CAST(categories AS FLOAT64)),
+    ' categories within an hour ($',
+    FORMAT('%\'.0f', CAST(total_amount AS FLOAT64)),
+    ')'
+  ) as description,
+  CASE 
+    WHEN risk_score >= 80 THEN 'HIGH'
+    WHEN risk_score >= 50 THEN 'MEDIUM'
+    ELSE 'LOW'
+  END as priority,
+  window_amount as total_amount,
+  'OPEN' as status,
+  CURRENT_DATE() as detection_date,
+  CURRENT_TIMESTAMP() as created_at
+FROM rule_alerts;
+
+-- Rule NIGHT_ONLINE_SPEND (config/rules/night_online_spend.yaml)
+-- {purchases} online purchases totalling ${total_amount} between 22:00 and 04:00 within 24 hours
+INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+WITH rule_transactions AS (
+  SELECT
+    t.trans_num,
+    t.trans_date_trans_time,
+    t.amt,
+    CONCAT(t.first, '_', t.last) as customer_id,
+    t.merchant as merchant_name,
+    CONCAT(t.first, '|', t.last, '|', CAST(t.dob AS STRING)) as key_0,
+    t.amt as field_total_amount,
+    t.amt as field_largest
+  FROM `anlaytics-465216.aml_data.credit_card_transactions` t
+  WHERE t.category IN ('shopping_net', 'misc_net', 'grocery_net')
+    AND EXTRACT(HOUR FROM t.trans_date_trans_time) IN (22, 23, 0, 1, 2, 3)
+    AND t.amt >= 200
+),
+
+-- Each transaction closes a window over the earlier activity of its group
+rule_windows AS (
+  SELECT
+    e.trans_num as end_trans_num,
+    e.trans_date_trans_time as window_end,
+    e.customer_id,
+    e.merchant_name,
+    e.key_0,
+    SUM(w.amt) as window_amount,
+    COUNT(*) as purchases,
+    SUM(w.field_total_amount) as total_amount,
+    MAX(w.field_largest) as largest
+  FROM rule_transactions e
+  JOIN rule_transactions w
+    ON w.key_0 = e.key_0
+    AND w.trans_date_trans_time > TIMESTAMP_SUB(e.trans_date_trans_time, INTERVAL 86400 SECOND)
+    AND w.trans_date_trans_time <= e.trans_date_trans_time
+  GROUP BY e.trans_num, e.trans_date_trans_time, e.customer_id, e.merchant_name, e.key_0
+),
+
+-- Qualifying windows are scored; the best window of each group and day is reported
+rule_scored AS (
+  SELECT
+    *,
+    CAST(LEAST(GREATEST(ROUND((((purchases * 15) + IFNULL(SAFE_DIVIDE(total_amount, 100), 0)) + IFNULL(SAFE_DIVIDE(GREATEST((largest - 500), 0), 50), 0))), 0), 100) AS INT64) as risk_score
+  FROM rule_windows
+  WHERE purchases >= 2
+    AND total_amount >= 1000
+),
+
+rule_alerts AS (
+  SELECT *
+  FROM rule_scored
+  WHERE TRUE
+  QUALIFY ROW_NUMBER() OVER (PARTITION BY key_0, DATE(window_end) ORDER BY risk_score DESC, window_end) = 1
+)
+
+SELECT 
+  (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+  ROW_NUMBER() OVER (ORDER BY risk_score DESC, window_end) as alert_id,
+  customer_id as customer_id,
+  DATE(window_end) as alert_date,
+  'NIGHT_ONLINE_SPEND' as alert_type,
+  risk_score,
+  CONCAT(
+    FORMAT('%\'.0f', CAST(purchases AS FLOAT64)),
+    ' online purchases totalling $',
+    FORMAT('%\'.0f', CAST(total_amount AS FLOAT64)),
+    ' between 22:00 and 04:00 within 24 hours'
+  ) as description,
+  CASE 
+    WHEN risk_score >= 80 THEN 'HIGH'
+    WHEN risk_score >= 50 THEN 'MEDIUM'
+    ELSE 'LOW'
+  END as priority,
+  window_amount as total_amount,
+  'OPEN' as status,
+  CURRENT_DATE() as detection_date,
+  CURRENT_TIMESTAMP() as created_at
+FROM rule_alerts;
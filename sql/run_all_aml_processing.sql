@@ -3,6 +3,44 @@
 -- Runs all AML detection algorithms in sequence
 -- ============================================================================
 
+-- Detector configuration (mirrors AML_CONFIG and config/aml_config.json)
+DECLARE structuring_threshold FLOAT64 DEFAULT 10000;
+DECLARE structuring_buffer FLOAT64 DEFAULT 0.9;
+DECLARE structuring_min_transactions INT64 DEFAULT 2;
+DECLARE structuring_windows ARRAY<INT64> DEFAULT [1, 3, 7, 30];
+DECLARE structuring_weight INT64 DEFAULT 25;
+DECLARE velocity_windows ARRAY<STRUCT<minutes INT64, min_transactions INT64, min_amount FLOAT64>> DEFAULT [
+  STRUCT(5 AS minutes, 5 AS min_transactions, 0.0 AS min_amount),
+  STRUCT(60 AS minutes, 10 AS min_transactions, 5000.0 AS min_amount),
+  STRUCT(1440 AS minutes, 25 AS min_transactions, 15000.0 AS min_amount)
+];
+DECLARE velocity_weight INT64 DEFAULT 20;
+DECLARE geographic_max_states INT64 DEFAULT 2;
+DECLARE geographic_max_cities INT64 DEFAULT 5;
+DECLARE geographic_weight INT64 DEFAULT 15;
+DECLARE card_testing_max_amount FLOAT64 DEFAULT 5;
+DECLARE card_testing_min_burst INT64 DEFAULT 5;
+DECLARE card_testing_window_minutes INT64 DEFAULT 60;
+DECLARE card_testing_large_amount FLOAT64 DEFAULT 100;
+DECLARE card_testing_weight INT64 DEFAULT 15;
+DECLARE dormancy_min_days INT64 DEFAULT 90;
+DECLARE dormancy_window_days INT64 DEFAULT 7;
+DECLARE dormancy_min_amount FLOAT64 DEFAULT 5000;
+DECLARE dormancy_weight INT64 DEFAULT 20;
+DECLARE high_risk_merchant_weight INT64 DEFAULT 5;
+DECLARE merchant_funnel_min_customers INT64 DEFAULT 5;
+DECLARE merchant_funnel_amount_tolerance FLOAT64 DEFAULT 0.01;
+DECLARE merchant_spike_baseline_days INT64 DEFAULT 30;
+DECLARE merchant_spike_multiplier FLOAT64 DEFAULT 5;
+DECLARE merchant_spike_min_transactions INT64 DEFAULT 10;
+DECLARE merchant_funnel_weight INT64 DEFAULT 10;
+DECLARE merchant_spike_weight INT64 DEFAULT 10;
+DECLARE peer_window_days INT64 DEFAULT 90;
+DECLARE peer_urban_population INT64 DEFAULT 50000;
+DECLARE peer_min_group_size INT64 DEFAULT 5;
+DECLARE peer_z_score FLOAT64 DEFAULT 3.5;
+DECLARE peer_outlier_weight INT64 DEFAULT 20;
+
 -- Step 1: Create alerts table schema
 CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.aml_alerts_level1` (
   alert_id INT64,
@@ -20,78 +58,204 @@ CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.aml_alerts_level1` (
 -- Step 2: Clear existing alerts
 DELETE FROM `anlaytics-465216.aml_data.aml_alerts_level1` WHERE TRUE;
 
--- Step 3: Run Velocity Detection
+-- Step 3: Run Velocity Detection (sliding windows)
 INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
-WITH velocity_analysis AS (
+WITH customer_transactions AS (
   SELECT 
     CONCAT(first, '_', last) as customer_id,
+    CONCAT(first, '|', last, '|', CAST(dob AS STRING)) as person_key,
     trans_date_trans_time,
-    amt,
-    merchant,
-    DATE(trans_date_trans_time) as transaction_date,
-    TIMESTAMP_DIFF(
-      trans_date_trans_time,
-      LAG(trans_date_trans_time) OVER (
-        PARTITION BY CONCAT(first, '_', last) 
-        ORDER BY trans_date_trans_time
-      ),
-      MINUTE
-    ) as minutes_since_last
+    amt
   FROM `anlaytics-465216.aml_data.credit_card_transactions`
 ),
-rapid_transactions AS (
+
+-- One window of each size ends at every transaction and reaches back
+-- from that transaction's timestamp, not from the current date
+velocity_windows_analysis AS (
   SELECT 
-    customer_id,
-    transaction_date,
-    COUNT(*) as rapid_transaction_count,
-    SUM(amt) as total_amount,
-    MIN(minutes_since_last) as min_time_diff
+    e.person_key,
+    e.customer_id,
+    w.minutes,
+    w.min_amount,
+    e.trans_date_trans_time as window_end,
+    MIN(b.trans_date_trans_time) as window_start,
+    COUNT(*) as transaction_count,
+    SUM(b.amt) as total_amount
+  FROM customer_transactions e
+  CROSS JOIN UNNEST(velocity_windows) AS w
+  JOIN customer_transactions b
+    ON b.person_key = e.person_key
+    AND b.trans_date_trans_time BETWEEN TIMESTAMP_SUB(e.trans_date_trans_time, INTERVAL w.minutes MINUTE) 
+      AND e.trans_date_trans_time
+  GROUP BY e.person_key, e.customer_id, w.minutes, w.min_transactions, w.min_amount, e.trans_date_trans_time
+  HAVING COUNT(*) >= w.min_transactions
+    OR (w.min_amount > 0 AND COUNT(*) >= 2 AND SUM(b.amt) >= w.min_amount)
+),
+
+-- Overlapping qualifying windows of one size form a single episode
+velocity_episodes AS (
+  SELECT 
+    *,
+    COUNTIF(new_episode) OVER (
+      PARTITION BY person_key, minutes 
+      ORDER BY window_end 
+      ROWS UNBOUNDED PRECEDING
+    ) as episode
+  FROM (
+    SELECT 
+      *,
+      IFNULL(window_start > LAG(window_end) OVER (
+        PARTITION BY person_key, minutes 
+        ORDER BY window_end
+      ), TRUE) as new_episode
+    FROM velocity_windows_analysis
+  )
+),
+
+-- Report each episode at its busiest window, and a larger window only when
+-- it covers different transactions than a smaller one
+velocity_analysis AS (
+  SELECT 
+    *
+  FROM (
+    SELECT 
+      *
+    FROM velocity_episodes
+    WHERE TRUE
+    QUALIFY ROW_NUMBER() OVER (
+      PARTITION BY person_key, minutes, episode 
+      ORDER BY transaction_count DESC, total_amount DESC, window_end
+    ) = 1
+  )
+  WHERE TRUE
+  QUALIFY ROW_NUMBER() OVER (
+    PARTITION BY person_key, window_start, window_end 
+    ORDER BY minutes
+  ) = 1
+),
+
+velocity_scores AS (
+  SELECT 
+    *,
+    LEAST(
+      transaction_count * velocity_weight +
+      IF(min_amount > 0, CAST(FLOOR(total_amount / min_amount) AS INT64) * velocity_weight, 0),
+      100
+    ) as risk_score
   FROM velocity_analysis
-  WHERE minutes_since_last <= 5
-  GROUP BY customer_id, transaction_date
-  HAVING COUNT(*) >= 5
 )
+
 SELECT 
-  ROW_NUMBER() OVER (ORDER BY rapid_transaction_count DESC) as alert_id,
+  (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+  ROW_NUMBER() OVER (ORDER BY transaction_count DESC) as alert_id,
   customer_id,
-  transaction_date as alert_date,
+  DATE(window_end) as alert_date,
   'VELOCITY' as alert_type,
-  LEAST(rapid_transaction_count * 20, 100) as risk_score,
-  CONCAT('Customer made ', rapid_transaction_count, ' rapid transactions totaling $', FORMAT('%\'.0f', total_amount)) as description,
+  risk_score,
+  CONCAT(
+    'Customer made ', transaction_count, 
+    ' transactions totaling $', FORMAT('%\'.0f', total_amount),
+    ' within ',
+    IF(MOD(minutes, 60) = 0,
+      CONCAT(DIV(minutes, 60), IF(minutes = 60, ' hour', ' hours')),
+      CONCAT(minutes, IF(minutes = 1, ' minute', ' minutes')))
+  ) as description,
   CASE 
-    WHEN rapid_transaction_count * 20 >= 80 THEN 'HIGH'
-    WHEN rapid_transaction_count * 20 >= 50 THEN 'MEDIUM'
+    WHEN risk_score >= 80 THEN 'HIGH'
+    WHEN risk_score >= 50 THEN 'MEDIUM'
     ELSE 'LOW'
   END as priority,
   total_amount,
   'OPEN' as status,
   CURRENT_DATE() as detection_date
-FROM rapid_transactions;
+FROM velocity_scores;
 
--- Step 4: Run Structuring Detection
+-- Step 4: Run Structuring Detection (rolling windows across cards)
 INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
-WITH structuring_analysis AS (
+WITH band_transactions AS (
   SELECT 
     CONCAT(first, '_', last) as customer_id,
+    -- A resolved customer is one person (name and date of birth) across all cards
+    CONCAT(first, '|', last, '|', CAST(dob AS STRING)) as person_key,
     DATE(trans_date_trans_time) as transaction_date,
-    COUNT(*) as transaction_count,
-    SUM(amt) as total_amount
+    cc_num,
+    amt,
+    merchant
   FROM `anlaytics-465216.aml_data.credit_card_transactions`
-  WHERE amt BETWEEN 9000 AND 9999
-  GROUP BY customer_id, transaction_date
-  HAVING COUNT(*) >= 2
+  WHERE amt >= structuring_threshold * structuring_buffer
+    AND amt < structuring_threshold  -- Just under the threshold
+),
+
+-- Every day with an in-band transaction ends one window of each size
+window_ends AS (
+  SELECT 
+    person_key,
+    ANY_VALUE(customer_id) as customer_id,
+    transaction_date as window_end
+  FROM band_transactions
+  GROUP BY person_key, transaction_date
+),
+
+structuring_windows_analysis AS (
+  SELECT 
+    e.person_key,
+    e.customer_id,
+    w as window_days,
+    e.window_end,
+    MIN(b.transaction_date) as window_start,
+    COUNT(*) as transaction_count,
+    COUNT(DISTINCT b.cc_num) as card_count,
+    SUM(b.amt) as total_amount,
+    STRING_AGG(DISTINCT b.merchant, ', ') as merchants
+  FROM window_ends e
+  CROSS JOIN UNNEST(structuring_windows) AS w
+  JOIN band_transactions b
+    ON b.person_key = e.person_key
+    AND b.transaction_date BETWEEN DATE_SUB(e.window_end, INTERVAL w - 1 DAY) AND e.window_end
+  GROUP BY e.person_key, e.customer_id, w, e.window_end
+  HAVING COUNT(*) >= structuring_min_transactions
+),
+
+-- Keep maximal windows: drop a window when the next window of the same size
+-- still contains all of its transactions, and drop larger windows that
+-- cover exactly the same transactions as a smaller one
+structuring_analysis AS (
+  SELECT 
+    a.*
+  FROM structuring_windows_analysis a
+  LEFT JOIN (
+    SELECT 
+      person_key,
+      window_end,
+      LEAD(window_end) OVER (PARTITION BY person_key ORDER BY window_end) as next_date
+    FROM window_ends
+  ) n
+    ON n.person_key = a.person_key AND n.window_end = a.window_end
+  WHERE n.next_date IS NULL 
+    OR DATE_SUB(n.next_date, INTERVAL a.window_days - 1 DAY) > a.window_start
+  QUALIFY ROW_NUMBER() OVER (
+    PARTITION BY a.person_key, a.window_start, a.window_end 
+    ORDER BY a.window_days
+  ) = 1
 )
+
 SELECT 
   (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
   ROW_NUMBER() OVER (ORDER BY transaction_count DESC) as alert_id,
   customer_id,
-  transaction_date as alert_date,
+  window_end as alert_date,
   'STRUCTURING' as alert_type,
-  LEAST(transaction_count * 25, 100) as risk_score,
-  CONCAT('Customer made ', transaction_count, ' transactions totaling $', FORMAT('%\'.0f', total_amount), ' just under $10,000 threshold') as description,
+  LEAST(transaction_count * structuring_weight, 100) as risk_score,
+  CONCAT(
+    'Customer made ', transaction_count, 
+    ' transactions totaling $', FORMAT('%\'.0f', total_amount),
+    ' just under $', FORMAT('%\'.0f', structuring_threshold), ' threshold',
+    ' within ', window_days, IF(window_days = 1, ' day', ' days'),
+    ' on ', card_count, IF(card_count = 1, ' card', ' cards')
+  ) as description,
   CASE 
-    WHEN transaction_count * 25 >= 80 THEN 'HIGH'
-    WHEN transaction_count * 25 >= 50 THEN 'MEDIUM'
+    WHEN transaction_count * structuring_weight >= 80 THEN 'HIGH'
+    WHEN transaction_count * structuring_weight >= 50 THEN 'MEDIUM'
     ELSE 'LOW'
   END as priority,
   total_amount,
@@ -110,7 +274,7 @@ WITH geographic_analysis AS (
     SUM(amt) as total_amount
   FROM `anlaytics-465216.aml_data.credit_card_transactions`
   GROUP BY customer_id, transaction_date
-  HAVING COUNT(DISTINCT state) > 2 OR (COUNT(DISTINCT city) > 5 AND COUNT(*) > 5)
+  HAVING COUNT(DISTINCT state) > geographic_max_states OR (COUNT(DISTINCT city) > geographic_max_cities AND COUNT(*) > geographic_max_cities)
 )
 SELECT 
   (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
@@ -118,11 +282,11 @@ SELECT
   customer_id,
   transaction_date as alert_date,
   'GEOGRAPHIC' as alert_type,
-  LEAST((unique_states * 15) + (unique_cities * 3), 100) as risk_score,
+  LEAST((unique_states * geographic_weight) + (unique_cities * 3), 100) as risk_score,
   CONCAT('Customer transacted in ', unique_states, ' states and ', unique_cities, ' cities in one day') as description,
   CASE 
-    WHEN (unique_states * 15) + (unique_cities * 3) >= 80 THEN 'HIGH'
-    WHEN (unique_states * 15) + (unique_cities * 3) >= 50 THEN 'MEDIUM'
+    WHEN (unique_states * geographic_weight) + (unique_cities * 3) >= 80 THEN 'HIGH'
+    WHEN (unique_states * geographic_weight) + (unique_cities * 3) >= 50 THEN 'MEDIUM'
     ELSE 'LOW'
   END as priority,
   total_amount,
@@ -130,7 +294,594 @@ SELECT
   CURRENT_DATE() as detection_date
 FROM geographic_analysis;
 
--- Step 6: Generate Customer Risk Profiles (runs the full customer_risk_profiles.sql)
+-- Step 6: Run Card Testing Detection
+INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+WITH card_transactions AS (
+  SELECT 
+    cc_num,
+    CONCAT(first, '_', last) as customer_id,
+    trans_date_trans_time,
+    amt
+  FROM `anlaytics-465216.aml_data.credit_card_transactions`
+),
+
+-- Each large charge looks back for a burst of micro transactions on the same card
+card_bursts AS (
+  SELECT 
+    l.cc_num,
+    l.customer_id,
+    l.trans_date_trans_time as charge_time,
+    l.amt as charge_amount,
+    MIN(m.trans_date_trans_time) as burst_start,
+    COUNT(*) as micro_count,
+    SUM(m.amt) as micro_amount
+  FROM card_transactions l
+  JOIN card_transactions m
+    ON m.cc_num = l.cc_num
+    AND m.amt <= card_testing_max_amount
+    AND m.trans_date_trans_time >= TIMESTAMP_SUB(l.trans_date_trans_time, INTERVAL card_testing_window_minutes MINUTE)
+    AND m.trans_date_trans_time < l.trans_date_trans_time
+  WHERE l.amt >= card_testing_large_amount
+  GROUP BY l.cc_num, l.customer_id, l.trans_date_trans_time, l.amt
+  HAVING COUNT(*) >= card_testing_min_burst
+),
+
+-- Large charges following the same burst are reported together
+card_testing_analysis AS (
+  SELECT 
+    cc_num,
+    ANY_VALUE(customer_id) as customer_id,
+    burst_start,
+    micro_count,
+    ANY_VALUE(micro_amount) as micro_amount,
+    MIN(charge_time) as first_charge,
+    MAX(charge_time) as last_charge,
+    COUNT(*) as charge_count,
+    SUM(charge_amount) as charge_amount
+  FROM card_bursts
+  GROUP BY cc_num, burst_start, micro_count
+)
+
+SELECT 
+  (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+  ROW_NUMBER() OVER (ORDER BY micro_count DESC) as alert_id,
+  customer_id,
+  DATE(last_charge) as alert_date,
+  'CARD_TESTING' as alert_type,
+  LEAST(micro_count * card_testing_weight, 100) as risk_score,
+  CONCAT(
+    'Card ending ', RIGHT(CAST(cc_num AS STRING), 4),
+    ' had ', micro_count, ' transactions under $', FORMAT('%.2f', card_testing_max_amount),
+    ' within ', TIMESTAMP_DIFF(first_charge, burst_start, MINUTE), ' minutes',
+    ' followed by ', charge_count, IF(charge_count = 1, ' large charge', ' large charges'),
+    ' totaling $', FORMAT('%\'.0f', charge_amount)
+  ) as description,
+  CASE 
+    WHEN micro_count * card_testing_weight >= 80 THEN 'HIGH'
+    WHEN micro_count * card_testing_weight >= 50 THEN 'MEDIUM'
+    ELSE 'LOW'
+  END as priority,
+  micro_amount + charge_amount as total_amount,
+  'OPEN' as status,
+  CURRENT_DATE() as detection_date
+FROM card_testing_analysis;
+
+-- Step 7: Run Dormancy Reactivation Detection
+INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+WITH customer_activity AS (
+  SELECT 
+    CONCAT(first, '_', last) as customer_id,
+    CONCAT(first, '|', last, '|', CAST(dob AS STRING)) as person_key,
+    trans_date_trans_time,
+    DATE(trans_date_trans_time) as transaction_date,
+    amt,
+    -- The previous activity can be arbitrarily old, so the full history is read
+    LAG(DATE(trans_date_trans_time)) OVER (
+      PARTITION BY CONCAT(first, '|', last, '|', CAST(dob AS STRING)) 
+      ORDER BY trans_date_trans_time
+    ) as previous_date
+  FROM `anlaytics-465216.aml_data.credit_card_transactions`
+),
+
+reactivations AS (
+  SELECT 
+    person_key,
+    customer_id,
+    transaction_date as reactivation_date,
+    previous_date,
+    DATE_DIFF(transaction_date, previous_date, DAY) as dormant_days
+  FROM customer_activity
+  WHERE DATE_DIFF(transaction_date, previous_date, DAY) >= dormancy_min_days
+),
+
+-- Activity in the window after each reactivation, with a running total to
+-- find the transaction that crosses the amount threshold
+reactivation_window AS (
+  SELECT 
+    r.person_key,
+    r.customer_id,
+    r.reactivation_date,
+    r.previous_date,
+    r.dormant_days,
+    a.trans_date_trans_time,
+    a.amt,
+    SUM(a.amt) OVER (
+      PARTITION BY r.person_key, r.reactivation_date 
+      ORDER BY a.trans_date_trans_time 
+      ROWS UNBOUNDED PRECEDING
+    ) as running_amount
+  FROM reactivations r
+  JOIN customer_activity a
+    ON a.person_key = r.person_key
+    AND a.transaction_date >= r.reactivation_date
+    AND DATE_DIFF(a.transaction_date, r.reactivation_date, DAY) < dormancy_window_days
+),
+
+dormancy_analysis AS (
+  SELECT 
+    person_key,
+    customer_id,
+    reactivation_date,
+    previous_date,
+    dormant_days,
+    COUNT(*) as transaction_count,
+    SUM(amt) as total_amount,
+    MIN(IF(running_amount >= dormancy_min_amount, trans_date_trans_time, NULL)) as crossing_time
+  FROM reactivation_window
+  GROUP BY person_key, customer_id, reactivation_date, previous_date, dormant_days
+  HAVING SUM(amt) >= dormancy_min_amount
+),
+
+dormancy_scores AS (
+  SELECT 
+    *,
+    LEAST(
+      dormancy_weight * (DIV(dormant_days, dormancy_min_days) + CAST(FLOOR(total_amount / dormancy_min_amount) AS INT64)),
+      100
+    ) as risk_score
+  FROM dormancy_analysis
+  WHERE TRUE
+)
+
+SELECT 
+  (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+  ROW_NUMBER() OVER (ORDER BY dormant_days DESC) as alert_id,
+  customer_id,
+  reactivation_date as alert_date,
+  'DORMANCY_REACTIVATION' as alert_type,
+  risk_score,
+  CONCAT(
+    'Customer reactivated after ', dormant_days, ' days dormant',
+    ' (last activity ', CAST(previous_date AS STRING), ')',
+    ' and moved $', FORMAT('%\'.0f', total_amount),
+    ' in ', transaction_count, IF(transaction_count = 1, ' transaction', ' transactions'),
+    ' within ', dormancy_window_days, IF(dormancy_window_days = 1, ' day', ' days')
+  ) as description,
+  CASE 
+    WHEN risk_score >= 80 THEN 'HIGH'
+    WHEN risk_score >= 50 THEN 'MEDIUM'
+    ELSE 'LOW'
+  END as priority,
+  total_amount,
+  'OPEN' as status,
+  CURRENT_DATE() as detection_date
+FROM dormancy_scores;
+
+-- Step 8: Run High-Risk Merchant Detection (needs merchant_risk_scores from: merchants score)
+INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+WITH high_risk_transactions AS (
+  SELECT 
+    CONCAT(t.first, '_', t.last) as customer_id,
+    CONCAT(t.first, '|', t.last, '|', CAST(t.dob AS STRING)) as person_key,
+    DATE(t.trans_date_trans_time) as transaction_date,
+    t.merchant,
+    t.amt,
+    m.risk_score as merchant_risk_score
+  FROM `anlaytics-465216.aml_data.credit_card_transactions` t
+  JOIN `anlaytics-465216.aml_data.merchant_risk_scores` m
+    ON m.merchant = t.merchant
+  WHERE m.is_high_risk
+),
+
+high_risk_merchant_analysis AS (
+  SELECT 
+    person_key,
+    customer_id,
+    transaction_date,
+    COUNT(*) as transaction_count,
+    SUM(amt) as total_amount,
+    MAX(merchant_risk_score) as top_merchant_score,
+    STRING_AGG(DISTINCT merchant, ', ' ORDER BY merchant) as merchants
+  FROM high_risk_transactions
+  GROUP BY person_key, customer_id, transaction_date
+),
+
+high_risk_merchant_scores AS (
+  SELECT 
+    *,
+    LEAST(top_merchant_score + (transaction_count - 1) * high_risk_merchant_weight, 100) as risk_score
+  FROM high_risk_merchant_analysis
+)
+
+SELECT 
+  (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+  ROW_NUMBER() OVER (ORDER BY risk_score DESC) as alert_id,
+  customer_id,
+  transaction_date as alert_date,
+  'HIGH_RISK_MERCHANT' as alert_type,
+  risk_score,
+  CONCAT(
+    'Customer made ', transaction_count, IF(transaction_count = 1, ' transaction', ' transactions'),
+    ' totaling $', FORMAT('%\'.0f', total_amount),
+    ' at high-risk merchants (', merchants, ')'
+  ) as description,
+  CASE 
+    WHEN risk_score >= 80 THEN 'HIGH'
+    WHEN risk_score >= 50 THEN 'MEDIUM'
+    ELSE 'LOW'
+  END as priority,
+  total_amount,
+  'OPEN' as status,
+  CURRENT_DATE() as detection_date
+FROM high_risk_merchant_scores;
+
+-- Step 9: Run Merchant Anomaly Detection (funnels and volume spikes)
+INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+WITH merchant_transactions AS (
+  SELECT 
+    merchant,
+    CONCAT(first, '|', last, '|', CAST(dob AS STRING)) as person_key,
+    cc_num,
+    DATE(trans_date_trans_time) as transaction_date,
+    trans_date_trans_time,
+    amt
+  FROM `anlaytics-465216.aml_data.credit_card_transactions`
+),
+
+merchant_days AS (
+  SELECT 
+    merchant,
+    transaction_date,
+    COUNT(*) as transaction_count,
+    SUM(amt) as total_amount
+  FROM merchant_transactions
+  GROUP BY merchant, transaction_date
+),
+
+-- Funnels: the amount band [amt, amt * (1 + tolerance)] paid by the most
+-- distinct customers on the day
+funnel_bands AS (
+  SELECT 
+    b.merchant,
+    b.transaction_date,
+    b.amt as band_low,
+    MAX(a.amt) as band_high,
+    COUNT(*) as funnel_transactions,
+    COUNT(DISTINCT a.person_key) as funnel_customers,
+    COUNT(DISTINCT a.cc_num) as funnel_cards,
+    SUM(a.amt) as funnel_amount
+  FROM (SELECT DISTINCT merchant, transaction_date, amt FROM merchant_transactions) b
+  JOIN merchant_transactions a
+    ON a.merchant = b.merchant
+    AND a.transaction_date = b.transaction_date
+    AND a.amt BETWEEN b.amt AND b.amt * (1 + merchant_funnel_amount_tolerance)
+  GROUP BY b.merchant, b.transaction_date, b.amt
+  QUALIFY ROW_NUMBER() OVER (
+    PARTITION BY b.merchant, b.transaction_date 
+    ORDER BY COUNT(DISTINCT a.person_key) DESC, SUM(a.amt) DESC, b.amt
+  ) = 1
+),
+
+-- Spikes: the day's takings against the daily average of the baseline days
+-- before it
+daily_baseline AS (
+  SELECT 
+    d.merchant,
+    d.transaction_date,
+    SUM(p.amt) / merchant_spike_baseline_days as baseline_daily_amount
+  FROM merchant_days d
+  JOIN merchant_transactions p
+    ON p.merchant = d.merchant
+    AND p.transaction_date BETWEEN DATE_SUB(d.transaction_date, INTERVAL merchant_spike_baseline_days DAY) 
+      AND DATE_SUB(d.transaction_date, INTERVAL 1 DAY)
+  GROUP BY d.merchant, d.transaction_date
+),
+
+merchant_anomaly_analysis AS (
+  SELECT 
+    d.merchant,
+    d.transaction_date,
+    d.transaction_count,
+    d.total_amount,
+    f.band_low,
+    f.band_high,
+    f.funnel_transactions,
+    f.funnel_customers,
+    f.funnel_cards,
+    f.funnel_amount,
+    b.baseline_daily_amount,
+    d.total_amount / b.baseline_daily_amount as spike_ratio,
+    IFNULL(f.funnel_customers >= merchant_funnel_min_customers, FALSE) as is_funnel,
+    IFNULL(
+      d.transaction_count >= merchant_spike_min_transactions 
+      AND d.total_amount >= merchant_spike_multiplier * b.baseline_daily_amount,
+      FALSE
+    ) as is_spike
+  FROM merchant_days d
+  LEFT JOIN funnel_bands f
+    ON f.merchant = d.merchant AND f.transaction_date = d.transaction_date
+  LEFT JOIN daily_baseline b
+    ON b.merchant = d.merchant AND b.transaction_date = d.transaction_date
+),
+
+merchant_anomaly_scores AS (
+  SELECT 
+    *,
+    LEAST(
+      IF(is_funnel, funnel_customers * merchant_funnel_weight, 0) +
+      IF(is_spike, CAST(FLOOR(spike_ratio) AS INT64) * merchant_spike_weight, 0),
+      100
+    ) as risk_score
+  FROM merchant_anomaly_analysis
+  WHERE is_funnel OR is_spike
+)
+
+SELECT 
+  (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+  ROW_NUMBER() OVER (ORDER BY risk_score DESC) as alert_id,
+  CONCAT('merchant:', merchant) as customer_id,  -- merchant alerts are raised on the merchant
+  transaction_date as alert_date,
+  'MERCHANT_ANOMALY' as alert_type,
+  risk_score,
+  ARRAY_TO_STRING([
+    IF(is_funnel, CONCAT(
+      'Merchant received ', funnel_transactions, ' payments ',
+      IF(band_low = band_high, 
+        CONCAT('of $', FORMAT('%.2f', band_low)),
+        CONCAT('between $', FORMAT('%.2f', band_low), ' and $', FORMAT('%.2f', band_high))),
+      ' from ', funnel_customers, ' unrelated customers on ', 
+      funnel_cards, IF(funnel_cards = 1, ' card', ' cards')
+    ), NULL),
+    IF(is_spike, CONCAT(
+      'Merchant took $', FORMAT('%\'.0f', total_amount),
+      ' in ', transaction_count, IF(transaction_count = 1, ' transaction', ' transactions'),
+      ', ', FORMAT('%.1f', spike_ratio), 'x its ', merchant_spike_baseline_days,
+      '-day daily average of $', FORMAT('%\'.0f', baseline_daily_amount)
+    ), NULL)
+  ], '; ') as description,
+  CASE 
+    WHEN risk_score >= 80 THEN 'HIGH'
+    WHEN risk_score >= 50 THEN 'MEDIUM'
+    ELSE 'LOW'
+  END as priority,
+  IF(is_spike, total_amount, funnel_amount) as total_amount,
+  'OPEN' as status,
+  CURRENT_DATE() as detection_date
+FROM merchant_anomaly_scores;
+
+-- Step 10: Rebuild Behavioural Baselines (runs the full customer_baselines.sql; BEHAVIOUR_DEVIATION
+-- alerts are only raised by the incremental processing, against the baseline before each batch)
+
+-- Step 11: Run Peer Outlier Detection (occupation, age band and urban/rural peer groups)
+CREATE OR REPLACE TABLE `anlaytics-465216.aml_data.customer_peer_metrics` AS
+WITH peer_window AS (
+  SELECT MAX(trans_date_trans_time) as window_end
+  FROM `anlaytics-465216.aml_data.credit_card_transactions`
+),
+
+customer_activity AS (
+  SELECT 
+    CONCAT(t.first, '|', t.last, '|', CAST(t.dob AS STRING)) as person_key,
+    CONCAT(t.first, '_', t.last) as customer_id,
+    ANY_VALUE(t.job) as job,
+    ANY_VALUE(t.dob) as dob,
+    ANY_VALUE(t.city_pop) as city_pop,
+    SUM(t.amt) as total_amount,
+    COUNT(*) as transaction_count,
+    AVG(t.amt) as avg_amount,
+    COUNTIF(EXTRACT(HOUR FROM t.trans_date_trans_time) >= 22 
+      OR EXTRACT(HOUR FROM t.trans_date_trans_time) < 6) / COUNT(*) as night_share,
+    COUNTIF(ENDS_WITH(t.category, '_net')) / COUNT(*) as online_share,
+    MAX(t.trans_date_trans_time) as last_transaction,
+    ANY_VALUE(w.window_end) as window_end
+  FROM `anlaytics-465216.aml_data.credit_card_transactions` t
+  CROSS JOIN peer_window w
+  WHERE t.trans_date_trans_time > TIMESTAMP_SUB(w.window_end, INTERVAL peer_window_days DAY)
+  GROUP BY person_key, customer_id
+),
+
+-- Age at the end of the window and urban/rural from city_pop
+classified AS (
+  SELECT 
+    * EXCEPT (dob, city_pop),
+    CASE 
+      WHEN age < 25 THEN 'under 25'
+      WHEN age < 35 THEN '25-34'
+      WHEN age < 45 THEN '35-44'
+      WHEN age < 55 THEN '45-54'
+      WHEN age < 65 THEN '55-64'
+      ELSE '65+'
+    END as age_band,
+    IF(city_pop >= peer_urban_population, 'URBAN', 'RURAL') as area_type
+  FROM (
+    SELECT 
+      *,
+      DATE_DIFF(DATE(window_end), dob, YEAR) - 
+        IF(EXTRACT(DAYOFYEAR FROM window_end) < EXTRACT(DAYOFYEAR FROM dob), 1, 0) as age
+    FROM customer_activity
+  )
+),
+
+-- Candidate groups from most to least specific, with their sizes
+group_sizes AS (
+  SELECT 
+    *,
+    COUNT(*) OVER (PARTITION BY job, age_band, area_type) as job_group_size,
+    COUNT(*) OVER (PARTITION BY age_band, area_type) as age_group_size,
+    COUNT(*) OVER (PARTITION BY area_type) as area_group_size
+  FROM classified
+),
+
+-- Each customer is compared with the most specific group of peer_min_group_size+
+peer_groups AS (
+  SELECT 
+    * EXCEPT (job_group_size, age_group_size, area_group_size),
+    CASE 
+      WHEN job_group_size >= peer_min_group_size THEN 'JOB_AGE_AREA'
+      WHEN age_group_size >= peer_min_group_size THEN 'AGE_AREA'
+      WHEN area_group_size >= peer_min_group_size THEN 'AREA'
+    END as peer_level,
+    CASE 
+      WHEN job_group_size >= peer_min_group_size THEN CONCAT(job, ', ', age_band, ', ', LOWER(area_type))
+      WHEN age_group_size >= peer_min_group_size THEN CONCAT(age_band, ', ', LOWER(area_type))
+      WHEN area_group_size >= peer_min_group_size THEN LOWER(area_type)
+    END as peer_group,
+    CASE 
+      WHEN job_group_size >= peer_min_group_size THEN job_group_size
+      WHEN age_group_size >= peer_min_group_size THEN age_group_size
+      WHEN area_group_size >= peer_min_group_size THEN area_group_size
+    END as peer_group_size
+  FROM group_sizes
+),
+
+peer_medians AS (
+  SELECT 
+    *,
+    PERCENTILE_CONT(total_amount, 0.5) OVER (PARTITION BY peer_group) as total_amount_median,
+    PERCENTILE_CONT(transaction_count, 0.5) OVER (PARTITION BY peer_group) as transaction_count_median,
+    PERCENTILE_CONT(avg_amount, 0.5) OVER (PARTITION BY peer_group) as avg_amount_median,
+    PERCENTILE_CONT(night_share, 0.5) OVER (PARTITION BY peer_group) as night_share_median,
+    PERCENTILE_CONT(online_share, 0.5) OVER (PARTITION BY peer_group) as online_share_median
+  FROM peer_groups
+),
+
+peer_spreads AS (
+  SELECT 
+    *,
+    PERCENTILE_CONT(ABS(total_amount - total_amount_median), 0.5) OVER (PARTITION BY peer_group) as total_amount_mad,
+    PERCENTILE_CONT(ABS(transaction_count - transaction_count_median), 0.5) OVER (PARTITION BY peer_group) as transaction_count_mad,
+    PERCENTILE_CONT(ABS(avg_amount - avg_amount_median), 0.5) OVER (PARTITION BY peer_group) as avg_amount_mad,
+    PERCENTILE_CONT(ABS(night_share - night_share_median), 0.5) OVER (PARTITION BY peer_group) as night_share_mad,
+    PERCENTILE_CONT(ABS(online_share - online_share_median), 0.5) OVER (PARTITION BY peer_group) as online_share_mad
+  FROM peer_medians
+),
+
+-- Robust z-scores: distance from the group median in units of 1.4826 times
+-- the median absolute deviation, floored at 10% of the median
+peer_scores AS (
+  SELECT 
+    *,
+    IF(peer_group IS NULL, 0, (total_amount - total_amount_median) / GREATEST(1.4826 * total_amount_mad, 0.1 * ABS(total_amount_median), 1)) as total_amount_z,
+    IF(peer_group IS NULL, 0, (transaction_count - transaction_count_median) / GREATEST(1.4826 * transaction_count_mad, 0.1 * ABS(transaction_count_median), 1)) as transaction_count_z,
+    IF(peer_group IS NULL, 0, (avg_amount - avg_amount_median) / GREATEST(1.4826 * avg_amount_mad, 0.1 * ABS(avg_amount_median), 1)) as avg_amount_z,
+    IF(peer_group IS NULL, 0, (night_share - night_share_median) / GREATEST(1.4826 * night_share_mad, 0.1 * ABS(night_share_median), 0.05)) as night_share_z,
+    IF(peer_group IS NULL, 0, (online_share - online_share_median) / GREATEST(1.4826 * online_share_mad, 0.1 * ABS(online_share_median), 0.05)) as online_share_z
+  FROM peer_spreads
+)
+
+SELECT 
+  person_key,
+  customer_id,
+  job,
+  age_band,
+  area_type,
+  peer_level,
+  peer_group,
+  peer_group_size,
+  total_amount,
+  transaction_count,
+  avg_amount,
+  night_share,
+  online_share,
+  total_amount_z,
+  transaction_count_z,
+  avg_amount_z,
+  night_share_z,
+  online_share_z,
+  IF(total_amount_z >= peer_z_score, 1, 0) +
+      IF(transaction_count_z >= peer_z_score, 1, 0) +
+      IF(avg_amount_z >= peer_z_score, 1, 0) +
+      IF(night_share_z >= peer_z_score, 1, 0) +
+      IF(online_share_z >= peer_z_score, 1, 0) as outlier_metrics,
+  last_transaction,
+  window_end
+FROM peer_scores;
+
+INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+WITH peer_comparison AS (
+  SELECT 
+    *,
+    PERCENTILE_CONT(total_amount, 0.5) OVER (PARTITION BY peer_group) as total_amount_median,
+    PERCENTILE_CONT(transaction_count, 0.5) OVER (PARTITION BY peer_group) as transaction_count_median,
+    PERCENTILE_CONT(avg_amount, 0.5) OVER (PARTITION BY peer_group) as avg_amount_median,
+    PERCENTILE_CONT(night_share, 0.5) OVER (PARTITION BY peer_group) as night_share_median,
+    PERCENTILE_CONT(online_share, 0.5) OVER (PARTITION BY peer_group) as online_share_median
+  FROM `anlaytics-465216.aml_data.customer_peer_metrics`
+  WHERE peer_group IS NOT NULL
+),
+
+peer_outliers AS (
+  SELECT 
+    *,
+    LEAST(outlier_metrics * peer_outlier_weight, 100) as risk_score,
+    -- Observed versus the peer median for every outlying metric
+    ARRAY_TO_STRING([
+      IF(total_amount_z >= peer_z_score, CONCAT(
+        'volume $', FORMAT('%\'.0f', total_amount), ' vs peer median $', FORMAT('%\'.0f', total_amount_median),
+        ' (z ', FORMAT('%.1f', total_amount_z), ')'), NULL),
+      IF(transaction_count_z >= peer_z_score, CONCAT(
+        'transactions ', transaction_count, ' vs peer median ', FORMAT('%.0f', transaction_count_median),
+        ' (z ', FORMAT('%.1f', transaction_count_z), ')'), NULL),
+      IF(avg_amount_z >= peer_z_score, CONCAT(
+        'average amount $', FORMAT('%\'.0f', avg_amount), ' vs peer median $', FORMAT('%\'.0f', avg_amount_median),
+        ' (z ', FORMAT('%.1f', avg_amount_z), ')'), NULL),
+      IF(night_share_z >= peer_z_score, CONCAT(
+        'night share ', FORMAT('%.0f%%', night_share * 100), ' vs peer median ', FORMAT('%.0f%%', night_share_median * 100),
+        ' (z ', FORMAT('%.1f', night_share_z), ')'), NULL),
+      IF(online_share_z >= peer_z_score, CONCAT(
+        'online share ', FORMAT('%.0f%%', online_share * 100), ' vs peer median ', FORMAT('%.0f%%', online_share_median * 100),
+        ' (z ', FORMAT('%.1f', online_share_z), ')'), NULL)
+    ], '; ') as reasons
+  FROM peer_comparison
+  WHERE outlier_metrics > 0
+)
+
+SELECT 
+  (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+  ROW_NUMBER() OVER (ORDER BY risk_score DESC) as alert_id,
+  customer_id,
+  DATE(last_transaction) as alert_date,
+  'PEER_OUTLIER' as alert_type,
+  risk_score,
+  CONCAT(
+    "Customer's ", peer_window_days, '-day activity is an outlier among ', peer_group_size,
+    ' peers (', peer_group, '): ', reasons
+  ) as description,
+  CASE 
+    WHEN risk_score >= 80 THEN 'HIGH'
+    WHEN risk_score >= 50 THEN 'MEDIUM'
+    ELSE 'LOW'
+  END as priority,
+  total_amount,
+  'OPEN' as status,
+  CURRENT_DATE() as detection_date
+FROM peer_outliers;
+
+-- Step 12: Rebuild Network Clusters (runs the Go graph tool: graph build; GRAPH_CLUSTER alerts are
+-- raised by: detect run)
+
+-- Step 13: Score Anomaly Model (runs the Go model tool: model score; appends anomaly_scores and
+-- raises ML_ANOMALY alerts from the trained isolation forest)
+
+-- Step 14: Score Fraud Model (runs the Go model tool: model fraud-score; appends calibrated
+-- fraud_scores from the model trained by: model fraud-train)
+
+-- Step 15: Run Rule Detection (runs sql/rule_detection.sql, generated from config/rules by:
+-- rules sql -out sql/rule_detection.sql)
+
+-- Step 16: Generate Customer Risk Profiles (runs the full customer_risk_profiles.sql)
+
+-- Step 17: Generate Merchant Risk Profiles (runs the full merchant_risk_profiles.sql)
 
 -- Final: Show summary
 SELECT 
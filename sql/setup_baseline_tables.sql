@@ -0,0 +1,32 @@
+-- ============================================================================
+-- BEHAVIOURAL BASELINE SETUP - Per-customer behaviour statistics
+-- Run once before the incremental processing, then seed the baselines from
+-- the full history with customer_baselines.sql (or: baseline update)
+-- ============================================================================
+
+-- One row per customer (name and date of birth), dimension and bucket.
+-- AMOUNT and DISTANCE (km between the customer's address and the merchant)
+-- keep a count, sum and sum of squares for the mean and standard deviation;
+-- CATEGORY and HOUR count transactions per category and hour of day.
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.customer_baselines` (
+  person_key STRING NOT NULL,
+  customer_id STRING,
+  dimension STRING NOT NULL,         -- AMOUNT, DISTANCE, CATEGORY or HOUR
+  bucket STRING NOT NULL,            -- category or hour; empty for AMOUNT and DISTANCE
+  transaction_count INT64,
+  value_sum FLOAT64,
+  value_sum_squares FLOAT64,
+  last_transaction TIMESTAMP,        -- latest transaction folded in
+  updated_at TIMESTAMP
+);
+
+-- Customers with enough history to be scored
+SELECT
+  customer_id,
+  transaction_count,
+  ROUND(value_sum / transaction_count, 2) AS mean_amount,
+  last_transaction
+FROM `anlaytics-465216.aml_data.customer_baselines`
+WHERE dimension = 'AMOUNT'
+  AND transaction_count >= 20
+ORDER BY customer_id;
@@ -0,0 +1,80 @@
+-- ============================================================================
+-- CASE MANAGEMENT SETUP - History of alert reviews
+-- Run once before using the Go cases tool (cmd/cases)
+-- ============================================================================
+
+-- Every status change, assignment, due date and note on a case, appended in
+-- event_id order and never updated. alert_id is the case ID. The status of
+-- the case's alerts in aml_alerts_level1 follows the latest STATUS event.
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.alert_events` (
+  event_id INT64 NOT NULL,
+  alert_id INT64 NOT NULL,
+  event_type STRING NOT NULL,        -- STATUS, ASSIGN, DUE_DATE or NOTE
+  from_status STRING,                -- STATUS events
+  to_status STRING,                  -- STATUS events
+  assignee STRING,                   -- ASSIGN events
+  due_date DATE,                     -- DUE_DATE events
+  note STRING,                       -- required when closing a case
+  actor STRING NOT NULL,
+  at TIMESTAMP
+);
+
+-- The alerts of each case: a customer's related alerts within a period
+-- (7 days by default) are worked as one case, identified by its first
+-- alert's ID. Written when alerts are consolidated (cmd/cases consolidate,
+-- detect run, model score, incremental processing); an alert without a row
+-- has not been consolidated yet.
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.case_alerts` (
+  case_id INT64 NOT NULL,
+  alert_id INT64 NOT NULL,
+  linked_at TIMESTAMP
+);
+
+-- Open cases with their alerts, current assignee and due date (30 days
+-- after the first alert's detection unless set)
+WITH members AS (
+  SELECT
+    IFNULL(c.case_id, a.alert_id) as case_id,
+    a.*
+  FROM `anlaytics-465216.aml_data.aml_alerts_level1` a
+  LEFT JOIN `anlaytics-465216.aml_data.case_alerts` c ON c.alert_id = a.alert_id
+),
+best AS (
+  SELECT case_id, alert_type, MAX(LEAST(GREATEST(risk_score, 0), 100)) as risk_score
+  FROM members
+  GROUP BY case_id, alert_type
+),
+scores AS (
+  -- 100 * (1 - product of (1 - score/100)) over each type's best score
+  SELECT
+    case_id,
+    CAST(ROUND(100 * (1 - EXP(SUM(LN(GREATEST(1 - risk_score / 100, 1e-9)))))) AS INT64) as aggregate_score,
+    STRING_AGG(alert_type, ',' ORDER BY alert_type) as alert_types
+  FROM best
+  GROUP BY case_id
+),
+latest AS (
+  SELECT
+    alert_id as case_id,
+    ARRAY_AGG(IF(event_type = 'ASSIGN', assignee, NULL) IGNORE NULLS ORDER BY event_id DESC LIMIT 1)[SAFE_OFFSET(0)] as assignee,
+    ARRAY_AGG(IF(event_type = 'DUE_DATE', due_date, NULL) IGNORE NULLS ORDER BY event_id DESC LIMIT 1)[SAFE_OFFSET(0)] as due_date
+  FROM `anlaytics-465216.aml_data.alert_events`
+  GROUP BY alert_id
+)
+SELECT 
+  m.case_id,
+  ANY_VALUE(IF(m.alert_id = m.case_id, m.status, NULL)) as status,
+  ANY_VALUE(m.customer_id) as customer_id,
+  COUNT(*) as alerts,
+  ANY_VALUE(g.alert_types) as alert_types,
+  ANY_VALUE(g.aggregate_score) as aggregate_score,
+  MIN(m.alert_date) as first_alert_date,
+  MAX(m.alert_date) as last_alert_date,
+  ANY_VALUE(l.assignee) as assignee,
+  IFNULL(ANY_VALUE(l.due_date), DATE_ADD(ANY_VALUE(IF(m.alert_id = m.case_id, m.detection_date, NULL)), INTERVAL 30 DAY)) as due_date
+FROM members m
+JOIN scores g ON g.case_id = m.case_id
+LEFT JOIN latest l ON l.case_id = m.case_id
+GROUP BY m.case_id
+HAVING status NOT IN ('CLOSED_FALSE_POSITIVE', 'CLOSED_SAR_FILED')
+ORDER BY due_date, aggregate_score DESC;
\ No newline at end of file
@@ -0,0 +1,73 @@
+-- ============================================================================
+-- CONFIG VERSION SETUP - Approved detector thresholds and rules
+-- Run once before using the Go configs tool (cmd/configs)
+-- ============================================================================
+
+-- One row per proposed configuration. Rows are never updated: a change is a
+-- new version.
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.config_versions` (
+  version INT64 NOT NULL,
+  author STRING NOT NULL,
+  rationale STRING NOT NULL,
+  effective_date DATE NOT NULL,      -- in force from this date once activated
+  based_on INT64,                    -- version active when proposed, 0 for none
+  config STRING,                     -- complete detector config (config/aml_config.json)
+  rules ARRAY<STRUCT<file STRING, content STRING>>,  -- rule files (config/rules)
+  checksum STRING,
+  proposed_at TIMESTAMP
+);
+
+-- Approvals, rejections and activations, appended in order
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.config_version_events` (
+  version INT64 NOT NULL,
+  action STRING NOT NULL,            -- APPROVE, REJECT or ACTIVATE
+  actor STRING NOT NULL,             -- approvers must differ from the author
+  comment STRING,
+  at TIMESTAMP
+);
+
+-- Detection runs with the config version that produced their alerts. The
+-- alerts are linked in detection_run_alerts; first_alert_id and
+-- last_alert_id only bound their IDs.
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.detection_runs` (
+  run_id INT64 NOT NULL,
+  source STRING,                     -- the raising tool, e.g. detect run or incremental_sql
+  config_version INT64,              -- 0 when run from unversioned files
+  config_checksum STRING,
+  config STRING,                     -- the detector config of an unversioned run
+  rules ARRAY<STRUCT<file STRING, content STRING>>,  -- and its rule files
+  since TIMESTAMP,
+  started_at TIMESTAMP,
+  finished_at TIMESTAMP,
+  transactions INT64,
+  alerts INT64,
+  suppressed INT64,                  -- alerts dropped by suppression rules
+  first_alert_id INT64,
+  last_alert_id INT64
+);
+
+-- detection_runs tables created before unversioned runs recorded their
+-- settings lack them
+ALTER TABLE `anlaytics-465216.aml_data.detection_runs` ADD COLUMN IF NOT EXISTS config STRING;
+ALTER TABLE `anlaytics-465216.aml_data.detection_runs` ADD COLUMN IF NOT EXISTS rules ARRAY<STRUCT<file STRING, content STRING>>;
+
+-- The alerts each run raised
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.detection_run_alerts` (
+  run_id INT64 NOT NULL,
+  alert_id INT64 NOT NULL
+);
+
+-- Version in force today
+SELECT 
+  v.version,
+  v.effective_date,
+  v.author,
+  e.actor as activated_by,
+  v.checksum,
+  v.rationale
+FROM `anlaytics-465216.aml_data.config_versions` v
+JOIN `anlaytics-465216.aml_data.config_version_events` e
+  ON e.version = v.version AND e.action = 'ACTIVATE'
+WHERE v.effective_date <= CURRENT_DATE()
+ORDER BY v.effective_date DESC, e.at DESC
+LIMIT 1;
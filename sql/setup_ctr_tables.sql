@@ -0,0 +1,43 @@
+-- ============================================================================
+-- CTR SETUP - Currency Transaction Report candidates
+-- Run once before using the Go CTR tool (cmd/ctr)
+-- ============================================================================
+
+-- One row per person (name and date of birth) and business day whose
+-- activity across all cards exceeds $10,000
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.ctr_candidates` (
+  candidate_id INT64 NOT NULL,
+  customer_id STRING NOT NULL,
+  first STRING,
+  last STRING,
+  dob STRING,
+  gender STRING,
+  street STRING,
+  city STRING,
+  state STRING,
+  zip STRING,
+  job STRING,
+  business_date DATE NOT NULL,
+  total_amount FLOAT64,
+  transaction_count INT64,
+  card_count INT64,
+  cards ARRAY<STRING>,               -- masked card numbers
+  trans_nums ARRAY<STRING>,
+  first_transaction TIMESTAMP,
+  last_transaction TIMESTAMP,
+  status STRING,                     -- PENDING, EXPORTED or VOID
+  batch_id STRING,                   -- FinCEN batch the CTR was exported in
+  created_at TIMESTAMP
+);
+
+-- Candidates waiting to be filed
+SELECT
+  business_date,
+  customer_id,
+  dob,
+  ROUND(total_amount, 2) AS total_amount,
+  transaction_count,
+  card_count
+FROM `anlaytics-465216.aml_data.ctr_candidates`
+WHERE status = 'PENDING'
+ORDER BY business_date, customer_id;
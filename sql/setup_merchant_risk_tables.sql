@@ -0,0 +1,66 @@
+-- ============================================================================
+-- MERCHANT RISK SETUP - Merchant risk scores and profiles
+-- Run once before the incremental processing or the Go merchant tool
+-- (cmd/merchants), which refreshes the scores from config/merchant_risk.json
+-- ============================================================================
+
+-- One row per merchant. risk_score is the larger of the registry weight
+-- (merchant or category) and the score from the share of the merchant's
+-- customers with alerts.
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.merchant_risk_scores` (
+  merchant STRING NOT NULL,
+  category STRING,
+  registry_weight INT64,
+  listed_merchant BOOL,              -- weight comes from the merchant entry, not the category
+  customers INT64,
+  alerted_customers INT64,
+  alert_density FLOAT64,
+  density_score INT64,
+  risk_score INT64,
+  is_high_risk BOOL,
+  scored_at TIMESTAMP
+);
+
+-- Merchant-side counterpart of customer_risk_profiles_level2, rebuilt by
+-- merchant_risk_profiles.sql, the incremental processing and
+-- "merchants profile"
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.merchant_risk_profiles` (
+  merchant STRING,
+  category STRING,
+  total_transactions INT64,
+  total_amount FLOAT64,
+  avg_amount FLOAT64,
+  unique_customers INT64,
+  unique_cards INT64,
+  active_days INT64,
+  avg_daily_amount FLOAT64,
+  peak_daily_amount FLOAT64,
+  peak_date DATE,
+  anomaly_alerts INT64,              -- MERCHANT_ANOMALY alerts
+  max_anomaly_score INT64,
+  merchant_risk_score INT64,         -- from merchant_risk_scores
+  risk_score INT64,
+  risk_category STRING,
+  first_transaction_date DATE,
+  last_transaction_date DATE,
+  profile_generated_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP()
+);
+
+-- MERCHANT_ANOMALY alerts raised before they carried the merchant:<name>
+-- subject have the bare merchant name in customer_id
+UPDATE `anlaytics-465216.aml_data.aml_alerts_level1`
+SET customer_id = CONCAT('merchant:', customer_id)
+WHERE alert_type = 'MERCHANT_ANOMALY'
+  AND NOT STARTS_WITH(customer_id, 'merchant:');
+
+-- High-risk merchants used by HIGH_RISK_MERCHANT alerts and risk profiles
+SELECT
+  merchant,
+  category,
+  risk_score,
+  registry_weight,
+  alerted_customers,
+  customers
+FROM `anlaytics-465216.aml_data.merchant_risk_scores`
+WHERE is_high_risk
+ORDER BY risk_score DESC, merchant;
@@ -78,6 +78,9 @@ CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.customer_risk_profiles_lev
   total_alerts INT64,
   high_priority_alerts INT64,
   max_alert_risk_score INT64,
+  high_risk_merchant_transactions INT64,
+  peer_outlier_metrics INT64,
+  ml_anomaly_score FLOAT64,        -- NULL until the anomaly model has scored the customer
   first_transaction_date DATE,
   last_transaction_date DATE,
   profile_generated_date TIMESTAMP DEFAULT CURRENT_TIMESTAMP()
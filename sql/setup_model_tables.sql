@@ -0,0 +1,78 @@
+-- ============================================================================
+-- MODEL SETUP - Isolation forest and fraud model scores
+-- Run once before the Go model tool (cmd/model), which appends a row for
+-- every customer-day or transaction it scores
+-- ============================================================================
+
+-- One row per scored customer-day. anomaly_score runs from 0 to 1; days at
+-- or above the model threshold (the training score at the contamination
+-- quantile) are anomalies and raise ML_ANOMALY alerts. model_version
+-- identifies the trained trees, so scores from different models can be
+-- told apart after retraining.
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.anomaly_scores` (
+  person_key STRING NOT NULL,
+  customer_id STRING,
+  score_date STRING NOT NULL,        -- YYYY-MM-DD
+  anomaly_score FLOAT64,
+  threshold FLOAT64,
+  is_anomaly BOOL,
+  top_features STRING,               -- features that isolated the day soonest
+  model_version STRING,              -- iforest-...
+  scored_at TIMESTAMP
+);
+
+-- One row per scored transaction with its calibrated fraud probability,
+-- from model fraud-score or the incremental processing. top_factors are the
+-- features that raise the probability most.
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.fraud_scores` (
+  trans_num STRING NOT NULL,
+  person_key STRING,
+  customer_id STRING,
+  trans_date_trans_time TIMESTAMP,
+  fraud_probability FLOAT64,         -- 0 to 1, Platt-calibrated
+  top_factors STRING,
+  model_version STRING,              -- logit-...
+  scored_at TIMESTAMP
+);
+
+-- The current fraud model, one row per feature, replaced by
+-- model fraud-train. The incremental processing scores new transactions
+-- with it: z = intercept + SUM(weight * (value - mean) / scale) and
+-- p = 1 / (1 + EXP(-(calibration_a * z + calibration_b))).
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.fraud_model_coefficients` (
+  model_version STRING NOT NULL,
+  feature STRING NOT NULL,
+  mean FLOAT64,
+  scale FLOAT64,
+  weight FLOAT64,
+  importance FLOAT64,                -- share of the total absolute weight
+  intercept FLOAT64,
+  calibration_a FLOAT64,
+  calibration_b FLOAT64,
+  trained_at TIMESTAMP
+);
+
+-- Highest-scoring customer-days from the latest model
+SELECT
+  customer_id,
+  score_date,
+  ROUND(anomaly_score, 3) AS anomaly_score,
+  top_features
+FROM `anlaytics-465216.aml_data.anomaly_scores`
+WHERE model_version = (
+  SELECT model_version FROM `anlaytics-465216.aml_data.anomaly_scores`
+  ORDER BY scored_at DESC LIMIT 1
+)
+ORDER BY anomaly_score DESC
+LIMIT 50;
+
+-- Most likely frauds scored in the last day
+SELECT
+  customer_id,
+  trans_date_trans_time,
+  ROUND(fraud_probability, 3) AS fraud_probability,
+  top_factors
+FROM `anlaytics-465216.aml_data.fraud_scores`
+WHERE scored_at >= TIMESTAMP_SUB(CURRENT_TIMESTAMP(), INTERVAL 1 DAY)
+ORDER BY fraud_probability DESC
+LIMIT 50;
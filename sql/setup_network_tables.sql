@@ -0,0 +1,41 @@
+-- ============================================================================
+-- NETWORK ANALYSIS SETUP - Clusters of linked customers
+-- Run once before the Go graph tool (cmd/graph), which rebuilds the table
+-- from the customer-merchant and shared-attribute graph
+-- ============================================================================
+
+-- One row per customer in a cluster: a connected component of customers
+-- sharing an address or a card, with at least graph_min_cluster_size
+-- members. A ring has graph_ring_min_size+ members with graph_ring_density
+-- of its pairs directly linked. cluster_id is derived from the members, so
+-- an unchanged cluster keeps its id across rebuilds.
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.network_clusters` (
+  cluster_id STRING NOT NULL,        -- NC-...
+  person_key STRING NOT NULL,
+  customer_id STRING,
+  cluster_size INT64,
+  linked_pairs INT64,                -- member pairs sharing at least one attribute
+  density FLOAT64,                   -- linked_pairs over all member pairs
+  link_types STRING,                 -- ADDRESS, CARD
+  is_ring BOOL,
+  shared_merchants INT64,            -- merchants paid by two or more members
+  cluster_amount FLOAT64,
+  linked_customers STRING,           -- members this customer shares an attribute with
+  transactions INT64,
+  total_amount FLOAT64,
+  degree INT64,                      -- distinct merchants and linked customers
+  degree_centrality FLOAT64,
+  pagerank FLOAT64,                  -- over the combined payment and attribute graph
+  updated_at TIMESTAMP
+);
+
+-- Clusters, rings first
+SELECT
+  cluster_id,
+  ANY_VALUE(is_ring) AS is_ring,
+  ANY_VALUE(cluster_size) AS cluster_size,
+  ANY_VALUE(link_types) AS link_types,
+  STRING_AGG(customer_id, ', ' ORDER BY pagerank DESC) AS members
+FROM `anlaytics-465216.aml_data.network_clusters`
+GROUP BY cluster_id
+ORDER BY is_ring DESC, cluster_size DESC;
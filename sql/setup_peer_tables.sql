@@ -0,0 +1,46 @@
+-- ============================================================================
+-- PEER GROUP SETUP - Customer peer group comparison
+-- Run once before the incremental processing; the table is rebuilt by the
+-- peer outlier step (peer_outlier_detection.sql) or: detect peers
+-- ============================================================================
+
+-- One row per customer active in the peer window. Customers are grouped by
+-- job, age band and area type (URBAN from peer_urban_population city_pop),
+-- falling back to age band and area, then area alone, for groups smaller
+-- than peer_min_group_size. The *_z columns are robust z-scores against the
+-- group median; outlier_metrics counts those of peer_z_score or more.
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.customer_peer_metrics` (
+  person_key STRING NOT NULL,
+  customer_id STRING,
+  job STRING,
+  age_band STRING,                   -- under 25, 25-34, ..., 65+
+  area_type STRING,                  -- URBAN or RURAL
+  peer_level STRING,                 -- JOB_AGE_AREA, AGE_AREA, AREA; NULL without a group
+  peer_group STRING,
+  peer_group_size INT64,
+  total_amount FLOAT64,
+  transaction_count INT64,
+  avg_amount FLOAT64,
+  night_share FLOAT64,               -- share of transactions from 22:00 to 06:00
+  online_share FLOAT64,              -- share of transactions in *_net categories
+  total_amount_z FLOAT64,
+  transaction_count_z FLOAT64,
+  avg_amount_z FLOAT64,
+  night_share_z FLOAT64,
+  online_share_z FLOAT64,
+  outlier_metrics INT64,
+  last_transaction TIMESTAMP,
+  window_end TIMESTAMP               -- latest transaction; the window reaches back peer_window_days
+);
+
+-- Peer groups and their outliers
+SELECT
+  peer_level,
+  peer_group,
+  peer_group_size,
+  COUNTIF(outlier_metrics > 0) AS outliers,
+  ROUND(APPROX_QUANTILES(total_amount, 2)[OFFSET(1)], 2) AS median_volume
+FROM `anlaytics-465216.aml_data.customer_peer_metrics`
+WHERE peer_group IS NOT NULL
+GROUP BY peer_level, peer_group, peer_group_size
+ORDER BY peer_group_size DESC;
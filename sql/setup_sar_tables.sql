@@ -0,0 +1,57 @@
+-- ============================================================================
+-- SAR SETUP - Suspicious Activity Report filings
+-- Run once before using the Go SAR tool (cmd/sar)
+-- ============================================================================
+
+-- One row per SAR prepared from a case. The subject, activity and evidence
+-- are copied from the case when the report is drafted so the filing keeps
+-- what was reported.
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.sar_filings` (
+  filing_id INT64 NOT NULL,
+  case_id INT64 NOT NULL,
+  status STRING,                     -- DRAFT, EXPORTED or ACKNOWLEDGED
+  customer_id STRING NOT NULL,
+  first STRING,
+  last STRING,
+  dob STRING,
+  gender STRING,
+  street STRING,
+  city STRING,
+  state STRING,
+  zip STRING,
+  job STRING,
+  cards ARRAY<STRING>,               -- masked card numbers
+  activity_from DATE,
+  activity_to DATE,
+  total_amount FLOAT64,
+  alert_ids ARRAY<INT64>,
+  alert_types ARRAY<STRING>,
+  trans_nums ARRAY<STRING>,
+  narrative STRING,
+  prepared_by STRING,
+  prepared_at TIMESTAMP,
+  batch_id STRING,                   -- FinCEN batch the SAR was exported in
+  file STRING,
+  checksum STRING,                   -- SHA-256 of the batch file
+  exported_at TIMESTAMP,
+  bsa_id STRING,                     -- assigned by FinCEN on acceptance
+  acknowledged_at TIMESTAMP
+);
+
+-- Filing status of every reported case: drafts still to complete, exports
+-- waiting for FinCEN's acknowledgement, and how long each took
+SELECT
+  f.filing_id,
+  f.case_id,
+  f.customer_id,
+  f.status,
+  f.activity_from,
+  f.activity_to,
+  ROUND(f.total_amount, 2) AS total_amount,
+  f.batch_id,
+  f.bsa_id,
+  DATE(f.prepared_at) AS prepared_on,
+  IF(f.status = 'DRAFT', NULL, DATE_DIFF(DATE(f.exported_at), f.activity_to, DAY)) AS days_to_export,
+  IF(f.status = 'EXPORTED', DATE_DIFF(CURRENT_DATE(), DATE(f.exported_at), DAY), NULL) AS days_awaiting_ack
+FROM `anlaytics-465216.aml_data.sar_filings` f
+ORDER BY f.status, f.filing_id;
@@ -0,0 +1,53 @@
+-- ============================================================================
+-- SANCTIONS SCREENING SETUP - Tables for list entries and match review
+-- Run once before using the Go screening tool (cmd/screen)
+-- ============================================================================
+
+-- Transactions behind each alert (written by the Go tools)
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.alert_transactions` (
+  alert_id INT64 NOT NULL,
+  trans_num STRING NOT NULL
+);
+
+-- Imported OFAC SDN, EU and UN consolidated list entries
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.sanctions_list_entries` (
+  source STRING NOT NULL,
+  uid STRING NOT NULL,
+  name STRING,
+  aliases ARRAY<STRING>,
+  entry_type STRING,
+  programs ARRAY<STRING>
+);
+
+-- Match details behind each SANCTIONS_HIT alert, kept for analyst review
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.sanctions_matches` (
+  alert_id INT64 NOT NULL,
+  customer_id STRING,
+  subject_type STRING,
+  subject_name STRING,
+  match_rank INT64,
+  list_source STRING,
+  list_uid STRING,
+  list_name STRING,
+  matched_name STRING,
+  entry_type STRING,
+  programs ARRAY<STRING>,
+  score FLOAT64,
+  jaro_winkler FLOAT64,
+  token_set_score FLOAT64,
+  review_status STRING,
+  screened_at TIMESTAMP
+);
+
+-- Matches awaiting review
+SELECT
+  m.alert_id,
+  m.customer_id,
+  m.subject_type,
+  m.subject_name,
+  m.list_source,
+  m.matched_name,
+  m.score
+FROM `anlaytics-465216.aml_data.sanctions_matches` m
+WHERE m.review_status = 'PENDING_REVIEW'
+ORDER BY m.score DESC;
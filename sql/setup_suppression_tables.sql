@@ -0,0 +1,79 @@
+-- ============================================================================
+-- ALERT SUPPRESSION SETUP - Rules for known-legitimate activity
+-- Run once before using the Go suppress tool (cmd/suppress)
+-- ============================================================================
+
+-- One row per proposed rule. Rows are never updated: a changed rule is a new
+-- rule, and the old one is revoked. Empty scope columns match anything; a
+-- rule names a customer, a merchant or both.
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.suppression_rules` (
+  rule_id INT64 NOT NULL,
+  customer_id STRING,                -- first_last
+  merchant STRING,                   -- alerts on activity only at this merchant
+  detector STRING,                   -- alert type; SANCTIONS_HIT and WATCHLIST are never suppressed
+  from_date DATE,                    -- alert dates covered
+  to_date DATE,
+  reason STRING NOT NULL,
+  author STRING NOT NULL,
+  expires DATE NOT NULL,             -- last day the rule applies, at most a year ahead
+  created_at TIMESTAMP
+);
+
+-- Approvals and revocations, appended in order. A rule applies once
+-- approved by someone other than its author, until it expires or is revoked.
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.suppression_rule_events` (
+  rule_id INT64 NOT NULL,
+  action STRING NOT NULL,            -- APPROVE or REVOKE
+  actor STRING NOT NULL,
+  comment STRING,                    -- required when revoking
+  at TIMESTAMP
+);
+
+-- Every alert a rule dropped before insertion
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.suppressed_alerts` (
+  rule_id INT64 NOT NULL,
+  source STRING,                     -- detect run, model score or incremental_sql
+  run_id INT64,                      -- detection_runs.run_id, 0 for model score
+  customer_id STRING,
+  alert_type STRING,
+  alert_date DATE,
+  risk_score INT64,
+  total_amount FLOAT64,
+  description STRING,
+  suppressed_at TIMESTAMP
+);
+
+-- detection_runs tables created before suppression lack the count
+ALTER TABLE `anlaytics-465216.aml_data.detection_runs` ADD COLUMN IF NOT EXISTS suppressed INT64;
+
+-- Rules in force with the alerts they suppressed in the last 30 days
+WITH status AS (
+  SELECT
+    rule_id,
+    LOGICAL_OR(action = 'APPROVE') as approved,
+    LOGICAL_OR(action = 'REVOKE') as revoked,
+    ARRAY_AGG(IF(action = 'APPROVE', actor, NULL) IGNORE NULLS LIMIT 1)[SAFE_OFFSET(0)] as approver
+  FROM `anlaytics-465216.aml_data.suppression_rule_events`
+  GROUP BY rule_id
+),
+recent AS (
+  SELECT rule_id, COUNT(*) as suppressed
+  FROM `anlaytics-465216.aml_data.suppressed_alerts`
+  WHERE suppressed_at >= TIMESTAMP_SUB(CURRENT_TIMESTAMP(), INTERVAL 30 DAY)
+  GROUP BY rule_id
+)
+SELECT 
+  r.rule_id,
+  r.customer_id,
+  r.merchant,
+  r.detector,
+  r.expires,
+  r.author,
+  s.approver,
+  r.reason,
+  IFNULL(h.suppressed, 0) as suppressed_last_30_days
+FROM `anlaytics-465216.aml_data.suppression_rules` r
+JOIN status s ON s.rule_id = r.rule_id
+LEFT JOIN recent h ON h.rule_id = r.rule_id
+WHERE s.approved AND NOT s.revoked AND r.expires >= CURRENT_DATE()
+ORDER BY r.expires;
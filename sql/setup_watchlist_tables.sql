@@ -0,0 +1,42 @@
+-- ============================================================================
+-- WATCHLIST SETUP - PEP, adverse media and internal watchlists
+-- Run once before using the Go watchlist tool (cmd/watchlist)
+-- ============================================================================
+
+-- One row per imported list
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.watchlists` (
+  list_name STRING NOT NULL,
+  category STRING NOT NULL,          -- PEP, ADVERSE_MEDIA or INTERNAL
+  risk_category STRING,              -- profile risk category a hit raises to
+  description STRING,
+  entry_count INT64,
+  imported_at TIMESTAMP
+);
+
+-- Entries and aliases of every list
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.watchlist_entries` (
+  list_name STRING NOT NULL,
+  category STRING,
+  entry_id STRING NOT NULL,
+  name STRING,
+  aliases ARRAY<STRING>,
+  entry_type STRING,
+  notes STRING
+);
+
+-- Customer hits behind each WATCHLIST alert. The risk profile build uses
+-- risk_category as a floor until a hit is reviewed as a false positive.
+CREATE TABLE IF NOT EXISTS `anlaytics-465216.aml_data.watchlist_hits` (
+  alert_id INT64 NOT NULL,
+  customer_id STRING NOT NULL,
+  customer_name STRING,
+  list_name STRING,
+  category STRING,
+  entry_id STRING,
+  entry_name STRING,
+  matched_name STRING,
+  score FLOAT64,
+  risk_category STRING,
+  review_status STRING,
+  screened_at TIMESTAMP
+);
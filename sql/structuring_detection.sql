@@ -1,42 +1,105 @@
 -- ============================================================================
 -- STRUCTURING DETECTION - BigQuery SQL  
--- Detects transactions just under $10,000 threshold
+-- Detects repeated transactions just under the $10,000 threshold within
+-- rolling 1, 3, 7 and 30 day windows across all cards of a customer
 -- ============================================================================
 
+-- Detector configuration (mirrors AML_CONFIG and config/aml_config.json)
+DECLARE structuring_threshold FLOAT64 DEFAULT 10000;
+DECLARE structuring_buffer FLOAT64 DEFAULT 0.9;  -- 90% of threshold
+DECLARE structuring_min_transactions INT64 DEFAULT 2;
+DECLARE structuring_windows ARRAY<INT64> DEFAULT [1, 3, 7, 30];
+DECLARE structuring_weight INT64 DEFAULT 25;
+
 INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
-WITH structuring_analysis AS (
+WITH band_transactions AS (
   SELECT 
     CONCAT(first, '_', last) as customer_id,
+    -- A resolved customer is one person (name and date of birth) across all cards
+    CONCAT(first, '|', last, '|', CAST(dob AS STRING)) as person_key,
     DATE(trans_date_trans_time) as transaction_date,
-    COUNT(*) as transaction_count,
-    SUM(amt) as total_amount,
-    AVG(amt) as avg_amount,
-    STRING_AGG(DISTINCT merchant, ', ') as merchants
+    cc_num,
+    amt,
+    merchant
   FROM `anlaytics-465216.aml_data.credit_card_transactions`
-  WHERE amt BETWEEN 9000 AND 9999  -- Just under $10K threshold
-  GROUP BY customer_id, transaction_date
-  HAVING COUNT(*) >= 2  -- Multiple transactions in same day
+  WHERE amt >= structuring_threshold * structuring_buffer
+    AND amt < structuring_threshold  -- Just under the threshold
+),
+
+-- Every day with an in-band transaction ends one window of each size
+window_ends AS (
+  SELECT 
+    person_key,
+    ANY_VALUE(customer_id) as customer_id,
+    transaction_date as window_end
+  FROM band_transactions
+  GROUP BY person_key, transaction_date
+),
+
+structuring_windows_analysis AS (
+  SELECT 
+    e.person_key,
+    e.customer_id,
+    w as window_days,
+    e.window_end,
+    MIN(b.transaction_date) as window_start,
+    COUNT(*) as transaction_count,
+    COUNT(DISTINCT b.cc_num) as card_count,
+    SUM(b.amt) as total_amount,
+    STRING_AGG(DISTINCT b.merchant, ', ') as merchants
+  FROM window_ends e
+  CROSS JOIN UNNEST(structuring_windows) AS w
+  JOIN band_transactions b
+    ON b.person_key = e.person_key
+    AND b.transaction_date BETWEEN DATE_SUB(e.window_end, INTERVAL w - 1 DAY) AND e.window_end
+  GROUP BY e.person_key, e.customer_id, w, e.window_end
+  HAVING COUNT(*) >= structuring_min_transactions
+),
+
+-- Keep maximal windows: drop a window when the next window of the same size
+-- still contains all of its transactions, and drop larger windows that
+-- cover exactly the same transactions as a smaller one
+structuring_analysis AS (
+  SELECT 
+    a.*
+  FROM structuring_windows_analysis a
+  LEFT JOIN (
+    SELECT 
+      person_key,
+      window_end,
+      LEAD(window_end) OVER (PARTITION BY person_key ORDER BY window_end) as next_date
+    FROM window_ends
+  ) n
+    ON n.person_key = a.person_key AND n.window_end = a.window_end
+  WHERE n.next_date IS NULL 
+    OR DATE_SUB(n.next_date, INTERVAL a.window_days - 1 DAY) > a.window_start
+  QUALIFY ROW_NUMBER() OVER (
+    PARTITION BY a.person_key, a.window_start, a.window_end 
+    ORDER BY a.window_days
+  ) = 1
 )
 
 SELECT 
-  (SELECT MAX(alert_id) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+  (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
   ROW_NUMBER() OVER (ORDER BY transaction_count DESC) as alert_id,
   customer_id,
-  transaction_date as alert_date,
+  window_end as alert_date,
   'STRUCTURING' as alert_type,
-  LEAST(transaction_count * 25, 100) as risk_score,
+  LEAST(transaction_count * structuring_weight, 100) as risk_score,
   CONCAT(
     'Customer made ', transaction_count, 
     ' transactions totaling $', FORMAT('%\'.0f', total_amount),
-    ' just under $10,000 threshold'
+    ' just under $', FORMAT('%\'.0f', structuring_threshold), ' threshold',
+    ' within ', window_days, IF(window_days = 1, ' day', ' days'),
+    ' on ', card_count, IF(card_count = 1, ' card', ' cards')
   ) as description,
   CASE 
-    WHEN transaction_count * 25 >= 80 THEN 'HIGH'
-    WHEN transaction_count * 25 >= 50 THEN 'MEDIUM'
+    WHEN transaction_count * structuring_weight >= 80 THEN 'HIGH'
+    WHEN transaction_count * structuring_weight >= 50 THEN 'MEDIUM'
     ELSE 'LOW'
   END as priority,
   total_amount,
   'OPEN' as status,
   CURRENT_DATE() as detection_date
 FROM structuring_analysis
-ORDER BY risk_score DESC;
\ No newline at end of file
+ORDER BY risk_score DESC;
@@ -1,62 +1,126 @@
 -- ============================================================================
 -- VELOCITY DETECTION - BigQuery SQL
--- Detects rapid transactions within 5 minutes
+-- Detects bursts of transactions or spend within sliding 5 minute, 1 hour
+-- and 24 hour windows
 -- ============================================================================
 
-CREATE OR REPLACE TABLE `anlaytics-465216.aml_data.aml_alerts_level1` AS
-WITH velocity_analysis AS (
+-- Detector configuration (mirrors config/aml_config.json). A window alerts
+-- on min_transactions, or on min_amount spent over 2+ transactions.
+DECLARE velocity_windows ARRAY<STRUCT<minutes INT64, min_transactions INT64, min_amount FLOAT64>> DEFAULT [
+  STRUCT(5 AS minutes, 5 AS min_transactions, 0.0 AS min_amount),
+  STRUCT(60 AS minutes, 10 AS min_transactions, 5000.0 AS min_amount),
+  STRUCT(1440 AS minutes, 25 AS min_transactions, 15000.0 AS min_amount)
+];
+DECLARE velocity_weight INT64 DEFAULT 20;
+
+INSERT INTO `anlaytics-465216.aml_data.aml_alerts_level1`
+WITH customer_transactions AS (
   SELECT 
     CONCAT(first, '_', last) as customer_id,
+    CONCAT(first, '|', last, '|', CAST(dob AS STRING)) as person_key,
     trans_date_trans_time,
-    amt,
-    merchant,
-    DATE(trans_date_trans_time) as transaction_date,
-    
-    -- Calculate time difference from previous transaction
-    TIMESTAMP_DIFF(
-      trans_date_trans_time,
-      LAG(trans_date_trans_time) OVER (
-        PARTITION BY CONCAT(first, '_', last) 
-        ORDER BY trans_date_trans_time
-      ),
-      MINUTE
-    ) as minutes_since_last
-    
+    amt
   FROM `anlaytics-465216.aml_data.credit_card_transactions`
 ),
 
-rapid_transactions AS (
+-- One window of each size ends at every transaction and reaches back
+-- from that transaction's timestamp, not from the current date
+velocity_windows_analysis AS (
+  SELECT 
+    e.person_key,
+    e.customer_id,
+    w.minutes,
+    w.min_amount,
+    e.trans_date_trans_time as window_end,
+    MIN(b.trans_date_trans_time) as window_start,
+    COUNT(*) as transaction_count,
+    SUM(b.amt) as total_amount
+  FROM customer_transactions e
+  CROSS JOIN UNNEST(velocity_windows) AS w
+  JOIN customer_transactions b
+    ON b.person_key = e.person_key
+    AND b.trans_date_trans_time BETWEEN TIMESTAMP_SUB(e.trans_date_trans_time, INTERVAL w.minutes MINUTE) 
+      AND e.trans_date_trans_time
+  GROUP BY e.person_key, e.customer_id, w.minutes, w.min_transactions, w.min_amount, e.trans_date_trans_time
+  HAVING COUNT(*) >= w.min_transactions
+    OR (w.min_amount > 0 AND COUNT(*) >= 2 AND SUM(b.amt) >= w.min_amount)
+),
+
+-- Overlapping qualifying windows of one size form a single episode
+velocity_episodes AS (
+  SELECT 
+    *,
+    COUNTIF(new_episode) OVER (
+      PARTITION BY person_key, minutes 
+      ORDER BY window_end 
+      ROWS UNBOUNDED PRECEDING
+    ) as episode
+  FROM (
+    SELECT 
+      *,
+      IFNULL(window_start > LAG(window_end) OVER (
+        PARTITION BY person_key, minutes 
+        ORDER BY window_end
+      ), TRUE) as new_episode
+    FROM velocity_windows_analysis
+  )
+),
+
+-- Report each episode at its busiest window, and a larger window only when
+-- it covers different transactions than a smaller one
+velocity_analysis AS (
+  SELECT 
+    *
+  FROM (
+    SELECT 
+      *
+    FROM velocity_episodes
+    WHERE TRUE
+    QUALIFY ROW_NUMBER() OVER (
+      PARTITION BY person_key, minutes, episode 
+      ORDER BY transaction_count DESC, total_amount DESC, window_end
+    ) = 1
+  )
+  WHERE TRUE
+  QUALIFY ROW_NUMBER() OVER (
+    PARTITION BY person_key, window_start, window_end 
+    ORDER BY minutes
+  ) = 1
+),
+
+velocity_scores AS (
   SELECT 
-    customer_id,
-    transaction_date,
-    COUNT(*) as rapid_transaction_count,
-    SUM(amt) as total_amount,
-    AVG(amt) as avg_amount,
-    MIN(minutes_since_last) as min_time_diff
+    *,
+    LEAST(
+      transaction_count * velocity_weight +
+      IF(min_amount > 0, CAST(FLOOR(total_amount / min_amount) AS INT64) * velocity_weight, 0),
+      100
+    ) as risk_score
   FROM velocity_analysis
-  WHERE minutes_since_last <= 5
-  GROUP BY customer_id, transaction_date
-  HAVING COUNT(*) >= 5
 )
 
 SELECT 
-  ROW_NUMBER() OVER (ORDER BY rapid_transaction_count DESC) as alert_id,
+  (SELECT IFNULL(MAX(alert_id), 0) FROM `anlaytics-465216.aml_data.aml_alerts_level1`) + 
+  ROW_NUMBER() OVER (ORDER BY transaction_count DESC) as alert_id,
   customer_id,
-  transaction_date as alert_date,
+  DATE(window_end) as alert_date,
   'VELOCITY' as alert_type,
-  LEAST(rapid_transaction_count * 20, 100) as risk_score,
+  risk_score,
   CONCAT(
-    'Customer made ', rapid_transaction_count, 
-    ' rapid transactions (within 5 minutes) totaling $', 
-    FORMAT('%\'.0f', total_amount)
+    'Customer made ', transaction_count, 
+    ' transactions totaling $', FORMAT('%\'.0f', total_amount),
+    ' within ',
+    IF(MOD(minutes, 60) = 0,
+      CONCAT(DIV(minutes, 60), IF(minutes = 60, ' hour', ' hours')),
+      CONCAT(minutes, IF(minutes = 1, ' minute', ' minutes')))
   ) as description,
   CASE 
-    WHEN rapid_transaction_count * 20 >= 80 THEN 'HIGH'
-    WHEN rapid_transaction_count * 20 >= 50 THEN 'MEDIUM'
+    WHEN risk_score >= 80 THEN 'HIGH'
+    WHEN risk_score >= 50 THEN 'MEDIUM'
     ELSE 'LOW'
   END as priority,
   total_amount,
   'OPEN' as status,
   CURRENT_DATE() as detection_date
-FROM rapid_transactions
-ORDER BY risk_score DESC;
\ No newline at end of file
+FROM velocity_scores
+ORDER BY risk_score DESC;